@@ -0,0 +1,407 @@
+// Package auth holds the users, API tokens and roles used by the lexserver to authenticate and authorise requests.
+//
+// Each user has a list of grants, each giving a role (reader, editor or admin) in a scope: all databases ("*"), a database ("db"), or a lexicon ("db:lexicon"). A role includes the roles below it, so that an editor is also a reader.
+//
+// Users authenticate using API tokens. Only a hash of each token is stored, so a token cannot be recovered from the store; it is shown once, when it is created (see Store.CreateToken).
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// Role is a set of permissions: reader (look up entries), editor (reader, and modify entries) and admin (editor, and manage databases, lexicons and users)
+type Role string
+
+const (
+	// Reader can look up entries, and list lexicons and their contents
+	Reader Role = "reader"
+	// Editor can also add, update and delete entries
+	Editor Role = "editor"
+	// Admin can also create and delete databases and lexicons, and manage users
+	Admin Role = "admin"
+)
+
+var roleRank = map[Role]int{Reader: 1, Editor: 2, Admin: 3}
+
+// ParseRole returns the role with the name s
+func ParseRole(s string) (Role, error) {
+	r := Role(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := roleRank[r]; !ok {
+		return r, fmt.Errorf("invalid role '%s' (should be one of %s, %s, %s)", s, Reader, Editor, Admin)
+	}
+	return r, nil
+}
+
+// Includes returns true if the role has all permissions of the other role
+func (r Role) Includes(other Role) bool {
+	return roleRank[r] > 0 && roleRank[r] >= roleRank[other]
+}
+
+// AllScope is the scope of a grant for all databases and lexicons
+const AllScope = "*"
+
+// Grant gives a role in a scope: all databases (AllScope), a database (db name), or a lexicon (lexicon reference, db:lexicon)
+type Grant struct {
+	Role  Role   `json:"role"`
+	Scope string `json:"scope"`
+}
+
+func (g Grant) String() string {
+	return fmt.Sprintf("%s@%s", g.Role, g.Scope)
+}
+
+// ParseGrant parses a grant of the form role@scope (e.g. editor@wikispeech_lexserver_testdb:sv). A grant without a scope (e.g. reader) is for all databases.
+func ParseGrant(s string) (Grant, error) {
+	fs := strings.SplitN(strings.TrimSpace(s), "@", 2)
+	role, err := ParseRole(fs[0])
+	if err != nil {
+		return Grant{}, err
+	}
+	g := Grant{Role: role, Scope: AllScope}
+	if len(fs) == 2 {
+		g.Scope = strings.TrimSpace(fs[1])
+	}
+	return g, g.validate()
+}
+
+func (g Grant) validate() error {
+	if _, err := ParseRole(string(g.Role)); err != nil {
+		return err
+	}
+	if g.Scope == "" {
+		return fmt.Errorf("empty scope for grant %s", g)
+	}
+	if g.Scope != AllScope && strings.Contains(g.Scope, ":") {
+		if _, err := lex.ParseLexRef(g.Scope); err != nil {
+			return fmt.Errorf("invalid scope for grant %s : %v", g, err)
+		}
+	}
+	return nil
+}
+
+// covers returns true if the grant applies to the database and lexicon. An empty lexicon name is used for the database as a whole, and an empty database name for all databases.
+func (g Grant) covers(dbRef lex.DBRef, lexName lex.LexName) bool {
+	if g.Scope == AllScope {
+		return true
+	}
+	if dbRef == "" {
+		return false
+	}
+	if !strings.Contains(g.Scope, ":") {
+		return strings.EqualFold(g.Scope, string(dbRef))
+	}
+	if lexName == "" {
+		return false
+	}
+	lexRef, _ := lex.ParseLexRef(g.Scope)
+	return strings.EqualFold(string(lexRef.DBRef), string(dbRef)) && strings.EqualFold(string(lexRef.LexName), string(lexName))
+}
+
+// Token is an API token of a user. Only the hash of the token is stored.
+type Token struct {
+	ID          string `json:"id"`
+	Hash        string `json:"hash,omitempty"`
+	Description string `json:"description,omitempty"`
+	Created     string `json:"created"`
+}
+
+// User is a user of the lexserver, with grants and API tokens
+type User struct {
+	Name   string  `json:"name"`
+	Grants []Grant `json:"grants"`
+	Tokens []Token `json:"tokens"`
+}
+
+// Can returns true if the user has the role for the database and lexicon. An empty lexicon name is used for the database as a whole (a lexicon grant doesn't give access to the database), and an empty database name for all databases (only AllScope grants apply).
+func (u User) Can(role Role, dbRef lex.DBRef, lexName lex.LexName) bool {
+	for _, g := range u.Grants {
+		if g.Role.Includes(role) && g.covers(dbRef, lexName) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAnywhere returns true if the user has the role in any scope
+func (u User) CanAnywhere(role Role) bool {
+	for _, g := range u.Grants {
+		if g.Role.Includes(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutHashes returns a copy of the user, without token hashes
+func (u User) withoutHashes() User {
+	res := User{Name: u.Name, Grants: append([]Grant{}, u.Grants...), Tokens: []Token{}}
+	for _, t := range u.Tokens {
+		t.Hash = ""
+		res.Tokens = append(res.Tokens, t)
+	}
+	return res
+}
+
+// Store holds the users, and persists them to a JSON file. It is safe for concurrent use.
+type Store struct {
+	mutex *sync.RWMutex
+	file  string
+	users map[string]User
+}
+
+// NewStore creates a store persisted in the JSON file. If the file exists, the users are loaded from it. After this call, the file is re-written each time a user is changed.
+func NewStore(fileName string) (*Store, error) {
+	s := &Store{mutex: &sync.RWMutex{}, file: fileName, users: make(map[string]User)}
+	bts, err := os.ReadFile(filepath.Clean(fileName))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("auth.NewStore: couldn't read file : %v", err)
+	}
+	var list []User
+	err = json.Unmarshal(bts, &list)
+	if err != nil {
+		return s, fmt.Errorf("auth.NewStore: couldn't unmarshal file '%s' : %v", fileName, err)
+	}
+	for _, u := range list {
+		s.users[u.Name] = u
+	}
+	return s, nil
+}
+
+// save should be called with the mutex locked. The file is only readable by the owner, and is replaced atomically.
+func (s *Store) save() error {
+	list := []User{}
+	for _, u := range s.users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	bts, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal users : %v", err)
+	}
+	tmp := s.file + ".tmp"
+	err = os.WriteFile(tmp, bts, 0600)
+	if err != nil {
+		return fmt.Errorf("couldn't save users : %v", err)
+	}
+	err = os.Rename(tmp, s.file)
+	if err != nil {
+		return fmt.Errorf("couldn't save users : %v", err)
+	}
+	return nil
+}
+
+// Users lists the users, ordered by name. Token hashes are not included.
+func (s *Store) Users() []User {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	res := []User{}
+	for _, u := range s.users {
+		res = append(res, u.withoutHashes())
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// User returns the user with the name, and false if there is no such user. Token hashes are not included.
+func (s *Store) User(name string) (User, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	u, ok := s.users[name]
+	return u.withoutHashes(), ok
+}
+
+// AddUser adds a user with the grants. The name must not be in use.
+func (s *Store) AddUser(name string, grants ...Grant) (User, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return User{}, fmt.Errorf("auth.AddUser: empty user name")
+	}
+	for _, g := range grants {
+		if err := g.validate(); err != nil {
+			return User{}, fmt.Errorf("auth.AddUser: %v", err)
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.users[name]; ok {
+		return User{}, fmt.Errorf("auth.AddUser: user already exists: '%s'", name)
+	}
+	u := User{Name: name, Grants: append([]Grant{}, grants...), Tokens: []Token{}}
+	s.users[name] = u
+	err := s.save()
+	if err != nil {
+		return User{}, fmt.Errorf("auth.AddUser: %v", err)
+	}
+	return u.withoutHashes(), nil
+}
+
+// DeleteUser deletes a user and its tokens
+func (s *Store) DeleteUser(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.users[name]; !ok {
+		return fmt.Errorf("auth.DeleteUser: no such user: '%s'", name)
+	}
+	delete(s.users, name)
+	err := s.save()
+	if err != nil {
+		return fmt.Errorf("auth.DeleteUser: %v", err)
+	}
+	return nil
+}
+
+// Grant adds a grant to a user, unless the user already has it
+func (s *Store) Grant(name string, g Grant) (User, error) {
+	if err := g.validate(); err != nil {
+		return User{}, fmt.Errorf("auth.Grant: %v", err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[name]
+	if !ok {
+		return User{}, fmt.Errorf("auth.Grant: no such user: '%s'", name)
+	}
+	for _, g0 := range u.Grants {
+		if g0 == g {
+			return u.withoutHashes(), nil
+		}
+	}
+	u.Grants = append(u.Grants, g)
+	s.users[name] = u
+	err := s.save()
+	if err != nil {
+		return User{}, fmt.Errorf("auth.Grant: %v", err)
+	}
+	return u.withoutHashes(), nil
+}
+
+// Revoke removes a grant from a user
+func (s *Store) Revoke(name string, g Grant) (User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[name]
+	if !ok {
+		return User{}, fmt.Errorf("auth.Revoke: no such user: '%s'", name)
+	}
+	grants := []Grant{}
+	for _, g0 := range u.Grants {
+		if g0 != g {
+			grants = append(grants, g0)
+		}
+	}
+	if len(grants) == len(u.Grants) {
+		return User{}, fmt.Errorf("auth.Revoke: user '%s' has no grant %s", name, g)
+	}
+	u.Grants = grants
+	s.users[name] = u
+	err := s.save()
+	if err != nil {
+		return User{}, fmt.Errorf("auth.Revoke: %v", err)
+	}
+	return u.withoutHashes(), nil
+}
+
+// hashToken returns the stored hash of a token. Tokens are random and long, so a plain SHA-256 hash is sufficient (unlike passwords, they cannot be guessed from a dictionary).
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func randomHex(n int) (string, error) {
+	bts := make([]byte, n)
+	_, err := rand.Read(bts)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bts), nil
+}
+
+// CreateToken creates a new API token for a user. Returns the token, which is not stored and cannot be recovered, and the stored token info. The token has the form <id>.<secret>, where the id identifies the token in the store.
+func (s *Store) CreateToken(name, description string) (string, Token, error) {
+	id, err := randomHex(4)
+	if err != nil {
+		return "", Token{}, fmt.Errorf("auth.CreateToken: %v", err)
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", Token{}, fmt.Errorf("auth.CreateToken: %v", err)
+	}
+	token := id + "." + secret
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[name]
+	if !ok {
+		return "", Token{}, fmt.Errorf("auth.CreateToken: no such user: '%s'", name)
+	}
+	t := Token{ID: id, Hash: hashToken(token), Description: strings.TrimSpace(description), Created: time.Now().UTC().Format(time.RFC3339)}
+	u.Tokens = append(u.Tokens, t)
+	s.users[name] = u
+	err = s.save()
+	if err != nil {
+		return "", Token{}, fmt.Errorf("auth.CreateToken: %v", err)
+	}
+	t.Hash = ""
+	return token, t, nil
+}
+
+// RevokeToken deletes the token with the id from a user
+func (s *Store) RevokeToken(name, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[name]
+	if !ok {
+		return fmt.Errorf("auth.RevokeToken: no such user: '%s'", name)
+	}
+	tokens := []Token{}
+	for _, t := range u.Tokens {
+		if t.ID != id {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) == len(u.Tokens) {
+		return fmt.Errorf("auth.RevokeToken: user '%s' has no token with id '%s'", name, id)
+	}
+	u.Tokens = tokens
+	s.users[name] = u
+	err := s.save()
+	if err != nil {
+		return fmt.Errorf("auth.RevokeToken: %v", err)
+	}
+	return nil
+}
+
+// Authenticate returns the user of an API token, and false if the token is unknown
+func (s *Store) Authenticate(token string) (User, bool) {
+	id, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return User{}, false
+	}
+	hash := []byte(hashToken(token))
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, u := range s.users {
+		for _, t := range u.Tokens {
+			if t.ID == id && subtle.ConstantTimeCompare([]byte(t.Hash), hash) == 1 {
+				return u.withoutHashes(), true
+			}
+		}
+	}
+	return User{}, false
+}
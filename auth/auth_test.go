@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestGrants(t *testing.T) {
+	for _, s := range []string{"", "guest", "editor@", "editor@db:"} {
+		if _, err := ParseGrant(s); err == nil {
+			t.Errorf("expected error for grant '%s'", s)
+		}
+	}
+	parse := func(s string) Grant {
+		t.Helper()
+		g, err := ParseGrant(s)
+		if err != nil {
+			t.Fatalf("couldn't parse grant '%s' : %v", s, err)
+		}
+		return g
+	}
+	u := User{Name: "anna", Grants: []Grant{parse("reader"), parse("editor@db1"), parse("admin@db2:sv")}}
+	for _, test := range []struct {
+		role    Role
+		db, lex string
+		expect  bool
+	}{
+		{Reader, "", "", true},
+		{Reader, "db3", "en", true},
+		{Editor, "", "", false},
+		{Editor, "db1", "", true},
+		{Editor, "db1", "sv", true},
+		{Admin, "db1", "sv", false},
+		{Editor, "db2", "", false},
+		{Admin, "db2", "sv", true},
+		{Admin, "DB2", "SV", true},
+		{Editor, "db2", "en", false},
+		{Admin, "db2", "", false},
+	} {
+		if res := u.Can(test.role, lex.DBRef(test.db), lex.LexName(test.lex)); res != test.expect {
+			t.Errorf("expected Can(%s, %s, %s) = %v, got %v", test.role, test.db, test.lex, test.expect, res)
+		}
+	}
+	if !u.CanAnywhere(Admin) {
+		t.Errorf("expected CanAnywhere(admin)")
+	}
+}
+
+func TestStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users.json")
+	s, err := NewStore(file)
+	if err != nil {
+		t.Fatalf("couldn't create store : %v", err)
+	}
+	_, err = s.AddUser("anna", Grant{Role: Editor, Scope: "db1"})
+	if err != nil {
+		t.Fatalf("couldn't add user : %v", err)
+	}
+	_, err = s.AddUser("anna")
+	if err == nil {
+		t.Errorf("expected error for existing user")
+	}
+	token, info, err := s.CreateToken("anna", "test")
+	if err != nil {
+		t.Fatalf("couldn't create token : %v", err)
+	}
+	if info.Hash != "" {
+		t.Errorf("expected no hash in token info")
+	}
+	_, _, err = s.CreateToken("bertil", "")
+	if err == nil {
+		t.Errorf("expected error for unknown user")
+	}
+
+	// the store is persisted, without the tokens themselves
+	s, err = NewStore(file)
+	if err != nil {
+		t.Fatalf("couldn't load store : %v", err)
+	}
+	u, ok := s.Authenticate(token)
+	if !ok || u.Name != "anna" || !u.Can(Editor, "db1", "") {
+		t.Errorf("expected user anna, got %#v", u)
+	}
+	for _, bad := range []string{"", token[:len(token)-1] + "x", info.ID, info.ID + "."} {
+		if _, ok := s.Authenticate(bad); ok {
+			t.Errorf("expected authentication to fail for token '%s'", bad)
+		}
+	}
+
+	u, err = s.Grant("anna", Grant{Role: Admin, Scope: AllScope})
+	if err != nil || !u.Can(Admin, "", "") {
+		t.Errorf("expected admin grant, got %#v : %v", u, err)
+	}
+	u, err = s.Revoke("anna", Grant{Role: Admin, Scope: AllScope})
+	if err != nil || u.Can(Admin, "", "") {
+		t.Errorf("expected revoked grant, got %#v : %v", u, err)
+	}
+	err = s.RevokeToken("anna", info.ID)
+	if err != nil {
+		t.Errorf("couldn't revoke token : %v", err)
+	}
+	if _, ok := s.Authenticate(token); ok {
+		t.Errorf("expected authentication to fail for revoked token")
+	}
+	err = s.DeleteUser("anna")
+	if err != nil {
+		t.Errorf("couldn't delete user : %v", err)
+	}
+	if us := s.Users(); len(us) != 0 {
+		t.Errorf("expected no users, got %#v", us)
+	}
+}
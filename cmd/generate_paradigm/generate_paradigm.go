@@ -0,0 +1,172 @@
+// generate_paradigm reads lemma entries from a lexicon file (WS format), and prints the inflected entries generated from each entry's paradigm (WS format).
+// If a lexicon database is specified, generated entries already in the lexicon are skipped.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/pronlex/line"
+	"github.com/stts-se/pronlex/paradigm"
+)
+
+func main() {
+
+	var cmdName = "generate_paradigm"
+
+	var paradigmFile = flag.String("paradigms", "", "paradigm definition file, or folder of files (*"+paradigm.FileExtension+")")
+	var paradigmName = flag.String("paradigm", "", "paradigm name to use for all input entries (default: use each entry's lemma paradigm)")
+	var engineFlag = flag.String("db_engine", "sqlite", "db engine (sqlite or mariadb)")
+	var dbLocation = flag.String("db_location", "", "db location (folder for sqlite; address for mariadb)")
+	var lexRefFlag = flag.String("lexicon", "", "lexicon (db_name:lex_name) used to skip already existing entries (optional)")
+	var status = flag.String("status", "generated", "status name for generated entries")
+	var source = flag.String("source", cmdName, "status source for generated entries")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE: %s [FLAGS] <LEXICON FILE>\n\nThe lexicon file should be in WS format. Use - to read from stdin.\n\nFLAGS:\n", cmdName)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if len(flag.Args()) != 1 || *paradigmFile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var paradigms = make(map[string]paradigm.Paradigm)
+	if fi, err := os.Stat(*paradigmFile); err == nil && fi.IsDir() {
+		paradigms, err = paradigm.LoadDir(*paradigmFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] %v\n", cmdName, err)
+			os.Exit(1)
+		}
+	} else {
+		ps, err := paradigm.LoadFile(*paradigmFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] %v\n", cmdName, err)
+			os.Exit(1)
+		}
+		for _, p := range ps {
+			paradigms[p.Name] = p
+		}
+	}
+
+	var dbm *dbapi.DBManager
+	var lexRef lex.LexRef
+	if *lexRefFlag != "" {
+		var err error
+		lexRef, err = lex.ParseLexRef(*lexRefFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] %v\n", cmdName, err)
+			os.Exit(1)
+		}
+		if *engineFlag == "mariadb" {
+			dbm = dbapi.NewMariaDBManager()
+		} else if *engineFlag == "sqlite" {
+			dbapi.Sqlite3WithRegex()
+			dbm = dbapi.NewSqliteDBManager()
+		} else {
+			fmt.Fprintf(os.Stderr, "[%s] invalid db engine : %s\n", cmdName, *engineFlag)
+			os.Exit(1)
+		}
+		err = dbm.OpenDB(*dbLocation, lexRef.DBRef)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] failed to open db : %v\n", cmdName, err)
+			os.Exit(1)
+		}
+		defer dbm.CloseDB(lexRef.DBRef)
+	}
+
+	wsFmt, err := line.NewWS()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] %v\n", cmdName, err)
+		os.Exit(1)
+	}
+
+	var in io.Reader = os.Stdin
+	if flag.Args()[0] != "-" {
+		fh, err := os.Open(flag.Args()[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] %v\n", cmdName, err)
+			os.Exit(1)
+		}
+		/* #nosec G307 */
+		defer fh.Close()
+		in = fh
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	nGenerated, nExisting, nFailed := 0, 0, 0
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		l := s.Text()
+		if strings.TrimSpace(l) == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		e, err := wsFmt.ParseToEntry(l)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] %v\n", cmdName, err)
+			nFailed++
+			continue
+		}
+		pName := *paradigmName
+		if pName == "" {
+			pName = e.Lemma.Paradigm
+		}
+		p, ok := paradigms[pName]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "[%s] skipping %s : unknown paradigm '%s'\n", cmdName, e.Strn, pName)
+			nFailed++
+			continue
+		}
+		generated, err := p.Generate(e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] skipping %s : %v\n", cmdName, e.Strn, err)
+			nFailed++
+			continue
+		}
+
+		existing := []lex.Entry{}
+		if dbm != nil {
+			words := []string{}
+			for _, g := range generated {
+				words = append(words, g.Strn)
+			}
+			existing, err = dbm.LookUpIntoSlice(dbapi.DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: dbapi.Query{Words: words}})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] lookup failed : %v\n", cmdName, err)
+				os.Exit(1)
+			}
+		}
+
+		for _, c := range paradigm.MarkExisting(generated, existing) {
+			if c.Exists {
+				nExisting++
+				continue
+			}
+			c.Entry.EntryStatus = lex.EntryStatus{Name: *status, Source: *source}
+			s, err := wsFmt.Entry2String(c.Entry)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] %v\n", cmdName, err)
+				os.Exit(1)
+			}
+			fmt.Fprintln(out, s)
+			nGenerated++
+		}
+	}
+	if err := s.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] %v\n", cmdName, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "[%s] generated %d entries (skipped %d existing, %d failed input entries)\n", cmdName, nGenerated, nExisting, nFailed)
+}
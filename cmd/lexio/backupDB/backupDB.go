@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+)
+
+func main() {
+
+	var cmdName = "backupDB"
+
+	var engineFlag = flag.String("db_engine", "sqlite", "db engine (sqlite or mariadb)")
+	var dbLocation = flag.String("db_location", "", "db location (folder for sqlite; address for mariadb)")
+	var dbName = flag.String("db_name", "", "db name")
+	var restore = flag.Bool("restore", false, "restore the db from the backup file, instead of creating a backup (the db must not exist)")
+
+	var fatalError = false
+	var dieIfEmptyFlag = func(name string, val *string) {
+		if *val == "" {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("[%s] flag %s is required", cmdName, name))
+			fatalError = true
+		}
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE: backupDB [FLAGS] <BACKUP FILE>\n\n")
+		fmt.Fprintf(os.Stderr, "Creates a gzip compressed backup of a lexicon database, or restores a database from a backup (-restore). The backup is consistent even if the database is written to while the backup is running.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if len(flag.Args()) != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	backupFile := flag.Args()[0]
+
+	dieIfEmptyFlag("db_engine", engineFlag)
+	dieIfEmptyFlag("db_location", dbLocation)
+	dieIfEmptyFlag("db_name", dbName)
+	if fatalError {
+		fmt.Fprintln(os.Stderr, fmt.Errorf("[%s] exit from unrecoverable errors", cmdName))
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	dbapi.Sqlite3WithRegex()
+
+	var dbm *dbapi.DBManager
+	if *engineFlag == "mariadb" {
+		dbm = dbapi.NewMariaDBManager()
+	} else if *engineFlag == "sqlite" {
+		dbm = dbapi.NewSqliteDBManager()
+	} else {
+		fmt.Fprintf(os.Stderr, "invalid db engine : %s\n", *engineFlag)
+		os.Exit(1)
+	}
+	dbRef := lex.DBRef(*dbName)
+
+	if *restore {
+		fh, err := os.Open(backupFile)
+		if err != nil {
+			log.Fatalf("[%s] failed to open backup file : %v", cmdName, err)
+		}
+		defer fh.Close()
+		err = dbm.Restore(*dbLocation, dbRef, fh)
+		if err != nil {
+			log.Fatalf("[%s] restore failed : %v", cmdName, err)
+		}
+		defer dbm.CloseDB(dbRef)
+		log.Printf("[%s] restored db %s from %s", cmdName, dbRef, backupFile)
+		return
+	}
+
+	err := dbm.OpenDB(*dbLocation, dbRef)
+	if err != nil {
+		log.Fatalf("[%s] failed to open db : %v", cmdName, err)
+	}
+	defer dbm.CloseDB(dbRef)
+
+	if _, err := os.Stat(backupFile); err == nil {
+		log.Fatalf("[%s] backup file already exists : %s", cmdName, backupFile)
+	}
+	fh, err := os.Create(backupFile)
+	if err != nil {
+		log.Fatalf("[%s] failed to create backup file : %v", cmdName, err)
+	}
+	err = dbm.Backup(dbRef, fh)
+	if err != nil {
+		fh.Close()
+		os.Remove(backupFile)
+		log.Fatalf("[%s] backup failed : %v", cmdName, err)
+	}
+	err = fh.Close()
+	if err != nil {
+		log.Fatalf("[%s] failed to close backup file : %v", cmdName, err)
+	}
+	log.Printf("[%s] saved backup of db %s to %s", cmdName, dbRef, backupFile)
+}
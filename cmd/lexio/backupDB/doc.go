@@ -0,0 +1,2 @@
+// Command line tool for creating a backup of a lexicon database, or restoring a database from a backup. Backups are created using the Sqlite online backup API (for Sqlite), or as an SQL dump generated by the dbapi package (for MariaDB), so no external database binaries are needed.
+package main
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/symbolset/mapper"
+)
+
+func main() {
+
+	var cmdName = "convertSymbolSet"
+
+	var engineFlag = flag.String("db_engine", "sqlite", "db engine (sqlite or mariadb)")
+	var dbLocation = flag.String("db_location", "", "db location (folder for sqlite; address for mariadb)")
+	var dbName = flag.String("db_name", "", "db name")
+	var lexName = flag.String("lex_name", "", "lexicon name")
+	var fromSymbolSet = flag.String("from", "", "symbol set file for the lexicon's current symbol set")
+	var toSymbolSet = flag.String("to", "", "symbol set file for the target symbol set")
+	var newLexName = flag.String("new_lex_name", "", "if set, the converted entries are saved in a new lexicon with this name, and the original lexicon is left as is (default: convert in place)")
+
+	var fatalError = false
+	var dieIfEmptyFlag = func(name string, val *string) {
+		if *val == "" {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("[%s] flag %s is required", cmdName, name))
+			fatalError = true
+		}
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE: convertSymbolSet [FLAGS]\n\n")
+		fmt.Fprintf(os.Stderr, "Transcriptions that cannot be mapped are left as is, and a validation message (%s) is added to the entry.\n\n", dbapi.SymbolSetConversionRuleName)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if len(flag.Args()) != 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	dieIfEmptyFlag("db_engine", engineFlag)
+	dieIfEmptyFlag("db_location", dbLocation)
+	dieIfEmptyFlag("db_name", dbName)
+	dieIfEmptyFlag("lex_name", lexName)
+	dieIfEmptyFlag("from", fromSymbolSet)
+	dieIfEmptyFlag("to", toSymbolSet)
+	if fatalError {
+		fmt.Fprintln(os.Stderr, fmt.Errorf("[%s] exit from unrecoverable errors", cmdName))
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	m, err := mapper.LoadMapperFromFile("SYMBOL", "SYMBOL", *fromSymbolSet, *toSymbolSet)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] couldn't load mapper : %v\n", cmdName, err)
+		os.Exit(1)
+	}
+
+	dbapi.Sqlite3WithRegex()
+
+	var dbm *dbapi.DBManager
+	if *engineFlag == "mariadb" {
+		dbm = dbapi.NewMariaDBManager()
+	} else if *engineFlag == "sqlite" {
+		dbm = dbapi.NewSqliteDBManager()
+	} else {
+		fmt.Fprintf(os.Stderr, "invalid db engine : %s\n", *engineFlag)
+		os.Exit(1)
+	}
+	dbRef := lex.DBRef(*dbName)
+	err = dbm.OpenDB(*dbLocation, dbRef)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] failed to open db : %v\n", cmdName, err)
+		os.Exit(1)
+	}
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(*dbName, *lexName)
+	res, err := dbm.ConvertSymbolSet(lexRef, m, lex.LexName(*newLexName))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] conversion failed : %v\n", cmdName, err)
+		os.Exit(1)
+	}
+	js, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] couldn't marshal result : %v\n", cmdName, err)
+		os.Exit(1)
+	}
+	fmt.Println(string(js))
+}
@@ -0,0 +1,2 @@
+// Command line tool for converting the transcriptions of a lexicon in the database from one phonetic symbol set to another, either in place or into a new lexicon. Unlike exporting, converting the file and re-importing, an in-place conversion keeps entry ids and status history.
+package main
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 
@@ -14,11 +16,38 @@ import (
 	"github.com/stts-se/pronlex/line"
 )
 
+// metaHeader returns the lexicon meta data as "key: value" lines, skipping empty values. Line breaks in values are replaced by space, so that each value fits on a single comment line.
+func metaHeader(lexRef lex.LexRef, meta dbapi.LexiconMeta) []string {
+	res := []string{fmt.Sprintf("lexicon: %s", lexRef.LexName)}
+	add := func(key, value string) {
+		value = strings.Join(strings.Fields(value), " ")
+		if value != "" {
+			res = append(res, fmt.Sprintf("%s: %s", key, value))
+		}
+	}
+	add("description", meta.Description)
+	add("license", meta.License)
+	add("sourceUrl", meta.SourceURL)
+	add("sourceVersion", meta.SourceVersion)
+	add("owner", meta.Owner)
+	add("created", meta.Created)
+	add("modified", meta.Modified)
+	keys := []string{}
+	for k := range meta.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(k, meta.Properties[k])
+	}
+	return res
+}
+
 func main() {
 
 	var cmdName = "exportLex"
 
-	var header = flag.Bool("header", false, "print header")
+	var header = flag.Bool("header", false, "print header (lexicon meta data and field names, as comment lines)")
 
 	var engineFlag = flag.String("db_engine", "sqlite", "db engine (sqlite or mariadb)")
 	var dbLocation = flag.String("db_location", "", "db location (folder for sqlite; address for mariadb)")
@@ -112,7 +141,18 @@ func main() {
 		log.Fatal(err)
 	}
 	if *header {
-		_, err := bf.Write([]byte(fmt.Sprintf("#%s\n", wsFmt.Header())))
+		meta, err := dbm.GetLexiconMeta(lexRef)
+		if err != nil {
+			log.Fatalf("failed to get lexicon meta data : %v", err)
+		}
+		for _, l := range metaHeader(lexRef, meta) {
+			_, err := bf.Write([]byte(fmt.Sprintf("# %s\n", l)))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "write error : %v\n", err)
+				os.Exit(1)
+			}
+		}
+		_, err = bf.Write([]byte(fmt.Sprintf("#%s\n", wsFmt.Header())))
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "write error : %v\n", err)
 			os.Exit(1)
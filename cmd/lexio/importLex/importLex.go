@@ -71,6 +71,7 @@ func main() {
 	var lexFile = flag.String("lex_file", "", "lexicon file")
 	var locale = flag.String("locale", "", "lexicon locale")
 	var ssFile = flag.String("symbolset", "", "lexicon symbolset file")
+	var user = flag.String("user", "", "user name, saved with the import batch (see lexserver /admin/import_batches)")
 
 	var fatalError = false
 	var dieIfEmptyFlag = func(name string, val *string) {
@@ -235,7 +236,7 @@ SAMPLE INVOCATION:
 	}
 	// TODO handle errors? Does it make sent to return array of error...?
 	stderrLogger.Write(fmt.Sprintf("importing lexicon file %s ...", *lexFile))
-	err = dbm.ImportLexiconFile(lexRef, logger, *lexFile, validator)
+	batch, err := dbm.ImportLexiconFileAs(lexRef, logger, *lexFile, validator, *user)
 
 	if err != nil {
 		log.Fatal(err)
@@ -255,6 +256,7 @@ SAMPLE INVOCATION:
 	stderrLogger.Write("dbName=" + string(dbRef))
 	stderrLogger.Write("lexName=" + *lexName)
 	stderrLogger.Write("lexFile=" + *lexFile)
+	stderrLogger.Write("importBatch=" + strconv.FormatInt(batch.ID, 10))
 	stderrLogger.Write("symbolSet=" + symbolSetName)
 	stderrLogger.Write("symbolSetFolder=" + symbolSetDir)
 	stderrLogger.Write("validate=" + strconv.FormatBool(*validate))
@@ -88,6 +88,18 @@ func runPostTests(dbm *dbapi.DBManager, dbLocation string, dbRef lex.DBRef, sqlD
 		log.Fatalf("Couldn't read validate schema version in file %s : %v\n", sqlDumpFile, err)
 	}
 
+	// upgrade dumps from older (compatible) schema versions
+	report, err := dbm.Migrate(dbRef)
+	if err != nil {
+		log.Fatalf("Couldn't migrate db : %v", err)
+	}
+	if report.Migrated {
+		log.Printf("Migrated db from schema version %s to %s (%d normalised entries)", report.FromVersion, report.ToVersion, report.NormalisedEntries)
+		for _, c := range report.Collisions {
+			log.Printf("Normalisation collision in lexicon %s : %q (entries %v)", c.Lexicon, c.Variants, c.EntryIDs)
+		}
+	}
+
 	// (2) output statistics
 	lexes, err := dbm.ListLexicons()
 	if err != nil {
@@ -0,0 +1,2 @@
+// Command line tool for checking the integrity of a lexicon database, and optionally repairing the problems that can be repaired mechanically (e.g., orphan lemmas, entries with several current statuses, and empty transcriptions).
+package main
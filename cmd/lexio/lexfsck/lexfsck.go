@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+)
+
+func printReport(report dbapi.IntegrityReport, verbose bool) {
+	cats := []string{}
+	for cat := range report.Categories {
+		cats = append(cats, cat)
+	}
+	sort.Strings(cats)
+
+	if verbose {
+		for _, p := range report.Problems {
+			status := ""
+			if p.Repaired {
+				status = "REPAIRED"
+			} else if !p.Repairable {
+				status = "NOT REPAIRABLE"
+			}
+			fmt.Printf("%s\t%s\t%d\t%s\t%s\n", p.Category, p.Lexicon, p.EntryID, p.Message, status)
+		}
+		if len(report.Problems) > 0 {
+			fmt.Println()
+		}
+	}
+
+	var fstr = "%-28s %6d\n"
+	fmt.Printf("INTEGRITY CHECK: %s\n", report.DB)
+	for _, cat := range cats {
+		fmt.Printf(fstr, cat, report.Categories[cat])
+	}
+	fmt.Printf(fstr, "TOTAL", len(report.Problems))
+	fmt.Printf(fstr, "REPAIRED", report.Repaired)
+}
+
+func main() {
+
+	var cmdName = "lexfsck"
+
+	var engineFlag = flag.String("db_engine", "sqlite", "db engine (sqlite or mariadb)")
+	var dbLocation = flag.String("db_location", "", "db location (folder for sqlite; address for mariadb)")
+	var dbName = flag.String("db_name", "", "db name")
+	var repair = flag.Bool("repair", false, "repair the problems that can be repaired mechanically (in a single transaction)")
+	var jsonOutput = flag.Bool("json", false, "print the report in JSON format")
+	var verbose = flag.Bool("v", false, "list each problem, not only the number of problems per category")
+
+	var fatalError = false
+	var dieIfEmptyFlag = func(name string, val *string) {
+		if *val == "" {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("[%s] flag %s is required", cmdName, name))
+			fatalError = true
+		}
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE: lexfsck [FLAGS]\n\n")
+		fmt.Fprintf(os.Stderr, "Checks the integrity of a lexicon database. Exits with status 1 if there are any problems left after the check (and repair, if -repair is set).\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if len(flag.Args()) != 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	dieIfEmptyFlag("db_engine", engineFlag)
+	dieIfEmptyFlag("db_location", dbLocation)
+	dieIfEmptyFlag("db_name", dbName)
+	if fatalError {
+		fmt.Fprintln(os.Stderr, fmt.Errorf("[%s] exit from unrecoverable errors", cmdName))
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	dbapi.Sqlite3WithRegex()
+
+	var dbm *dbapi.DBManager
+	if *engineFlag == "mariadb" {
+		dbm = dbapi.NewMariaDBManager()
+	} else if *engineFlag == "sqlite" {
+		dbm = dbapi.NewSqliteDBManager()
+	} else {
+		fmt.Fprintf(os.Stderr, "invalid db engine : %s\n", *engineFlag)
+		os.Exit(1)
+	}
+	dbRef := lex.DBRef(*dbName)
+	err := dbm.OpenDB(*dbLocation, dbRef)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] failed to open db : %v\n", cmdName, err)
+		os.Exit(1)
+	}
+	defer dbm.CloseDB(dbRef)
+
+	var report dbapi.IntegrityReport
+	if *repair {
+		report, err = dbm.RepairIntegrity(dbRef)
+	} else {
+		report, err = dbm.CheckIntegrity(dbRef)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] integrity check failed : %v\n", cmdName, err)
+		os.Exit(1)
+	}
+
+	if *jsonOutput {
+		js, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] couldn't marshal report : %v\n", cmdName, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(js))
+	} else {
+		printReport(report, *verbose)
+	}
+
+	if !report.OK() {
+		dbm.CloseDB(dbRef)
+		os.Exit(1)
+	}
+}
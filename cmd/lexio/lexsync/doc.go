@@ -0,0 +1,2 @@
+// Command line tool for replicating lexicon databases from a primary lexserver. Databases that don't exist locally are created from backups of the primary; existing databases are updated with the changes made on the primary since the last sync.
+package main
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/pronlex/replication"
+)
+
+func main() {
+
+	var cmdName = "lexsync"
+
+	var engineFlag = flag.String("db_engine", "sqlite", "db engine (sqlite or mariadb)")
+	var dbLocation = flag.String("db_location", "", "db location (folder for sqlite; address for mariadb)")
+	var limit = flag.Int("limit", replication.DefaultLimit, "max number of change events to fetch in each request")
+	var token = flag.String("token", "", "API token for the primary lexserver, if it requires authentication (admin role for the replicated databases)")
+	var follow = flag.Duration("follow", 0, "keep running, and pull changes at this interval (e.g. 1m); if 0, exit after one sync")
+
+	var fatalError = false
+	var dieIfEmptyFlag = func(name string, val *string) {
+		if *val == "" {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("[%s] flag %s is required", cmdName, name))
+			fatalError = true
+		}
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE: lexsync [FLAGS] <PRIMARY URL> [DB NAMES...]\n\n")
+		fmt.Fprintf(os.Stderr, "Replicates lexicon databases from a primary lexserver (all databases of the primary, if no db names are given). Databases that don't exist locally are created from backups of the primary; existing databases are updated with the changes made on the primary since the last sync. The replicated databases should not be edited other than by lexsync (or a lexserver running with -follow_primary).\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if len(flag.Args()) < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	client := replication.Client{PrimaryURL: flag.Args()[0], Limit: *limit, Token: *token}
+	dbRefs := []lex.DBRef{}
+	for _, name := range flag.Args()[1:] {
+		dbRefs = append(dbRefs, lex.DBRef(name))
+	}
+
+	dieIfEmptyFlag("db_engine", engineFlag)
+	dieIfEmptyFlag("db_location", dbLocation)
+	if fatalError {
+		fmt.Fprintln(os.Stderr, fmt.Errorf("[%s] exit from unrecoverable errors", cmdName))
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	dbapi.Sqlite3WithRegex()
+
+	var dbm *dbapi.DBManager
+	if *engineFlag == "mariadb" {
+		dbm = dbapi.NewMariaDBManager()
+	} else if *engineFlag == "sqlite" {
+		dbm = dbapi.NewSqliteDBManager()
+	} else {
+		fmt.Fprintf(os.Stderr, "invalid db engine : %s\n", *engineFlag)
+		os.Exit(1)
+	}
+
+	// open the local databases to sync
+	local := dbRefs
+	if len(local) == 0 {
+		var err error
+		local, err = client.ListDBs()
+		if err != nil {
+			log.Fatalf("[%s] %v", cmdName, err)
+		}
+	}
+	for _, dbRef := range local {
+		exists, err := dbm.DBExists(*dbLocation, dbRef)
+		if err != nil {
+			log.Fatalf("[%s] %v", cmdName, err)
+		}
+		if !exists {
+			continue
+		}
+		err = dbm.OpenDB(*dbLocation, dbRef)
+		if err != nil {
+			log.Fatalf("[%s] failed to open db : %v", cmdName, err)
+		}
+	}
+	// close all databases, including the ones created by the sync
+	closeDBs := func() {
+		dbNames, err := dbm.ListDBNames()
+		if err != nil {
+			log.Printf("[%s] couldn't close databases : %v", cmdName, err)
+		}
+		for _, dbRef := range dbNames {
+			dbm.CloseDB(dbRef)
+		}
+	}
+
+	if *follow > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		log.Printf("[%s] following %s, pulling changes every %v", cmdName, client.Source(), *follow)
+		replication.Follow(ctx, dbm, *dbLocation, client, *follow, dbRefs...)
+		closeDBs()
+		return
+	}
+
+	err := replication.Sync(dbm, *dbLocation, client, dbRefs...)
+	if err != nil {
+		log.Printf("[%s] %v", cmdName, err)
+		closeDBs()
+		os.Exit(1)
+	}
+	for _, dbRef := range local {
+		if !dbm.ContainsDB(dbRef) {
+			continue
+		}
+		pos, err := dbm.ReplicationPosition(dbRef, client.Source())
+		if err != nil {
+			log.Printf("[%s] %v", cmdName, err)
+			continue
+		}
+		log.Printf("[%s] db %s is at position %d in the change feed of %s", cmdName, dbRef, pos, client.Source())
+	}
+	closeDBs()
+}
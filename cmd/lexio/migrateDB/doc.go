@@ -0,0 +1,2 @@
+// Command line tool for upgrading a lexicon database created with an older (compatible) schema version to the current schema version. The migration normalises existing entries (Unicode NFC), and reports entries that are identical after normalisation.
+package main
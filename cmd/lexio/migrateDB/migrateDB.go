@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+)
+
+func printReport(report dbapi.MigrationReport) {
+	if !report.Migrated {
+		fmt.Printf("DB %s is up to date (schema version %s)\n", report.DB, report.ToVersion)
+		return
+	}
+	var fstr = "%-28s %6d\n"
+	fmt.Printf("MIGRATED: %s (schema version %s => %s)\n", report.DB, report.FromVersion, report.ToVersion)
+	fmt.Printf(fstr, "normalised entries", report.NormalisedEntries)
+	fmt.Printf(fstr, "collisions", len(report.Collisions))
+	fmt.Printf(fstr, "tag conflicts", len(report.TagConflicts))
+	fmt.Printf(fstr, "converted assign comments", report.ConvertedAssignComments)
+	for _, c := range report.Collisions {
+		fmt.Printf("COLLISION\t%s\t%s\t%q\t%v\n", c.Lexicon, c.Strn, c.Variants, c.EntryIDs)
+	}
+	for _, id := range report.TagConflicts {
+		fmt.Printf("TAG CONFLICT\t%d\n", id)
+	}
+}
+
+func main() {
+
+	var cmdName = "migrateDB"
+
+	var engineFlag = flag.String("db_engine", "sqlite", "db engine (sqlite or mariadb)")
+	var dbLocation = flag.String("db_location", "", "db location (folder for sqlite; address for mariadb)")
+	var dbName = flag.String("db_name", "", "db name")
+	var jsonOutput = flag.Bool("json", false, "print the report in JSON format")
+
+	var fatalError = false
+	var dieIfEmptyFlag = func(name string, val *string) {
+		if *val == "" {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("[%s] flag %s is required", cmdName, name))
+			fatalError = true
+		}
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE: migrateDB [FLAGS]\n\n")
+		fmt.Fprintf(os.Stderr, "Upgrades a lexicon database to the current schema version (%s), and reports entries that are identical after Unicode normalisation. Databases that are up to date are not changed.\n\n", dbapi.SchemaVersion)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if len(flag.Args()) != 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	dieIfEmptyFlag("db_engine", engineFlag)
+	dieIfEmptyFlag("db_location", dbLocation)
+	dieIfEmptyFlag("db_name", dbName)
+	if fatalError {
+		fmt.Fprintln(os.Stderr, fmt.Errorf("[%s] exit from unrecoverable errors", cmdName))
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	dbapi.Sqlite3WithRegex()
+
+	var dbm *dbapi.DBManager
+	if *engineFlag == "mariadb" {
+		dbm = dbapi.NewMariaDBManager()
+	} else if *engineFlag == "sqlite" {
+		dbm = dbapi.NewSqliteDBManager()
+	} else {
+		fmt.Fprintf(os.Stderr, "invalid db engine : %s\n", *engineFlag)
+		os.Exit(1)
+	}
+	dbRef := lex.DBRef(*dbName)
+	err := dbm.OpenDB(*dbLocation, dbRef)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] failed to open db : %v\n", cmdName, err)
+		os.Exit(1)
+	}
+	defer dbm.CloseDB(dbRef)
+
+	report, err := dbm.Migrate(dbRef)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] migration failed : %v\n", cmdName, err)
+		dbm.CloseDB(dbRef)
+		os.Exit(1)
+	}
+
+	if *jsonOutput {
+		js, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] couldn't marshal report : %v\n", cmdName, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(js))
+	} else {
+		printReport(report)
+	}
+}
@@ -50,11 +50,12 @@ func deleteEntry(dbm *dbapi.DBManager, entryID int64, dbRef, lexName string) err
 	return err
 }
 
-func remDupes(words []string) []string {
+// remDupes removes words that are identical for the lookup mode of the query (see dbapi.Query.WordKey)
+func remDupes(words []string, mode dbapi.Query) []string {
 	var res []string
 	found := make(map[string]bool)
 	for _, w := range words {
-		w0 := strings.ToLower(w)
+		w0 := mode.WordKey(w)
 		if !found[w0] {
 			res = append(res, w)
 			found[w0] = true
@@ -93,10 +94,12 @@ func lookUp0(dbRef lex.DBRef, q dbapi.DBMQuery, dbm *dbapi.DBManager) ([]lex.Ent
 	return resWriter.Entries, nil
 }
 
-func lookUp(words []string, dbRef lex.DBRef, dbm *dbapi.DBManager) ([]lex.Entry, error) {
+func lookUp(words []string, mode dbapi.Query, dbRef lex.DBRef, dbm *dbapi.DBManager) ([]lex.Entry, error) {
 	var res []lex.Entry
 
 	q := dbapi.NewQuery()
+	q.IgnoreCase = mode.IgnoreCase
+	q.IgnoreDiacritics = mode.IgnoreDiacritics
 	// PageLength defaults to 25
 	q.PageLength = 20000
 
@@ -148,6 +151,8 @@ func main() {
 	dbLocation := flag.String("db_location", "", "DB location (folder for sqlite; address for mariadb)")
 	dbName := flag.String("db_name", "", "DB reference name (for sqlite, it should be without the .db suffix")
 	lexName := flag.String("lexicon", "", "Lexicon name")
+	ignoreCaseFlag := flag.Bool("ignore_case", false, "Case-insensitive lookup (using Unicode case folding)")
+	ignoreDiacriticsFlag := flag.Bool("ignore_diacritics", false, "Diacritic-insensitive lookup (also ignores case)")
 
 	var fatalError = false
 	var dieIfEmptyFlag = func(name string, val *string) {
@@ -291,10 +296,12 @@ Flags:`)
 		os.Exit(0)
 	}
 
+	mode := dbapi.Query{IgnoreCase: *ignoreCaseFlag, IgnoreDiacritics: *ignoreDiacriticsFlag}
+
 	// Only look up same string once
-	words = remDupes(words)
+	words = remDupes(words, mode)
 
-	entries, err := lookUp(words, lex.DBRef(*dbName), dbm)
+	entries, err := lookUp(words, mode, lex.DBRef(*dbName), dbm)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: failed look-up : '%v'\n", err)
 		os.Exit(1)
@@ -313,14 +320,13 @@ Flags:`)
 		foundWords := make(map[string]bool)
 		for _, e := range entries {
 			//fmt.Printf("%#v\n", e)
-			f := strings.ToLower(e.Strn)
+			f := mode.WordKey(e.Strn)
 			foundWords[f] = true
 		}
 
 		for _, w := range words {
-			w = strings.ToLower(w)
-			if !foundWords[w] {
-				fmt.Println(w)
+			if !foundWords[mode.WordKey(w)] {
+				fmt.Println(dbapi.NormaliseWord(w))
 			}
 
 		}
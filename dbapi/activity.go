@@ -0,0 +1,231 @@
+package dbapi
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// ActivityBucketSize is the size of the time buckets used by DBManager.Activity
+type ActivityBucketSize string
+
+const (
+	// ActivityDay groups status changes per day
+	ActivityDay ActivityBucketSize = "day"
+	// ActivityWeek groups status changes per week, starting on Mondays
+	ActivityWeek ActivityBucketSize = "week"
+	// ActivityMonth groups status changes per calendar month
+	ActivityMonth ActivityBucketSize = "month"
+)
+
+// ParseActivityBucketSize returns the ActivityBucketSize for a string (day, week or month)
+func ParseActivityBucketSize(s string) (ActivityBucketSize, error) {
+	switch b := ActivityBucketSize(strings.ToLower(strings.TrimSpace(s))); b {
+	case ActivityDay, ActivityWeek, ActivityMonth:
+		return b, nil
+	}
+	return "", fmt.Errorf("invalid activity bucket size '%s' (expected day, week or month)", s)
+}
+
+// ActivityBucket holds the number of status changes in a time interval, in total, by status name and by status source
+type ActivityBucket struct {
+	// Start is the first day of the bucket, formatted as YYYY-MM-DD
+	Start    string           `json:"start"`
+	Total    int64            `json:"total"`
+	Statuses map[string]int64 `json:"statuses"`
+	Sources  map[string]int64 `json:"sources"`
+}
+
+// ActivityStats holds the result of a call to DBManager.Activity. Buckets are sorted by time, and include buckets without any status changes.
+type ActivityStats struct {
+	Lexicon string             `json:"lexicon"`
+	From    string             `json:"from"`
+	To      string             `json:"to"`
+	Bucket  ActivityBucketSize `json:"bucket"`
+	// Statuses and Sources list all status names and status sources found in the buckets, sorted alphabetically
+	Statuses []string         `json:"statuses"`
+	Sources  []string         `json:"sources"`
+	Buckets  []ActivityBucket `json:"buckets"`
+}
+
+// MaxActivityBuckets is the max number of buckets returned by DBManager.Activity (ten years of days)
+const MaxActivityBuckets = 3660
+
+// ActivityRangeError is returned by DBManager.Activity for an invalid interval, or an interval with too many buckets (see MaxActivityBuckets)
+type ActivityRangeError struct {
+	Msg string
+}
+
+func (e *ActivityRangeError) Error() string {
+	return e.Msg
+}
+
+// Activity counts the status changes (entries added, moved between statuses, etc.) in a lexicon, based on the EntryStatus history, grouped by time bucket, status name and status source.
+// The interval is from (inclusive) to to (exclusive), truncated to days. If from is zero, it is set to the day of the first status change in the lexicon. If to is zero, it is set to tomorrow, so that today is included.
+// Returns an *ActivityRangeError if the interval is empty, or has more than MaxActivityBuckets buckets.
+func (dbm *DBManager) Activity(lexRef lex.LexRef, from, to time.Time, bucket ActivityBucketSize) (ActivityStats, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return ActivityStats{}, fmt.Errorf("DBManager.Activity: no such db '%s'", lexRef.DBRef)
+	}
+	res, err := activity(db, lexRef, from, to, bucket)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.Activity: %w", err)
+	}
+	return res, nil
+}
+
+const activityDateFormat = "2006-01-02"
+
+// dbTimestampFormat is the format of DATETIME values in the database (as set by CURRENT_TIMESTAMP)
+const dbTimestampFormat = "2006-01-02 15:04:05"
+
+// parseDBTimestamp parses a DATETIME value, as scanned into a string by the Sqlite or MariaDB driver
+func parseDBTimestamp(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, dbTimestampFormat} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("couldn't parse timestamp '%s'", s)
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// bucketStart returns the start of the bucket containing t
+func bucketStart(t time.Time, bucket ActivityBucketSize) time.Time {
+	t = truncateDay(t)
+	switch bucket {
+	case ActivityWeek:
+		offset := (int(t.Weekday()) + 6) % 7 // days since Monday
+		return t.AddDate(0, 0, -offset)
+	case ActivityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return t
+}
+
+func nextBucket(t time.Time, bucket ActivityBucketSize) time.Time {
+	switch bucket {
+	case ActivityWeek:
+		return t.AddDate(0, 0, 7)
+	case ActivityMonth:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// The SQL for activity stats is the same for Sqlite and MariaDB, so the function below is called directly by DBManager for both db engines. Timestamps are grouped into buckets in Go, since the SQL date functions differ between the engines.
+
+func activity(db *sql.DB, lexRef lex.LexRef, from, to time.Time, bucket ActivityBucketSize) (ActivityStats, error) {
+	res := ActivityStats{Lexicon: lexRef.String(), Bucket: bucket, Statuses: []string{}, Sources: []string{}, Buckets: []ActivityBucket{}}
+	if bucket == "" {
+		bucket = ActivityDay
+		res.Bucket = bucket
+	}
+
+	var lexiconID int64
+	err := db.QueryRow("SELECT id FROM Lexicon WHERE name = ?", string(lexRef.LexName)).Scan(&lexiconID)
+	if err == sql.ErrNoRows {
+		return res, fmt.Errorf("no such lexicon '%s'", lexRef.LexName)
+	}
+	if err != nil {
+		return res, fmt.Errorf("activity failed to get lexicon : %v", err)
+	}
+
+	if from.IsZero() {
+		var first sql.NullString
+		err = db.QueryRow("SELECT MIN(EntryStatus.Timestamp) FROM EntryStatus, Entry WHERE EntryStatus.entryId = Entry.id AND Entry.lexiconId = ?", lexiconID).Scan(&first)
+		if err != nil {
+			return res, fmt.Errorf("activity failed to get first status timestamp : %v", err)
+		}
+		if first.Valid {
+			from, err = parseDBTimestamp(first.String)
+			if err != nil {
+				return res, fmt.Errorf("activity : %v", err)
+			}
+		} else {
+			from = time.Now().UTC()
+		}
+	}
+	if to.IsZero() {
+		to = time.Now().UTC().AddDate(0, 0, 1)
+	}
+	from = truncateDay(from)
+	to = truncateDay(to)
+	if !from.Before(to) {
+		return res, &ActivityRangeError{Msg: fmt.Sprintf("invalid interval: from (%s) must be before to (%s)", from.Format(activityDateFormat), to.Format(activityDateFormat))}
+	}
+	n := 0
+	for t := bucketStart(from, bucket); t.Before(to); t = nextBucket(t, bucket) {
+		n++
+		if n > MaxActivityBuckets {
+			return res, &ActivityRangeError{Msg: fmt.Sprintf("the interval from %s to %s has more than %d buckets of size %s (use a shorter interval, or a larger bucket size)", from.Format(activityDateFormat), to.Format(activityDateFormat), MaxActivityBuckets, bucket)}
+		}
+	}
+	res.From = from.Format(activityDateFormat)
+	res.To = to.Format(activityDateFormat)
+
+	// bucket start => index in res.Buckets
+	buckets := make(map[time.Time]int)
+	for t := bucketStart(from, bucket); t.Before(to); t = nextBucket(t, bucket) {
+		b := ActivityBucket{Start: t.Format(activityDateFormat), Statuses: make(map[string]int64), Sources: make(map[string]int64)}
+		res.Buckets = append(res.Buckets, b)
+		buckets[t] = len(res.Buckets) - 1
+	}
+
+	rows, err := db.Query("SELECT EntryStatus.Timestamp, EntryStatus.name, EntryStatus.source FROM EntryStatus, Entry WHERE EntryStatus.entryId = Entry.id AND Entry.lexiconId = ? AND EntryStatus.Timestamp >= ? AND EntryStatus.Timestamp < ?", lexiconID, from.Format(dbTimestampFormat), to.Format(dbTimestampFormat))
+	if err != nil {
+		return res, fmt.Errorf("activity failed to list status changes : %v", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]bool)
+	sources := make(map[string]bool)
+	for rows.Next() {
+		var ts, name, source string
+		err = rows.Scan(&ts, &name, &source)
+		if err != nil {
+			return res, fmt.Errorf("activity failed to scan row : %v", err)
+		}
+		t, err := parseDBTimestamp(ts)
+		if err != nil {
+			return res, fmt.Errorf("activity : %v", err)
+		}
+		i, ok := buckets[bucketStart(t, bucket)]
+		if !ok {
+			// shouldn't happen, since the timestamps are within the interval
+			continue
+		}
+		b := &res.Buckets[i]
+		b.Total++
+		b.Statuses[name]++
+		b.Sources[source]++
+		statuses[name] = true
+		sources[source] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		return res, fmt.Errorf("activity failed to list status changes : %v", err)
+	}
+
+	for s := range statuses {
+		res.Statuses = append(res.Statuses, s)
+	}
+	sort.Strings(res.Statuses)
+	for s := range sources {
+		res.Sources = append(res.Sources, s)
+	}
+	sort.Strings(res.Sources)
+
+	return res, nil
+}
@@ -0,0 +1,98 @@
+package dbapi
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestActivitySqlite(t *testing.T) {
+	dbRef := lex.DBRef("activity_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	ids, err := dbm.InsertEntries(lexRef, []lex.Entry{
+		newTestEntry("hund", `" h u0 n d`),
+		newTestEntry("katt", `" k a t`),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	// Older status history, added with explicit timestamps: Wed 2020-01-01 and Mon 2020-01-06
+	db := dbm.dbs[dbRef]
+	for _, s := range []struct{ ts, name, source string }{
+		{"2020-01-01 10:00:00", "imported", "nst"},
+		{"2020-01-06 09:00:00", "ok", "anna"},
+		{"2020-01-06 11:00:00", "ok", "anna"},
+	} {
+		_, err = db.Exec("INSERT INTO EntryStatus (entryId, name, source, Timestamp, current) VALUES (?, ?, ?, ?, 0)", ids[0], s.name, s.source, s.ts)
+		if err != nil {
+			t.Fatalf("failed to insert status : %v", err)
+		}
+	}
+
+	from := time.Date(2019, 12, 30, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 1, 13, 0, 0, 0, 0, time.UTC)
+	res, err := dbm.Activity(lexRef, from, to, ActivityWeek)
+	if err != nil {
+		t.Fatalf("activity failed : %v", err)
+	}
+	if len(res.Buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d : %#v", len(res.Buckets), res.Buckets)
+	}
+	if b := res.Buckets[0]; b.Start != "2019-12-30" || b.Total != 1 || b.Sources["nst"] != 1 {
+		t.Errorf("unexpected first bucket : %#v", b)
+	}
+	if b := res.Buckets[1]; b.Start != "2020-01-06" || b.Total != 2 || b.Statuses["ok"] != 2 || b.Sources["anna"] != 2 {
+		t.Errorf("unexpected second bucket : %#v", b)
+	}
+	if len(res.Statuses) != 2 || res.Statuses[0] != "imported" || res.Statuses[1] != "ok" {
+		t.Errorf("unexpected statuses : %v", res.Statuses)
+	}
+
+	res, err = dbm.Activity(lexRef, from, to, ActivityDay)
+	if err != nil {
+		t.Fatalf("activity failed : %v", err)
+	}
+	if len(res.Buckets) != 14 {
+		t.Errorf("expected 14 buckets, got %d", len(res.Buckets))
+	}
+
+	// Default interval: from the first status change, including today's inserts
+	res, err = dbm.Activity(lexRef, time.Time{}, time.Time{}, ActivityMonth)
+	if err != nil {
+		t.Fatalf("activity failed : %v", err)
+	}
+	if res.From != "2020-01-01" || res.Buckets[0].Total != 3 {
+		t.Errorf("unexpected default interval result : %s %#v", res.From, res.Buckets[0])
+	}
+	var total int64
+	for _, b := range res.Buckets {
+		total += b.Total
+	}
+	if total != 5 {
+		t.Errorf("expected 5 status changes in total, got %d", total)
+	}
+
+	var re *ActivityRangeError
+	_, err = dbm.Activity(lexRef, to, from, ActivityDay)
+	if !errors.As(err, &re) {
+		t.Errorf("expected range error for reversed interval, got %v", err)
+	}
+
+	// the number of buckets is limited
+	long := from.AddDate(0, 0, MaxActivityBuckets+1)
+	_, err = dbm.Activity(lexRef, from, long, ActivityDay)
+	if !errors.As(err, &re) {
+		t.Errorf("expected range error for too many buckets, got %v", err)
+	}
+	_, err = dbm.Activity(lexRef, from, long, ActivityMonth)
+	if err != nil {
+		t.Errorf("failed to get activity per month : %v", err)
+	}
+}
@@ -0,0 +1,492 @@
+package dbapi
+
+// Assignments are entries assigned to users (annotators) for review, with an optional due date and priority. Each user has a worklist of assigned entries, with progress counts (see Worklist).
+// An assignment is open until a new entry status is set for the entry (by UpdateEntryAs, MoveNewEntries or ApplyChanges), which closes all open assignments of the entry. An open assignment can also be cancelled.
+// Assignments are stored in the Assignment table of each database. They are local to the database, and not replicated (see ApplyChanges).
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// AssignmentStatus is the status of an Assignment
+type AssignmentStatus string
+
+const (
+	// AssignmentOpen is used for an assignment that has not yet been completed
+	AssignmentOpen AssignmentStatus = "open"
+	// AssignmentDone is used for an assignment that was closed by setting a new entry status
+	AssignmentDone AssignmentStatus = "done"
+	// AssignmentCancelled is used for an assignment that was cancelled before it was completed
+	AssignmentCancelled AssignmentStatus = "cancelled"
+)
+
+// assignCommentLabel is the comment label used for assignments before schema version 3.9, e.g. [assign_to: nisse] (bengt)
+const assignCommentLabel = "assign_to"
+
+// dueDateLayout is the format of assignment due dates
+const dueDateLayout = "2006-01-02"
+
+// Assignment is an entry assigned to a user for review
+type Assignment struct {
+	ID      int64      `json:"id"`
+	LexRef  lex.LexRef `json:"lexRef"`
+	EntryID int64      `json:"entryId"`
+	// Strn is the orthography of the entry
+	Strn       string `json:"strn"`
+	Assignee   string `json:"assignee"`
+	AssignedBy string `json:"assignedBy,omitempty"`
+	// Priority is used to order the worklist; higher values come first
+	Priority int `json:"priority"`
+	// Due is the due date (YYYY-MM-DD), or empty
+	Due     string           `json:"due,omitempty"`
+	Comment string           `json:"comment,omitempty"`
+	Status  AssignmentStatus `json:"status"`
+	Created string           `json:"created"`
+	Closed  string           `json:"closed,omitempty"`
+	// ClosedBy is the source of the entry status that closed the assignment, or the user who cancelled it
+	ClosedBy string `json:"closedBy,omitempty"`
+	// ClosedStatus is the name of the entry status that closed the assignment
+	ClosedStatus string `json:"closedStatus,omitempty"`
+	// Overdue is true for an open assignment past its due date
+	Overdue bool `json:"overdue,omitempty"`
+}
+
+// AssignOptions holds the properties of new assignments (see AssignEntries)
+type AssignOptions struct {
+	Assignee   string
+	AssignedBy string
+	Priority   int
+	// Due is the due date (YYYY-MM-DD), or empty
+	Due     string
+	Comment string
+}
+
+func (o AssignOptions) validate() (AssignOptions, error) {
+	o.Assignee = strings.TrimSpace(o.Assignee)
+	o.AssignedBy = strings.TrimSpace(o.AssignedBy)
+	o.Due = strings.TrimSpace(o.Due)
+	o.Comment = strings.TrimSpace(o.Comment)
+	if o.Assignee == "" {
+		return o, fmt.Errorf("no assignee")
+	}
+	if o.Due != "" {
+		if _, err := time.Parse(dueDateLayout, o.Due); err != nil {
+			return o, fmt.Errorf("invalid due date '%s' (expected YYYY-MM-DD)", o.Due)
+		}
+	}
+	return o, nil
+}
+
+// WorklistProgress holds the number of assignments with each status. Cancelled assignments are not counted.
+type WorklistProgress struct {
+	Total   int `json:"total"`
+	Open    int `json:"open"`
+	Done    int `json:"done"`
+	Overdue int `json:"overdue"`
+}
+
+func (p *WorklistProgress) add(a Assignment) {
+	switch a.Status {
+	case AssignmentOpen:
+		p.Open++
+	case AssignmentDone:
+		p.Done++
+	default:
+		return
+	}
+	p.Total++
+	if a.Overdue {
+		p.Overdue++
+	}
+}
+
+// LexiconProgress is the worklist progress of a user in a lexicon
+type LexiconProgress struct {
+	LexRef lex.LexRef `json:"lexRef"`
+	WorklistProgress
+}
+
+// Worklist lists the assignments of a user, in all databases
+type Worklist struct {
+	Assignee string           `json:"assignee"`
+	Progress WorklistProgress `json:"progress"`
+	// Lexicons holds the progress of each lexicon with assignments for the user
+	Lexicons []LexiconProgress `json:"lexicons"`
+	// Assignments are ordered with open assignments first, by due date (assignments without a due date last), priority and id
+	Assignments []Assignment `json:"assignments"`
+}
+
+const assignmentColumns = "Assignment.id, Assignment.entryId, Lexicon.name, Entry.strn, Assignment.assignee, Assignment.assignedBy, Assignment.priority, Assignment.due, Assignment.comment, Assignment.status, Assignment.created, Assignment.closed, Assignment.closedBy, Assignment.closedStatus"
+
+// listAssignments lists the assignments in the database matching the SQL condition (may be empty), ordered by id
+func listAssignments(db *sql.DB, dbRef lex.DBRef, cond string, args ...interface{}) ([]Assignment, error) {
+	res := []Assignment{}
+	q := "SELECT " + assignmentColumns + " FROM Assignment, Entry, Lexicon WHERE Assignment.entryId = Entry.id AND Entry.lexiconId = Lexicon.id"
+	if cond != "" {
+		q += " AND " + cond
+	}
+	rows, err := db.Query(q+" ORDER BY Assignment.id", args...)
+	if err != nil {
+		return res, fmt.Errorf("failed to list assignments : %v", err)
+	}
+	defer rows.Close()
+	today := time.Now().UTC().Format(dueDateLayout)
+	for rows.Next() {
+		a := Assignment{LexRef: lex.LexRef{DBRef: dbRef}}
+		err = rows.Scan(&a.ID, &a.EntryID, &a.LexRef.LexName, &a.Strn, &a.Assignee, &a.AssignedBy, &a.Priority, &a.Due, &a.Comment, &a.Status, &a.Created, &a.Closed, &a.ClosedBy, &a.ClosedStatus)
+		if err != nil {
+			return res, fmt.Errorf("failed to scan assignment : %v", err)
+		}
+		a.Overdue = a.Status == AssignmentOpen && a.Due != "" && a.Due < today
+		res = append(res, a)
+	}
+	err = rows.Err()
+	if err != nil {
+		return res, fmt.Errorf("failed to list assignments : %v", err)
+	}
+	return res, nil
+}
+
+// insertAssignments assigns the entries (assumed to exist) of a database. Entries already assigned to the assignee (open assignments) are skipped. Returns the new assignments. The caller must hold the DBManager write lock.
+func insertAssignments(db *sql.DB, dbRef lex.DBRef, entryIDs []int64, o AssignOptions) ([]Assignment, error) {
+	res := []Assignment{}
+	open, err := listAssignments(db, dbRef, "Assignment.assignee = ? AND Assignment.status = ?", o.Assignee, AssignmentOpen)
+	if err != nil {
+		return res, err
+	}
+	skip := make(map[int64]bool)
+	for _, a := range open {
+		skip[a.EntryID] = true
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return res, fmt.Errorf("failed to start db transaction : %v", err)
+	}
+	defer tx.Rollback()
+	ts := time.Now().UTC().Format(time.RFC3339)
+	ids := []int64{}
+	for _, id := range entryIDs {
+		if skip[id] {
+			continue
+		}
+		skip[id] = true
+		r, err := tx.Exec("INSERT INTO Assignment (entryId, assignee, assignedBy, priority, due, comment, status, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", id, o.Assignee, o.AssignedBy, o.Priority, o.Due, o.Comment, AssignmentOpen, ts)
+		if err != nil {
+			return res, fmt.Errorf("failed to insert assignment : %v", err)
+		}
+		aID, err := r.LastInsertId()
+		if err != nil {
+			return res, fmt.Errorf("failed to get assignment id : %v", err)
+		}
+		ids = append(ids, aID)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return res, fmt.Errorf("failed to commit transaction : %v", err)
+	}
+	if len(ids) == 0 {
+		return res, nil
+	}
+	// the caller holds the DBManager write lock, so the new assignments have consecutive ids
+	return listAssignments(db, dbRef, "Assignment.id BETWEEN ? AND ?", ids[0], ids[len(ids)-1])
+}
+
+// AssignEntries assigns entries of a lexicon to a user. Entries that are already assigned to the user (open assignments) are skipped. Returns the new assignments.
+func (dbm *DBManager) AssignEntries(lexRef lex.LexRef, entryIDs []int64, opts AssignOptions) ([]Assignment, error) {
+	opts, err := opts.validate()
+	if err != nil {
+		return []Assignment{}, fmt.Errorf("DBManager.AssignEntries: %v", err)
+	}
+	if len(entryIDs) == 0 {
+		return []Assignment{}, fmt.Errorf("DBManager.AssignEntries: no entry ids")
+	}
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return []Assignment{}, fmt.Errorf("DBManager.AssignEntries: no such db '%s'", lexRef.DBRef)
+	}
+	found, err := dbm.dbifOf(db).lookUpIds(db, []lex.LexName{lexRef.LexName}, Query{EntryIDs: entryIDs})
+	if err != nil {
+		return []Assignment{}, fmt.Errorf("DBManager.AssignEntries: %v", err)
+	}
+	if len(found) != len(uniqueIDs(entryIDs)) {
+		return []Assignment{}, fmt.Errorf("DBManager.AssignEntries: no entry with id %v in lexicon '%s'", missingIDs(entryIDs, found), lexRef)
+	}
+	res, err := insertAssignments(db, lexRef.DBRef, entryIDs, opts)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.AssignEntries: %v", err)
+	}
+	return res, nil
+}
+
+// AssignQuery assigns the entries matching a query to a user (see AssignEntries). The query must not be empty; use e.g. Query{WordLike: "%"} to assign all entries of a lexicon.
+func (dbm *DBManager) AssignQuery(q DBMQuery, opts AssignOptions) ([]Assignment, error) {
+	res := []Assignment{}
+	opts, err := opts.validate()
+	if err != nil {
+		return res, fmt.Errorf("DBManager.AssignQuery: %v", err)
+	}
+	if len(q.LexRefs) == 0 {
+		return res, fmt.Errorf("DBManager.AssignQuery: no lexicons")
+	}
+	if q.Query.Empty() {
+		return res, fmt.Errorf("DBManager.AssignQuery: empty query")
+	}
+	dbz := make(map[lex.DBRef][]lex.LexName)
+	for _, l := range q.LexRefs {
+		dbz[l.DBRef] = append(dbz[l.DBRef], l.LexName)
+	}
+
+	dbm.Lock()
+	defer dbm.Unlock()
+	for dbRef, lexNames := range dbz {
+		db, ok := dbm.dbs[dbRef]
+		if !ok {
+			return res, fmt.Errorf("DBManager.AssignQuery: no such db '%s'", dbRef)
+		}
+		ids, err := dbm.dbifOf(db).lookUpIds(db, lexNames, q.Query)
+		if err != nil {
+			return res, fmt.Errorf("DBManager.AssignQuery: %v", err)
+		}
+		as, err := insertAssignments(db, dbRef, ids, opts)
+		if err != nil {
+			return res, fmt.Errorf("DBManager.AssignQuery: %v", err)
+		}
+		res = append(res, as...)
+	}
+	return res, nil
+}
+
+// Worklist returns the assignments of a user in all databases, with progress counts. If status is not empty, only assignments with the status are listed (the progress counts include all assignments).
+func (dbm *DBManager) Worklist(assignee string, status AssignmentStatus) (Worklist, error) {
+	assignee = strings.TrimSpace(assignee)
+	res := Worklist{Assignee: assignee, Lexicons: []LexiconProgress{}, Assignments: []Assignment{}}
+	if assignee == "" {
+		return res, fmt.Errorf("DBManager.Worklist: no assignee")
+	}
+
+	dbm.RLock()
+	defer dbm.RUnlock()
+	dbRefs := []lex.DBRef{}
+	for dbRef := range dbm.dbs {
+		dbRefs = append(dbRefs, dbRef)
+	}
+	sort.Slice(dbRefs, func(i, j int) bool { return dbRefs[i] < dbRefs[j] })
+	lexicons := make(map[lex.LexRef]int) // index in res.Lexicons
+	for _, dbRef := range dbRefs {
+		as, err := listAssignments(dbm.dbs[dbRef], dbRef, "Assignment.assignee = ?", assignee)
+		if err != nil {
+			return res, fmt.Errorf("DBManager.Worklist: %v", err)
+		}
+		for _, a := range as {
+			res.Progress.add(a)
+			if a.Status != AssignmentCancelled {
+				i, ok := lexicons[a.LexRef]
+				if !ok {
+					i = len(res.Lexicons)
+					lexicons[a.LexRef] = i
+					res.Lexicons = append(res.Lexicons, LexiconProgress{LexRef: a.LexRef})
+				}
+				res.Lexicons[i].add(a)
+			}
+			if status == "" || a.Status == status {
+				res.Assignments = append(res.Assignments, a)
+			}
+		}
+	}
+	sort.SliceStable(res.Assignments, func(i, j int) bool {
+		a, b := res.Assignments[i], res.Assignments[j]
+		if (a.Status == AssignmentOpen) != (b.Status == AssignmentOpen) {
+			return a.Status == AssignmentOpen
+		}
+		if a.Due != b.Due {
+			return b.Due == "" || (a.Due != "" && a.Due < b.Due)
+		}
+		return a.Priority > b.Priority
+	})
+	return res, nil
+}
+
+// CancelAssignment cancels an open assignment in the lexicon, on behalf of a user
+func (dbm *DBManager) CancelAssignment(lexRef lex.LexRef, id int64, user string) (Assignment, error) {
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return Assignment{}, fmt.Errorf("DBManager.CancelAssignment: no such db '%s'", lexRef.DBRef)
+	}
+	as, err := listAssignments(db, lexRef.DBRef, "Assignment.id = ? AND Lexicon.name = ?", id, string(lexRef.LexName))
+	if err != nil {
+		return Assignment{}, fmt.Errorf("DBManager.CancelAssignment: %v", err)
+	}
+	if len(as) != 1 {
+		return Assignment{}, fmt.Errorf("DBManager.CancelAssignment: no assignment with id %d in lexicon '%s'", id, lexRef)
+	}
+	if as[0].Status != AssignmentOpen {
+		return as[0], fmt.Errorf("DBManager.CancelAssignment: assignment %d is already %s", id, as[0].Status)
+	}
+	_, err = db.Exec("UPDATE Assignment SET status = ?, closed = ?, closedBy = ? WHERE id = ?", AssignmentCancelled, time.Now().UTC().Format(time.RFC3339), strings.TrimSpace(user), id)
+	if err != nil {
+		return as[0], fmt.Errorf("DBManager.CancelAssignment: failed to update assignment : %v", err)
+	}
+	as, err = listAssignments(db, lexRef.DBRef, "Assignment.id = ?", id)
+	if err != nil || len(as) != 1 {
+		return Assignment{}, fmt.Errorf("DBManager.CancelAssignment: failed to reload assignment %d : %v", id, err)
+	}
+	return as[0], nil
+}
+
+// closeAssignmentsTx closes the open assignments of the entries matching the condition on Assignment.entryId (e.g., "= ?", or "IN (SELECT ...)"), when a new entry status is set. It is called in the transaction setting the status, so that the assignments are closed if, and only if, the status is saved.
+func closeAssignmentsTx(tx *sql.Tx, status lex.EntryStatus, entryIDCond string, args ...any) error {
+	args = append([]any{AssignmentDone, time.Now().UTC().Format(time.RFC3339), status.Source, status.Name, AssignmentOpen}, args...)
+	_, err := tx.Exec("UPDATE Assignment SET status = ?, closed = ?, closedBy = ?, closedStatus = ? WHERE status = ? AND entryId "+entryIDCond, args...)
+	if err != nil {
+		return fmt.Errorf("failed to close assignments : %v", err)
+	}
+	return nil
+}
+
+// ConvertAssignComments converts entry comments with the label assign_to (e.g. [assign_to: nisse] (bengt)) into open assignments, and removes the comments. The comment text is used as assignee, and the comment source as the user who made the assignment. Comments without text are kept. Returns the number of converted comments.
+// Databases are converted when migrated from a schema version before 3.9 (see Migrate), so this is only needed for comments added later, e.g. by importing old lexicon files.
+func (dbm *DBManager) ConvertAssignComments(dbRef lex.DBRef) (int, error) {
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return 0, fmt.Errorf("DBManager.ConvertAssignComments: no such db '%s'", dbRef)
+	}
+	entries := make(map[lex.LexName][]int64)
+	rows, err := db.Query("SELECT DISTINCT Lexicon.name, Entry.id FROM EntryComment, Entry, Lexicon WHERE EntryComment.entryId = Entry.id AND Entry.lexiconId = Lexicon.id AND lower(EntryComment.label) = ? AND trim(EntryComment.comment) <> ''", assignCommentLabel)
+	if err != nil {
+		return 0, fmt.Errorf("DBManager.ConvertAssignComments: failed to list comments : %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lexName lex.LexName
+		var id int64
+		err = rows.Scan(&lexName, &id)
+		if err != nil {
+			return 0, fmt.Errorf("DBManager.ConvertAssignComments: failed to scan comment : %v", err)
+		}
+		entries[lexName] = append(entries[lexName], id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return 0, fmt.Errorf("DBManager.ConvertAssignComments: failed to list comments : %v", err)
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	n, err := convertAssignComments(db)
+	if err != nil {
+		return n, fmt.Errorf("DBManager.ConvertAssignComments: %w", err)
+	}
+	for lexName, ids := range entries {
+		lexRef := lex.LexRef{DBRef: dbRef, LexName: lexName}
+		dbm.invalidateLexicons(lexRef)
+		err = dbm.recordChanges(lexRef, ChangeUpdate, ids...)
+		if err != nil {
+			return n, fmt.Errorf("DBManager.ConvertAssignComments: %v", err)
+		}
+	}
+	return n, nil
+}
+
+// convertAssignComments implements ConvertAssignComments, in a single transaction
+func convertAssignComments(db *sql.DB) (int, error) {
+	type assignComment struct {
+		id, entryID          int64
+		assignee, assignedBy string
+	}
+	cs := []assignComment{}
+	rows, err := db.Query("SELECT id, entryId, comment, source FROM EntryComment WHERE lower(label) = ? AND trim(comment) <> '' ORDER BY id", assignCommentLabel)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list assign comments : %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c assignComment
+		var source sql.NullString
+		err = rows.Scan(&c.id, &c.entryID, &c.assignee, &source)
+		if err != nil {
+			return 0, fmt.Errorf("failed to scan assign comment : %v", err)
+		}
+		c.assignee = strings.TrimSpace(c.assignee)
+		c.assignedBy = strings.TrimSpace(source.String)
+		cs = append(cs, c)
+	}
+	err = rows.Err()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list assign comments : %v", err)
+	}
+	if len(cs) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start db transaction : %v", err)
+	}
+	defer tx.Rollback()
+	entryIDs := []int64{}
+	for _, c := range cs {
+		entryIDs = append(entryIDs, c.entryID)
+	}
+	err = checkEntriesNotFrozen(tx, uniqueIDs(entryIDs))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().UTC().Format(time.RFC3339)
+	for _, c := range cs {
+		_, err = tx.Exec("INSERT INTO Assignment (entryId, assignee, assignedBy, status, created) VALUES (?, ?, ?, ?, ?)", c.entryID, c.assignee, c.assignedBy, AssignmentOpen, ts)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert assignment : %v", err)
+		}
+		_, err = tx.Exec("DELETE FROM EntryComment WHERE id = ?", c.id)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete assign comment : %v", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit transaction : %v", err)
+	}
+	return len(cs), nil
+}
+
+// uniqueIDs returns the ids without duplicates
+func uniqueIDs(ids []int64) []int64 {
+	res := []int64{}
+	seen := make(map[int64]bool)
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// missingIDs returns the ids not in found
+func missingIDs(ids, found []int64) []int64 {
+	res := []int64{}
+	seen := make(map[int64]bool)
+	for _, id := range found {
+		seen[id] = true
+	}
+	for _, id := range uniqueIDs(ids) {
+		if !seen[id] {
+			res = append(res, id)
+		}
+	}
+	return res
+}
@@ -0,0 +1,210 @@
+package dbapi
+
+import (
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestAssignmentsSqlite(t *testing.T) {
+	dbRef := lex.DBRef("assignment_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	kattEntry := newTestEntry("katt", `" k a t`)
+	kattEntry.Comments = []lex.EntryComment{{Label: "assign_to", Comment: "bertil", Source: "anna"}, {Label: "other", Comment: "a cat", Source: "anna"}}
+	ids, err := dbm.InsertEntries(lexRef, []lex.Entry{
+		newTestEntry("hund", `" h u0 n d`),
+		newTestEntry("hundar", `" h u0 n . d a r`),
+		kattEntry,
+	})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	hund, hundar, katt := ids[0], ids[1], ids[2]
+
+	_, err = dbm.AssignEntries(lexRef, []int64{hund}, AssignOptions{Assignee: "anna", Due: "tomorrow"})
+	if err == nil {
+		t.Errorf("expected error for invalid due date")
+	}
+	_, err = dbm.AssignEntries(lexRef, []int64{hund, 4711}, AssignOptions{Assignee: "anna"})
+	if err == nil {
+		t.Errorf("expected error for unknown entry")
+	}
+	as, err := dbm.AssignEntries(lexRef, []int64{hund}, AssignOptions{Assignee: "anna", AssignedBy: "bertil", Due: "2000-01-01", Priority: 1})
+	if err != nil {
+		t.Fatalf("failed to assign entry : %v", err)
+	}
+	if len(as) != 1 || as[0].Strn != "hund" || as[0].Status != AssignmentOpen || !as[0].Overdue || as[0].AssignedBy != "bertil" {
+		t.Errorf("unexpected assignments : %#v", as)
+	}
+
+	// entries already assigned to the user are skipped
+	as, err = dbm.AssignQuery(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{WordLike: "hund%"}}, AssignOptions{Assignee: "anna", Priority: 2})
+	if err != nil {
+		t.Fatalf("failed to assign query : %v", err)
+	}
+	if len(as) != 1 || as[0].EntryID != hundar || as[0].Overdue {
+		t.Errorf("unexpected assignments : %#v", as)
+	}
+
+	wl, err := dbm.Worklist("anna", "")
+	if err != nil {
+		t.Fatalf("failed to get worklist : %v", err)
+	}
+	if wl.Progress != (WorklistProgress{Total: 2, Open: 2, Overdue: 1}) || len(wl.Lexicons) != 1 || wl.Lexicons[0].Open != 2 {
+		t.Errorf("unexpected worklist progress : %#v", wl)
+	}
+	// assignments with a due date come first
+	if len(wl.Assignments) != 2 || wl.Assignments[0].EntryID != hund || wl.Assignments[1].EntryID != hundar {
+		t.Errorf("unexpected worklist assignments : %#v", wl.Assignments)
+	}
+
+	// setting a new entry status closes the assignments of the entry
+	es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{EntryIDs: []int64{hund}}})
+	if err != nil || len(es) != 1 {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	e := es[0]
+	e.EntryStatus = lex.EntryStatus{Name: "ok", Source: "anna"}
+	_, _, err = dbm.UpdateEntry(e)
+	if err != nil {
+		t.Fatalf("failed to update entry : %v", err)
+	}
+	wl, err = dbm.Worklist("anna", AssignmentDone)
+	if err != nil {
+		t.Fatalf("failed to get worklist : %v", err)
+	}
+	if wl.Progress != (WorklistProgress{Total: 2, Open: 1, Done: 1}) {
+		t.Errorf("unexpected worklist progress : %#v", wl.Progress)
+	}
+	if len(wl.Assignments) != 1 || wl.Assignments[0].EntryID != hund || wl.Assignments[0].ClosedStatus != "ok" || wl.Assignments[0].ClosedBy != "anna" || wl.Assignments[0].Closed == "" {
+		t.Errorf("unexpected worklist assignments : %#v", wl.Assignments)
+	}
+
+	// an update without a new status doesn't close the assignment
+	es, err = dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{EntryIDs: []int64{hundar}}})
+	if err != nil || len(es) != 1 {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	e = es[0]
+	e.PartOfSpeech = "NN"
+	e.EntryStatus = lex.EntryStatus{}
+	_, _, err = dbm.UpdateEntry(e)
+	if err != nil {
+		t.Fatalf("failed to update entry : %v", err)
+	}
+	wl, err = dbm.Worklist("anna", AssignmentOpen)
+	if err != nil {
+		t.Fatalf("failed to get worklist : %v", err)
+	}
+	if len(wl.Assignments) != 1 || wl.Assignments[0].EntryID != hundar {
+		t.Fatalf("unexpected worklist assignments : %#v", wl.Assignments)
+	}
+
+	a, err := dbm.CancelAssignment(lexRef, wl.Assignments[0].ID, "bertil")
+	if err != nil {
+		t.Fatalf("failed to cancel assignment : %v", err)
+	}
+	if a.Status != AssignmentCancelled || a.ClosedBy != "bertil" {
+		t.Errorf("unexpected assignment : %#v", a)
+	}
+	_, err = dbm.CancelAssignment(lexRef, a.ID, "bertil")
+	if err == nil {
+		t.Errorf("expected error for cancelled assignment")
+	}
+	wl, err = dbm.Worklist("anna", "")
+	if err != nil {
+		t.Fatalf("failed to get worklist : %v", err)
+	}
+	if wl.Progress != (WorklistProgress{Total: 1, Done: 1}) || len(wl.Assignments) != 2 {
+		t.Errorf("unexpected worklist : %#v", wl)
+	}
+
+	// assign_to comments are converted into assignments
+	n, err := dbm.ConvertAssignComments(dbRef)
+	if err != nil {
+		t.Fatalf("failed to convert assign comments : %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 converted comment, got %d", n)
+	}
+	wl, err = dbm.Worklist("bertil", "")
+	if err != nil {
+		t.Fatalf("failed to get worklist : %v", err)
+	}
+	if len(wl.Assignments) != 1 || wl.Assignments[0].EntryID != katt || wl.Assignments[0].AssignedBy != "anna" || wl.Assignments[0].Status != AssignmentOpen {
+		t.Errorf("unexpected worklist assignments : %#v", wl.Assignments)
+	}
+	es, err = dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{EntryIDs: []int64{katt}}})
+	if err != nil || len(es) != 1 {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	if len(es[0].Comments) != 1 || es[0].Comments[0].Label != "other" {
+		t.Errorf("expected assign_to comment to be removed, got %#v", es[0].Comments)
+	}
+
+	// moving entries with a new status closes their assignments
+	newLexRef := lex.NewLexRef(string(dbRef), "lex2")
+	defineTestLexicons(t, dbm, newLexRef)
+	newIDs, err := dbm.InsertEntries(newLexRef, []lex.Entry{newTestEntry("häst", `" h E s t`), newTestEntry("hund", `" h u0 n d`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	_, err = dbm.AssignEntries(newLexRef, newIDs, AssignOptions{Assignee: "cecilia"})
+	if err != nil {
+		t.Fatalf("failed to assign entries : %v", err)
+	}
+	_, err = dbm.MoveNewEntries(dbRef, newLexRef.LexName, lexRef.LexName, "mover", "moved")
+	if err != nil {
+		t.Fatalf("failed to move entries : %v", err)
+	}
+	wl, err = dbm.Worklist("cecilia", "")
+	if err != nil {
+		t.Fatalf("failed to get worklist : %v", err)
+	}
+	if wl.Progress != (WorklistProgress{Total: 2, Open: 1, Done: 1}) {
+		t.Errorf("unexpected worklist progress : %#v", wl.Progress)
+	}
+	for _, a := range wl.Assignments {
+		if a.EntryID == newIDs[0] && (a.Status != AssignmentDone || a.ClosedStatus != "moved" || a.ClosedBy != "mover") {
+			t.Errorf("expected the assignment of the moved entry to be closed, got %#v", a)
+		}
+		// hund is already in lex1, so it is not moved
+		if a.EntryID == newIDs[1] && a.Status != AssignmentOpen {
+			t.Errorf("expected the assignment of the entry that was not moved to be open, got %#v", a)
+		}
+	}
+
+	// if the assignments cannot be closed, the new status is not saved
+	_, err = dbm.dbs[dbRef].Exec(`CREATE TRIGGER failAssignment BEFORE UPDATE ON Assignment WHEN NEW.closedStatus = 'fail' BEGIN SELECT RAISE(ABORT, 'closing failed'); END`)
+	if err != nil {
+		t.Fatalf("failed to create trigger : %v", err)
+	}
+	es, err = dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{newLexRef}, Query: Query{EntryIDs: []int64{newIDs[1]}}})
+	if err != nil || len(es) != 1 {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	e = es[0]
+	e.EntryStatus = lex.EntryStatus{Name: "fail", Source: "cecilia"}
+	_, _, err = dbm.UpdateEntry(e)
+	if err == nil {
+		t.Errorf("expected error for failed closing of assignments")
+	}
+	es, err = dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{newLexRef}, Query: Query{EntryIDs: []int64{newIDs[1]}}})
+	if err != nil || len(es) != 1 {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	if es[0].EntryStatus.Name != "imported" {
+		t.Errorf("expected status imported after a failed update, got %#v", es[0].EntryStatus)
+	}
+	wl, err = dbm.Worklist("cecilia", AssignmentOpen)
+	if err != nil {
+		t.Fatalf("failed to get worklist : %v", err)
+	}
+	if len(wl.Assignments) != 1 || wl.Assignments[0].EntryID != newIDs[1] {
+		t.Errorf("expected the assignment to be open after a failed update, got %#v", wl.Assignments)
+	}
+}
@@ -0,0 +1,109 @@
+package dbapi
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// Backup writes a gzip compressed backup of a database to w. For Sqlite, the backup is a copy of the database file, created using the Sqlite online backup API. For MariaDB, the backup is an SQL dump (one INSERT statement per line), read in a single consistent snapshot transaction.
+// In both cases, the backup is consistent even if the database is written to while the backup is running, and writes are not blocked by the backup.
+// The backup can be restored using Restore.
+func (dbm *DBManager) Backup(dbRef lex.DBRef, w io.Writer) error {
+	// The db manager lock is only held while getting the db, so that other db calls are not blocked while the backup is running
+	dbm.RLock()
+	db, ok := dbm.dbs[dbRef]
+	dbif := dbm.dbifOf(db)
+	dbm.RUnlock()
+	if !ok {
+		return fmt.Errorf("DBManager.Backup: no such db '%s'", dbRef)
+	}
+
+	gz := gzip.NewWriter(w)
+	err := dbif.backup(db, gz)
+	if err != nil {
+		return fmt.Errorf("DBManager.Backup: %v", err)
+	}
+	err = gz.Close()
+	if err != nil {
+		return fmt.Errorf("DBManager.Backup: couldn't close gzip writer : %v", err)
+	}
+	return nil
+}
+
+// BackupFileName returns a suitable file name for a backup of the db, as created by Backup (<db_name>.db.gz for Sqlite; <db_name>.sql.gz for MariaDB)
+func (dbm *DBManager) BackupFileName(dbRef lex.DBRef) string {
+	dbm.RLock()
+	engine := dbm.dbifOf(dbm.dbs[dbRef]).engine()
+	dbm.RUnlock()
+	if engine == MariaDB {
+		return string(dbRef) + ".sql.gz"
+	}
+	return string(dbRef) + ".db.gz"
+}
+
+// Restore reads a backup created by Backup from r, and restores it into a new database, which is then opened. The database must not already exist (for MariaDB, the database may exist, but must not contain any tables; see DBExists).
+// The schema version of the backup must be compatible with SchemaVersion. Backups from older schema versions are migrated after restore (see Migrate).
+func (dbm *DBManager) Restore(dbLocation string, dbRef lex.DBRef, r io.Reader) error {
+	name := string(dbRef)
+	if name == "" {
+		return fmt.Errorf("DBManager.Restore: illegal argument: name must not be empty")
+	}
+	if strings.Contains(name, ":") {
+		return fmt.Errorf("DBManager.Restore: illegal argument: name must not contain ':'")
+	}
+
+	exists, err := dbm.dbifAt(dbLocation).dbExists(dbLocation, dbRef)
+	if err != nil {
+		return fmt.Errorf("DBManager.Restore: %v", err)
+	}
+	if exists {
+		return fmt.Errorf("DBManager.Restore: db already exists: '%s'", name)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("DBManager.Restore: couldn't read gzip input : %v", err)
+	}
+	defer gz.Close()
+
+	err = dbm.dbifAt(dbLocation).restore(dbLocation, dbRef, gz)
+	if err != nil {
+		return fmt.Errorf("DBManager.Restore: %v", err)
+	}
+
+	err = dbm.OpenDB(dbLocation, dbRef)
+	if err != nil {
+		return fmt.Errorf("DBManager.Restore: failed to open restored db : %v", err)
+	}
+
+	report, err := dbm.Migrate(dbRef)
+	if err != nil {
+		return fmt.Errorf("DBManager.Restore: failed to migrate restored db : %v", err)
+	}
+	if len(report.Collisions) > 0 {
+		log.Printf("DBManager.Restore: %d normalisation collisions found when migrating db '%s'", len(report.Collisions), dbRef)
+	}
+	return nil
+}
+
+// compatibleSchemaVersion returns an error if a schema version (of a backup, or a database to migrate) doesn't have the same major version (the part before the first dot) as SchemaVersion, or if it is newer than SchemaVersion
+func compatibleSchemaVersion(version string) error {
+	if version == "" {
+		return fmt.Errorf("no schema version found")
+	}
+	major := func(v string) string {
+		return strings.SplitN(strings.TrimSpace(v), ".", 2)[0]
+	}
+	if major(version) != major(SchemaVersion) {
+		return fmt.Errorf("mismatching schema versions. Found: %s, dbapi.SchemaVersion: %s", version, SchemaVersion)
+	}
+	if version != SchemaVersion && !schemaVersionBefore(version, SchemaVersion) {
+		return fmt.Errorf("schema version %s is newer than dbapi.SchemaVersion %s", version, SchemaVersion)
+	}
+	return nil
+}
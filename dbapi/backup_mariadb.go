@@ -0,0 +1,220 @@
+package dbapi
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// mariaDBBackupTables lists the tables included in a MariaDB backup, in an order that satisfies the foreign key constraints on restore. The SchemaVersion table is not included, since it is created by the schema on restore.
+var mariaDBBackupTables = []string{"Lexicon", "Lemma", "Entry", "EntryTag", "EntryComment", "EntryValidation", "EntryStatus", "Transcription", "Lemma2Entry", "EntryRelation", "LexiconMeta", "LexiconProperty", "EntryUsage", "MissedWord", "ChangeEvent", "ReplicationState", "EntryProposal", "Assignment", "ImportBatch", "ImportBatchEntry", "FrozenLexicon"}
+
+const mariaDBBackupHeader = "-- pronlex backup; engine: mariadb; schema version: "
+
+// mariaDBBackupBatchSize is the max number of rows per INSERT statement in a backup
+const mariaDBBackupBatchSize = 500
+
+// backup writes an SQL dump of the database to w: a header line with the schema version, followed by one INSERT statement per line.
+// All tables are read in a single read only transaction with repeatable read isolation, so that the dump is a consistent snapshot, without locking the tables.
+func (mdb mariaDBIF) backup(db *sql.DB, w io.Writer) error {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("dbapi_mariadb: failed to start db transaction : %v", err)
+	}
+	defer tx.Rollback()
+
+	version, err := mdb.getSchemaVersionTx(tx)
+	if err != nil {
+		return fmt.Errorf("dbapi_mariadb: %v", err)
+	}
+
+	bw := bufio.NewWriter(w)
+	_, err = bw.WriteString(mariaDBBackupHeader + version + "\n")
+	if err != nil {
+		return fmt.Errorf("dbapi_mariadb: couldn't write backup : %v", err)
+	}
+	for _, table := range mariaDBBackupTables {
+		err = dumpMariaDBTable(tx, table, bw)
+		if err != nil {
+			return fmt.Errorf("dbapi_mariadb: couldn't dump table %s : %v", table, err)
+		}
+	}
+	err = bw.Flush()
+	if err != nil {
+		return fmt.Errorf("dbapi_mariadb: couldn't write backup : %v", err)
+	}
+	return nil
+}
+
+func dumpMariaDBTable(tx *sql.Tx, table string, w *bufio.Writer) error {
+	rows, err := tx.Query("SELECT * FROM " + table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", table, strings.Join(cols, ", "))
+
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+
+	n := 0
+	for rows.Next() {
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			w.WriteString(insert)
+		} else {
+			w.WriteString(", ")
+		}
+		literals := make([]string, len(vals))
+		for i, v := range vals {
+			literals[i], err = mariaDBLiteral(v)
+			if err != nil {
+				return fmt.Errorf("column %s : %v", cols[i], err)
+			}
+		}
+		w.WriteString("(" + strings.Join(literals, ", ") + ")")
+		n++
+		if n == mariaDBBackupBatchSize {
+			w.WriteString(";\n")
+			n = 0
+		}
+	}
+	if n > 0 {
+		w.WriteString(";\n")
+	}
+	return rows.Err()
+}
+
+// mariaDBLiteral formats a scanned value as an SQL literal. Line breaks are escaped, so that each statement is kept on a single line.
+func mariaDBLiteral(v any) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "NULL", nil
+	case []byte:
+		return mariaDBQuote(string(x)), nil
+	case string:
+		return mariaDBQuote(x), nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case uint64:
+		return strconv.FormatUint(x, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64), nil
+	case bool:
+		if x {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return mariaDBQuote(x.Format(dbTimestampFormat)), nil
+	}
+	return "", fmt.Errorf("unsupported value type %T", v)
+}
+
+var mariaDBEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\x00", `\0`, "\x1a", `\Z`)
+
+func mariaDBQuote(s string) string {
+	return "'" + mariaDBEscaper.Replace(s) + "'"
+}
+
+// restore defines the database schema for dbRef, and executes the statements in the SQL dump in r in a single transaction. If the restore fails, the tables are dropped again.
+func (mdb mariaDBIF) restore(dbLocation string, dbRef lex.DBRef, r io.Reader) error {
+	br := bufio.NewReader(r)
+	header, err := br.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("dbapi_mariadb: couldn't read backup header : %v", err)
+	}
+	if !strings.HasPrefix(header, mariaDBBackupHeader) {
+		return fmt.Errorf("dbapi_mariadb: invalid backup : missing header")
+	}
+	version := strings.TrimPrefix(strings.TrimSpace(header), mariaDBBackupHeader)
+	err = compatibleSchemaVersion(version)
+	if err != nil {
+		return fmt.Errorf("dbapi_mariadb: invalid backup : %v", err)
+	}
+
+	err = mdb.defineDB(dbLocation, dbRef)
+	if err != nil {
+		return fmt.Errorf("dbapi_mariadb: %v", err)
+	}
+	err = mdb.restoreTables(dbLocation, dbRef, version, br)
+	if err != nil {
+		msg := fmt.Sprintf("dbapi_mariadb: %v", err)
+		err2 := mdb.dropDB(dbLocation, dbRef)
+		if err2 != nil {
+			msg = fmt.Sprintf("%s : failed to drop db : %v", msg, err2)
+		}
+		return errors.New(msg)
+	}
+	return nil
+}
+
+func (mdb mariaDBIF) restoreTables(dbLocation string, dbRef lex.DBRef, version string, br *bufio.Reader) error {
+	db, err := mdb.openDB(dbLocation, dbRef)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start db transaction : %v", err)
+	}
+	rollback := func(msg string) error {
+		err := tx.Rollback()
+		if err != nil {
+			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err)
+		}
+		return errors.New(msg)
+	}
+
+	for lineNo := 2; ; lineNo++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return rollback(fmt.Sprintf("couldn't read backup : %v", err))
+		}
+		stmt := strings.TrimSpace(line)
+		if stmt != "" && !strings.HasPrefix(stmt, "--") {
+			_, err2 := tx.Exec(stmt)
+			if err2 != nil {
+				return rollback(fmt.Sprintf("failed to restore line %d : %v", lineNo, err2))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	// The schema is defined using the current SchemaVersion, but the data is from the backup's version, and may need to be migrated (see DBManager.Migrate)
+	_, err = tx.Exec("UPDATE SchemaVersion SET name = ?", version)
+	if err != nil {
+		return rollback(fmt.Sprintf("failed to set schema version : %v", err))
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit restore : %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package dbapi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// backup copies the database to a temporary file using the Sqlite online backup API, and then writes the temporary file to w.
+// The copy is made in a single step, holding a read transaction on the source db. Since the db is in WAL mode, writers are not blocked by the copy.
+func (sdb sqliteDBIF) backup(db *sql.DB, w io.Writer) error {
+	tmp, err := os.CreateTemp("", "pronlex-backup-*.db")
+	if err != nil {
+		return fmt.Errorf("dbapi_sqlite: couldn't create temporary backup file : %v", err)
+	}
+	tmpPath := tmp.Name()
+	defer removeSqliteFiles(tmpPath)
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("dbapi_sqlite: couldn't close temporary backup file : %v", err)
+	}
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("dbapi_sqlite: couldn't get db connection : %v", err)
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(driverConn any) error {
+		src, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection type %T", driverConn)
+		}
+		destConn, err := (&sqlite3.SQLiteDriver{}).Open(tmpPath)
+		if err != nil {
+			return fmt.Errorf("couldn't open temporary backup file : %v", err)
+		}
+		dest := destConn.(*sqlite3.SQLiteConn)
+		defer dest.Close()
+
+		bk, err := dest.Backup("main", src, "main")
+		if err != nil {
+			return fmt.Errorf("couldn't initialize backup : %v", err)
+		}
+		done, err := bk.Step(-1)
+		if err != nil {
+			msg := fmt.Sprintf("backup failed : %v", err)
+			err2 := bk.Finish()
+			if err2 != nil {
+				msg = fmt.Sprintf("%s : failed to finish backup : %v", msg, err2)
+			}
+			return errors.New(msg)
+		}
+		if !done {
+			bk.Finish()
+			return fmt.Errorf("backup failed : backup was not completed")
+		}
+		return bk.Finish()
+	})
+	if err != nil {
+		return fmt.Errorf("dbapi_sqlite: %v", err)
+	}
+
+	fh, err := os.Open(tmpPath)
+	if err != nil {
+		return fmt.Errorf("dbapi_sqlite: couldn't open temporary backup file : %v", err)
+	}
+	defer fh.Close()
+	_, err = io.Copy(w, fh)
+	if err != nil {
+		return fmt.Errorf("dbapi_sqlite: couldn't write backup : %v", err)
+	}
+	return nil
+}
+
+// restore writes the backup in r to a temporary file in dbLocation, checks its integrity and schema version, and then renames it to the db file for dbRef
+func (sdb sqliteDBIF) restore(dbLocation string, dbRef lex.DBRef, r io.Reader) error {
+	dbPath := filepath.Join(dbLocation, string(dbRef)+".db")
+
+	// the temporary file name must not end with .db, since it would then be listed as a database in dbLocation
+	tmp, err := os.CreateTemp(dbLocation, "."+string(dbRef)+".db.restore-*")
+	if err != nil {
+		return fmt.Errorf("dbapi_sqlite: couldn't create temporary restore file : %v", err)
+	}
+	tmpPath := tmp.Name()
+	defer removeSqliteFiles(tmpPath)
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("dbapi_sqlite: couldn't read backup : %v", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("dbapi_sqlite: couldn't close temporary restore file : %v", err)
+	}
+
+	err = sdb.checkRestoredDB(tmpPath)
+	if err != nil {
+		return fmt.Errorf("dbapi_sqlite: invalid backup : %v", err)
+	}
+
+	err = os.Rename(tmpPath, dbPath)
+	if err != nil {
+		return fmt.Errorf("dbapi_sqlite: couldn't move restored db to %s : %v", dbPath, err)
+	}
+	return nil
+}
+
+func (sdb sqliteDBIF) checkRestoredDB(dbPath string) error {
+	db, err := sql.Open("sqlite3_with_regexp", dbPath)
+	if err != nil {
+		return fmt.Errorf("couldn't open db : %v", err)
+	}
+	defer db.Close()
+
+	var check string
+	err = db.QueryRow("PRAGMA integrity_check").Scan(&check)
+	if err != nil {
+		return fmt.Errorf("integrity check failed : %v", err)
+	}
+	if check != "ok" {
+		return fmt.Errorf("integrity check failed : %s", check)
+	}
+
+	var version string
+	err = db.QueryRow("SELECT name FROM SchemaVersion").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("couldn't retrieve schema version : %v", err)
+	}
+	return compatibleSchemaVersion(version)
+}
+
+// removeSqliteFiles removes a (temporary) db file, along with any WAL files
+func removeSqliteFiles(dbPath string) {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		os.Remove(dbPath + suffix)
+	}
+}
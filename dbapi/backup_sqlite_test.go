@@ -0,0 +1,92 @@
+package dbapi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestBackupRestoreSqlite(t *testing.T) {
+	dbRef := lex.DBRef("backup_test")
+	restoredRef := lex.DBRef("backup_test_restored")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+	err := dbm.DropDB(".", restoredRef)
+	if err != nil {
+		t.Fatalf("failed to drop db : %v", err)
+	}
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	_, err = dbm.InsertEntries(lexRef, []lex.Entry{
+		newTestEntry("hund", `" h u0 n d`),
+		newTestEntry("katt", `" k a t`),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = dbm.Backup(dbRef, &buf)
+	if err != nil {
+		t.Fatalf("backup failed : %v", err)
+	}
+	backup := buf.Bytes()
+
+	// Changes after the backup should not be included in the restored db
+	_, err = dbm.InsertEntries(lexRef, []lex.Entry{newTestEntry("räv", `" r E: v`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	err = dbm.Restore(".", restoredRef, bytes.NewReader(backup))
+	if err != nil {
+		t.Fatalf("restore failed : %v", err)
+	}
+	defer dbm.DropDB(".", restoredRef)
+	defer dbm.CloseDB(restoredRef)
+
+	n, err := dbm.EntryCount(lex.NewLexRef(string(restoredRef), "lex1"))
+	if err != nil {
+		t.Fatalf("failed to count entries : %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 entries in restored db, got %d", n)
+	}
+	report, err := dbm.CheckIntegrity(restoredRef)
+	if err != nil {
+		t.Fatalf("integrity check failed : %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("expected restored db to pass integrity check : %#v", report)
+	}
+
+	// Restoring into an existing db is not allowed
+	err = dbm.Restore(".", dbRef, bytes.NewReader(backup))
+	if err == nil {
+		t.Errorf("expected error when restoring into an existing db")
+	}
+
+	// Incompatible schema versions: another major version, or a newer minor version
+	for _, version := range []string{"1.0", newerSchemaVersion(t)} {
+		_, err = dbm.dbs[dbRef].Exec("UPDATE SchemaVersion SET name = ?", version)
+		if err != nil {
+			t.Fatalf("failed to update schema version : %v", err)
+		}
+		buf.Reset()
+		err = dbm.Backup(dbRef, &buf)
+		if err != nil {
+			t.Fatalf("backup failed : %v", err)
+		}
+		err = dbm.Restore(".", lex.DBRef("backup_test_invalid"), &buf)
+		if err == nil {
+			dbm.CloseDB(lex.DBRef("backup_test_invalid"))
+			dbm.DropDB(".", lex.DBRef("backup_test_invalid"))
+			t.Errorf("expected error when restoring a backup with schema version %s", version)
+		}
+		if exists, _ := dbm.DBExists(".", lex.DBRef("backup_test_invalid")); exists {
+			t.Errorf("expected no db to be created for a backup with schema version %s", version)
+		}
+	}
+}
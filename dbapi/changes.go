@@ -0,0 +1,327 @@
+package dbapi
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// The change feed lets other services follow modifications to the lexicons, instead of polling.
+// Each modification made through the DBManager is saved as a ChangeEvent in the ChangeEvent table of the database, and published to all subscribers (see SubscribeChanges).
+// Events are numbered by a sequence number (ChangeEvent.Seq) that increases for each event in a database. A subscriber that has been disconnected can resume by listing the events after the last sequence number it received (see ListChanges). Old events can be deleted using PruneChanges.
+//
+// Bulk modifications (imports, MoveNewEntries, symbol set conversion and validation) are saved as a single ChangeLexicon event, rather than one event per entry. Changes made by RepairIntegrity and Migrate, and changes made to the database by other means than the DBManager, are not included in the feed.
+
+// ChangeType is the type of a ChangeEvent
+type ChangeType string
+
+const (
+	// ChangeInsert is used for a new entry
+	ChangeInsert ChangeType = "insert"
+	// ChangeUpdate is used for an updated entry (including updated validation results, and added, changed or deleted entry relations)
+	ChangeUpdate ChangeType = "update"
+	// ChangeStatus is used for an entry with a new entry status. An entry update that sets a new status generates both a ChangeUpdate and a ChangeStatus event.
+	ChangeStatus ChangeType = "status"
+	// ChangeDelete is used for a deleted entry
+	ChangeDelete ChangeType = "delete"
+	// ChangeLexiconCreated is used for a new lexicon
+	ChangeLexiconCreated ChangeType = "lexicon_created"
+	// ChangeLexiconDeleted is used for a deleted lexicon
+	ChangeLexiconDeleted ChangeType = "lexicon_deleted"
+	// ChangeLexicon is used for bulk modifications of a lexicon, that may affect any number of entries. Subscribers that keep a copy of the lexicon should reload it.
+	ChangeLexicon ChangeType = "lexicon_changed"
+)
+
+// ChangeEvent is a modification of a lexicon. For entry events, EntryID is the id of the entry; for lexicon events, it is 0.
+type ChangeEvent struct {
+	Seq       int64      `json:"seq"`
+	LexRef    lex.LexRef `json:"lexRef"`
+	Type      ChangeType `json:"type"`
+	EntryID   int64      `json:"entryId,omitempty"`
+	Timestamp string     `json:"timestamp"`
+}
+
+// ChangeSubscription receives change events published by a DBManager (see DBManager.SubscribeChanges)
+type ChangeSubscription struct {
+	// Events receives the change events for all databases, in sequence order for each database. The channel is closed when the subscription is cancelled, or if the subscriber doesn't keep up with the events (in which case it should resume using DBManager.ListChanges).
+	Events <-chan ChangeEvent
+	events chan ChangeEvent
+	feed   *changeFeed
+}
+
+// Cancel stops the subscription, and closes the Events channel
+func (s *ChangeSubscription) Cancel() {
+	s.feed.mutex.Lock()
+	defer s.feed.mutex.Unlock()
+	s.feed.unsubscribe(s)
+}
+
+type changeFeed struct {
+	// mutex is held while saving and publishing events, so that events are published in sequence order
+	mutex       sync.Mutex
+	subscribers map[*ChangeSubscription]bool
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{subscribers: make(map[*ChangeSubscription]bool)}
+}
+
+// unsubscribe removes a subscriber. The caller must hold the feed mutex.
+func (f *changeFeed) unsubscribe(s *ChangeSubscription) {
+	if f.subscribers[s] {
+		delete(f.subscribers, s)
+		close(s.events)
+	}
+}
+
+// SubscribeChanges returns a subscription for change events published after the call. The bufferSize is the number of events that may be waiting for the subscriber, before it is disconnected.
+func (dbm *DBManager) SubscribeChanges(bufferSize int) *ChangeSubscription {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	ch := make(chan ChangeEvent, bufferSize)
+	s := &ChangeSubscription{Events: ch, events: ch, feed: dbm.changeFeed}
+	dbm.changeFeed.mutex.Lock()
+	defer dbm.changeFeed.mutex.Unlock()
+	dbm.changeFeed.subscribers[s] = true
+	return s
+}
+
+// ListChanges returns the change events in the database with a sequence number greater than since, in sequence order. If lexNames is non-empty, only events for these lexicons are included. If limit is greater than 0, at most limit events are returned.
+func (dbm *DBManager) ListChanges(dbRef lex.DBRef, since int64, lexNames []lex.LexName, limit int) ([]ChangeEvent, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return []ChangeEvent{}, fmt.Errorf("DBManager.ListChanges: no such db '%s'", dbRef)
+	}
+	res, err := listChanges(db, dbRef, since, lexNames, limit)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.ListChanges: %v", err)
+	}
+	return res, nil
+}
+
+// LastChange returns the sequence number of the most recent change event in the database, or 0 if there are no events
+func (dbm *DBManager) LastChange(dbRef lex.DBRef) (int64, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return 0, fmt.Errorf("DBManager.LastChange: no such db '%s'", dbRef)
+	}
+	var res sql.NullInt64
+	err := db.QueryRow("SELECT MAX(seq) FROM ChangeEvent").Scan(&res)
+	if err != nil {
+		return 0, fmt.Errorf("DBManager.LastChange: failed to list change events : %v", err)
+	}
+	return res.Int64, nil
+}
+
+func listChanges(db *sql.DB, dbRef lex.DBRef, since int64, lexNames []lex.LexName, limit int) ([]ChangeEvent, error) {
+	res := []ChangeEvent{}
+	q := "SELECT seq, lexiconName, type, entryId, timestamp FROM ChangeEvent WHERE seq > ?"
+	args := []any{since}
+	if len(lexNames) > 0 {
+		q += " AND lexiconName IN " + nQs(len(lexNames))
+		for _, l := range lexNames {
+			args = append(args, strings.ToLower(string(l)))
+		}
+	}
+	q += " ORDER BY seq"
+	if limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return res, fmt.Errorf("failed to list change events : %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e ChangeEvent
+		var lexName, changeType string
+		var entryID sql.NullInt64
+		err = rows.Scan(&e.Seq, &lexName, &changeType, &entryID, &e.Timestamp)
+		if err != nil {
+			return res, fmt.Errorf("failed to scan change event : %v", err)
+		}
+		e.LexRef = lex.LexRef{DBRef: dbRef, LexName: lex.LexName(lexName)}
+		e.Type = ChangeType(changeType)
+		e.EntryID = entryID.Int64
+		res = append(res, e)
+	}
+	err = rows.Err()
+	if err != nil {
+		return res, fmt.Errorf("failed to list change events : %v", err)
+	}
+	return res, nil
+}
+
+// PruneChanges deletes the change events saved before the specified time, except for the most recent event, which is kept so that the sequence number of the database can still be read (see InitReplication). Returns the number of deleted events.
+// Subscribers and replicas that are behind the pruned events can no longer resume from their position (see ExportChanges), so the retention period should be longer than the interval of any replica.
+func (dbm *DBManager) PruneChanges(dbRef lex.DBRef, before time.Time) (int64, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return 0, fmt.Errorf("DBManager.PruneChanges: no such db '%s'", dbRef)
+	}
+	f := dbm.changeFeed
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var last sql.NullInt64
+	err := db.QueryRow("SELECT MAX(seq) FROM ChangeEvent").Scan(&last)
+	if err != nil {
+		return 0, fmt.Errorf("DBManager.PruneChanges: failed to list change events : %v", err)
+	}
+	if !last.Valid {
+		return 0, nil
+	}
+	res, err := db.Exec("DELETE FROM ChangeEvent WHERE seq < ? AND timestamp < ?", last.Int64, before.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, fmt.Errorf("DBManager.PruneChanges: failed to delete change events : %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("DBManager.PruneChanges: %v", err)
+	}
+	return n, nil
+}
+
+// changeEvents returns change events of the given type for the lexicon: one event per entry id, or a single lexicon event if there are no entry ids. The sequence numbers are set when the events are saved.
+func changeEvents(lexRef lex.LexRef, changeType ChangeType, entryIDs ...int64) []ChangeEvent {
+	lexRef = lex.LexRef{DBRef: lexRef.DBRef, LexName: lex.LexName(strings.ToLower(string(lexRef.LexName)))}
+	ts := time.Now().UTC().Format(time.RFC3339)
+	es := []ChangeEvent{}
+	if len(entryIDs) == 0 {
+		es = append(es, ChangeEvent{LexRef: lexRef, Type: changeType, Timestamp: ts})
+	}
+	for _, id := range entryIDs {
+		es = append(es, ChangeEvent{LexRef: lexRef, Type: changeType, EntryID: id, Timestamp: ts})
+	}
+	return es
+}
+
+// recordChanges saves change events of the given type for the lexicon (see changeEvents), and publishes them to the subscribers.
+// It is called after a modification has been committed. If the events cannot be saved, the error is returned, so that the caller can report that the modification is missing from the change feed (and from the replicas following it, see ExportChanges). The caller must hold the DBManager lock (read or write).
+func (dbm *DBManager) recordChanges(lexRef lex.LexRef, changeType ChangeType, entryIDs ...int64) error {
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return fmt.Errorf("failed to save change events : no such db '%s'", lexRef.DBRef)
+	}
+	es := changeEvents(lexRef, changeType, entryIDs...)
+
+	f := dbm.changeFeed
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save change events for %s : failed to start db transaction : %v", lexRef, err)
+	}
+	es, err = insertChangesTx(tx, es)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("failed to save change events for %s : %v", lexRef, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to save change events for %s : failed to commit : %v", lexRef, err)
+	}
+	f.publish(es)
+	return nil
+}
+
+// publish sends saved change events to the subscribers. The caller must hold the feed mutex.
+func (f *changeFeed) publish(es []ChangeEvent) {
+	for _, e := range es {
+		for s := range f.subscribers {
+			select {
+			case s.events <- e:
+			default:
+				log.Printf("DBManager: change feed subscriber is not keeping up, disconnecting")
+				f.unsubscribe(s)
+			}
+		}
+	}
+}
+
+// publishChanges sends change events saved using insertChangesTx to the subscribers, after the transaction has been committed
+func (dbm *DBManager) publishChanges(es []ChangeEvent) {
+	f := dbm.changeFeed
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.publish(es)
+}
+
+// insertChangesTx saves change events in a transaction, and returns them with their sequence numbers set
+func insertChangesTx(tx *sql.Tx, es []ChangeEvent) ([]ChangeEvent, error) {
+	for i, e := range es {
+		var entryID any
+		if e.EntryID != 0 {
+			entryID = e.EntryID
+		}
+		res, err := tx.Exec("INSERT INTO ChangeEvent (lexiconName, type, entryId, timestamp) VALUES (?, ?, ?, ?)", string(e.LexRef.LexName), string(e.Type), entryID, e.Timestamp)
+		if err != nil {
+			return es, fmt.Errorf("failed to insert change event : %v", err)
+		}
+		es[i].Seq, err = res.LastInsertId()
+		if err != nil {
+			return es, fmt.Errorf("failed to get change event sequence number : %v", err)
+		}
+	}
+	return es, nil
+}
+
+// recordEntryChanges saves and publishes change events of the given type for entries identified by id only (e.g., the entries of an entry relation), which may belong to different lexicons (see recordChanges). The caller must hold the DBManager lock (read or write).
+func (dbm *DBManager) recordEntryChanges(dbRef lex.DBRef, changeType ChangeType, entryIDs ...int64) error {
+	db, ok := dbm.dbs[dbRef]
+	if !ok || len(entryIDs) == 0 {
+		return nil
+	}
+	rows, err := db.Query("SELECT Entry.id, Lexicon.name FROM Entry, Lexicon WHERE Entry.lexiconId = Lexicon.id AND Entry.id IN "+nQs(len(entryIDs)), convI(entryIDs)...)
+	if err != nil {
+		return fmt.Errorf("failed to save change events for %s : %v", dbRef, err)
+	}
+	lexNames := []string{}
+	ids := make(map[string][]int64)
+	for rows.Next() {
+		var id int64
+		var lexName string
+		err = rows.Scan(&id, &lexName)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to save change events for %s : %v", dbRef, err)
+		}
+		if _, ok := ids[lexName]; !ok {
+			lexNames = append(lexNames, lexName)
+		}
+		ids[lexName] = append(ids[lexName], id)
+	}
+	rows.Close()
+	for _, lexName := range lexNames {
+		err = dbm.recordChanges(lex.LexRef{DBRef: dbRef, LexName: lex.LexName(lexName)}, changeType, ids[lexName]...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relationEntryIDs returns the ids of the entries of an entry relation
+func relationEntryIDs(db *sql.DB, relationID int64) ([]int64, error) {
+	var from, to int64
+	err := db.QueryRow("SELECT fromEntryId, toEntryId FROM EntryRelation WHERE id = ?", relationID).Scan(&from, &to)
+	if err == sql.ErrNoRows {
+		return []int64{}, nil
+	}
+	if err != nil {
+		return []int64{}, err
+	}
+	return []int64{from, to}, nil
+}
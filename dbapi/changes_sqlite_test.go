@@ -0,0 +1,153 @@
+package dbapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestChangeFeedSqlite(t *testing.T) {
+	dbRef := lex.DBRef("changes_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	sub := dbm.SubscribeChanges(100)
+	defer sub.Cancel()
+
+	lexRef1 := lex.NewLexRef(string(dbRef), "lex1")
+	lexRef2 := lex.NewLexRef(string(dbRef), "lex2")
+	defineTestLexicons(t, dbm, lexRef1, lexRef2)
+	ids, err := dbm.InsertEntries(lexRef1, []lex.Entry{newTestEntry("hund", `" h u0 n d`), newTestEntry("katt", `" h u0 n d`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	_, err = dbm.InsertEntries(lexRef2, []lex.Entry{newTestEntry("räv", `" h u0 n d`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef1}, Query: Query{EntryIDs: []int64{ids[0]}}})
+	if err != nil || len(es) != 1 {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	e := es[0]
+	e.Transcriptions[0].Strn = `" h u0 n t`
+	e.EntryStatus = lex.EntryStatus{Name: "ok", Source: "tester"}
+	_, _, err = dbm.UpdateEntry(e)
+	if err != nil {
+		t.Fatalf("failed to update entry : %v", err)
+	}
+	_, err = dbm.DeleteEntry(ids[1], lexRef1)
+	if err != nil {
+		t.Fatalf("failed to delete entry : %v", err)
+	}
+
+	type event struct {
+		lexName lex.LexName
+		t       ChangeType
+		id      int64
+	}
+	expect := []event{
+		{"lex1", ChangeLexiconCreated, 0},
+		{"lex2", ChangeLexiconCreated, 0},
+		{"lex1", ChangeInsert, ids[0]},
+		{"lex1", ChangeInsert, ids[1]},
+		{"lex2", ChangeInsert, 0}, // id not checked
+		{"lex1", ChangeUpdate, ids[0]},
+		{"lex1", ChangeStatus, ids[0]},
+		{"lex1", ChangeDelete, ids[1]},
+	}
+	check := func(src string, es []ChangeEvent, expect []event, firstSeq int64) {
+		t.Helper()
+		if len(es) != len(expect) {
+			t.Fatalf("%s: expected %d events, got %d : %#v", src, len(expect), len(es), es)
+		}
+		for i, e := range es {
+			x := expect[i]
+			if e.LexRef.DBRef != dbRef || e.LexRef.LexName != x.lexName || e.Type != x.t || (x.id != 0 && e.EntryID != x.id) {
+				t.Errorf("%s: expected event %#v at %d, got %#v", src, x, i, e)
+			}
+			if e.Seq != firstSeq+int64(i) {
+				t.Errorf("%s: expected seq %d, got %d", src, firstSeq+int64(i), e.Seq)
+			}
+		}
+	}
+
+	published := []ChangeEvent{}
+	for range expect {
+		published = append(published, <-sub.Events)
+	}
+	check("published", published, expect, 1)
+
+	listed, err := dbm.ListChanges(dbRef, 0, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to list changes : %v", err)
+	}
+	check("listed", listed, expect, 1)
+
+	// resume after seq 5, only lex1
+	listed, err = dbm.ListChanges(dbRef, 5, []lex.LexName{"LEX1"}, 2)
+	if err != nil {
+		t.Fatalf("failed to list changes : %v", err)
+	}
+	check("resumed", listed, expect[5:7], 6)
+
+	sub.Cancel()
+	if _, ok := <-sub.Events; ok {
+		t.Errorf("expected closed channel after cancel")
+	}
+
+	// a subscriber that doesn't keep up is disconnected
+	slow := dbm.SubscribeChanges(1)
+	_, err = dbm.InsertEntries(lexRef1, []lex.Entry{newTestEntry("mus", `" h u0 n d`), newTestEntry("råtta", `" h u0 n d`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	n := 0
+	for range slow.Events {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("expected 1 event before disconnect, got %d", n)
+	}
+
+	// all events but the last are pruned
+	listed, err = dbm.ListChanges(dbRef, 0, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to list changes : %v", err)
+	}
+	last := listed[len(listed)-1].Seq
+	pruned, err := dbm.PruneChanges(dbRef, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to prune changes : %v", err)
+	}
+	if w, g := int64(len(listed)-1), pruned; w != g {
+		t.Errorf("expected %d pruned events, got %d", w, g)
+	}
+	listed, err = dbm.ListChanges(dbRef, 0, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to list changes : %v", err)
+	}
+	if len(listed) != 1 || listed[0].Seq != last {
+		t.Errorf("expected only event %d after pruning, got %#v", last, listed)
+	}
+	_, err = dbm.ExportChanges(dbRef, 0, 0)
+	if err == nil {
+		t.Errorf("expected error when exporting pruned changes")
+	}
+	_, err = dbm.ExportChanges(dbRef, last-1, 0)
+	if err != nil {
+		t.Errorf("failed to export changes after pruning : %v", err)
+	}
+
+	// a modification that cannot be saved in the change feed is reported
+	_, err = dbm.dbs[dbRef].Exec("DROP TABLE ChangeEvent")
+	if err != nil {
+		t.Fatalf("failed to drop table : %v", err)
+	}
+	_, err = dbm.InsertEntries(lexRef1, []lex.Entry{newTestEntry("älg", `" h u0 n d`)})
+	if err == nil {
+		t.Errorf("expected error for insert without change event")
+	}
+}
@@ -0,0 +1,76 @@
+package dbapi
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// dbLocations holds the engine of each database location added using DBManager.AddDBLocation
+type dbLocations struct {
+	mutex sync.RWMutex
+	dbifs map[string]DBIF
+}
+
+func newDBLocations() *dbLocations {
+	return &dbLocations{dbifs: make(map[string]DBIF)}
+}
+
+func dbifForEngine(engine DBEngine) (DBIF, error) {
+	switch engine {
+	case Sqlite:
+		return sqliteDBIF{}, nil
+	case MariaDB:
+		return mariaDBIF{}, nil
+	default:
+		return nil, fmt.Errorf("unknown db engine: %s", engine.String())
+	}
+}
+
+// AddDBLocation sets the engine of a database location (for Sqlite, a folder with database files; for MariaDB, a server DSN), so that a single DBManager can serve databases using different engines. Locations that are not added use the engine of the DBManager (see NewDBManager).
+// The location should be added before any databases are loaded from it (see FirstTimePopulateDBCache), defined, or restored.
+func (dbm *DBManager) AddDBLocation(engine DBEngine, dbLocation string) error {
+	dbif, err := dbifForEngine(engine)
+	if err != nil {
+		return fmt.Errorf("DBManager.AddDBLocation: %v", err)
+	}
+	dbm.locations.mutex.Lock()
+	defer dbm.locations.mutex.Unlock()
+	if d, ok := dbm.locations.dbifs[dbLocation]; ok && d.engine() != engine {
+		return fmt.Errorf("DBManager.AddDBLocation: location already added with engine %s", d.engine())
+	}
+	dbm.locations.dbifs[dbLocation] = dbif
+	return nil
+}
+
+// dbifAt returns the DBIF for the engine of a database location (see AddDBLocation)
+func (dbm *DBManager) dbifAt(dbLocation string) DBIF {
+	dbm.locations.mutex.RLock()
+	defer dbm.locations.mutex.RUnlock()
+	if dbif, ok := dbm.locations.dbifs[dbLocation]; ok {
+		return dbif
+	}
+	return dbm.dbif
+}
+
+// dbifOf returns the DBIF for the engine of an opened database. The caller must hold the DBManager lock.
+func (dbm *DBManager) dbifOf(db *sql.DB) DBIF {
+	if dbif, ok := dbm.dbifs[db]; ok {
+		return dbif
+	}
+	return dbm.dbif
+}
+
+// DBLocation returns the location a database was opened from (see OpenDB), and its engine
+func (dbm *DBManager) DBLocation(dbRef lex.DBRef) (string, DBEngine, bool) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return "", dbm.dbif.engine(), false
+	}
+	loc, ok := dbm.dbLocations[dbRef]
+	return loc, dbm.dbifOf(db).engine(), ok
+}
@@ -0,0 +1,62 @@
+package dbapi
+
+import (
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestDBLocationsSqlite(t *testing.T) {
+	dbRef1 := lex.DBRef("location_test1")
+	dbRef2 := lex.DBRef("location_test2")
+	dbm := createTestSqliteDBManager(t, dbRef1)
+	defer dbm.CloseDB(dbRef1)
+
+	dir := t.TempDir()
+	err := dbm.AddDBLocation(Sqlite, dir)
+	if err != nil {
+		t.Fatalf("failed to add db location : %v", err)
+	}
+	err = dbm.AddDBLocation(MariaDB, dir)
+	if err == nil {
+		t.Errorf("expected error when adding location with another engine")
+	}
+
+	err = dbm.DefineDB(dir, dbRef2)
+	if err != nil {
+		t.Fatalf("failed to define db : %v", err)
+	}
+	defer dbm.CloseDB(dbRef2)
+
+	loc, engine, ok := dbm.DBLocation(dbRef1)
+	if !ok || loc != "." || engine != Sqlite {
+		t.Errorf("expected location '.' (%s), got '%s' (%s)", Sqlite, loc, engine)
+	}
+	loc, engine, ok = dbm.DBLocation(dbRef2)
+	if !ok || loc != dir || engine != Sqlite {
+		t.Errorf("expected location '%s' (%s), got '%s' (%s)", dir, Sqlite, loc, engine)
+	}
+	if _, _, ok := dbm.DBLocation("location_test_missing"); ok {
+		t.Errorf("expected no location for missing db")
+	}
+
+	// both databases are loaded from their own location
+	dbm2 := NewSqliteDBManager()
+	err = dbm2.AddDBLocation(Sqlite, dir)
+	if err != nil {
+		t.Fatalf("failed to add db location : %v", err)
+	}
+	for _, l := range []string{".", dir} {
+		err = dbm2.FirstTimePopulateDBCache(l)
+		if err != nil {
+			t.Fatalf("failed to populate db cache from %s : %v", l, err)
+		}
+	}
+	defer dbm2.CloseDB(dbRef1)
+	defer dbm2.CloseDB(dbRef2)
+	for _, dbRef := range []lex.DBRef{dbRef1, dbRef2} {
+		if !dbm2.ContainsDB(dbRef) {
+			t.Errorf("expected db %s to be loaded", dbRef)
+		}
+	}
+}
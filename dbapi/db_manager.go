@@ -1,6 +1,7 @@
 package dbapi
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -8,9 +9,11 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/stts-se/pronlex/lex"
 	"github.com/stts-se/pronlex/validation"
+	"github.com/stts-se/symbolset/mapper"
 )
 
 // DBManager is used by external services (i.e., lexserver) to cache sql database instances along with their names
@@ -19,6 +22,36 @@ type DBManager struct {
 	dbs          map[lex.DBRef]*sql.DB
 	dbif         DBIF
 	MaxOpenConns int
+
+	// lexicon stacks are not stored in any db, since they may span several dbs (see SetLexiconStackFile)
+	stackMutex *sync.RWMutex
+	stacks     map[string]LexiconStack
+	stackFile  string
+
+	// validators bound to lexicons (see BindValidator)
+	validatorMutex *sync.RWMutex
+	validators     map[lex.LexRef]validation.Validator
+
+	// lookup result cache, nil if disabled (see SetLookupCacheSize)
+	lookupCache *lookupCache
+
+	// subscribers to change events (see SubscribeChanges)
+	changeFeed *changeFeed
+
+	// advisory entry locks (see LockEntry)
+	entryLocks *entryLocks
+
+	// lookup latency per database (see LookupLatencies)
+	lookupLatencies *lookupLatencies
+
+	// context of the calls made on behalf of a request, nil if none (see WithContext)
+	ctx context.Context
+
+	// engines of the database locations added using AddDBLocation
+	locations *dbLocations
+	// engine and location of each opened database, if other than the default engine
+	dbifs       map[*sql.DB]DBIF
+	dbLocations map[lex.DBRef]string
 }
 
 func (dbm DBManager) Engine() DBEngine {
@@ -52,12 +85,12 @@ func NewDBManager(engine DBEngine) (*DBManager, error) {
 
 // NewSqliteDBManager creates a new DBManager instance with empty cache
 func NewSqliteDBManager() *DBManager {
-	return &DBManager{mutex: &sync.RWMutex{}, dbs: make(map[lex.DBRef]*sql.DB), dbif: sqliteDBIF{}}
+	return &DBManager{mutex: &sync.RWMutex{}, dbs: make(map[lex.DBRef]*sql.DB), dbif: sqliteDBIF{}, stackMutex: &sync.RWMutex{}, stacks: make(map[string]LexiconStack), validatorMutex: &sync.RWMutex{}, validators: make(map[lex.LexRef]validation.Validator), changeFeed: newChangeFeed(), entryLocks: newEntryLocks(), lookupLatencies: newLookupLatencies(), locations: newDBLocations(), dbifs: make(map[*sql.DB]DBIF), dbLocations: make(map[lex.DBRef]string)}
 }
 
 // NewMariaDBManager creates a new DBManager instance with empty cache
 func NewMariaDBManager() *DBManager {
-	return &DBManager{mutex: &sync.RWMutex{}, dbs: make(map[lex.DBRef]*sql.DB), dbif: mariaDBIF{}}
+	return &DBManager{mutex: &sync.RWMutex{}, dbs: make(map[lex.DBRef]*sql.DB), dbif: mariaDBIF{}, stackMutex: &sync.RWMutex{}, stacks: make(map[string]LexiconStack), validatorMutex: &sync.RWMutex{}, validators: make(map[lex.LexRef]validation.Validator), changeFeed: newChangeFeed(), entryLocks: newEntryLocks(), lookupLatencies: newLookupLatencies(), locations: newDBLocations(), dbifs: make(map[*sql.DB]DBIF), dbLocations: make(map[lex.DBRef]string)}
 }
 
 // CloseDB is used to close the specified database
@@ -68,6 +101,7 @@ func (dbm *DBManager) CloseDB(dbRef lex.DBRef) error {
 	if !ok {
 		return fmt.Errorf("DBManager.CloseDB: no such db '%s'", dbRef)
 	}
+	dbm.invalidateDB(dbRef)
 	err := db.Close()
 	if err != nil {
 		return fmt.Errorf("DBManager.CloseDB: couldn't close '%s'", dbRef)
@@ -76,12 +110,12 @@ func (dbm *DBManager) CloseDB(dbRef lex.DBRef) error {
 	return err
 }
 
-// FirstTimePopulateDBCache reads all available dbs into the database cache
+// FirstTimePopulateDBCache reads all available dbs at a location into the database cache. The dbs are opened using the engine of the location (see AddDBLocation).
 func (dbm *DBManager) FirstTimePopulateDBCache(dbLocation string) error {
 	var err error // återanvänds för alla fel
 
 	log.Print("db_manager: loading dbs from location ", dbLocation)
-	dbs, err := dbm.dbif.listLexiconDatabases(dbLocation)
+	dbs, err := dbm.dbifAt(dbLocation).listLexiconDatabases(dbLocation)
 	if err != nil {
 		return fmt.Errorf("couldn't open db file area: %v", err)
 	}
@@ -117,7 +151,7 @@ func (dbm *DBManager) DefineDB(dbLocation string, dbRef lex.DBRef) error {
 	// 	return fmt.Errorf("DBManager.DefineDB: no such db '%s'", dbRef)
 	// }
 
-	err := dbm.dbif.defineDB(dbLocation, dbRef)
+	err := dbm.dbifAt(dbLocation).defineDB(dbLocation, dbRef)
 	if err != nil {
 		msg := fmt.Sprintf("DBManager.DefineDB: failed to define db : %v", err)
 		return errors.New(msg)
@@ -153,7 +187,7 @@ func (dbm *DBManager) OpenDB(dbLocation string, dbRef lex.DBRef) error {
 		return fmt.Errorf("DBManager.OpenDB: db is already loaded: '%s'", name)
 	}
 
-	db, err := dbm.dbif.openDB(dbLocation, dbRef)
+	db, err := dbm.dbifAt(dbLocation).openDB(dbLocation, dbRef)
 
 	if err != nil {
 		return fmt.Errorf("DBManager.OpenDB: couldn't open db : %v", err)
@@ -163,7 +197,12 @@ func (dbm *DBManager) OpenDB(dbLocation string, dbRef lex.DBRef) error {
 		db.SetMaxOpenConns(dbm.MaxOpenConns)
 	}
 
+	dbm.invalidateDB(dbRef)
 	dbm.dbs[dbRef] = db
+	dbm.dbLocations[dbRef] = dbLocation
+	if dbif := dbm.dbifAt(dbLocation); dbif.engine() != dbm.dbif.engine() {
+		dbm.dbifs[db] = dbif
+	}
 
 	return nil
 }
@@ -188,6 +227,7 @@ func (dbm *DBManager) AddDB(dbRef lex.DBRef, db *sql.DB) error {
 		return fmt.Errorf("DBManager.AddDB: db already exists: '%s'", name)
 	}
 
+	dbm.invalidateDB(dbRef)
 	dbm.dbs[dbRef] = db
 
 	return nil
@@ -203,6 +243,9 @@ func (dbm *DBManager) RemoveDB(dbRef lex.DBRef) error {
 		return fmt.Errorf("DBManager.RemoveDB: no such db '%s'", name)
 	}
 
+	dbm.invalidateDB(dbRef)
+	delete(dbm.dbifs, dbm.dbs[dbRef])
+	delete(dbm.dbLocations, dbRef)
 	delete(dbm.dbs, dbRef)
 
 	return nil
@@ -255,6 +298,7 @@ func (dbm *DBManager) ListDBNames() ([]lex.DBRef, error) {
 
 // DeleteLexicon deletes the lexicon from the associated lexicon
 // database. Returns an error if the lexicon doesn't exist,  or if the lexicon is not empty.
+// The lexicon is removed from any lexicon stacks (see DefineLexiconStack).
 func (dbm *DBManager) DeleteLexicon(lexRef lex.LexRef) error {
 	dbm.Lock()
 	defer dbm.Unlock()
@@ -264,9 +308,19 @@ func (dbm *DBManager) DeleteLexicon(lexRef lex.LexRef) error {
 		return fmt.Errorf("DBManager.DeleteLexicon: no such db '%s'", lexRef.DBRef)
 	}
 
-	err := dbm.dbif.deleteLexicon(db, string(lexRef.LexName))
+	dbm.invalidateLexicons(lexRef)
+	err := dbm.dbifOf(db).deleteLexicon(db, string(lexRef.LexName))
+	if err != nil {
+		return fmt.Errorf("DBManager.DeleteLexicon: couldn't delete '%s' : %w", lexRef, err)
+	}
+	dbm.UnbindValidator(lexRef)
+	err = dbm.removeFromLexiconStacks(func(ref lex.LexRef) bool { return ref == lexRef })
+	if err != nil {
+		return fmt.Errorf("DBManager.DeleteLexicon: %v", err)
+	}
+	err = dbm.recordChanges(lexRef, ChangeLexiconDeleted)
 	if err != nil {
-		return fmt.Errorf("DBManager.DeleteLexicon: couldn't delete '%s' : %v", lexRef, err)
+		return fmt.Errorf("DBManager.DeleteLexicon: %v", err)
 	}
 
 	return nil
@@ -282,7 +336,7 @@ func (dbm *DBManager) LexiconStats(lexRef lex.LexRef) (LexStats, error) {
 		return LexStats{}, fmt.Errorf("DBManager.LexiconStats: no such db '%s'", lexRef.DBRef)
 	}
 
-	stats, err := dbm.dbif.lexiconStats(db, string(lexRef.LexName))
+	stats, err := dbm.dbifOf(db).lexiconStats(db, string(lexRef.LexName))
 	if err != nil {
 		return LexStats{}, fmt.Errorf("DBManager.LexiconStats: couldn't get stats '%s' : %v", lexRef, err)
 	}
@@ -301,10 +355,14 @@ func (dbm *DBManager) DefineLexicons(dbRef lex.DBRef, symbolSetName string, loca
 		if !ok {
 			return fmt.Errorf("DBManager.DefineLexicon: No such db: '%s'", dbRef)
 		}
-		_, err := dbm.dbif.defineLexicon(db, lexicon{name: string(l), symbolSetName: symbolSetName, locale: locale})
+		_, err := dbm.dbifOf(db).defineLexicon(db, lexicon{name: string(l), symbolSetName: symbolSetName, locale: locale})
 		if err != nil {
 			return fmt.Errorf("DBManager.DefineLexicon: failed to add '%s:%s' : %v", dbRef, l, err)
 		}
+		err = dbm.recordChanges(lex.LexRef{DBRef: dbRef, LexName: l}, ChangeLexiconCreated)
+		if err != nil {
+			return fmt.Errorf("DBManager.DefineLexicon: %v", err)
+		}
 	}
 
 	return nil
@@ -320,10 +378,14 @@ func (dbm *DBManager) DefineLexicon(lexRef lex.LexRef, symbolSetName string, loc
 	if !ok {
 		return fmt.Errorf("DBManager.DefineLexicon: No such db: '%s'", lexRef.DBRef)
 	}
-	_, err := dbm.dbif.defineLexicon(db, lexicon{name: string(lexRef.LexName), symbolSetName: symbolSetName, locale: locale})
+	_, err := dbm.dbifOf(db).defineLexicon(db, lexicon{name: string(lexRef.LexName), symbolSetName: symbolSetName, locale: locale})
 	if err != nil {
 		return fmt.Errorf("DBManager.DefineLexicon: failed to add '%s' : %v", lexRef.String(), err)
 	}
+	err = dbm.recordChanges(lexRef, ChangeLexiconCreated)
+	if err != nil {
+		return fmt.Errorf("DBManager.DefineLexicon: %v", err)
+	}
 
 	return nil
 }
@@ -344,7 +406,7 @@ func (dbm *DBManager) ListIDs(lexRef lex.LexRef) ([]int64, error) {
 		return []int64{}, fmt.Errorf("DBManager.ListIDs failed: no db of name '%s'", lexRef.DBRef)
 	}
 
-	ids, err := dbm.dbif.lookUpIds(db, []lex.LexName{lexRef.LexName}, Query{})
+	ids, err := dbm.dbifOf(db).lookUpIds(db, []lex.LexName{lexRef.LexName}, Query{})
 	if err != nil {
 		return []int64{}, fmt.Errorf("DBManager.ListIDs failed for lexicon : '%s'", lexRef)
 	}
@@ -396,6 +458,22 @@ func (dbm *DBManager) LookUp(q DBMQuery, out lex.EntryWriter) error {
 	dbm.RLock()
 	defer dbm.RUnlock()
 
+	cacheKey, cacheable := lookupCacheKey(q)
+	cacheable = cacheable && dbm.lookupCache != nil
+	var cached []lex.Entry
+	if cacheable {
+		if es, ok := dbm.lookupCache.get(cacheKey); ok {
+			for _, e := range es {
+				e.Lock = dbm.entryLocks.lockFor(e)
+				err := out.Write(e)
+				if err != nil {
+					return fmt.Errorf("error writing to lex.EntryWriter : %v", err)
+				}
+			}
+			return nil
+		}
+	}
+
 	ch := make(chan lookUpRes)
 	for dbR, lexs := range dbz {
 		db, ok := dbm.dbs[dbR]
@@ -404,21 +482,35 @@ func (dbm *DBManager) LookUp(q DBMQuery, out lex.EntryWriter) error {
 		}
 
 		go func(db0 *sql.DB, dbRef lex.DBRef, lexNames []lex.LexName) {
+			start := time.Now()
+			send := func(rez lookUpRes) {
+				dbm.lookupLatencies.observe(dbRef, time.Since(start))
+				ch <- rez
+			}
 			rez := lookUpRes{}
 			rez.dbRef = dbRef
 			ew := lex.EntrySliceWriter{}
-			err := dbm.dbif.lookUp(db0, lexNames, q.Query, &ew)
+			err := dbm.dbifOf(db0).lookUp(db0, lexNames, q.Query, &ew)
 			if err != nil {
 				rez.err = fmt.Errorf("dbapi.LookUp failed for %v:%v : %v", dbRef, lexNames, err)
-				ch <- rez
+				send(rez)
 				return
 			}
-			for _, e := range ew.Entries {
+			entries := ew.Entries
+			if q.Query.IncludeRelated && len(entries) > 0 {
+				entries, err = addRelatedEntries(dbm.dbifOf(db0), db0, entries)
+				if err != nil {
+					rez.err = fmt.Errorf("dbapi.LookUp failed to add related entries for %v:%v : %v", dbRef, lexNames, err)
+					send(rez)
+					return
+				}
+			}
+			for _, e := range entries {
 				e.LexRef.DBRef = dbRef
 				rez.entries = append(rez.entries, e)
 			}
 
-			ch <- rez
+			send(rez)
 		}(db, dbR, lexs)
 	}
 
@@ -429,6 +521,10 @@ func (dbm *DBManager) LookUp(q DBMQuery, out lex.EntryWriter) error {
 		}
 
 		for _, e := range lkUp.entries {
+			if cacheable {
+				cached = append(cached, copyEntry(e))
+			}
+			e.Lock = dbm.entryLocks.lockFor(e)
 			err := out.Write(e)
 			if err != nil {
 				return fmt.Errorf("error writing to lex.EntryWriter : %v", err)
@@ -436,6 +532,9 @@ func (dbm *DBManager) LookUp(q DBMQuery, out lex.EntryWriter) error {
 		}
 	}
 
+	if cacheable {
+		dbm.lookupCache.put(cacheKey, q.LexRefs, cached)
+	}
 	return nil
 }
 
@@ -459,7 +558,7 @@ func (dbm *DBManager) ListLexicons() ([]lex.LexRefWithInfo, error) {
 	// Go ask each db instance in its own Go-routine
 	for dbRef, db := range dbs {
 		go func(dbRef lex.DBRef, db *sql.DB, ch0 chan lexRes) {
-			lexs, err := dbm.dbif.listLexicons(db)
+			lexs, err := dbm.dbifOf(db).listLexicons(db)
 			lexList := []lex.LexRefWithInfo{}
 			for _, ln := range lexs {
 				lexRef := lex.LexRef{DBRef: dbRef, LexName: lex.LexName(ln.name)}
@@ -509,8 +608,22 @@ func (dbm *DBManager) LexiconExists(lexRef lex.LexRef) (bool, error) {
 	return false, nil
 }
 
-// InsertEntries saves a list of Entries and associates them to the lexicon
+// InsertEntries saves a list of Entries and associates them to the lexicon. If a validator is bound to the lexicon (see BindValidator), the entries are validated before they are saved.
+// The inserted entries are recorded as an import batch (see InsertEntriesAs).
 func (dbm *DBManager) InsertEntries(lexRef lex.LexRef, entries []lex.Entry) ([]int64, error) {
+	ids, _, err := dbm.InsertEntriesAs(lexRef, entries, "")
+	return ids, err
+}
+
+// InsertEntriesAs inserts entries (see InsertEntries) on behalf of a user, and records them as an import batch, that can be undone using UndoImportBatch. Returns the ids of the new entries, and the import batch.
+func (dbm *DBManager) InsertEntriesAs(lexRef lex.LexRef, entries []lex.Entry, user string) ([]int64, ImportBatch, error) {
+	res, batch, err := dbm.insertEntriesAs(lexRef, entries, user)
+	dbm.logError("DBManager.InsertEntries", err)
+	return res, batch, err
+}
+
+// insertEntriesAs implements InsertEntriesAs
+func (dbm *DBManager) insertEntriesAs(lexRef lex.LexRef, entries []lex.Entry, user string) ([]int64, ImportBatch, error) {
 
 	var res []int64
 
@@ -519,26 +632,74 @@ func (dbm *DBManager) InsertEntries(lexRef lex.LexRef, entries []lex.Entry) ([]i
 
 	db, ok := dbm.dbs[lexRef.DBRef]
 	if !ok {
-		return res, fmt.Errorf("DBManager.InsertEntries: unknown db '%s'", lexRef.DBRef)
+		return res, ImportBatch{}, fmt.Errorf("DBManager.InsertEntries: unknown db '%s'", lexRef.DBRef)
 	}
 
 	//_ = db
 	//_ = lexName
-	l, err := dbm.dbif.getLexicon(db, string(lexRef.LexName))
+	l, err := dbm.dbifOf(db).getLexicon(db, string(lexRef.LexName))
 	//fmt.Printf("%v\n", l)
 	if err != nil {
-		return res, fmt.Errorf("DBManager.InsertEntries failed call to getLexicons : %v", err)
+		return res, ImportBatch{}, fmt.Errorf("DBManager.InsertEntries failed call to getLexicons : %v", err)
+	}
+	err = checkNotFrozen(db, l.id)
+	if err != nil {
+		return res, ImportBatch{}, fmt.Errorf("DBManager.InsertEntries: %w", err)
+	}
+	checksum, err := entriesChecksum(entries)
+	if err != nil {
+		return res, ImportBatch{}, fmt.Errorf("DBManager.InsertEntries failed to compute checksum : %v", err)
 	}
 	//fmt.Println(lexName)
-	res, err = dbm.dbif.insertEntries(db, l, entries)
+	dbm.invalidateLexicons(lexRef)
+	// the import batch and the entries are saved in one transaction
+	tx, err := db.Begin()
 	if err != nil {
-		return res, fmt.Errorf("DBManager.InsertEntries failed: %v", err)
+		return res, ImportBatch{}, fmt.Errorf("DBManager.InsertEntries failed to start db transaction : %v", err)
 	}
-	return res, err
+	defer tx.Rollback()
+	batchID, err := createImportBatch(tx, l.id, "", checksum, user)
+	if err != nil {
+		return res, ImportBatch{}, fmt.Errorf("DBManager.InsertEntries: %v", err)
+	}
+	res, err = dbm.dbifOf(db).insertEntriesTx(tx, l, dbm.revalidate(lexRef, normaliseEntries(entries)))
+	if err != nil {
+		return res, ImportBatch{}, fmt.Errorf("DBManager.InsertEntries failed: %w", err)
+	}
+	lineNumbers := []int{}
+	for i := range res {
+		lineNumbers = append(lineNumbers, i+1)
+	}
+	err = addImportBatchEntriesTx(dbm.dbifOf(db), tx, lexRef.LexName, batchID, res, lineNumbers)
+	if err != nil {
+		return res, ImportBatch{}, fmt.Errorf("DBManager.InsertEntries: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return res, ImportBatch{}, fmt.Errorf("DBManager.InsertEntries failed db commit : %v", err)
+	}
+	if len(res) > 0 {
+		err = dbm.recordChanges(lexRef, ChangeInsert, res...)
+		if err != nil {
+			return res, ImportBatch{}, fmt.Errorf("DBManager.InsertEntries: %v", err)
+		}
+	}
+	batch, err := getImportBatch(db, lexRef.DBRef, batchID)
+	if err != nil {
+		return res, batch, fmt.Errorf("DBManager.InsertEntries: %v", err)
+	}
+	return res, batch, nil
 }
 
 // UpdateValidation using the cached validation in the specified lex.Entry
 func (dbm *DBManager) UpdateValidation(e lex.Entry) error {
+	err := dbm.updateValidation(e)
+	dbm.logError("DBManager.UpdateValidation", err)
+	return err
+}
+
+// updateValidation implements UpdateValidation
+func (dbm *DBManager) updateValidation(e lex.Entry) error {
 	dbm.Lock()
 	defer dbm.Unlock()
 	db, ok := dbm.dbs[e.LexRef.DBRef]
@@ -546,25 +707,92 @@ func (dbm *DBManager) UpdateValidation(e lex.Entry) error {
 		return fmt.Errorf("DBManager.UpdateValidation: no such db '%s'", e.LexRef.DBRef)
 	}
 
-	return dbm.dbif.updateValidation(db, []lex.Entry{e})
+	dbm.invalidateLexicons(e.LexRef)
+	err := dbm.dbifOf(db).updateValidation(db, []lex.Entry{e})
+	if err != nil {
+		return err
+	}
+	err = dbm.recordChanges(e.LexRef, ChangeUpdate, e.ID)
+	if err != nil {
+		return fmt.Errorf("DBManager.UpdateValidation: %v", err)
+	}
+	return nil
 }
 
-// UpdateEntry wraps call to UpdateEntryTx with a transaction, and returns the updated entry, fresh from the db
+// UpdateEntry wraps call to UpdateEntryTx with a transaction, and returns the updated entry, fresh from the db. If a validator is bound to the lexicon (see BindValidator), the entry is revalidated before it is saved.
+// Entries that are locked (see LockEntry) cannot be updated using UpdateEntry; use UpdateEntryAs instead.
 func (dbm *DBManager) UpdateEntry(e lex.Entry) (lex.Entry, bool, error) {
-	var res lex.Entry
+	return dbm.UpdateEntryAs(e, "")
+}
 
+// UpdateEntryAs updates an entry (see UpdateEntry) on behalf of a lock owner. Returns an *EntryLockedError if the entry, or the orthography group of the entry before or after the update, is locked by another owner.
+// If the update sets a new entry status, the open assignments of the entry are closed (see Assignment).
+func (dbm *DBManager) UpdateEntryAs(e lex.Entry, lockOwner string) (lex.Entry, bool, error) {
 	dbm.Lock()
 	defer dbm.Unlock()
 	db, ok := dbm.dbs[e.LexRef.DBRef]
 	if !ok {
-		return res, false, fmt.Errorf("DBManager.UpdateEntry: no such db '%s'", e.LexRef.DBRef)
+		return lex.Entry{}, false, fmt.Errorf("DBManager.UpdateEntry: no such db '%s'", e.LexRef.DBRef)
+	}
+	res, updated, err := dbm.updateEntryAs(db, e, lockOwner)
+	dbm.logError("DBManager.UpdateEntry", err)
+	return res, updated, err
+}
+
+// updateEntryAs implements UpdateEntryAs. The caller must hold the DBManager write lock.
+func (dbm *DBManager) updateEntryAs(db *sql.DB, e lex.Entry, lockOwner string) (lex.Entry, bool, error) {
+	var res lex.Entry
+
+	// the status before the update is needed to tell whether a new status was set
+	var before lex.EntrySliceWriter
+	err := dbm.dbifOf(db).lookUp(db, []lex.LexName{e.LexRef.LexName}, Query{EntryIDs: []int64{e.ID}}, &before)
+	if err != nil {
+		return res, false, fmt.Errorf("DBManager.UpdateEntry: %v", err)
+	}
+
+	e = normaliseEntry(e)
+	strns := []string{e.Strn}
+	if len(before.Entries) == 1 {
+		strns = append(strns, before.Entries[0].Strn)
+	}
+	err = dbm.entryLocks.check(e.LexRef, e.ID, strings.TrimSpace(lockOwner), strns...)
+	if err != nil {
+		return res, false, err
 	}
 
-	return dbm.dbif.updateEntry(db, e)
+	dbm.invalidateLexicons(e.LexRef)
+	res, updated, err := dbm.dbifOf(db).updateEntry(db, dbm.revalidate(e.LexRef, []lex.Entry{e})[0])
+	if err != nil || !updated {
+		return res, updated, err
+	}
+	dbm.entryLocks.entryUpdated(e.LexRef, e.ID, res.Strn, false)
+	err = dbm.recordChanges(e.LexRef, ChangeUpdate, e.ID)
+	if err != nil {
+		return res, updated, fmt.Errorf("DBManager.UpdateEntry: %v", err)
+	}
+	if len(before.Entries) == 1 && before.Entries[0].EntryStatus.ID != res.EntryStatus.ID {
+		err = dbm.recordChanges(e.LexRef, ChangeStatus, e.ID)
+		if err != nil {
+			return res, updated, fmt.Errorf("DBManager.UpdateEntry: %v", err)
+		}
+	}
+	return res, updated, nil
 }
 
-// DeleteEntry deletes an entry from the database
+// DeleteEntry deletes an entry from the database. Entries that are locked (see LockEntry) cannot be deleted using DeleteEntry; use DeleteEntryAs instead.
 func (dbm *DBManager) DeleteEntry(entryID int64, lexRef lex.LexRef) (int64, error) {
+	return dbm.DeleteEntryAs(entryID, lexRef, "")
+}
+
+// DeleteEntryAs deletes an entry from the database on behalf of a lock owner. Returns an *EntryLockedError if the entry, or its orthography group, is locked by another owner.
+func (dbm *DBManager) DeleteEntryAs(entryID int64, lexRef lex.LexRef, lockOwner string) (int64, error) {
+	n, err := dbm.deleteEntryAs(entryID, lexRef, lockOwner)
+	dbm.logError("DBManager.DeleteEntry", err)
+	return n, err
+}
+
+// deleteEntryAs implements DeleteEntryAs
+func (dbm *DBManager) deleteEntryAs(entryID int64, lexRef lex.LexRef, lockOwner string) (int64, error) {
 	dbm.Lock()
 	defer dbm.Unlock()
 	db, ok := dbm.dbs[lexRef.DBRef]
@@ -572,18 +800,151 @@ func (dbm *DBManager) DeleteEntry(entryID int64, lexRef lex.LexRef) (int64, erro
 		return 0, fmt.Errorf("DBManager.DeleteEntry: no such db '%s'", lexRef.DBRef)
 	}
 
-	return dbm.dbif.deleteEntry(db, entryID, string(lexRef.LexName))
+	var before lex.EntrySliceWriter
+	err := dbm.dbifOf(db).lookUp(db, []lex.LexName{lexRef.LexName}, Query{EntryIDs: []int64{entryID}}, &before)
+	if err != nil {
+		return 0, fmt.Errorf("DBManager.DeleteEntry: %v", err)
+	}
+	strns := []string{}
+	if len(before.Entries) == 1 {
+		strns = append(strns, before.Entries[0].Strn)
+	}
+	err = dbm.entryLocks.check(lexRef, entryID, strings.TrimSpace(lockOwner), strns...)
+	if err != nil {
+		return 0, err
+	}
+
+	dbm.invalidateLexicons(lexRef)
+	n, err := dbm.dbifOf(db).deleteEntry(db, entryID, string(lexRef.LexName))
+	if err == nil && n > 0 {
+		dbm.entryLocks.entryUpdated(lexRef, entryID, "", true)
+		err = dbm.recordChanges(lexRef, ChangeDelete, entryID)
+		if err != nil {
+			return n, fmt.Errorf("DBManager.DeleteEntry: %v", err)
+		}
+	}
+	return n, err
+}
+
+// InsertEntryRelation saves a typed relation between two entries in the specified database. The entries may belong to different lexicons. Returns the relation with its new db id.
+func (dbm *DBManager) InsertEntryRelation(dbRef lex.DBRef, r lex.EntryRelation) (lex.EntryRelation, error) {
+	if !lex.ValidEntryRelationType(r.Type) {
+		return r, fmt.Errorf("DBManager.InsertEntryRelation: invalid relation type '%s' (valid types: %s)", r.Type, strings.Join(lex.EntryRelationTypes, ", "))
+	}
+	if r.FromEntryID == r.ToEntryID {
+		return r, fmt.Errorf("DBManager.InsertEntryRelation: an entry cannot be related to itself (id %d)", r.FromEntryID)
+	}
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return r, fmt.Errorf("DBManager.InsertEntryRelation: no such db '%s'", dbRef)
+	}
+	res, err := insertEntryRelation(db, r)
+	if err != nil {
+		return res, err
+	}
+	err = dbm.recordEntryChanges(dbRef, ChangeUpdate, res.FromEntryID, res.ToEntryID)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.InsertEntryRelation: %v", err)
+	}
+	return res, nil
+}
+
+// UpdateEntryRelation changes the type and/or position of an existing relation, identified by its id. The related entries cannot be changed (delete the relation and insert a new one instead).
+func (dbm *DBManager) UpdateEntryRelation(dbRef lex.DBRef, r lex.EntryRelation) (lex.EntryRelation, error) {
+	if !lex.ValidEntryRelationType(r.Type) {
+		return r, fmt.Errorf("DBManager.UpdateEntryRelation: invalid relation type '%s' (valid types: %s)", r.Type, strings.Join(lex.EntryRelationTypes, ", "))
+	}
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return r, fmt.Errorf("DBManager.UpdateEntryRelation: no such db '%s'", dbRef)
+	}
+	res, err := updateEntryRelation(db, r)
+	if err != nil {
+		return res, err
+	}
+	err = dbm.recordEntryChanges(dbRef, ChangeUpdate, res.FromEntryID, res.ToEntryID)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.UpdateEntryRelation: %v", err)
+	}
+	return res, nil
+}
+
+// DeleteEntryRelation deletes the relation with the specified id. (Relations are also deleted automatically when any of the related entries is deleted.)
+func (dbm *DBManager) DeleteEntryRelation(dbRef lex.DBRef, id int64) error {
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return fmt.Errorf("DBManager.DeleteEntryRelation: no such db '%s'", dbRef)
+	}
+	entryIDs, err := relationEntryIDs(db, id)
+	if err != nil {
+		return fmt.Errorf("DBManager.DeleteEntryRelation: %v", err)
+	}
+	err = deleteEntryRelation(db, id)
+	if err != nil {
+		return err
+	}
+	err = dbm.recordEntryChanges(dbRef, ChangeUpdate, entryIDs...)
+	if err != nil {
+		return fmt.Errorf("DBManager.DeleteEntryRelation: %v", err)
+	}
+	return nil
+}
+
+// ListEntryRelations returns all relations to or from the specified entry
+func (dbm *DBManager) ListEntryRelations(dbRef lex.DBRef, entryID int64) ([]lex.EntryRelation, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return []lex.EntryRelation{}, fmt.Errorf("DBManager.ListEntryRelations: no such db '%s'", dbRef)
+	}
+	return listEntryRelations(db, []int64{entryID})
 }
 
 // ImportLexiconFile is intended for 'clean' imports. It doesn't check whether the words already exist and so on. It does not do any sanity checks whatsoever of the transcriptions before they are added. If the validator parameter is initialized, each entry will be validated before import, and the validation result will be added to the db.
+// The import is recorded as an import batch (see ImportLexiconFileAs).
 func (dbm *DBManager) ImportLexiconFile(lexRef lex.LexRef, logger Logger, lexiconFileName string, validator *validation.Validator) error {
+	_, err := dbm.ImportLexiconFileAs(lexRef, logger, lexiconFileName, validator, "")
+	return err
+}
+
+// ImportLexiconFileAs imports a lexicon file (see ImportLexiconFile) on behalf of a user, and records the import as an import batch, with the file name and checksum, and the line number of each entry. The batch can be undone using UndoImportBatch. If the import fails, the returned batch holds the entries saved before the failure (if any).
+func (dbm *DBManager) ImportLexiconFileAs(lexRef lex.LexRef, logger Logger, lexiconFileName string, validator *validation.Validator, user string) (ImportBatch, error) {
+	batch, err := dbm.importLexiconFileAs(lexRef, logger, lexiconFileName, validator, user)
+	dbm.logError("DBManager.ImportLexiconFile", err)
+	return batch, err
+}
+
+// importLexiconFileAs implements ImportLexiconFileAs
+func (dbm *DBManager) importLexiconFileAs(lexRef lex.LexRef, logger Logger, lexiconFileName string, validator *validation.Validator, user string) (ImportBatch, error) {
 	dbm.Lock()
 	defer dbm.Unlock()
 	db, ok := dbm.dbs[lexRef.DBRef]
 	if !ok {
-		return fmt.Errorf("DBManager.ImportLexiconFile: no such db '%s'", lexRef.DBRef)
+		return ImportBatch{}, fmt.Errorf("DBManager.ImportLexiconFile: no such db '%s'", lexRef.DBRef)
+	}
+	dbm.invalidateLexicons(lexRef)
+	batchID, err := importLexiconFile(dbm.dbifOf(db), db, lexRef.LexName, logger, lexiconFileName, validator, user)
+	// a failed import may have saved some of the entries
+	err2 := dbm.recordChanges(lexRef, ChangeLexicon)
+	if err == nil && err2 != nil {
+		err = fmt.Errorf("DBManager.ImportLexiconFile: %v", err2)
 	}
-	return importLexiconFile(dbm.dbif, db, lexRef.LexName, logger, lexiconFileName, validator)
+	var batch ImportBatch
+	if batchID > 0 {
+		var err2 error
+		batch, err2 = getImportBatch(db, lexRef.DBRef, batchID)
+		if err == nil && err2 != nil {
+			err = fmt.Errorf("DBManager.ImportLexiconFile: %v", err2)
+		}
+	}
+	return batch, err
 }
 
 // EntryCount counts the number of entries in a lexicon
@@ -594,7 +955,7 @@ func (dbm *DBManager) EntryCount(lexRef lex.LexRef) (int64, error) {
 	if !ok {
 		return 0, fmt.Errorf("DBManager.ImportLexiconFile: no such db '%s'", lexRef.DBRef)
 	}
-	return dbm.dbif.entryCount(db, string(lexRef.LexName))
+	return dbm.dbifOf(db).entryCount(db, string(lexRef.LexName))
 }
 
 // Locale looks up the locale for a specific lexicon
@@ -605,7 +966,7 @@ func (dbm *DBManager) Locale(lexRef lex.LexRef) (string, error) {
 	if !ok {
 		return "", fmt.Errorf("DBManager.ImportLexiconFile: no such db '%s'", lexRef.DBRef)
 	}
-	return dbm.dbif.locale(db, string(lexRef.LexName))
+	return dbm.dbifOf(db).locale(db, string(lexRef.LexName))
 }
 
 // ListCommentLabels returns a list of all comment labels
@@ -616,7 +977,7 @@ func (dbm *DBManager) ListCommentLabels(lexRef lex.LexRef) ([]string, error) {
 	if !ok {
 		return []string{}, fmt.Errorf("DBManager.ListCommentLabels: no such db '%s'", lexRef.DBRef)
 	}
-	return dbm.dbif.listCommentLabels(db, string(lexRef.LexName))
+	return dbm.dbifOf(db).listCommentLabels(db, string(lexRef.LexName))
 }
 
 // ListCurrentEntryUsers returns a list of all names EntryUsers marked 'current' (i.e., the most recent status).
@@ -627,7 +988,7 @@ func (dbm *DBManager) ListCurrentEntryUsers(lexRef lex.LexRef) ([]string, error)
 	if !ok {
 		return []string{}, fmt.Errorf("DBManager.ListCurrentEntryUsers: no such db '%s'", lexRef.DBRef)
 	}
-	return dbm.dbif.listCurrentEntryUsers(db, string(lexRef.LexName))
+	return dbm.dbifOf(db).listCurrentEntryUsers(db, string(lexRef.LexName))
 }
 
 // ListCurrentEntryUsersWithFreq returns a map of all names EntryUsers marked 'current' (i.e., the most recent status), and the frequency for each user
@@ -638,7 +999,7 @@ func (dbm *DBManager) ListCurrentEntryUsersWithFreq(lexRef lex.LexRef) (map[stri
 	if !ok {
 		return make(map[string]int), fmt.Errorf("DBManager.ListCurrentEntryUsersWithFreq: no such db '%s'", lexRef.DBRef)
 	}
-	return dbm.dbif.listCurrentEntryUsersWithFreq(db, string(lexRef.LexName))
+	return dbm.dbifOf(db).listCurrentEntryUsersWithFreq(db, string(lexRef.LexName))
 }
 
 // ListCurrentEntryStatuses returns a list of all names EntryStatuses marked 'current' (i.e., the most recent status).
@@ -649,7 +1010,7 @@ func (dbm *DBManager) ListCurrentEntryStatuses(lexRef lex.LexRef) ([]string, err
 	if !ok {
 		return []string{}, fmt.Errorf("DBManager.ListCurrentEntryStatuses: no such db '%s'", lexRef.DBRef)
 	}
-	return dbm.dbif.listCurrentEntryStatuses(db, string(lexRef.LexName))
+	return dbm.dbifOf(db).listCurrentEntryStatuses(db, string(lexRef.LexName))
 }
 
 // ListCurrentEntryStatusesWithFreq returns a list of all names EntryStatuses marked 'current' (i.e., the most recent status), and the frequency for each status.
@@ -660,7 +1021,7 @@ func (dbm *DBManager) ListCurrentEntryStatusesWithFreq(lexRef lex.LexRef) (map[s
 	if !ok {
 		return make(map[string]int), fmt.Errorf("DBManager.ListCurrentEntryStatusesWithFreq: no such db '%s'", lexRef.DBRef)
 	}
-	return dbm.dbif.listCurrentEntryStatusesWithFreq(db, string(lexRef.LexName))
+	return dbm.dbifOf(db).listCurrentEntryStatusesWithFreq(db, string(lexRef.LexName))
 }
 
 // ListAllEntryStatuses returns a list of all names EntryStatuses, also those that are not 'current'  (i.e., the most recent status).
@@ -672,7 +1033,7 @@ func (dbm *DBManager) ListAllEntryStatuses(lexRef lex.LexRef) ([]string, error)
 	if !ok {
 		return []string{}, fmt.Errorf("DBManager.ListAllEntryStatuses: no such db '%s'", lexRef.DBRef)
 	}
-	return dbm.dbif.listAllEntryStatuses(db, string(lexRef.LexName))
+	return dbm.dbifOf(db).listAllEntryStatuses(db, string(lexRef.LexName))
 }
 
 // GetLexicon returns a information (LexRefWithInfo) matching a lexicon name in the db.
@@ -684,7 +1045,7 @@ func (dbm *DBManager) GetLexicon(lexRef lex.LexRef) (lex.LexRefWithInfo, error)
 	if !ok {
 		return lex.LexRefWithInfo{}, fmt.Errorf("DBManager.GetLexicon: no such db '%s'", lexRef.DBRef)
 	}
-	l, err := dbm.dbif.getLexicon(db, string(lexRef.LexName))
+	l, err := dbm.dbifOf(db).getLexicon(db, string(lexRef.LexName))
 	if err != nil {
 		return lex.LexRefWithInfo{}, err
 	}
@@ -694,6 +1055,68 @@ func (dbm *DBManager) GetLexicon(lexRef lex.LexRef) (lex.LexRefWithInfo, error)
 	}, nil
 }
 
+// GetLexiconMeta returns the meta data (description, license, etc) of the specified lexicon
+func (dbm *DBManager) GetLexiconMeta(lexRef lex.LexRef) (LexiconMeta, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return LexiconMeta{}, fmt.Errorf("DBManager.GetLexiconMeta: no such db '%s'", lexRef.DBRef)
+	}
+	return dbm.dbifOf(db).getLexiconMeta(db, string(lexRef.LexName))
+}
+
+// SetLexiconMeta replaces the meta data of the specified lexicon, including all properties. The Created and Modified fields are set by the database, and are ignored here.
+func (dbm *DBManager) SetLexiconMeta(lexRef lex.LexRef, meta LexiconMeta) error {
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return fmt.Errorf("DBManager.SetLexiconMeta: no such db '%s'", lexRef.DBRef)
+	}
+	return dbm.dbifOf(db).setLexiconMeta(db, string(lexRef.LexName), meta)
+}
+
+// ConvertSymbolSet maps all transcriptions of a lexicon from one phonetic symbol set to another, using the specified mapper. The mapper's first symbol set must be the lexicon's current symbol set.
+//
+// If newLexName is empty, the lexicon is converted in place: the transcriptions are updated, and the lexicon's symbol set is set to the mapper's second symbol set. Entry ids and status history are kept intact.
+// If newLexName is set, the entries are instead copied into a new lexicon with that name (with new entry ids), and the original lexicon is left untouched.
+//
+// Transcriptions that cannot be mapped are left as they are, and a validation message (rule name SymbolSetConversionRuleName) is added to the entry.
+func (dbm *DBManager) ConvertSymbolSet(lexRef lex.LexRef, m mapper.Mapper, newLexName lex.LexName) (SymbolSetConversionResult, error) {
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return SymbolSetConversionResult{}, fmt.Errorf("DBManager.ConvertSymbolSet: no such db '%s'", lexRef.DBRef)
+	}
+	if newLexName == "" {
+		dbm.invalidateLexicons(lexRef)
+		res, err := convertSymbolSetInPlace(dbm.dbifOf(db), db, lexRef.LexName, m)
+		if err == nil {
+			// the bound validator is for the old symbol set
+			dbm.UnbindValidator(lexRef)
+			err = dbm.recordChanges(lexRef, ChangeLexicon)
+			if err != nil {
+				return res, fmt.Errorf("DBManager.ConvertSymbolSet: %v", err)
+			}
+		}
+		return res, err
+	}
+	res, err := convertSymbolSetToNewLexicon(dbm.dbifOf(db), db, lexRef.LexName, m, newLexName)
+	if err == nil {
+		newLexRef := lex.LexRef{DBRef: lexRef.DBRef, LexName: newLexName}
+		err = dbm.recordChanges(newLexRef, ChangeLexiconCreated)
+		if err == nil {
+			err = dbm.recordChanges(newLexRef, ChangeLexicon)
+		}
+		if err != nil {
+			return res, fmt.Errorf("DBManager.ConvertSymbolSet: %v", err)
+		}
+	}
+	return res, err
+}
+
 // MoveNewEntries moves lexical entries from the lexicon named
 // fromLexicon to the lexicon named toLexicon.  The 'newSource' string is
 // the name of the new source of the entries to be moved, and 'newStatus' is
@@ -706,6 +1129,9 @@ func (dbm *DBManager) GetLexicon(lexRef lex.LexRef) (lex.LexRefWithInfo, error)
 // rationale behind this function is to first create a small
 // additional lexicon with new entries (the fromLexicon), that can
 // later be appended to the master lexicon (the toLexicon).
+//
+// The moved entries get a new entry status, which closes their open
+// assignments (see Assignment).
 func (dbm *DBManager) MoveNewEntries(dbRef lex.DBRef, fromLex, toLex lex.LexName, newSource, newStatus string) (MoveResult, error) {
 	dbm.Lock()
 	defer dbm.Unlock()
@@ -713,7 +1139,18 @@ func (dbm *DBManager) MoveNewEntries(dbRef lex.DBRef, fromLex, toLex lex.LexName
 	if !ok {
 		return MoveResult{}, fmt.Errorf("DBManager.MoveNewEntries: no such db '%s'", dbRef)
 	}
-	return dbm.dbif.moveNewEntries(db, string(fromLex), string(toLex), newSource, newStatus)
+	dbm.invalidateLexicons(lex.LexRef{DBRef: dbRef, LexName: fromLex}, lex.LexRef{DBRef: dbRef, LexName: toLex})
+	res, err := dbm.dbifOf(db).moveNewEntries(db, string(fromLex), string(toLex), newSource, newStatus)
+	if err == nil && res.N > 0 {
+		err = dbm.recordChanges(lex.LexRef{DBRef: dbRef, LexName: fromLex}, ChangeLexicon)
+		if err == nil {
+			err = dbm.recordChanges(lex.LexRef{DBRef: dbRef, LexName: toLex}, ChangeLexicon)
+		}
+		if err != nil {
+			return res, fmt.Errorf("DBManager.MoveNewEntries: %v", err)
+		}
+	}
+	return res, err
 }
 
 // Validate all entries given the specified lexRef and search query. Updates validation stats in db, and returns these.
@@ -724,7 +1161,15 @@ func (dbm *DBManager) Validate(lexRef lex.LexRef, logger Logger, vd validation.V
 	if !ok {
 		return ValStats{}, fmt.Errorf("DBManager.Validate: no such db '%s'", lexRef.DBRef)
 	}
-	return validate(dbm.dbif, db, []lex.LexName{lexRef.LexName}, logger, vd, q)
+	dbm.invalidateLexicons(lexRef)
+	res, err := validate(dbm.dbifOf(db), db, []lex.LexName{lexRef.LexName}, logger, vd, q)
+	if err == nil {
+		err = dbm.recordChanges(lexRef, ChangeLexicon)
+		if err != nil {
+			return res, fmt.Errorf("DBManager.Validate: %v", err)
+		}
+	}
+	return res, err
 }
 
 // ValidationStats returns existing validation stats for the specified lexRef
@@ -735,7 +1180,7 @@ func (dbm *DBManager) ValidationStats(lexRef lex.LexRef) (ValStats, error) {
 	if !ok {
 		return ValStats{}, fmt.Errorf("DBManager.ValidationStats: no such db '%s'", lexRef.DBRef)
 	}
-	return dbm.dbif.validationStats(db, string(lexRef.LexName))
+	return dbm.dbifOf(db).validationStats(db, string(lexRef.LexName))
 }
 
 // GetSchemaVersion retrieves the schema version from the database
@@ -746,17 +1191,25 @@ func (dbm *DBManager) GetSchemaVersion(dbRef lex.DBRef) (string, error) {
 	if !ok {
 		return "", fmt.Errorf("DBManager.GetSchemaVersion: no such db '%s'", dbRef)
 	}
-	return dbm.dbif.getSchemaVersion(db)
+	return dbm.dbifOf(db).getSchemaVersion(db)
 
 }
 
 // DropDB drop the database (cannot be undone).
 // For Sqlite, the database is entirely dropped, for MariaDB, all database tables are dropped, but the database is not deleted. Deletion of MariaDB databases should be done by a server admiinstrator.
+// The lexicons of the database are removed from any lexicon stacks.
 func (dbm *DBManager) DropDB(dbLocation string, dbRef lex.DBRef) error {
-	return dbm.dbif.dropDB(dbLocation, dbRef)
+	dbm.Lock()
+	dbm.invalidateDB(dbRef)
+	dbm.Unlock()
+	err := dbm.dbifAt(dbLocation).dropDB(dbLocation, dbRef)
+	if err != nil {
+		return err
+	}
+	return dbm.removeFromLexiconStacks(func(ref lex.LexRef) bool { return ref.DBRef == dbRef })
 }
 
 // DBExists checks if a database exist. For Sqlite, it checks if the actual database file exists. For MariaDB, it checks if the database exists, and contains tables required for a lexicon database. The reason for this is how the user privileges work for MariaDB. See also DefinedDB and DropDB.
 func (dbm *DBManager) DBExists(dbLocation string, dbRef lex.DBRef) (bool, error) {
-	return dbm.dbif.dbExists(dbLocation, dbRef)
+	return dbm.dbifAt(dbLocation).dbExists(dbLocation, dbRef)
 }
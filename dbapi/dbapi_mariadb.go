@@ -184,6 +184,13 @@ func (mdb mariaDBIF) getLexiconTx(tx *sql.Tx, name string) (lexicon, error) {
 // It should be impossible to delete the Lexicon table entry if associated to any entries.
 func (mdb mariaDBIF) deleteLexicon(db *sql.DB, lexName string) error {
 	log.Printf("deleteLexicon called with lexicon name %s\n", lexName)
+	l, err := mdb.getLexicon(db, lexName)
+	if err == nil {
+		err = checkNotFrozen(db, l.id)
+		if err != nil {
+			return err
+		}
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -335,7 +342,7 @@ func (mdb mariaDBIF) deleteEntry(db *sql.DB, entryID int64, lexName string) (int
 	defer tx.Commit()
 
 	// Check that lexicon exists
-	_, err = mdb.getLexiconTx(tx, lexName)
+	l, err := mdb.getLexiconTx(tx, lexName)
 	if err != nil {
 		msg := fmt.Sprintf("dbapi.deleteEntry failed to find lexicon '%s' : %v", lexName, err)
 
@@ -346,6 +353,14 @@ func (mdb mariaDBIF) deleteEntry(db *sql.DB, entryID int64, lexName string) (int
 
 		return 0, errors.New(msg)
 	}
+	err = checkNotFrozen(tx, l.id)
+	if err != nil {
+		err2 := tx.Rollback()
+		if err2 != nil {
+			return 0, fmt.Errorf("%w : rollback failed : %v", err, err2)
+		}
+		return 0, err
+	}
 
 	res, err := tx.Exec("DELETE FROM Entry WHERE  id = ? AND lexiconId IN (SELECT id FROM Lexicon WHERE name = ?)", entryID, lexName)
 	if err != nil {
@@ -442,6 +457,18 @@ func defineLexiconTx(tx *sql.Tx, l lexicon) (lexicon, error) {
 		return l, errors.New(msg)
 	}
 
+	_, err = tx.Exec("INSERT INTO LexiconMeta (lexiconId) VALUES (?)", id)
+	if err != nil {
+		msg := fmt.Sprintf("failed to insert lexicon meta data : %v", err)
+
+		err2 := tx.Rollback()
+		if err2 != nil {
+			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
+		}
+
+		return l, errors.New(msg)
+	}
+
 	//tx.Commit()
 
 	return lexicon{id: id, name: strings.ToLower(l.name), symbolSetName: l.symbolSetName}, err
@@ -528,9 +555,18 @@ func (mdb mariaDBIF) moveNewEntriesTx(tx *sql.Tx, fromLexicon, toLexicon, newSou
 
 		return res, errors.New(msg)
 	}
+	err = checkNotFrozen(tx, fromLex.id, toLex.id)
+	if err != nil {
+		err2 := tx.Rollback()
+		if err2 != nil {
+			return res, fmt.Errorf("%w : rollback failed : %v", err, err2)
+		}
+		return res, err
+	}
 
-	const where = `WHERE Entry.id IN (SELECT a.id FROM (select * from Entry) AS a WHERE a.lexiconId = ?
+	const newEntries = `IN (SELECT a.id FROM (select * from Entry) AS a WHERE a.lexiconId = ?
                        AND NOT EXISTS(SELECT ee.strn FROM (select * from Entry) AS ee WHERE ee.lexiconId = ? AND ee.strn = a.strn))`
+	const where = `WHERE Entry.id ` + newEntries
 
 	insertQuery := `INSERT INTO EntryStatus (name, source, entryId, current) SELECT ?, ?, Entry.id, '1' FROM Entry ` + where
 
@@ -547,6 +583,16 @@ func (mdb mariaDBIF) moveNewEntriesTx(tx *sql.Tx, fromLexicon, toLexicon, newSou
 		return res, errors.New(msg)
 	}
 
+	// the assignments are closed before the entries are moved, since the moved entries are no longer matched by newEntries
+	err = closeAssignmentsTx(tx, lex.EntryStatus{Name: newStatus, Source: newSource}, newEntries, fromLex.id, toLex.id)
+	if err != nil {
+		err2 := tx.Rollback()
+		if err2 != nil {
+			return res, fmt.Errorf("%w : rollback failed : %v", err, err2)
+		}
+		return res, err
+	}
+
 	//_ = q0Rez
 
 	updateQuery := `UPDATE Entry SET lexiconId = ? ` + where
@@ -578,24 +624,41 @@ func (mdb mariaDBIF) moveNewEntriesTx(tx *sql.Tx, fromLexicon, toLexicon, newSou
 }
 
 // TODO move to function?
-var entrySTMTMDB = "insert into Entry (lexiconId, strn, language, partofspeech, morphology, wordparts, preferred) values (?, ?, ?, ?, ?, ?, ?)"
+var entrySTMTMDB = "insert into Entry (lexiconId, strn, language, partofspeech, morphology, wordparts, preferred, strnFold, strnBase) values (?, ?, ?, ?, ?, ?, ?, ?, ?)"
 var transAfterEntrySTMTMDB = "insert into Transcription (entryId, strn, language, sources) values (?, ?, ?, ?)"
 
 var statusSetCurrentFalse = "UPDATE EntryStatus SET current = 0 WHERE EntryStatus.entryId = ?"
 var insertStatusMDB = "INSERT INTO EntryStatus (entryId, name, source) values (?, ?, ?)"
 
-// InsertEntries saves a list of Entries and associates them to Lexicon
-// TODO: Change second input argument to string (lexicon name) instead of Lexicon struct.
-// TODO change input arg to sql.Tx
+// insertEntries saves a list of Entries and associates them to Lexicon, in a new transaction (see insertEntriesTx)
 func (mdb mariaDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction failed : %v", err)
+	}
+	defer tx.Rollback()
+
+	ids, err := mdb.insertEntriesTx(tx, l, es)
+	if err != nil {
+		return ids, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return ids, fmt.Errorf("insertEntries failed db commit : %v", err)
+	}
+	return ids, nil
+}
+
+// insertEntriesTx saves a list of Entries and associates them to Lexicon, in a transaction. If an error is returned, the caller must roll back the transaction.
+// TODO: Change second input argument to string (lexicon name) instead of Lexicon struct.
+func (mdb mariaDBIF) insertEntriesTx(tx *sql.Tx, l lexicon, es []lex.Entry) ([]int64, error) {
 
 	var ids []int64
-	// Transaction -->
-	tx, err := db.Begin()
+
+	err := checkNotFrozen(tx, l.id)
 	if err != nil {
-		return ids, fmt.Errorf("begin transaction failed : %v", err)
+		return ids, err
 	}
-	defer tx.Commit()
 
 	stmt1, err := tx.Prepare(entrySTMTMDB)
 	if err != nil {
@@ -611,10 +674,6 @@ func (mdb mariaDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]int
 
 		if len(e.Transcriptions) == 0 {
 			msg := fmt.Sprintf("cannot insert entry without transcriptions: %#v", e)
-			err2 := tx.Rollback()
-			if err2 != nil {
-				msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-			}
 			return ids, errors.New(msg)
 		}
 		// convert 'Preferred' into DB integer value
@@ -629,28 +688,22 @@ func (mdb mariaDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]int
 			_, err := tx.Exec(setPreferredFalse, e.Strn)
 			if err != nil {
 				msg := fmt.Sprintf("failed preferred update of previous entries : %v", err)
-				err2 := tx.Rollback()
-				if err2 != nil {
-					msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-				}
 				return ids, errors.New(msg)
 			}
 		}
 
 		res, err := tx.Stmt(stmt1).Exec(
 			l.id,
-			strings.ToLower(e.Strn),
+			NormaliseWord(e.Strn),
 			e.Language,
 			e.PartOfSpeech,
 			e.Morphology,
 			e.WordParts,
-			pref)
+			pref,
+			FoldCase(e.Strn),
+			RemoveDiacritics(e.Strn))
 		if err != nil {
 			msg := fmt.Sprintf("failed exec : %v", err)
-			err2 := tx.Rollback()
-			if err2 != nil {
-				msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-			}
 
 			return ids, errors.New(msg)
 		}
@@ -658,10 +711,6 @@ func (mdb mariaDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]int
 		id, err := res.LastInsertId()
 		if err != nil {
 			msg := fmt.Sprintf("failed last insert id : %v", err)
-			err2 := tx.Rollback()
-			if err2 != nil {
-				msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-			}
 
 			return ids, errors.New(msg)
 		}
@@ -676,10 +725,6 @@ func (mdb mariaDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]int
 			_, err := tx.Stmt(stmt2).Exec(id, t.Strn, t.Language, t.SourcesString())
 			if err != nil {
 				msg := fmt.Sprintf("failed exec : %v", err)
-				err2 := tx.Rollback()
-				if err2 != nil {
-					msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-				}
 
 				return ids, errors.New(msg)
 			}
@@ -691,20 +736,12 @@ func (mdb mariaDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]int
 			if err != nil {
 
 				msg := fmt.Sprintf("failed set or get lemma : %v", err)
-				err2 := tx.Rollback()
-				if err2 != nil {
-					msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-				}
 
 				return ids, errors.New(msg)
 			}
 			err = mdb.associateLemma2Entry(tx, lemma, e)
 			if err != nil {
 				msg := fmt.Sprintf("failed lemma to entry assoc: %v", err)
-				err2 := tx.Rollback()
-				if err2 != nil {
-					msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-				}
 
 				return ids, errors.New(msg)
 			}
@@ -714,10 +751,6 @@ func (mdb mariaDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]int
 			err = mdb.insertEntryTagTx(tx, e.ID, e.Tag, e.Strn)
 			if err != nil {
 				msg := fmt.Sprintf("failed to insert entry tag '%s' for '%s': %v", e.Tag, e.Strn, err)
-				err2 := tx.Rollback()
-				if err2 != nil {
-					msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-				}
 
 				return ids, errors.New(msg)
 			}
@@ -735,10 +768,6 @@ func (mdb mariaDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]int
 			_, err = tx.Exec(insertStatusMDB, e.ID, strings.ToLower(e.EntryStatus.Name), strings.ToLower(e.EntryStatus.Source)) //, e.EntryStatus.Current) // TODO?
 			if err != nil {
 				msg := fmt.Sprintf("inserting EntryStatus failed : %v", err)
-				err2 := tx.Rollback()
-				if err2 != nil {
-					msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-				}
 
 				return ids, errors.New(msg)
 			}
@@ -747,10 +776,6 @@ func (mdb mariaDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]int
 		err = mdb.insertEntryValidations(tx, e, e.EntryValidations)
 		if err != nil {
 			msg := fmt.Sprintf("inserting EntryValidations failed : %v", err)
-			err2 := tx.Rollback()
-			if err2 != nil {
-				msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-			}
 
 			return ids, errors.New(msg)
 		}
@@ -759,19 +784,12 @@ func (mdb mariaDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]int
 		if err != nil {
 
 			msg := fmt.Sprintf("inserting EntryComments failed : %v", err)
-			err2 := tx.Rollback()
-			if err2 != nil {
-				msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-			}
 
 			return ids, errors.New(msg)
 		}
 
 	}
 
-	//tx.Commit()
-	// <- transaction
-
 	return ids, err
 }
 
@@ -1321,12 +1339,11 @@ func (mdb mariaDBIF) updateEntry(db *sql.DB, e lex.Entry) (res lex.Entry, update
 
 	updated, err = mdb.updateEntryTx(tx, e)
 	if err != nil {
-		msg := fmt.Sprintf("failed updating entry : %v", err)
 		err2 := tx.Rollback()
 		if err2 != nil {
-			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
+			return res, updated, fmt.Errorf("failed updating entry : %w : rollback failed : %v", err, err2)
 		}
-		return res, updated, errors.New(msg)
+		return res, updated, fmt.Errorf("failed updating entry : %w", err)
 	}
 	err = tx.Commit()
 	if err != nil {
@@ -1349,6 +1366,10 @@ func (mdb mariaDBIF) updateEntry(db *sql.DB, e lex.Entry) (res lex.Entry, update
 // UpdateEntryTx updates the fields of an lex.Entry that do not match the
 // corresponding values in the db
 func (mdb mariaDBIF) updateEntryTx(tx *sql.Tx, e lex.Entry) (updated bool, err error) { // TODO return the updated entry?
+	err = checkEntriesNotFrozen(tx, []int64{e.ID})
+	if err != nil {
+		return false, err
+	}
 	// updated == false
 	//dbEntryMap := //GetEntriesFromIDsTx(tx, []int64{(e.ID)})
 	var esw lex.EntrySliceWriter
@@ -1810,6 +1831,15 @@ func (mdb mariaDBIF) updateEntryStatus(tx *sql.Tx, e lex.Entry, dbE lex.Entry) (
 			}
 			return false, errors.New(msg)
 		}
+		status := lex.EntryStatus{Name: strings.ToLower(e.EntryStatus.Name), Source: strings.ToLower(e.EntryStatus.Source)}
+		err = closeAssignmentsTx(tx, status, "= ?", dbE.ID)
+		if err != nil {
+			err2 := tx.Rollback()
+			if err2 != nil {
+				return false, fmt.Errorf("%w : rollback failed : %v", err, err2)
+			}
+			return false, err
+		}
 
 		return true, nil
 	}
@@ -1885,12 +1915,11 @@ func (mdb mariaDBIF) updateValidation(db *sql.DB, entries []lex.Entry) error {
 
 	err = mdb.updateValidationTx(tx, entries)
 	if err != nil {
-		msg := fmt.Sprintf("failed updating validation : %v", err)
 		err2 := tx.Rollback()
 		if err2 != nil {
-			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
+			return fmt.Errorf("failed updating validation : %w : rollback failed : %v", err, err2)
 		}
-		return errors.New(msg)
+		return fmt.Errorf("failed updating validation : %w", err)
 	}
 	err = tx.Commit()
 	if err != nil {
@@ -1901,6 +1930,14 @@ func (mdb mariaDBIF) updateValidation(db *sql.DB, entries []lex.Entry) error {
 }
 
 func (mdb mariaDBIF) updateValidationTx(tx *sql.Tx, entries []lex.Entry) error {
+	ids := []int64{}
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	err := checkEntriesNotFrozen(tx, ids)
+	if err != nil {
+		return err
+	}
 	for _, e := range entries {
 		_, err := mdb.updateEntryValidationForce(tx, e)
 		if err != nil {
@@ -2656,3 +2693,48 @@ func (mdb mariaDBIF) dbExists(dbLocation string, dbRef lex.DBRef) (bool, error)
 	}
 	return false, nil
 }
+
+// getLexiconMeta returns the meta data of the named lexicon
+func (mdb mariaDBIF) getLexiconMeta(db *sql.DB, lexName string) (LexiconMeta, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return LexiconMeta{}, fmt.Errorf("dbapi.getLexiconMeta failed to start db transaction : %v", err)
+	}
+	defer tx.Commit()
+
+	l, err := mdb.getLexiconTx(tx, lexName)
+	if err != nil {
+		return LexiconMeta{}, err
+	}
+	return getLexiconMetaTx(tx, l.id)
+}
+
+// setLexiconMeta replaces the meta data of the named lexicon
+func (mdb mariaDBIF) setLexiconMeta(db *sql.DB, lexName string, meta LexiconMeta) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("dbapi.setLexiconMeta failed to start db transaction : %v", err)
+	}
+	defer tx.Commit()
+
+	l, err := mdb.getLexiconTx(tx, lexName)
+	if err != nil {
+		return err
+	}
+	return setLexiconMetaTx(tx, l.id, meta)
+}
+
+// queryStats counts the entries matching the query, along with facet counts for the matching entries
+func (mdb mariaDBIF) queryStats(db *sql.DB, lexNames []lex.LexName, q Query) (QueryStats, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return QueryStats{}, fmt.Errorf("dbapi.queryStats failed to start db transaction : %v", err)
+	}
+	defer tx.Commit()
+
+	err = mdb.validateInputLexicons(tx, lexNames, q)
+	if err != nil {
+		return QueryStats{}, err
+	}
+	return queryStatsTx(tx, lexNames, q)
+}
@@ -319,6 +319,13 @@ func lexiconFromIDTx(tx *sql.Tx, id int64) (lexicon, error) {
 // It should be impossible to delete the Lexicon table entry if associated to any entries.
 func (sdb sqliteDBIF) deleteLexicon(db *sql.DB, lexName string) error {
 	log.Printf("deleteLexicon called with lexicon name %s\n", lexName)
+	l, err := sdb.getLexicon(db, lexName)
+	if err == nil {
+		err = checkNotFrozen(db, l.id)
+		if err != nil {
+			return err
+		}
+	}
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -471,7 +478,7 @@ func (sdb sqliteDBIF) deleteEntry(db *sql.DB, entryID int64, lexName string) (in
 	defer tx.Commit()
 
 	// Check that lexicon exists
-	_, err = sdb.getLexiconTx(tx, lexName)
+	l, err := sdb.getLexiconTx(tx, lexName)
 	if err != nil {
 		msg := fmt.Sprintf("dbapi.deleteEntry failed to find lexicon '%s' : %v", lexName, err)
 
@@ -482,6 +489,14 @@ func (sdb sqliteDBIF) deleteEntry(db *sql.DB, entryID int64, lexName string) (in
 
 		return 0, errors.New(msg)
 	}
+	err = checkNotFrozen(tx, l.id)
+	if err != nil {
+		err2 := tx.Rollback()
+		if err2 != nil {
+			return 0, fmt.Errorf("%w : rollback failed : %v", err, err2)
+		}
+		return 0, err
+	}
 
 	res, err := tx.Exec("DELETE FROM entry WHERE  id = ? AND lexiconid IN (SELECT id FROM lexicon WHERE name = ?)", entryID, lexName)
 	if err != nil {
@@ -578,6 +593,18 @@ func (sdb sqliteDBIF) defineLexiconTx(tx *sql.Tx, l lexicon) (lexicon, error) {
 		return l, errors.New(msg)
 	}
 
+	_, err = tx.Exec("INSERT INTO LexiconMeta (lexiconId) VALUES (?)", id)
+	if err != nil {
+		msg := fmt.Sprintf("failed to insert lexicon meta data : %v", err)
+
+		err2 := tx.Rollback()
+		if err2 != nil {
+			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
+		}
+
+		return l, errors.New(msg)
+	}
+
 	//tx.Commit()
 
 	return lexicon{id: id, name: strings.ToLower(l.name), symbolSetName: l.symbolSetName}, err
@@ -665,9 +692,18 @@ func (sdb sqliteDBIF) moveNewEntriesTx(tx *sql.Tx, fromLexicon, toLexicon, newSo
 
 		return res, errors.New(msg)
 	}
+	err = checkNotFrozen(tx, fromLex.id, toLex.id)
+	if err != nil {
+		err2 := tx.Rollback()
+		if err2 != nil {
+			return res, fmt.Errorf("%w : rollback failed : %v", err, err2)
+		}
+		return res, err
+	}
 
-	const where = `WHERE entry.id IN (SELECT a.id FROM entry a WHERE a.lexiconid = ?
+	const newEntries = `IN (SELECT a.id FROM entry a WHERE a.lexiconid = ?
                        AND NOT EXISTS(SELECT strn FROM entry WHERE lexiconid = ? AND strn = a.strn))`
+	const where = `WHERE entry.id ` + newEntries
 
 	insertQuery := `INSERT INTO entrystatus (name, source, entryid, current) SELECT ?, ?, entry.id, '1' FROM entry ` + where
 
@@ -684,6 +720,16 @@ func (sdb sqliteDBIF) moveNewEntriesTx(tx *sql.Tx, fromLexicon, toLexicon, newSo
 		return res, errors.New(msg)
 	}
 
+	// the assignments are closed before the entries are moved, since the moved entries are no longer matched by newEntries
+	err = closeAssignmentsTx(tx, lex.EntryStatus{Name: newStatus, Source: newSource}, newEntries, fromLex.id, toLex.id)
+	if err != nil {
+		err2 := tx.Rollback()
+		if err2 != nil {
+			return res, fmt.Errorf("%w : rollback failed : %v", err, err2)
+		}
+		return res, err
+	}
+
 	//_ = q0Rez
 
 	updateQuery := `UPDATE entry SET lexiconid = ? ` + where
@@ -715,24 +761,41 @@ func (sdb sqliteDBIF) moveNewEntriesTx(tx *sql.Tx, fromLexicon, toLexicon, newSo
 }
 
 // TODO move to function?
-var entrySTMTSqlite = "insert into entry (lexiconid, strn, language, partofspeech, morphology, wordparts, preferred) values (?, ?, ?, ?, ?, ?, ?)"
+var entrySTMTSqlite = "insert into entry (lexiconid, strn, language, partofspeech, morphology, wordparts, preferred, strnFold, strnBase) values (?, ?, ?, ?, ?, ?, ?, ?, ?)"
 var transAfterEntrySTMTSqlite = "insert into transcription (entryid, strn, language, sources) values (?, ?, ?, ?)"
 
 // var statusSetCurrentFalse = "UPDATE entrystatus SET current = 0 WHERE entrystatus.entryid = ?"
 var insertStatusSqlite = "INSERT INTO entrystatus (entryid, name, source) values (?, ?, ?)"
 
-// InsertEntries saves a list of Entries and associates them to Lexicon
-// TODO: Change second input argument to string (lexicon name) instead of Lexicon struct.
-// TODO change input arg to sql.Tx
+// insertEntries saves a list of Entries and associates them to Lexicon, in a new transaction (see insertEntriesTx)
 func (sdb sqliteDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction failed : %v", err)
+	}
+	defer tx.Rollback()
+
+	ids, err := sdb.insertEntriesTx(tx, l, es)
+	if err != nil {
+		return ids, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return ids, fmt.Errorf("insertEntries failed db commit : %v", err)
+	}
+	return ids, nil
+}
+
+// insertEntriesTx saves a list of Entries and associates them to Lexicon, in a transaction. If an error is returned, the caller must roll back the transaction.
+// TODO: Change second input argument to string (lexicon name) instead of Lexicon struct.
+func (sdb sqliteDBIF) insertEntriesTx(tx *sql.Tx, l lexicon, es []lex.Entry) ([]int64, error) {
 
 	var ids []int64
-	// Transaction -->
-	tx, err := db.Begin()
+
+	err := checkNotFrozen(tx, l.id)
 	if err != nil {
-		return ids, fmt.Errorf("begin transaction failed : %v", err)
+		return ids, err
 	}
-	defer tx.Commit()
 
 	stmt1, err := tx.Prepare(entrySTMTSqlite)
 	if err != nil {
@@ -748,10 +811,6 @@ func (sdb sqliteDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]in
 
 		if len(e.Transcriptions) == 0 {
 			msg := fmt.Sprintf("cannot insert entry without transcriptions: %#v", e)
-			err2 := tx.Rollback()
-			if err2 != nil {
-				msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-			}
 			return ids, errors.New(msg)
 		}
 		// convert 'Preferred' into DB integer value
@@ -766,28 +825,22 @@ func (sdb sqliteDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]in
 			_, err := tx.Exec(setPreferredFalse, e.Strn)
 			if err != nil {
 				msg := fmt.Sprintf("failed preferred update of previous entries : %v", err)
-				err2 := tx.Rollback()
-				if err2 != nil {
-					msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-				}
 				return ids, errors.New(msg)
 			}
 		}
 
 		res, err := tx.Stmt(stmt1).Exec(
 			l.id,
-			strings.ToLower(e.Strn),
+			NormaliseWord(e.Strn),
 			e.Language,
 			e.PartOfSpeech,
 			e.Morphology,
 			e.WordParts,
-			pref)
+			pref,
+			FoldCase(e.Strn),
+			RemoveDiacritics(e.Strn))
 		if err != nil {
 			msg := fmt.Sprintf("failed exec : %v", err)
-			err2 := tx.Rollback()
-			if err2 != nil {
-				msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-			}
 
 			return ids, errors.New(msg)
 		}
@@ -795,10 +848,6 @@ func (sdb sqliteDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]in
 		id, err := res.LastInsertId()
 		if err != nil {
 			msg := fmt.Sprintf("failed last insert id : %v", err)
-			err2 := tx.Rollback()
-			if err2 != nil {
-				msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-			}
 
 			return ids, errors.New(msg)
 		}
@@ -813,10 +862,6 @@ func (sdb sqliteDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]in
 			_, err := tx.Stmt(stmt2).Exec(id, t.Strn, t.Language, t.SourcesString())
 			if err != nil {
 				msg := fmt.Sprintf("failed exec : %v", err)
-				err2 := tx.Rollback()
-				if err2 != nil {
-					msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-				}
 
 				return ids, errors.New(msg)
 			}
@@ -828,20 +873,12 @@ func (sdb sqliteDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]in
 			if err != nil {
 
 				msg := fmt.Sprintf("failed set or get lemma : %v", err)
-				err2 := tx.Rollback()
-				if err2 != nil {
-					msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-				}
 
 				return ids, errors.New(msg)
 			}
 			err = sdb.associateLemma2Entry(tx, lemma, e)
 			if err != nil {
 				msg := fmt.Sprintf("failed lemma to entry assoc: %v", err)
-				err2 := tx.Rollback()
-				if err2 != nil {
-					msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-				}
 
 				return ids, errors.New(msg)
 			}
@@ -851,10 +888,6 @@ func (sdb sqliteDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]in
 			err = sdb.insertEntryTagTx(tx, e.ID, e.Tag)
 			if err != nil {
 				msg := fmt.Sprintf("failed to insert entry tag '%s' for '%s': %v", e.Tag, e.Strn, err)
-				err2 := tx.Rollback()
-				if err2 != nil {
-					msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-				}
 
 				return ids, errors.New(msg)
 			}
@@ -872,10 +905,6 @@ func (sdb sqliteDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]in
 			_, err = tx.Exec(insertStatusSqlite, e.ID, strings.ToLower(e.EntryStatus.Name), strings.ToLower(e.EntryStatus.Source)) //, e.EntryStatus.Current) // TODO?
 			if err != nil {
 				msg := fmt.Sprintf("inserting EntryStatus failed : %v", err)
-				err2 := tx.Rollback()
-				if err2 != nil {
-					msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-				}
 
 				return ids, errors.New(msg)
 			}
@@ -884,10 +913,6 @@ func (sdb sqliteDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]in
 		err = sdb.insertEntryValidations(tx, e, e.EntryValidations)
 		if err != nil {
 			msg := fmt.Sprintf("inserting EntryValidations failed : %v", err)
-			err2 := tx.Rollback()
-			if err2 != nil {
-				msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-			}
 
 			return ids, errors.New(msg)
 		}
@@ -896,19 +921,12 @@ func (sdb sqliteDBIF) insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]in
 		if err != nil {
 
 			msg := fmt.Sprintf("inserting EntryComments failed : %v", err)
-			err2 := tx.Rollback()
-			if err2 != nil {
-				msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
-			}
 
 			return ids, errors.New(msg)
 		}
 
 	}
 
-	//tx.Commit()
-	// <- transaction
-
 	return ids, err
 }
 
@@ -1467,12 +1485,11 @@ func (sdb sqliteDBIF) updateEntry(db *sql.DB, e lex.Entry) (res lex.Entry, updat
 
 	updated, err = sdb.updateEntryTx(tx, e)
 	if err != nil {
-		msg := fmt.Sprintf("failed updating entry : %v", err)
 		err2 := tx.Rollback()
 		if err2 != nil {
-			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
+			return res, updated, fmt.Errorf("failed updating entry : %w : rollback failed : %v", err, err2)
 		}
-		return res, updated, errors.New(msg)
+		return res, updated, fmt.Errorf("failed updating entry : %w", err)
 	}
 	err = tx.Commit()
 	if err != nil {
@@ -1495,6 +1512,10 @@ func (sdb sqliteDBIF) updateEntry(db *sql.DB, e lex.Entry) (res lex.Entry, updat
 // UpdateEntryTx updates the fields of an lex.Entry that do not match the
 // corresponding values in the db
 func (sdb sqliteDBIF) updateEntryTx(tx *sql.Tx, e lex.Entry) (updated bool, err error) { // TODO return the updated entry?
+	err = checkEntriesNotFrozen(tx, []int64{e.ID})
+	if err != nil {
+		return false, err
+	}
 	// updated == false
 	//dbEntryMap := //GetEntriesFromIDsTx(tx, []int64{(e.ID)})
 	var esw lex.EntrySliceWriter
@@ -1948,6 +1969,15 @@ func (sdb sqliteDBIF) updateEntryStatus(tx *sql.Tx, e lex.Entry, dbE lex.Entry)
 			}
 			return false, errors.New(msg)
 		}
+		status := lex.EntryStatus{Name: strings.ToLower(e.EntryStatus.Name), Source: strings.ToLower(e.EntryStatus.Source)}
+		err = closeAssignmentsTx(tx, status, "= ?", dbE.ID)
+		if err != nil {
+			err2 := tx.Rollback()
+			if err2 != nil {
+				return false, fmt.Errorf("%w : rollback failed : %v", err, err2)
+			}
+			return false, err
+		}
 
 		return true, nil
 	}
@@ -2028,12 +2058,11 @@ func (sdb sqliteDBIF) updateValidation(db *sql.DB, entries []lex.Entry) error {
 
 	err = sdb.updateValidationTx(tx, entries)
 	if err != nil {
-		msg := fmt.Sprintf("failed updating validation : %v", err)
 		err2 := tx.Rollback()
 		if err2 != nil {
-			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
+			return fmt.Errorf("failed updating validation : %w : rollback failed : %v", err, err2)
 		}
-		return errors.New(msg)
+		return fmt.Errorf("failed updating validation : %w", err)
 	}
 	err = tx.Commit()
 	if err != nil {
@@ -2044,6 +2073,14 @@ func (sdb sqliteDBIF) updateValidation(db *sql.DB, entries []lex.Entry) error {
 }
 
 func (sdb sqliteDBIF) updateValidationTx(tx *sql.Tx, entries []lex.Entry) error {
+	ids := []int64{}
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	err := checkEntriesNotFrozen(tx, ids)
+	if err != nil {
+		return err
+	}
 	for _, e := range entries {
 		_, err := sdb.updateEntryValidationForce(tx, e)
 		if err != nil {
@@ -2793,3 +2830,48 @@ func (sdb sqliteDBIF) dbExists(dbLocation string, dbRef lex.DBRef) (bool, error)
 	}
 	return true, nil
 }
+
+// getLexiconMeta returns the meta data of the named lexicon
+func (sdb sqliteDBIF) getLexiconMeta(db *sql.DB, lexName string) (LexiconMeta, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return LexiconMeta{}, fmt.Errorf("dbapi.getLexiconMeta failed to start db transaction : %v", err)
+	}
+	defer tx.Commit()
+
+	l, err := sdb.getLexiconTx(tx, lexName)
+	if err != nil {
+		return LexiconMeta{}, err
+	}
+	return getLexiconMetaTx(tx, l.id)
+}
+
+// setLexiconMeta replaces the meta data of the named lexicon
+func (sdb sqliteDBIF) setLexiconMeta(db *sql.DB, lexName string, meta LexiconMeta) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("dbapi.setLexiconMeta failed to start db transaction : %v", err)
+	}
+	defer tx.Commit()
+
+	l, err := sdb.getLexiconTx(tx, lexName)
+	if err != nil {
+		return err
+	}
+	return setLexiconMetaTx(tx, l.id, meta)
+}
+
+// queryStats counts the entries matching the query, along with facet counts for the matching entries
+func (sdb sqliteDBIF) queryStats(db *sql.DB, lexNames []lex.LexName, q Query) (QueryStats, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return QueryStats{}, fmt.Errorf("dbapi.queryStats failed to start db transaction : %v", err)
+	}
+	defer tx.Commit()
+
+	err = sdb.validateInputLexicons(tx, lexNames, q)
+	if err != nil {
+		return QueryStats{}, err
+	}
+	return queryStatsTx(tx, lexNames, q)
+}
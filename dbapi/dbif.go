@@ -2,6 +2,7 @@ package dbapi
 
 import (
 	"database/sql"
+	"io"
 
 	"github.com/stts-se/pronlex/lex"
 )
@@ -20,9 +21,11 @@ type DBIF interface {
 	entryCount(db *sql.DB, lexiconName string) (int64, error)
 	getEntryFromID(db *sql.DB, id int64) (lex.Entry, error)
 	getLexicon(db *sql.DB, name string) (lexicon, error)
+	getLexiconMeta(db *sql.DB, lexName string) (LexiconMeta, error)
 	getLexiconMapTx(tx *sql.Tx) (map[string]bool, error)
 	getLexiconTx(tx *sql.Tx, name string) (lexicon, error)
 	insertEntries(db *sql.DB, l lexicon, es []lex.Entry) ([]int64, error)
+	insertEntriesTx(tx *sql.Tx, l lexicon, es []lex.Entry) ([]int64, error)
 	insertEntryComments(tx *sql.Tx, eID int64, eComments []lex.EntryComment) error
 	//insertEntryTagTx(tx *sql.Tx, entryID int64, tag string) error // different signature for mariadb/sqlite
 	insertEntryValidations(tx *sql.Tx, e lex.Entry, eValis []lex.EntryValidation) error
@@ -48,6 +51,8 @@ type DBIF interface {
 	lookUpTx(tx *sql.Tx, lexNames []lex.LexName, q Query, out lex.EntryWriter) error
 	moveNewEntries(db *sql.DB, fromLexicon, toLexicon, newSource, newStatus string) (MoveResult, error)
 	moveNewEntriesTx(tx *sql.Tx, fromLexicon, toLexicon, newSource, newStatus string) (MoveResult, error)
+	queryStats(db *sql.DB, lexNames []lex.LexName, q Query) (QueryStats, error)
+	setLexiconMeta(db *sql.DB, lexName string, meta LexiconMeta) error
 	setOrGetLemma(tx *sql.Tx, strn string, reading string, paradigm string) (lex.Lemma, error)
 	updateEntryComments(tx *sql.Tx, e lex.Entry, dbE lex.Entry) (bool, error)
 	updateEntry(db *sql.DB, e lex.Entry) (res lex.Entry, updated bool, err error)
@@ -76,6 +81,9 @@ type DBIF interface {
 
 	getSchemaVersion(db *sql.DB) (string, error)
 
+	backup(db *sql.DB, w io.Writer) error
+	restore(dbClusterLocation string, dbRef lex.DBRef, r io.Reader) error
+
 	// listLexiconDatabases returns a map with dbref + full dbpath
 	listLexiconDatabases(dbClusterLocation string) ([]lex.DBRef, error)
 
@@ -0,0 +1,320 @@
+package dbapi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// Entry locks let annotators check out entries while editing them, so that two annotators don't edit the same entry (or orthography group) at the same time.
+// Locks are advisory: they are enforced by UpdateEntryAs and DeleteEntryAs (and UpdateEntry and DeleteEntry, which have no lock owner), but not by bulk operations such as imports, MoveNewEntries or replication.
+// A lock expires unless it is renewed (see RenewEntryLock). Locks are held in memory by the DBManager, and are not saved in the database, so they are released when the DBManager is closed.
+
+// DefaultEntryLockTTL is the time to live for entry locks, if no other value is specified
+const DefaultEntryLockTTL = 15 * time.Minute
+
+// EntryLockedError is returned when an entry is locked by another owner
+type EntryLockedError struct {
+	Lock lex.EntryLock
+}
+
+func (e *EntryLockedError) Error() string {
+	return fmt.Sprintf("%s is locked by '%s' until %s (lock id %d)", lockTarget(e.Lock), e.Lock.Owner, e.Lock.Expires, e.Lock.ID)
+}
+
+func lockTarget(l lex.EntryLock) string {
+	if l.EntryID != 0 {
+		return fmt.Sprintf("entry %d in lexicon %s", l.EntryID, l.LexRef)
+	}
+	return fmt.Sprintf("orthography group '%s' in lexicon %s", l.Strn, l.LexRef)
+}
+
+type entryLock struct {
+	lex.EntryLock
+	expires time.Time
+}
+
+func (l *entryLock) covers(lexRef lex.LexRef, entryID int64, strns []string) bool {
+	if l.LexRef != lexRef {
+		return false
+	}
+	// an entry lock covers the entry itself, and an orthography group lock covers all entries with the orthography
+	if l.EntryID != 0 && entryID != 0 {
+		return l.EntryID == entryID
+	}
+	for _, s := range strns {
+		if l.Strn == s {
+			return true
+		}
+	}
+	return false
+}
+
+type entryLocks struct {
+	mutex  sync.Mutex
+	lastID int64
+	locks  map[int64]*entryLock
+}
+
+func newEntryLocks() *entryLocks {
+	return &entryLocks{locks: make(map[int64]*entryLock)}
+}
+
+// prune removes expired locks. The caller must hold the mutex.
+func (ls *entryLocks) prune(now time.Time) {
+	for id, l := range ls.locks {
+		if !now.Before(l.expires) {
+			delete(ls.locks, id)
+		}
+	}
+}
+
+// conflict returns a lock covering the entry (or orthography group, if entryID is 0) held by another owner. The caller must hold the mutex.
+func (ls *entryLocks) conflict(lexRef lex.LexRef, entryID int64, owner string, strns []string) (*entryLock, bool) {
+	ids := []int64{}
+	for id, l := range ls.locks {
+		if l.Owner != owner && l.covers(lexRef, entryID, strns) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, false
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ls.locks[ids[0]], true
+}
+
+// check returns an *EntryLockedError if the entry is locked by another owner than the specified one. The strns are the orthographies of the entry (before and after an update).
+func (ls *entryLocks) check(lexRef lex.LexRef, entryID int64, owner string, strns ...string) error {
+	lexRef = cacheLexRef(lexRef)
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	ls.prune(time.Now())
+	if l, ok := ls.conflict(lexRef, entryID, owner, strns); ok {
+		return &EntryLockedError{Lock: l.EntryLock}
+	}
+	return nil
+}
+
+// lockFor returns the lock held on an entry (an entry lock, if any, before an orthography group lock), or nil if the entry isn't locked
+func (ls *entryLocks) lockFor(e lex.Entry) *lex.EntryLock {
+	lexRef := cacheLexRef(e.LexRef)
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	if len(ls.locks) == 0 {
+		return nil
+	}
+	now := time.Now()
+	var res *entryLock
+	for _, l := range ls.locks {
+		if !now.Before(l.expires) || !l.covers(lexRef, e.ID, []string{e.Strn}) {
+			continue
+		}
+		if res == nil || (l.EntryID != 0 && res.EntryID == 0) || (l.EntryID == res.EntryID && l.ID < res.ID) {
+			res = l
+		}
+	}
+	if res == nil {
+		return nil
+	}
+	l := res.EntryLock
+	return &l
+}
+
+// lock creates a new lock, or renews the owner's existing lock on the same entry or orthography group
+func (ls *entryLocks) lock(lexRef lex.LexRef, entryID int64, strn, owner string, ttl time.Duration) (lex.EntryLock, error) {
+	lexRef = cacheLexRef(lexRef)
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	now := time.Now()
+	ls.prune(now)
+	if l, ok := ls.conflict(lexRef, entryID, owner, []string{strn}); ok {
+		return lex.EntryLock{}, &EntryLockedError{Lock: l.EntryLock}
+	}
+	for _, l := range ls.locks {
+		if l.Owner == owner && l.LexRef == lexRef && l.EntryID == entryID && (entryID != 0 || l.Strn == strn) {
+			l.Strn = strn
+			l.expires = now.Add(ttl)
+			l.Expires = l.expires.UTC().Format(time.RFC3339)
+			return l.EntryLock, nil
+		}
+	}
+	ls.lastID++
+	l := &entryLock{
+		EntryLock: lex.EntryLock{ID: ls.lastID, LexRef: lexRef, EntryID: entryID, Strn: strn, Owner: owner,
+			Created: now.UTC().Format(time.RFC3339),
+			Expires: now.Add(ttl).UTC().Format(time.RFC3339),
+		},
+		expires: now.Add(ttl),
+	}
+	ls.locks[l.ID] = l
+	return l.EntryLock, nil
+}
+
+// get returns an active lock by id
+func (ls *entryLocks) get(id int64) (*entryLock, error) {
+	ls.prune(time.Now())
+	l, ok := ls.locks[id]
+	if !ok {
+		return nil, fmt.Errorf("no such lock: %d (it may have expired)", id)
+	}
+	return l, nil
+}
+
+func (ls *entryLocks) renew(id int64, owner string, ttl time.Duration) (lex.EntryLock, error) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	l, err := ls.get(id)
+	if err != nil {
+		return lex.EntryLock{}, err
+	}
+	if l.Owner != owner {
+		return lex.EntryLock{}, &EntryLockedError{Lock: l.EntryLock}
+	}
+	l.expires = time.Now().Add(ttl)
+	l.Expires = l.expires.UTC().Format(time.RFC3339)
+	return l.EntryLock, nil
+}
+
+// unlock removes a lock. If force is false, the lock must be held by the owner.
+func (ls *entryLocks) unlock(id int64, owner string, force bool) (lex.EntryLock, error) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	l, err := ls.get(id)
+	if err != nil {
+		return lex.EntryLock{}, err
+	}
+	if !force && l.Owner != owner {
+		return lex.EntryLock{}, &EntryLockedError{Lock: l.EntryLock}
+	}
+	delete(ls.locks, id)
+	return l.EntryLock, nil
+}
+
+// entryUpdated updates the orthography of entry locks after an update, or removes them after the entry has been deleted
+func (ls *entryLocks) entryUpdated(lexRef lex.LexRef, entryID int64, strn string, deleted bool) {
+	lexRef = cacheLexRef(lexRef)
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	for id, l := range ls.locks {
+		if l.LexRef == lexRef && l.EntryID == entryID {
+			if deleted {
+				delete(ls.locks, id)
+			} else {
+				l.Strn = strn
+			}
+		}
+	}
+}
+
+func (ls *entryLocks) list(lexRefs []lex.LexRef) []lex.EntryLock {
+	include := make(map[lex.LexRef]bool)
+	for _, l := range lexRefs {
+		include[cacheLexRef(l)] = true
+	}
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	ls.prune(time.Now())
+	res := []lex.EntryLock{}
+	for _, l := range ls.locks {
+		if len(include) == 0 || include[l.LexRef] {
+			res = append(res, l.EntryLock)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func lockParams(owner string, ttl time.Duration) (string, time.Duration, error) {
+	owner = strings.TrimSpace(owner)
+	if owner == "" {
+		return owner, ttl, fmt.Errorf("empty lock owner")
+	}
+	if ttl <= 0 {
+		ttl = DefaultEntryLockTTL
+	}
+	return owner, ttl, nil
+}
+
+// LockEntry locks an entry for the owner, for the ttl duration (DefaultEntryLockTTL if 0). If the owner already holds a lock on the entry, it is renewed. Returns an *EntryLockedError if the entry (or its orthography group) is locked by another owner.
+func (dbm *DBManager) LockEntry(lexRef lex.LexRef, entryID int64, owner string, ttl time.Duration) (lex.EntryLock, error) {
+	owner, ttl, err := lockParams(owner, ttl)
+	if err != nil {
+		return lex.EntryLock{}, fmt.Errorf("DBManager.LockEntry: %v", err)
+	}
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return lex.EntryLock{}, fmt.Errorf("DBManager.LockEntry: no such db '%s'", lexRef.DBRef)
+	}
+	var w lex.EntrySliceWriter
+	err = dbm.dbifOf(db).lookUp(db, []lex.LexName{lexRef.LexName}, Query{EntryIDs: []int64{entryID}}, &w)
+	if err != nil {
+		return lex.EntryLock{}, fmt.Errorf("DBManager.LockEntry: %v", err)
+	}
+	if len(w.Entries) != 1 {
+		return lex.EntryLock{}, fmt.Errorf("DBManager.LockEntry: no entry with id %d in lexicon %s", entryID, lexRef)
+	}
+	return dbm.entryLocks.lock(lexRef, entryID, w.Entries[0].Strn, owner, ttl)
+}
+
+// LockOrthography locks all entries with the specified orthography in a lexicon (including entries added while the lock is held) for the owner, for the ttl duration (DefaultEntryLockTTL if 0). If the owner already holds a lock on the orthography group, it is renewed. Returns an *EntryLockedError if the group, or any entry in it, is locked by another owner.
+func (dbm *DBManager) LockOrthography(lexRef lex.LexRef, strn string, owner string, ttl time.Duration) (lex.EntryLock, error) {
+	owner, ttl, err := lockParams(owner, ttl)
+	if err != nil {
+		return lex.EntryLock{}, fmt.Errorf("DBManager.LockOrthography: %v", err)
+	}
+	strn = NormaliseWord(strings.TrimSpace(strn))
+	if strn == "" {
+		return lex.EntryLock{}, fmt.Errorf("DBManager.LockOrthography: empty orthography")
+	}
+	exists, err := dbm.LexiconExists(lexRef)
+	if err != nil {
+		return lex.EntryLock{}, fmt.Errorf("DBManager.LockOrthography: %v", err)
+	}
+	if !exists {
+		return lex.EntryLock{}, fmt.Errorf("DBManager.LockOrthography: no such lexicon: %s", lexRef)
+	}
+	return dbm.entryLocks.lock(lexRef, 0, strn, owner, ttl)
+}
+
+// RenewEntryLock extends the expiry of a lock held by the owner to ttl from now (DefaultEntryLockTTL if 0)
+func (dbm *DBManager) RenewEntryLock(id int64, owner string, ttl time.Duration) (lex.EntryLock, error) {
+	owner, ttl, err := lockParams(owner, ttl)
+	if err != nil {
+		return lex.EntryLock{}, fmt.Errorf("DBManager.RenewEntryLock: %v", err)
+	}
+	res, err := dbm.entryLocks.renew(id, owner, ttl)
+	if _, ok := err.(*EntryLockedError); err != nil && !ok {
+		return res, fmt.Errorf("DBManager.RenewEntryLock: %v", err)
+	}
+	return res, err
+}
+
+// UnlockEntry releases a lock held by the owner. Returns the released lock.
+func (dbm *DBManager) UnlockEntry(id int64, owner string) (lex.EntryLock, error) {
+	res, err := dbm.entryLocks.unlock(id, strings.TrimSpace(owner), false)
+	if _, ok := err.(*EntryLockedError); err != nil && !ok {
+		return res, fmt.Errorf("DBManager.UnlockEntry: %v", err)
+	}
+	return res, err
+}
+
+// ForceUnlockEntry releases a lock, regardless of the owner (e.g., a lock left by an annotator who has gone home). Returns the released lock.
+func (dbm *DBManager) ForceUnlockEntry(id int64) (lex.EntryLock, error) {
+	res, err := dbm.entryLocks.unlock(id, "", true)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.ForceUnlockEntry: %v", err)
+	}
+	return res, nil
+}
+
+// ListEntryLocks returns the active locks for the specified lexicons (all lexicons, if none are specified), ordered by id
+func (dbm *DBManager) ListEntryLocks(lexRefs ...lex.LexRef) []lex.EntryLock {
+	return dbm.entryLocks.list(lexRefs)
+}
@@ -0,0 +1,159 @@
+package dbapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestEntryLocksSqlite(t *testing.T) {
+	dbRef := lex.DBRef("entrylock_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	ids, err := dbm.InsertEntries(lexRef, []lex.Entry{newTestEntry("band", `" b a n d`), newTestEntry("band", `" b E n d`), newTestEntry("hund", `" h u0 n d`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	band1, band2, hund := ids[0], ids[1], ids[2]
+	lookUp := func(id int64) lex.Entry {
+		t.Helper()
+		es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{EntryIDs: []int64{id}}})
+		if err != nil || len(es) != 1 {
+			t.Fatalf("lookup failed : %v", err)
+		}
+		return es[0]
+	}
+	isLocked := func(err error) bool {
+		_, ok := err.(*EntryLockedError)
+		return ok
+	}
+
+	l1, err := dbm.LockEntry(lexRef, band1, "anna", time.Minute)
+	if err != nil {
+		t.Fatalf("failed to lock entry : %v", err)
+	}
+	if l1.Owner != "anna" || l1.EntryID != band1 || l1.Strn != "band" {
+		t.Errorf("unexpected lock : %#v", l1)
+	}
+	// the lock is visible in lookup results
+	if e := lookUp(band1); e.Lock == nil || e.Lock.ID != l1.ID {
+		t.Errorf("expected lock %d in lookup result, got %#v", l1.ID, e.Lock)
+	}
+	if e := lookUp(band2); e.Lock != nil {
+		t.Errorf("expected no lock in lookup result, got %#v", e.Lock)
+	}
+
+	// another owner can't lock, update or delete the entry, or lock its orthography group
+	_, err = dbm.LockEntry(lexRef, band1, "bertil", 0)
+	if !isLocked(err) {
+		t.Errorf("expected EntryLockedError, got %v", err)
+	}
+	_, err = dbm.LockOrthography(lexRef, "Band", "bertil", 0)
+	if !isLocked(err) {
+		t.Errorf("expected EntryLockedError, got %v", err)
+	}
+	e := lookUp(band1)
+	e.Transcriptions[0].Strn = `" b a n t`
+	_, _, err = dbm.UpdateEntryAs(e, "bertil")
+	if !isLocked(err) {
+		t.Errorf("expected EntryLockedError, got %v", err)
+	}
+	_, _, err = dbm.UpdateEntry(e)
+	if !isLocked(err) {
+		t.Errorf("expected EntryLockedError, got %v", err)
+	}
+	_, err = dbm.DeleteEntryAs(band1, lexRef, "bertil")
+	if !isLocked(err) {
+		t.Errorf("expected EntryLockedError, got %v", err)
+	}
+	_, err = dbm.UnlockEntry(l1.ID, "bertil")
+	if !isLocked(err) {
+		t.Errorf("expected EntryLockedError, got %v", err)
+	}
+	// but the owner can
+	_, updated, err := dbm.UpdateEntryAs(e, "anna")
+	if err != nil || !updated {
+		t.Errorf("expected update, got %v", err)
+	}
+	// and other entries are not locked
+	_, _, err = dbm.UpdateEntryAs(lookUp(band2), "bertil")
+	if err != nil {
+		t.Errorf("expected update, got %v", err)
+	}
+
+	// locking again renews the lock
+	l1b, err := dbm.LockEntry(lexRef, band1, "anna", time.Hour)
+	if err != nil || l1b.ID != l1.ID || l1b.Expires <= l1.Expires {
+		t.Errorf("expected renewed lock %d, got %#v : %v", l1.ID, l1b, err)
+	}
+	l1c, err := dbm.RenewEntryLock(l1.ID, "anna", 2*time.Hour)
+	if err != nil || l1c.Expires <= l1b.Expires {
+		t.Errorf("expected renewed lock %d, got %#v : %v", l1.ID, l1c, err)
+	}
+	_, err = dbm.RenewEntryLock(l1.ID, "bertil", 0)
+	if !isLocked(err) {
+		t.Errorf("expected EntryLockedError, got %v", err)
+	}
+
+	_, err = dbm.UnlockEntry(l1.ID, "anna")
+	if err != nil {
+		t.Errorf("failed to unlock : %v", err)
+	}
+	if e := lookUp(band1); e.Lock != nil {
+		t.Errorf("expected no lock after unlock, got %#v", e.Lock)
+	}
+
+	// orthography group locks
+	g, err := dbm.LockOrthography(lexRef, "band", "bertil", time.Minute)
+	if err != nil {
+		t.Fatalf("failed to lock orthography group : %v", err)
+	}
+	for _, id := range []int64{band1, band2} {
+		if e := lookUp(id); e.Lock == nil || e.Lock.ID != g.ID {
+			t.Errorf("expected lock %d on entry %d, got %#v", g.ID, id, e.Lock)
+		}
+		_, err = dbm.LockEntry(lexRef, id, "anna", 0)
+		if !isLocked(err) {
+			t.Errorf("expected EntryLockedError, got %v", err)
+		}
+	}
+	// an entry can't be renamed into a locked group
+	e = lookUp(hund)
+	e.Strn = "band"
+	_, _, err = dbm.UpdateEntryAs(e, "anna")
+	if !isLocked(err) {
+		t.Errorf("expected EntryLockedError, got %v", err)
+	}
+	if locks := dbm.ListEntryLocks(lexRef); len(locks) != 1 || locks[0].ID != g.ID {
+		t.Errorf("expected one lock, got %#v", locks)
+	}
+	_, err = dbm.ForceUnlockEntry(g.ID)
+	if err != nil {
+		t.Errorf("failed to force unlock : %v", err)
+	}
+	_, err = dbm.ForceUnlockEntry(g.ID)
+	if err == nil {
+		t.Errorf("expected error for unknown lock")
+	}
+	_, err = dbm.DeleteEntryAs(band2, lexRef, "anna")
+	if err != nil {
+		t.Errorf("failed to delete entry : %v", err)
+	}
+
+	// expired locks are released
+	_, err = dbm.LockEntry(lexRef, hund, "anna", time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to lock entry : %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if e := lookUp(hund); e.Lock != nil {
+		t.Errorf("expected expired lock, got %#v", e.Lock)
+	}
+	if locks := dbm.ListEntryLocks(); len(locks) != 0 {
+		t.Errorf("expected no locks, got %#v", locks)
+	}
+}
@@ -0,0 +1,220 @@
+package dbapi
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// The SQL for entry relations is the same for Sqlite and MariaDB, so the functions below are used for both database engines
+
+// InsertEntryRelation saves a typed relation between two entries, and returns the relation with its new db id
+func insertEntryRelation(db *sql.DB, r lex.EntryRelation) (lex.EntryRelation, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return r, fmt.Errorf("dbapi.insertEntryRelation failed to start db transaction : %v", err)
+	}
+	defer tx.Rollback()
+
+	err = checkEntriesNotFrozen(tx, []int64{r.FromEntryID, r.ToEntryID})
+	if err != nil {
+		return r, err
+	}
+
+	res, err := tx.Exec("INSERT INTO EntryRelation (type, fromEntryId, toEntryId, position) VALUES (?, ?, ?, ?)", r.Type, r.FromEntryID, r.ToEntryID, r.Position)
+	if err != nil {
+		return r, fmt.Errorf("dbapi.insertEntryRelation failed to insert relation '%s' : %v", r, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return r, fmt.Errorf("dbapi.insertEntryRelation failed to get last insert id : %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return r, fmt.Errorf("dbapi.insertEntryRelation failed to commit transaction : %v", err)
+	}
+	r.ID = id
+	return r, nil
+}
+
+// UpdateEntryRelation changes the type and position of an existing relation
+func updateEntryRelation(db *sql.DB, r lex.EntryRelation) (lex.EntryRelation, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return r, fmt.Errorf("dbapi.updateEntryRelation failed to start db transaction : %v", err)
+	}
+	defer tx.Rollback()
+
+	old, err := getEntryRelationTx(tx, r.ID)
+	if err != nil {
+		return r, fmt.Errorf("dbapi.updateEntryRelation : %v", err)
+	}
+	err = checkEntriesNotFrozen(tx, []int64{old.FromEntryID, old.ToEntryID})
+	if err != nil {
+		return r, err
+	}
+
+	res, err := tx.Exec("UPDATE EntryRelation SET type = ?, position = ? WHERE id = ?", r.Type, r.Position, r.ID)
+	if err != nil {
+		return r, fmt.Errorf("dbapi.updateEntryRelation failed to update relation with id %d : %v", r.ID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return r, fmt.Errorf("dbapi.updateEntryRelation failed to call RowsAffected : %v", err)
+	}
+	if n == 0 {
+		return r, fmt.Errorf("dbapi.updateEntryRelation : no relation with id %d", r.ID)
+	}
+	updated, err := getEntryRelationTx(tx, r.ID)
+	if err != nil {
+		return r, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return r, fmt.Errorf("dbapi.updateEntryRelation failed to commit transaction : %v", err)
+	}
+	return updated, nil
+}
+
+// DeleteEntryRelation removes the relation with the specified id
+func deleteEntryRelation(db *sql.DB, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("dbapi.deleteEntryRelation failed to start db transaction : %v", err)
+	}
+	defer tx.Rollback()
+
+	old, err := getEntryRelationTx(tx, id)
+	if err != nil {
+		return fmt.Errorf("dbapi.deleteEntryRelation : %v", err)
+	}
+	err = checkEntriesNotFrozen(tx, []int64{old.FromEntryID, old.ToEntryID})
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("DELETE FROM EntryRelation WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("dbapi.deleteEntryRelation failed to delete relation with id %d : %v", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("dbapi.deleteEntryRelation failed to call RowsAffected : %v", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("dbapi.deleteEntryRelation : no relation with id %d", id)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("dbapi.deleteEntryRelation failed to commit transaction : %v", err)
+	}
+	return nil
+}
+
+// ListEntryRelations returns all relations to or from any of the input entry ids, ordered by type, position and id
+func listEntryRelations(db *sql.DB, entryIDs []int64) ([]lex.EntryRelation, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return []lex.EntryRelation{}, fmt.Errorf("dbapi.listEntryRelations failed to start db transaction : %v", err)
+	}
+	defer tx.Commit()
+	return listEntryRelationsTx(tx, entryIDs)
+}
+
+func getEntryRelationTx(tx *sql.Tx, id int64) (lex.EntryRelation, error) {
+	r := lex.EntryRelation{}
+	err := tx.QueryRow("SELECT id, type, fromEntryId, toEntryId, position, Timestamp FROM EntryRelation WHERE id = ?", id).Scan(&r.ID, &r.Type, &r.FromEntryID, &r.ToEntryID, &r.Position, &r.Timestamp)
+	if err == sql.ErrNoRows {
+		return r, fmt.Errorf("no relation with id %d", id)
+	}
+	if err != nil {
+		return r, fmt.Errorf("getEntryRelationTx query failed : %v", err)
+	}
+	return r, nil
+}
+
+func listEntryRelationsTx(tx *sql.Tx, entryIDs []int64) ([]lex.EntryRelation, error) {
+	res := []lex.EntryRelation{}
+	if len(entryIDs) == 0 {
+		return res, nil
+	}
+
+	qs := nQs(len(entryIDs))
+	q := "SELECT id, type, fromEntryId, toEntryId, position, Timestamp FROM EntryRelation WHERE fromEntryId IN " + qs + " OR toEntryId IN " + qs + " ORDER BY type, fromEntryId, position, id"
+	args := append(convI(entryIDs), convI(entryIDs)...)
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return res, fmt.Errorf("listEntryRelationsTx query failed : %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r := lex.EntryRelation{}
+		err = rows.Scan(&r.ID, &r.Type, &r.FromEntryID, &r.ToEntryID, &r.Position, &r.Timestamp)
+		if err != nil {
+			return res, fmt.Errorf("listEntryRelationsTx failed to scan row : %v", err)
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// addRelatedEntries is used by DBManager.LookUp when Query.IncludeRelated is set. It attaches the relations of each entry, and appends the related entries not already in the input slice (possibly from other lexicons in the same database).
+func addRelatedEntries(dbif DBIF, db *sql.DB, entries []lex.Entry) ([]lex.Entry, error) {
+	ids := []int64{}
+	seen := make(map[int64]bool)
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+		seen[e.ID] = true
+	}
+	rels, err := listEntryRelations(db, ids)
+	if err != nil {
+		return entries, err
+	}
+
+	missing := []int64{}
+	for _, r := range rels {
+		for _, id := range []int64{r.FromEntryID, r.ToEntryID} {
+			if !seen[id] {
+				missing = append(missing, id)
+				seen[id] = true
+			}
+		}
+	}
+	res := entries
+	if len(missing) > 0 {
+		related := lex.EntrySliceWriter{}
+		err = dbif.lookUp(db, []lex.LexName{}, Query{EntryIDs: missing}, &related)
+		if err != nil {
+			return entries, fmt.Errorf("failed to look up related entries : %v", err)
+		}
+		// relations of the related entries are included as well, but are not followed further
+		relatedIDs := []int64{}
+		for _, e := range related.Entries {
+			relatedIDs = append(relatedIDs, e.ID)
+		}
+		rels2, err := listEntryRelations(db, relatedIDs)
+		if err != nil {
+			return entries, err
+		}
+		rels = append(rels, rels2...)
+		res = append(res, related.Entries...)
+	}
+
+	relMap := make(map[int64][]lex.EntryRelation)
+	relSeen := make(map[int64]bool)
+	for _, r := range rels {
+		if relSeen[r.ID] {
+			continue
+		}
+		relSeen[r.ID] = true
+		relMap[r.FromEntryID] = append(relMap[r.FromEntryID], r)
+		if r.ToEntryID != r.FromEntryID {
+			relMap[r.ToEntryID] = append(relMap[r.ToEntryID], r)
+		}
+	}
+	for i, e := range res {
+		res[i].Relations = relMap[e.ID]
+	}
+	return res, nil
+}
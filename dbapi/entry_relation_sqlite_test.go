@@ -0,0 +1,176 @@
+package dbapi
+
+import (
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// createTestSqliteDBManager defines a fresh sqlite db in the current folder, and returns a DBManager holding it
+func createTestSqliteDBManager(t testing.TB, dbRef lex.DBRef) *DBManager {
+	dbm := NewSqliteDBManager()
+	err := dbm.DropDB(".", dbRef)
+	if err != nil {
+		t.Fatalf("failed to drop db : %v", err)
+	}
+	err = dbm.DefineDB(".", dbRef)
+	if err != nil {
+		t.Fatalf("failed to define db : %v", err)
+	}
+	return dbm
+}
+
+// defineTestLexicons defines lexicons with the sv-se_ws-sampa symbol set
+func defineTestLexicons(t testing.TB, dbm *DBManager, lexRefs ...lex.LexRef) {
+	t.Helper()
+	for _, lexRef := range lexRefs {
+		err := dbm.DefineLexicon(lexRef, "sv-se_ws-sampa", "sv_SE")
+		if err != nil {
+			t.Fatalf("failed to define lexicon : %v", err)
+		}
+	}
+}
+
+// newTestEntry returns an entry with a single transcription, and the entry status imported
+func newTestEntry(strn, trans string) lex.Entry {
+	return lex.Entry{Strn: strn,
+		Language:       "sv-se",
+		Transcriptions: []lex.Transcription{{Strn: trans}},
+		EntryStatus:    lex.EntryStatus{Name: "imported", Source: "test"},
+	}
+}
+
+func TestEntryRelationsSqlite(t *testing.T) {
+	dbRef := lex.DBRef("entryrelation_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef1 := lex.NewLexRef(string(dbRef), "lex1")
+	lexRef2 := lex.NewLexRef(string(dbRef), "lex2")
+	defineTestLexicons(t, dbm, lexRef1, lexRef2)
+
+	ids1, err := dbm.InsertEntries(lexRef1, []lex.Entry{
+		newTestEntry("kexpaket", `"" k e k + p a . k % e: t`),
+		newTestEntry("kex", `" k e k s`),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	ids2, err := dbm.InsertEntries(lexRef2, []lex.Entry{
+		newTestEntry("paket", `p a . k "e: t`),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	compound, kex, paket := ids1[0], ids1[1], ids2[0]
+
+	// invalid relations
+	_, err = dbm.InsertEntryRelation(dbRef, lex.EntryRelation{Type: "unknown", FromEntryID: compound, ToEntryID: kex})
+	if err == nil {
+		t.Errorf("expected error for unknown relation type")
+	}
+	_, err = dbm.InsertEntryRelation(dbRef, lex.EntryRelation{Type: lex.SeeAlso, FromEntryID: kex, ToEntryID: kex})
+	if err == nil {
+		t.Errorf("expected error for self relation")
+	}
+
+	r1, err := dbm.InsertEntryRelation(dbRef, lex.EntryRelation{Type: lex.CompoundPart, FromEntryID: compound, ToEntryID: kex, Position: 1})
+	if err != nil {
+		t.Fatalf("failed to insert relation : %v", err)
+	}
+	// cross-lexicon relation
+	r2, err := dbm.InsertEntryRelation(dbRef, lex.EntryRelation{Type: lex.CompoundPart, FromEntryID: compound, ToEntryID: paket, Position: 2})
+	if err != nil {
+		t.Fatalf("failed to insert relation : %v", err)
+	}
+	_, err = dbm.InsertEntryRelation(dbRef, lex.EntryRelation{Type: lex.CompoundPart, FromEntryID: compound, ToEntryID: paket, Position: 2})
+	if err == nil {
+		t.Errorf("expected error for duplicate relation")
+	}
+
+	rels, err := dbm.ListEntryRelations(dbRef, compound)
+	if err != nil {
+		t.Fatalf("failed to list relations : %v", err)
+	}
+	if w, g := 2, len(rels); w != g {
+		t.Fatalf("expected %d relations, got %d", w, g)
+	}
+	if w, g := r1.ID, rels[0].ID; w != g {
+		t.Errorf("expected relation id %d, got %d", w, g)
+	}
+	if w, g := r2.ID, rels[1].ID; w != g {
+		t.Errorf("expected relation id %d, got %d", w, g)
+	}
+
+	// lookup with related entries
+	q := DBMQuery{LexRefs: []lex.LexRef{lexRef1}, Query: Query{Words: []string{"kexpaket"}}}
+	res, err := dbm.LookUpIntoSlice(q)
+	if err != nil {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	if w, g := 1, len(res); w != g {
+		t.Fatalf("expected %d entries, got %d", w, g)
+	}
+	if len(res[0].Relations) != 0 {
+		t.Errorf("expected no relations without includeRelated, got %v", res[0].Relations)
+	}
+	q.Query.IncludeRelated = true
+	res, err = dbm.LookUpIntoSlice(q)
+	if err != nil {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	if w, g := 3, len(res); w != g {
+		t.Fatalf("expected %d entries, got %d", w, g)
+	}
+	if w, g := 2, len(res[0].Relations); w != g {
+		t.Errorf("expected %d relations, got %d", w, g)
+	}
+	found := false
+	for _, e := range res {
+		if e.ID == paket {
+			found = true
+			if w, g := lexRef2, e.LexRef; w != g {
+				t.Errorf("expected lexRef %v, got %v", w, g)
+			}
+			if w, g := 1, len(e.Relations); w != g {
+				t.Errorf("expected %d relations, got %d", w, g)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected related entry from other lexicon")
+	}
+
+	// update
+	r1.Type = lex.SeeAlso
+	r1, err = dbm.UpdateEntryRelation(dbRef, r1)
+	if err != nil {
+		t.Fatalf("failed to update relation : %v", err)
+	}
+	if w, g := lex.SeeAlso, r1.Type; w != g {
+		t.Errorf("expected %s, got %s", w, g)
+	}
+
+	// delete
+	err = dbm.DeleteEntryRelation(dbRef, r1.ID)
+	if err != nil {
+		t.Fatalf("failed to delete relation : %v", err)
+	}
+	err = dbm.DeleteEntryRelation(dbRef, r1.ID)
+	if err == nil {
+		t.Errorf("expected error for deleting non-existing relation")
+	}
+
+	// deleting an entry removes its relations
+	_, err = dbm.DeleteEntry(paket, lexRef2)
+	if err != nil {
+		t.Fatalf("failed to delete entry : %v", err)
+	}
+	rels, err = dbm.ListEntryRelations(dbRef, compound)
+	if err != nil {
+		t.Fatalf("failed to list relations : %v", err)
+	}
+	if w, g := 0, len(rels); w != g {
+		t.Errorf("expected %d relations, got %d", w, g)
+	}
+}
@@ -0,0 +1,201 @@
+package dbapi
+
+// A frozen lexicon is read-only: its entries cannot be inserted, updated, deleted, moved or revalidated, and the lexicon cannot be deleted, until it is unfrozen. This protects lexicons used in production from accidental changes.
+// The check is made by the DBIF implementations (insertEntries, updateEntryTx, deleteEntry, moveNewEntriesTx, updateValidationTx and deleteLexicon), and violations are reported as a *FrozenLexiconError.
+// The frozen state is local to the database, and not replicated; changes applied from a replication source (see ApplyChanges) are not checked.
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// LexiconFreeze is the frozen state of a lexicon
+type LexiconFreeze struct {
+	LexRef lex.LexRef `json:"lexRef"`
+	Frozen bool       `json:"frozen"`
+	// User is the user who froze the lexicon
+	User    string `json:"user,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	// Created is the time when the lexicon was frozen
+	Created string `json:"created,omitempty"`
+}
+
+// FrozenLexiconError is returned when trying to modify a frozen lexicon
+type FrozenLexiconError struct {
+	LexName string
+}
+
+func (e *FrozenLexiconError) Error() string {
+	return fmt.Sprintf("lexicon '%s' is frozen (read-only)", e.LexName)
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// frozenLexicons returns the names of the frozen lexicons in the database, by lexicon id
+func frozenLexicons(q queryer) (map[int64]string, error) {
+	res := make(map[int64]string)
+	rows, err := q.Query("SELECT Lexicon.id, Lexicon.name FROM FrozenLexicon, Lexicon WHERE FrozenLexicon.lexiconId = Lexicon.id")
+	if err != nil {
+		return res, fmt.Errorf("failed to list frozen lexicons : %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		err = rows.Scan(&id, &name)
+		if err != nil {
+			return res, fmt.Errorf("failed to scan frozen lexicon : %v", err)
+		}
+		res[id] = name
+	}
+	return res, rows.Err()
+}
+
+// checkNotFrozen returns a *FrozenLexiconError if one of the lexicons is frozen
+func checkNotFrozen(q queryer, lexiconIDs ...int64) error {
+	frozen, err := frozenLexicons(q)
+	if err != nil {
+		return err
+	}
+	for _, id := range lexiconIDs {
+		if name, ok := frozen[id]; ok {
+			return &FrozenLexiconError{LexName: name}
+		}
+	}
+	return nil
+}
+
+// frozenCheckChunk is the max number of entries looked up at a time by checkEntriesNotFrozen
+const frozenCheckChunk = 500
+
+// checkEntriesNotFrozen returns a *FrozenLexiconError if one of the entries belongs to a frozen lexicon
+func checkEntriesNotFrozen(q queryer, entryIDs []int64) error {
+	frozen, err := frozenLexicons(q)
+	if err != nil || len(frozen) == 0 || len(entryIDs) == 0 {
+		return err
+	}
+	for start := 0; start < len(entryIDs); start += frozenCheckChunk {
+		end := start + frozenCheckChunk
+		if end > len(entryIDs) {
+			end = len(entryIDs)
+		}
+		chunk := entryIDs[start:end]
+		rows, err := q.Query("SELECT DISTINCT lexiconId FROM Entry WHERE id IN "+nQs(len(chunk)), convI(chunk)...)
+		if err != nil {
+			return fmt.Errorf("failed to list entry lexicons : %v", err)
+		}
+		for rows.Next() {
+			var id int64
+			err = rows.Scan(&id)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan entry lexicon : %v", err)
+			}
+			if name, ok := frozen[id]; ok {
+				rows.Close()
+				return &FrozenLexiconError{LexName: name}
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("failed to list entry lexicons : %v", err)
+		}
+	}
+	return nil
+}
+
+// getLexiconFreeze returns the frozen state of the lexicon
+func getLexiconFreeze(db *sql.DB, lexRef lex.LexRef, lexiconID int64) (LexiconFreeze, error) {
+	res := LexiconFreeze{LexRef: lexRef}
+	err := db.QueryRow("SELECT frozenBy, comment, created FROM FrozenLexicon WHERE lexiconId = ?", lexiconID).Scan(&res.User, &res.Comment, &res.Created)
+	if err == sql.ErrNoRows {
+		return res, nil
+	}
+	if err != nil {
+		return res, fmt.Errorf("failed to get frozen state : %v", err)
+	}
+	res.Frozen = true
+	return res, nil
+}
+
+// GetLexiconFreeze returns the frozen state of the specified lexicon
+func (dbm *DBManager) GetLexiconFreeze(lexRef lex.LexRef) (LexiconFreeze, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return LexiconFreeze{}, fmt.Errorf("DBManager.GetLexiconFreeze: no such db '%s'", lexRef.DBRef)
+	}
+	l, err := dbm.dbifOf(db).getLexicon(db, string(lexRef.LexName))
+	if err != nil {
+		return LexiconFreeze{}, fmt.Errorf("DBManager.GetLexiconFreeze: %v", err)
+	}
+	res, err := getLexiconFreeze(db, lexRef, l.id)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.GetLexiconFreeze: %v", err)
+	}
+	return res, nil
+}
+
+// FreezeLexicon makes the specified lexicon read-only, until it is unfrozen (see UnfreezeLexicon). Returns an error if the lexicon is already frozen.
+func (dbm *DBManager) FreezeLexicon(lexRef lex.LexRef, user, comment string) (LexiconFreeze, error) {
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return LexiconFreeze{}, fmt.Errorf("DBManager.FreezeLexicon: no such db '%s'", lexRef.DBRef)
+	}
+	l, err := dbm.dbifOf(db).getLexicon(db, string(lexRef.LexName))
+	if err != nil {
+		return LexiconFreeze{}, fmt.Errorf("DBManager.FreezeLexicon: %v", err)
+	}
+	res, err := getLexiconFreeze(db, lexRef, l.id)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.FreezeLexicon: %v", err)
+	}
+	if res.Frozen {
+		return res, fmt.Errorf("DBManager.FreezeLexicon: lexicon '%s' is already frozen", lexRef.LexName)
+	}
+	_, err = db.Exec("INSERT INTO FrozenLexicon (lexiconId, frozenBy, comment, created) VALUES (?, ?, ?, ?)", l.id, strings.TrimSpace(user), comment, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return LexiconFreeze{}, fmt.Errorf("DBManager.FreezeLexicon: failed to freeze lexicon : %v", err)
+	}
+	res, err = getLexiconFreeze(db, lexRef, l.id)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.FreezeLexicon: %v", err)
+	}
+	return res, nil
+}
+
+// UnfreezeLexicon makes a frozen lexicon (see FreezeLexicon) writable again. Returns the frozen state of the lexicon before it was unfrozen, or an error if the lexicon is not frozen.
+func (dbm *DBManager) UnfreezeLexicon(lexRef lex.LexRef) (LexiconFreeze, error) {
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return LexiconFreeze{}, fmt.Errorf("DBManager.UnfreezeLexicon: no such db '%s'", lexRef.DBRef)
+	}
+	l, err := dbm.dbifOf(db).getLexicon(db, string(lexRef.LexName))
+	if err != nil {
+		return LexiconFreeze{}, fmt.Errorf("DBManager.UnfreezeLexicon: %v", err)
+	}
+	res, err := getLexiconFreeze(db, lexRef, l.id)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.UnfreezeLexicon: %v", err)
+	}
+	if !res.Frozen {
+		return res, fmt.Errorf("DBManager.UnfreezeLexicon: lexicon '%s' is not frozen", lexRef.LexName)
+	}
+	_, err = db.Exec("DELETE FROM FrozenLexicon WHERE lexiconId = ?", l.id)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.UnfreezeLexicon: failed to unfreeze lexicon : %v", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,153 @@
+package dbapi
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/symbolset/mapper"
+)
+
+func TestFrozenLexiconSqlite(t *testing.T) {
+	dbRef := lex.DBRef("frozen_lexicon_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	newLexRef := lex.NewLexRef(string(dbRef), "lex2")
+	defineTestLexicons(t, dbm, lexRef, newLexRef)
+	katt := newTestEntry("katt", `" k a t`)
+	katt.Comments = []lex.EntryComment{{Label: assignCommentLabel, Source: "bengt", Comment: "nisse"}}
+	ids, err := dbm.InsertEntries(lexRef, []lex.Entry{newTestEntry("hund", `" h u0 n d`), katt})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	rel, err := dbm.InsertEntryRelation(dbRef, lex.EntryRelation{Type: "compound_part", FromEntryID: ids[0], ToEntryID: ids[1]})
+	if err != nil {
+		t.Fatalf("failed to insert entry relation : %v", err)
+	}
+	_, err = dbm.InsertEntries(newLexRef, []lex.Entry{newTestEntry("häst", `" h E s t`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	_, err = dbm.UnfreezeLexicon(lexRef)
+	if err == nil {
+		t.Errorf("expected error for unfreezing a lexicon that is not frozen")
+	}
+	f, err := dbm.FreezeLexicon(lexRef, "anna", "used by the released voice")
+	if err != nil {
+		t.Fatalf("failed to freeze lexicon : %v", err)
+	}
+	if !f.Frozen || f.User != "anna" || f.Created == "" {
+		t.Errorf("unexpected frozen state : %#v", f)
+	}
+	_, err = dbm.FreezeLexicon(lexRef, "anna", "")
+	if err == nil {
+		t.Errorf("expected error for freezing a frozen lexicon")
+	}
+
+	isFrozen := func(err error) bool {
+		t.Helper()
+		var fe *FrozenLexiconError
+		return errors.As(err, &fe) && fe.LexName == "lex1"
+	}
+	_, _, err = dbm.InsertEntriesAs(lexRef, []lex.Entry{newTestEntry("mus", `" m u0: s`)}, "anna")
+	if !isFrozen(err) {
+		t.Errorf("expected frozen lexicon error for insert, got %v", err)
+	}
+	es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{EntryIDs: []int64{ids[0]}}})
+	if err != nil || len(es) != 1 {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	e := es[0]
+	e.PartOfSpeech = "NN"
+	_, _, err = dbm.UpdateEntry(e)
+	if !isFrozen(err) {
+		t.Errorf("expected frozen lexicon error for update, got %v", err)
+	}
+	e.EntryValidations = []lex.EntryValidation{{RuleName: "test", Level: "Warning", Message: "test"}}
+	err = dbm.UpdateValidation(e)
+	if !isFrozen(err) {
+		t.Errorf("expected frozen lexicon error for validation update, got %v", err)
+	}
+	_, err = dbm.DeleteEntry(ids[1], lexRef)
+	if !isFrozen(err) {
+		t.Errorf("expected frozen lexicon error for delete, got %v", err)
+	}
+	_, err = dbm.MoveNewEntries(dbRef, newLexRef.LexName, lexRef.LexName, "test", "moved")
+	if !isFrozen(err) {
+		t.Errorf("expected frozen lexicon error for move, got %v", err)
+	}
+	_, err = dbm.ImportLexiconFileAs(lexRef, SilentLogger{}, "./sv-lextest.txt", nil, "anna")
+	if !isFrozen(err) {
+		t.Errorf("expected frozen lexicon error for import, got %v", err)
+	}
+	_, err = dbm.InsertEntryRelation(dbRef, lex.EntryRelation{Type: "compound_part", FromEntryID: ids[1], ToEntryID: ids[0]})
+	if !isFrozen(err) {
+		t.Errorf("expected frozen lexicon error for relation insert, got %v", err)
+	}
+	rel.Position = 1
+	_, err = dbm.UpdateEntryRelation(dbRef, rel)
+	if !isFrozen(err) {
+		t.Errorf("expected frozen lexicon error for relation update, got %v", err)
+	}
+	err = dbm.DeleteEntryRelation(dbRef, rel.ID)
+	if !isFrozen(err) {
+		t.Errorf("expected frozen lexicon error for relation delete, got %v", err)
+	}
+	m, err := mapper.LoadMapperFromFile("SYMBOL", "SYMBOL", "./test_data/sv-se_ws-sampa.sym", "./test_data/sv-se_sampa_mary.sym")
+	if err != nil {
+		t.Fatalf("failed to load mapper : %v", err)
+	}
+	_, err = dbm.ConvertSymbolSet(lexRef, m, "")
+	if !isFrozen(err) {
+		t.Errorf("expected frozen lexicon error for symbol set conversion, got %v", err)
+	}
+	_, err = dbm.ConvertAssignComments(dbRef)
+	if !isFrozen(err) {
+		t.Errorf("expected frozen lexicon error for assign comment conversion, got %v", err)
+	}
+	err = dbm.DeleteLexicon(lexRef)
+	if !isFrozen(err) {
+		t.Errorf("expected frozen lexicon error for lexicon deletion, got %v", err)
+	}
+	n, err := dbm.EntryCount(lexRef)
+	if err != nil || n != 2 {
+		t.Errorf("expected 2 entries in frozen lexicon, got %d : %v", n, err)
+	}
+	bs, err := dbm.ListImportBatches(dbRef, lexRef.LexName)
+	if err != nil || len(bs) != 1 {
+		t.Errorf("expected 1 import batch, got %#v : %v", bs, err)
+	}
+
+	// other lexicons in the database are not affected
+	_, err = dbm.InsertEntries(newLexRef, []lex.Entry{newTestEntry("mus", `" m u0: s`)})
+	if err != nil {
+		t.Errorf("failed to insert entries : %v", err)
+	}
+
+	f, err = dbm.UnfreezeLexicon(lexRef)
+	if err != nil {
+		t.Fatalf("failed to unfreeze lexicon : %v", err)
+	}
+	if f.User != "anna" || f.Comment != "used by the released voice" {
+		t.Errorf("unexpected frozen state : %#v", f)
+	}
+	f, err = dbm.GetLexiconFreeze(lexRef)
+	if err != nil || f.Frozen {
+		t.Errorf("expected unfrozen lexicon, got %#v : %v", f, err)
+	}
+	_, _, err = dbm.UpdateEntry(e)
+	if err != nil {
+		t.Errorf("failed to update entry : %v", err)
+	}
+	rels, err := dbm.ListEntryRelations(dbRef, ids[0])
+	if err != nil || len(rels) != 1 || rels[0].Position != 0 {
+		t.Errorf("expected the relation to be unchanged, got %#v : %v", rels, err)
+	}
+	n2, err := dbm.ConvertAssignComments(dbRef)
+	if err != nil || n2 != 1 {
+		t.Errorf("expected 1 converted comment after unfreezing, got %d : %v", n2, err)
+	}
+}
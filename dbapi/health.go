@@ -0,0 +1,88 @@
+package dbapi
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// Database health statuses (see DBHealth)
+const (
+	// HealthOK is the status of a database that is reachable, and has the expected schema version
+	HealthOK = "ok"
+	// HealthUnavailable is the status of a database that cannot be reached, or queried
+	HealthUnavailable = "unavailable"
+	// HealthSchemaMismatch is the status of a database with a schema version other than SchemaVersion
+	HealthSchemaMismatch = "schema_mismatch"
+)
+
+// DBHealth is the result of a health check of a database (see DBManager.CheckHealth)
+type DBHealth struct {
+	DBRef  lex.DBRef `json:"db"`
+	Status string    `json:"status"`
+	// SchemaVersion is the schema version of the database, if it could be read
+	SchemaVersion string `json:"schemaVersion,omitempty"`
+	// Error is the reason the database is not ok, if any
+	Error string `json:"error,omitempty"`
+	// Duration is the time taken for the check, in milliseconds
+	Duration int64 `json:"durationMs"`
+}
+
+// OK returns true if the database status is HealthOK
+func (h DBHealth) OK() bool {
+	return h.Status == HealthOK
+}
+
+// CheckHealth checks each database in the DBManager: that it can be reached (ping), and that its schema version equals SchemaVersion. The databases are checked concurrently, and each check is aborted after the specified timeout. The result is sorted by database name.
+func (dbm *DBManager) CheckHealth(timeout time.Duration) []DBHealth {
+	// the databases are checked without holding the DBManager lock, so that a slow database doesn't block other calls
+	dbm.RLock()
+	dbs := make(map[lex.DBRef]*sql.DB)
+	for dbRef, db := range dbm.dbs {
+		dbs[dbRef] = db
+	}
+	dbm.RUnlock()
+
+	ch := make(chan DBHealth, len(dbs))
+	for dbRef, db := range dbs {
+		go func(dbRef lex.DBRef, db *sql.DB) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			ch <- checkDBHealth(ctx, dbRef, db)
+		}(dbRef, db)
+	}
+	res := []DBHealth{}
+	for range dbs {
+		res = append(res, <-ch)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].DBRef < res[j].DBRef })
+	return res
+}
+
+func checkDBHealth(ctx context.Context, dbRef lex.DBRef, db *sql.DB) (res DBHealth) {
+	start := time.Now()
+	res = DBHealth{DBRef: dbRef, Status: HealthUnavailable}
+	defer func() { res.Duration = time.Since(start).Milliseconds() }()
+
+	err := db.PingContext(ctx)
+	if err != nil {
+		res.Error = fmt.Sprintf("ping failed : %v", err)
+		return res
+	}
+	err = db.QueryRowContext(ctx, "SELECT name FROM SchemaVersion").Scan(&res.SchemaVersion)
+	if err != nil {
+		res.Error = fmt.Sprintf("couldn't read schema version : %v", err)
+		return res
+	}
+	if res.SchemaVersion != SchemaVersion {
+		res.Status = HealthSchemaMismatch
+		res.Error = fmt.Sprintf("schema version %s, expected %s", res.SchemaVersion, SchemaVersion)
+		return res
+	}
+	res.Status = HealthOK
+	return res
+}
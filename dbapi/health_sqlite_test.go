@@ -0,0 +1,38 @@
+package dbapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestCheckHealthSqlite(t *testing.T) {
+	dbRef := lex.DBRef("health_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	hs := dbm.CheckHealth(5 * time.Second)
+	if len(hs) != 1 || !hs[0].OK() || hs[0].DBRef != dbRef || hs[0].SchemaVersion != SchemaVersion {
+		t.Fatalf("expected healthy db %s, got %#v", dbRef, hs)
+	}
+
+	db := dbm.dbs[dbRef]
+	_, err := db.Exec("UPDATE SchemaVersion SET name = '0.1'")
+	if err != nil {
+		t.Fatalf("failed to update schema version : %v", err)
+	}
+	hs = dbm.CheckHealth(5 * time.Second)
+	if len(hs) != 1 || hs[0].Status != HealthSchemaMismatch || hs[0].SchemaVersion != "0.1" {
+		t.Errorf("expected schema mismatch, got %#v", hs)
+	}
+
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("failed to close db : %v", err)
+	}
+	hs = dbm.CheckHealth(5 * time.Second)
+	if len(hs) != 1 || hs[0].Status != HealthUnavailable || hs[0].Error == "" {
+		t.Errorf("expected unavailable db, got %#v", hs)
+	}
+}
@@ -0,0 +1,438 @@
+package dbapi
+
+// Import batches record each run of ImportLexiconFile and InsertEntries: the file name, a checksum of the input, the user, and the entries created, with their line numbers in the input.
+// An import batch can be undone (see UndoImportBatch), which deletes the entries of the batch that have not been edited since the import. An entry counts as edited if its reviewable fields (see proposalFields) or its entry status have changed, or if it has been moved to another lexicon.
+// Import batches are local to the database, and not replicated (see ApplyChanges); an undone batch is replicated as ordinary entry deletions.
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// ImportBatch is a run of ImportLexiconFile or InsertEntries
+type ImportBatch struct {
+	ID     int64      `json:"id"`
+	LexRef lex.LexRef `json:"lexRef"`
+	// FileName is the base name of the imported file, or empty for entries inserted using InsertEntries
+	FileName string `json:"fileName,omitempty"`
+	// Checksum is the SHA-256 checksum of the imported file, or of the JSON encoded entries inserted using InsertEntries
+	Checksum   string `json:"checksum"`
+	User       string `json:"user,omitempty"`
+	Created    string `json:"created"`
+	EntryCount int    `json:"entryCount"`
+	// Undone is the time when the batch was undone, or empty
+	Undone string `json:"undone,omitempty"`
+}
+
+// ImportBatchEntry is an entry created by an import batch. LineNumber is the line of the entry in the imported file, or the position of the entry (starting at 1) in the list of entries inserted using InsertEntries.
+type ImportBatchEntry struct {
+	EntryID    int64 `json:"entryId"`
+	LineNumber int   `json:"lineNumber"`
+}
+
+// UndoImportReport lists the entries of an undone import batch
+type UndoImportReport struct {
+	Batch ImportBatch `json:"batch"`
+	// Deleted are the entries that were deleted
+	Deleted []ImportBatchEntry `json:"deleted"`
+	// Edited are the entries that were kept, since they have been edited after the import
+	Edited []ImportBatchEntry `json:"edited"`
+	// Locked are the entries that were kept, since they are locked (see LockEntry)
+	Locked []ImportBatchEntry `json:"locked"`
+	// Missing are the entries that had already been deleted
+	Missing []ImportBatchEntry `json:"missing"`
+}
+
+// fileChecksum returns the SHA-256 checksum of a file
+func fileChecksum(fileName string) (string, error) {
+	fh, err := os.Open(filepath.Clean(fileName))
+	if err != nil {
+		return "", err
+	}
+	/* #nosec G307 */
+	defer fh.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, fh)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// entriesChecksum returns the SHA-256 checksum of the JSON encoded entries
+func entriesChecksum(es []lex.Entry) (string, error) {
+	bts, err := json.Marshal(es)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bts)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// entryFingerprint returns a checksum of the reviewable fields and the entry status of an entry, used to tell whether the entry has been edited since it was imported
+func entryFingerprint(e lex.Entry) (string, error) {
+	bts, err := json.Marshal(struct {
+		Fields   []ProposalChange
+		StatusID int64
+	}{proposalFields(e), e.EntryStatus.ID})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bts)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// createImportBatch saves a new (empty) import batch for the lexicon, and returns its id
+func createImportBatch(db execer, lexiconID int64, fileName, checksum, user string) (int64, error) {
+	res, err := db.Exec("INSERT INTO ImportBatch (lexiconId, fileName, checksum, importedBy, created) VALUES (?, ?, ?, ?, ?)", lexiconID, fileName, checksum, strings.TrimSpace(user), time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert import batch : %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get import batch id : %v", err)
+	}
+	return id, nil
+}
+
+// fingerprintChunk is the max number of entries looked up at a time when adding entries to an import batch
+const fingerprintChunk = 500
+
+// addImportBatchEntries adds newly created entries of the lexicon to an import batch. The entries are looked up to compute their fingerprints (see entryFingerprint), so that later edits can be detected. Each chunk of entries is added in a transaction of its own.
+func addImportBatchEntries(dbif DBIF, db *sql.DB, lexName lex.LexName, batchID int64, ids []int64, lineNumbers []int) error {
+	if len(ids) != len(lineNumbers) {
+		return fmt.Errorf("got %d entry ids, but %d line numbers", len(ids), len(lineNumbers))
+	}
+	for start := 0; start < len(ids); start += fingerprintChunk {
+		end := start + fingerprintChunk
+		if end > len(ids) {
+			end = len(ids)
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to start db transaction : %v", err)
+		}
+		err = addImportBatchEntriesTx(dbif, tx, lexName, batchID, ids[start:end], lineNumbers[start:end])
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return fmt.Errorf("failed to commit transaction : %v", err)
+		}
+	}
+	return nil
+}
+
+// addImportBatchEntriesTx adds newly created entries of the lexicon to an import batch, in a transaction (see addImportBatchEntries)
+func addImportBatchEntriesTx(dbif DBIF, tx *sql.Tx, lexName lex.LexName, batchID int64, ids []int64, lineNumbers []int) error {
+	if len(ids) != len(lineNumbers) {
+		return fmt.Errorf("got %d entry ids, but %d line numbers", len(ids), len(lineNumbers))
+	}
+	for start := 0; start < len(ids); start += fingerprintChunk {
+		end := start + fingerprintChunk
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var w lex.EntrySliceWriter
+		err := dbif.lookUpTx(tx, []lex.LexName{lexName}, Query{EntryIDs: ids[start:end]}, &w)
+		if err != nil {
+			return err
+		}
+		fps, err := entryStates(w.Entries)
+		if err != nil {
+			return err
+		}
+		for i := start; i < end; i++ {
+			_, err = tx.Exec("INSERT INTO ImportBatchEntry (batchId, entryId, lineNumber, fingerprint) VALUES (?, ?, ?, ?)", batchID, ids[i], lineNumbers[i], fps[ids[i]].fingerprint)
+			if err != nil {
+				return fmt.Errorf("failed to insert import batch entry : %v", err)
+			}
+		}
+		_, err = tx.Exec("UPDATE ImportBatch SET entryCount = entryCount + ? WHERE id = ?", end-start, batchID)
+		if err != nil {
+			return fmt.Errorf("failed to update import batch : %v", err)
+		}
+	}
+	return nil
+}
+
+// entryState is the fingerprint (see entryFingerprint) and orthography of an entry
+type entryState struct {
+	fingerprint, strn string
+}
+
+// fingerprints looks up the entries of the lexicon, and returns their fingerprints and orthographies. Entries not found in the lexicon are not included.
+func fingerprints(dbif DBIF, db *sql.DB, lexName lex.LexName, ids []int64) (map[int64]entryState, error) {
+	var w lex.EntrySliceWriter
+	err := dbif.lookUp(db, []lex.LexName{lexName}, Query{EntryIDs: ids}, &w)
+	if err != nil {
+		return make(map[int64]entryState), err
+	}
+	return entryStates(w.Entries)
+}
+
+// entryStates returns the fingerprints and orthographies of the entries
+func entryStates(entries []lex.Entry) (map[int64]entryState, error) {
+	res := make(map[int64]entryState)
+	for _, e := range entries {
+		fp, err := entryFingerprint(e)
+		if err != nil {
+			return res, fmt.Errorf("failed to compute fingerprint of entry %d : %v", e.ID, err)
+		}
+		res[e.ID] = entryState{fingerprint: fp, strn: e.Strn}
+	}
+	return res, nil
+}
+
+const importBatchColumns = "ImportBatch.id, Lexicon.name, ImportBatch.fileName, ImportBatch.checksum, ImportBatch.importedBy, ImportBatch.created, ImportBatch.entryCount, ImportBatch.undone"
+
+// listImportBatches lists the import batches in the database matching the SQL condition (may be empty), ordered by id
+func listImportBatches(db *sql.DB, dbRef lex.DBRef, cond string, args ...interface{}) ([]ImportBatch, error) {
+	res := []ImportBatch{}
+	q := "SELECT " + importBatchColumns + " FROM ImportBatch, Lexicon WHERE ImportBatch.lexiconId = Lexicon.id"
+	if cond != "" {
+		q += " AND " + cond
+	}
+	rows, err := db.Query(q+" ORDER BY ImportBatch.id", args...)
+	if err != nil {
+		return res, fmt.Errorf("failed to list import batches : %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		b := ImportBatch{LexRef: lex.LexRef{DBRef: dbRef}}
+		err = rows.Scan(&b.ID, &b.LexRef.LexName, &b.FileName, &b.Checksum, &b.User, &b.Created, &b.EntryCount, &b.Undone)
+		if err != nil {
+			return res, fmt.Errorf("failed to scan import batch : %v", err)
+		}
+		res = append(res, b)
+	}
+	err = rows.Err()
+	if err != nil {
+		return res, fmt.Errorf("failed to list import batches : %v", err)
+	}
+	return res, nil
+}
+
+// getImportBatch returns the import batch with the id
+func getImportBatch(db *sql.DB, dbRef lex.DBRef, id int64) (ImportBatch, error) {
+	bs, err := listImportBatches(db, dbRef, "ImportBatch.id = ?", id)
+	if err != nil {
+		return ImportBatch{}, err
+	}
+	if len(bs) != 1 {
+		return ImportBatch{}, fmt.Errorf("no import batch with id %d in db '%s'", id, dbRef)
+	}
+	return bs[0], nil
+}
+
+// ListImportBatches returns the import batches of a database, ordered by id. If lexName is not empty, only the batches of that lexicon are listed.
+func (dbm *DBManager) ListImportBatches(dbRef lex.DBRef, lexName lex.LexName) ([]ImportBatch, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return []ImportBatch{}, fmt.Errorf("DBManager.ListImportBatches: no such db '%s'", dbRef)
+	}
+	var bs []ImportBatch
+	var err error
+	if lexName == "" {
+		bs, err = listImportBatches(db, dbRef, "")
+	} else {
+		bs, err = listImportBatches(db, dbRef, "Lexicon.name = ?", string(lexName))
+	}
+	if err != nil {
+		return bs, fmt.Errorf("DBManager.ListImportBatches: %v", err)
+	}
+	return bs, nil
+}
+
+// ImportBatchEntries returns the entries created by an import batch, ordered by line number
+func (dbm *DBManager) ImportBatchEntries(dbRef lex.DBRef, batchID int64) ([]ImportBatchEntry, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return []ImportBatchEntry{}, fmt.Errorf("DBManager.ImportBatchEntries: no such db '%s'", dbRef)
+	}
+	_, err := getImportBatch(db, dbRef, batchID)
+	if err != nil {
+		return []ImportBatchEntry{}, fmt.Errorf("DBManager.ImportBatchEntries: %v", err)
+	}
+	es, _, err := importBatchEntries(db, batchID)
+	if err != nil {
+		return es, fmt.Errorf("DBManager.ImportBatchEntries: %v", err)
+	}
+	return es, nil
+}
+
+// importBatchEntries returns the entries of an import batch ordered by line number, and their fingerprints
+func importBatchEntries(db *sql.DB, batchID int64) ([]ImportBatchEntry, map[int64]string, error) {
+	res := []ImportBatchEntry{}
+	fps := make(map[int64]string)
+	rows, err := db.Query("SELECT entryId, lineNumber, fingerprint FROM ImportBatchEntry WHERE batchId = ? ORDER BY lineNumber, entryId", batchID)
+	if err != nil {
+		return res, fps, fmt.Errorf("failed to list import batch entries : %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e ImportBatchEntry
+		var fp string
+		err = rows.Scan(&e.EntryID, &e.LineNumber, &fp)
+		if err != nil {
+			return res, fps, fmt.Errorf("failed to scan import batch entry : %v", err)
+		}
+		res = append(res, e)
+		fps[e.EntryID] = fp
+	}
+	err = rows.Err()
+	if err != nil {
+		return res, fps, fmt.Errorf("failed to list import batch entries : %v", err)
+	}
+	return res, fps, nil
+}
+
+// UndoImportBatch deletes the entries created by an import batch, except the entries that have been edited since the import, and entries locked by someone (see LockEntry). The batch is marked as undone, and cannot be undone again. The entries are deleted, and the batch is marked as undone, in a single transaction. Returns a report of the deleted and kept entries.
+func (dbm *DBManager) UndoImportBatch(dbRef lex.DBRef, batchID int64) (UndoImportReport, error) {
+	res := UndoImportReport{Deleted: []ImportBatchEntry{}, Edited: []ImportBatchEntry{}, Locked: []ImportBatchEntry{}, Missing: []ImportBatchEntry{}}
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return res, fmt.Errorf("DBManager.UndoImportBatch: no such db '%s'", dbRef)
+	}
+	b, err := getImportBatch(db, dbRef, batchID)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.UndoImportBatch: %v", err)
+	}
+	if b.Undone != "" {
+		return res, fmt.Errorf("DBManager.UndoImportBatch: import batch %d was already undone at %s", batchID, b.Undone)
+	}
+	l, err := dbm.dbifOf(db).getLexicon(db, string(b.LexRef.LexName))
+	if err != nil {
+		return res, fmt.Errorf("DBManager.UndoImportBatch: %v", err)
+	}
+	err = checkNotFrozen(db, l.id)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.UndoImportBatch: %w", err)
+	}
+	es, importFPs, err := importBatchEntries(db, batchID)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.UndoImportBatch: %v", err)
+	}
+
+	ids := []int64{}
+	for _, e := range es {
+		ids = append(ids, e.EntryID)
+	}
+	current := make(map[int64]entryState)
+	for start := 0; start < len(ids); start += fingerprintChunk {
+		end := start + fingerprintChunk
+		if end > len(ids) {
+			end = len(ids)
+		}
+		fps, err := fingerprints(dbm.dbifOf(db), db, b.LexRef.LexName, ids[start:end])
+		if err != nil {
+			return res, fmt.Errorf("DBManager.UndoImportBatch: %v", err)
+		}
+		for id, st := range fps {
+			current[id] = st
+		}
+	}
+
+	dbm.invalidateLexicons(b.LexRef)
+	toDelete := []ImportBatchEntry{}
+	for _, e := range es {
+		st, ok := current[e.EntryID]
+		switch {
+		case !ok && !entryExists(db, e.EntryID):
+			res.Missing = append(res.Missing, e)
+		case !ok || st.fingerprint != importFPs[e.EntryID]:
+			// entries moved to another lexicon are not found in the lexicon of the batch
+			res.Edited = append(res.Edited, e)
+		case dbm.entryLocks.check(b.LexRef, e.EntryID, "", st.strn) != nil:
+			res.Locked = append(res.Locked, e)
+		default:
+			toDelete = append(toDelete, e)
+		}
+	}
+	res.Deleted, err = undoImportBatch(db, l.id, batchID, toDelete)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.UndoImportBatch: %w", err)
+	}
+	deleted := []int64{}
+	for _, e := range res.Deleted {
+		dbm.entryLocks.entryUpdated(b.LexRef, e.EntryID, "", true)
+		deleted = append(deleted, e.EntryID)
+	}
+	if len(deleted) > 0 {
+		err = dbm.recordChanges(b.LexRef, ChangeDelete, deleted...)
+		if err != nil {
+			return res, fmt.Errorf("DBManager.UndoImportBatch: %v", err)
+		}
+	}
+	res.Batch, err = getImportBatch(db, dbRef, batchID)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.UndoImportBatch: %v", err)
+	}
+	return res, nil
+}
+
+// undoImportBatch deletes the entries from the lexicon, and marks the import batch as undone, in a single transaction. Returns the entries that were deleted.
+func undoImportBatch(db *sql.DB, lexiconID, batchID int64, es []ImportBatchEntry) ([]ImportBatchEntry, error) {
+	res := []ImportBatchEntry{}
+	tx, err := db.Begin()
+	if err != nil {
+		return res, fmt.Errorf("failed to start db transaction : %v", err)
+	}
+	defer tx.Rollback()
+	err = checkNotFrozen(tx, lexiconID)
+	if err != nil {
+		return res, err
+	}
+	for _, e := range es {
+		r, err := tx.Exec("DELETE FROM Entry WHERE id = ? AND lexiconId = ?", e.EntryID, lexiconID)
+		if err != nil {
+			return []ImportBatchEntry{}, fmt.Errorf("failed to delete entry %d : %v", e.EntryID, err)
+		}
+		n, err := r.RowsAffected()
+		if err != nil {
+			return []ImportBatchEntry{}, fmt.Errorf("failed to call RowsAffected : %v", err)
+		}
+		if n > 0 {
+			res = append(res, e)
+		}
+	}
+	_, err = tx.Exec("UPDATE ImportBatch SET undone = ? WHERE id = ?", time.Now().UTC().Format(time.RFC3339), batchID)
+	if err != nil {
+		return []ImportBatchEntry{}, fmt.Errorf("failed to update import batch : %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return []ImportBatchEntry{}, fmt.Errorf("failed to commit transaction : %v", err)
+	}
+	return res, nil
+}
+
+// entryExists returns true if there is an entry with the id in any lexicon of the database
+func entryExists(db *sql.DB, id int64) bool {
+	var n int
+	err := db.QueryRow("SELECT count(*) FROM Entry WHERE id = ?", id).Scan(&n)
+	return err == nil && n > 0
+}
@@ -0,0 +1,168 @@
+package dbapi
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestImportBatchesSqlite(t *testing.T) {
+	dbRef := lex.DBRef("import_batch_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+
+	// a file with a comment line, so that line numbers differ from entry positions
+	bts, err := os.ReadFile("./sv-lextest.txt")
+	if err != nil {
+		t.Fatalf("failed to read test file : %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(bts)), "\n")
+	fileName := filepath.Join(t.TempDir(), "batch-test.txt")
+	err = os.WriteFile(fileName, []byte("# test file\n"+strings.Join(lines[:3], "\n")+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write test file : %v", err)
+	}
+	b1, err := dbm.ImportLexiconFileAs(lexRef, SilentLogger{}, fileName, nil, "anna")
+	if err != nil {
+		t.Fatalf("failed to import file : %v", err)
+	}
+	if b1.FileName != "batch-test.txt" || b1.User != "anna" || b1.EntryCount != 3 || len(b1.Checksum) != 64 {
+		t.Errorf("unexpected import batch : %#v", b1)
+	}
+	es, err := dbm.ImportBatchEntries(dbRef, b1.ID)
+	if err != nil {
+		t.Fatalf("failed to list import batch entries : %v", err)
+	}
+	if len(es) != 3 || es[0].LineNumber != 2 || es[2].LineNumber != 4 {
+		t.Errorf("unexpected import batch entries : %#v", es)
+	}
+
+	ids, b2, err := dbm.InsertEntriesAs(lexRef, []lex.Entry{
+		{Strn: "hund", Language: "sv-se", Transcriptions: []lex.Transcription{{Strn: `" h u0 n d`}}, EntryStatus: lex.EntryStatus{Name: "imported", Source: "test"}},
+		{Strn: "katt", Language: "sv-se", Transcriptions: []lex.Transcription{{Strn: `" k a t`}}, EntryStatus: lex.EntryStatus{Name: "imported", Source: "test"}},
+	}, "bertil")
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	if b2.ID == b1.ID || b2.FileName != "" || b2.User != "bertil" || b2.EntryCount != 2 {
+		t.Errorf("unexpected import batch : %#v", b2)
+	}
+	bs, err := dbm.ListImportBatches(dbRef, "")
+	if err != nil || len(bs) != 2 {
+		t.Errorf("expected 2 import batches, got %#v : %v", bs, err)
+	}
+
+	// edit one entry of the file import, set a new status for another, and delete the third
+	lookUp := func(id int64) lex.Entry {
+		t.Helper()
+		es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{EntryIDs: []int64{id}}})
+		if err != nil || len(es) != 1 {
+			t.Fatalf("lookup failed : %v", err)
+		}
+		return es[0]
+	}
+	e := lookUp(es[0].EntryID)
+	e.Transcriptions[0].Strn += " n"
+	e.EntryStatus = lex.EntryStatus{}
+	_, _, err = dbm.UpdateEntry(e)
+	if err != nil {
+		t.Fatalf("failed to update entry : %v", err)
+	}
+	e = lookUp(es[1].EntryID)
+	e.EntryStatus = lex.EntryStatus{Name: "ok", Source: "anna"}
+	_, _, err = dbm.UpdateEntry(e)
+	if err != nil {
+		t.Fatalf("failed to update entry : %v", err)
+	}
+	_, err = dbm.DeleteEntry(es[2].EntryID, lexRef)
+	if err != nil {
+		t.Fatalf("failed to delete entry : %v", err)
+	}
+	report, err := dbm.UndoImportBatch(dbRef, b1.ID)
+	if err != nil {
+		t.Fatalf("failed to undo import batch : %v", err)
+	}
+	if len(report.Deleted) != 0 || len(report.Edited) != 2 || len(report.Missing) != 1 || report.Missing[0].LineNumber != 4 || report.Batch.Undone == "" {
+		t.Errorf("unexpected undo report : %#v", report)
+	}
+	_, err = dbm.UndoImportBatch(dbRef, b1.ID)
+	if err == nil {
+		t.Errorf("expected error for undone batch")
+	}
+
+	// a locked entry is kept
+	_, err = dbm.LockEntry(lexRef, ids[1], "anna", 0)
+	if err != nil {
+		t.Fatalf("failed to lock entry : %v", err)
+	}
+	report, err = dbm.UndoImportBatch(dbRef, b2.ID)
+	if err != nil {
+		t.Fatalf("failed to undo import batch : %v", err)
+	}
+	if len(report.Deleted) != 1 || report.Deleted[0].EntryID != ids[0] || len(report.Locked) != 1 || report.Locked[0].EntryID != ids[1] {
+		t.Errorf("unexpected undo report : %#v", report)
+	}
+	n, err := dbm.EntryCount(lexRef)
+	if err != nil || n != 3 {
+		t.Errorf("expected 3 entries after undo, got %d : %v", n, err)
+	}
+
+	// a failed insert doesn't leave an empty import batch
+	_, err = dbm.dbs[dbRef].Exec("CREATE TRIGGER failTranscription BEFORE INSERT ON Transcription WHEN NEW.strn = 'fail' BEGIN SELECT RAISE(ABORT, 'test failure'); END")
+	if err != nil {
+		t.Fatalf("failed to create trigger : %v", err)
+	}
+	_, _, err = dbm.InsertEntriesAs(lexRef, []lex.Entry{
+		{Strn: "mus", Language: "sv-se", Transcriptions: []lex.Transcription{{Strn: "fail"}}, EntryStatus: lex.EntryStatus{Name: "imported", Source: "test"}},
+	}, "bertil")
+	if err == nil {
+		t.Errorf("expected error for failed insert")
+	}
+	bs, err = dbm.ListImportBatches(dbRef, "")
+	if err != nil || len(bs) != 2 {
+		t.Errorf("expected 2 import batches after failed insert, got %#v : %v", bs, err)
+	}
+}
+
+func TestInsertEntriesAsRollbackSqlite(t *testing.T) {
+	dbRef := lex.DBRef("import_batch_rollback_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+
+	// the import batch is not saved if the entries cannot be inserted
+	_, _, err := dbm.InsertEntriesAs(lexRef, []lex.Entry{newTestEntry("hund", `" h u0 n d`), {Strn: "katt", Language: "sv-se"}}, "anna")
+	if err == nil {
+		t.Errorf("expected error for entry without transcriptions")
+	}
+
+	// neither the import batch nor the entries are saved if the entries cannot be added to the batch
+	_, err = dbm.dbs[dbRef].Exec(`CREATE TRIGGER failImportBatchEntry BEFORE INSERT ON ImportBatchEntry BEGIN SELECT RAISE(ABORT, 'adding failed'); END`)
+	if err != nil {
+		t.Fatalf("failed to create trigger : %v", err)
+	}
+	_, _, err = dbm.InsertEntriesAs(lexRef, []lex.Entry{newTestEntry("hund", `" h u0 n d`)}, "anna")
+	if err == nil {
+		t.Errorf("expected error for failed import batch entries")
+	}
+
+	bs, err := dbm.ListImportBatches(dbRef, "")
+	if err != nil || len(bs) != 0 {
+		t.Errorf("expected no import batches, got %#v : %v", bs, err)
+	}
+	n, err := dbm.EntryCount(lexRef)
+	if err != nil {
+		t.Fatalf("failed to count entries : %v", err)
+	}
+	if n != 0 {
+		t.Errorf("expected no entries, got %d", n)
+	}
+}
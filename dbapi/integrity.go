@@ -0,0 +1,302 @@
+package dbapi
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// Integrity problem categories, as reported by DBManager.CheckIntegrity
+const (
+	// IntegrityOrphanLemma is a lemma not linked to any entry (repairable: the lemma is deleted)
+	IntegrityOrphanLemma = "orphan_lemma"
+	// IntegrityTagWordForm is an entry tag with a word form different from the entry's orthography (repairable: the word form is updated, unless this causes a tag conflict with another entry)
+	IntegrityTagWordForm = "entry_tag_word_form"
+	// IntegrityNoCurrentStatus is an entry without a current status (repairable if the entry has a status history: the latest status is set to current)
+	IntegrityNoCurrentStatus = "no_current_status"
+	// IntegrityMultipleCurrentStatuses is an entry with more than one current status (repairable: only the latest status is kept as current)
+	IntegrityMultipleCurrentStatuses = "multiple_current_statuses"
+	// IntegrityMultiplePreferred is an orthography with more than one preferred entry in the same lexicon (not repairable, since it requires a manual choice)
+	IntegrityMultiplePreferred = "multiple_preferred"
+	// IntegrityEmptyTranscription is a transcription with an empty string (repairable if the entry has other transcriptions: the empty transcription is deleted)
+	IntegrityEmptyTranscription = "empty_transcription"
+	// IntegrityNoTranscription is an entry without transcriptions, which cannot be found by lookup (not repairable)
+	IntegrityNoTranscription = "no_transcription"
+)
+
+// IntegrityProblem is a single problem found by DBManager.CheckIntegrity
+type IntegrityProblem struct {
+	Category string `json:"category"`
+	Lexicon  string `json:"lexicon,omitempty"`
+	EntryID  int64  `json:"entryId,omitempty"`
+	// ID is the id of the lemma, transcription or status concerned, if any
+	ID         int64  `json:"id,omitempty"`
+	Message    string `json:"message"`
+	Repairable bool   `json:"repairable"`
+	Repaired   bool   `json:"repaired"`
+}
+
+// IntegrityReport holds the result of a call to DBManager.CheckIntegrity or DBManager.RepairIntegrity
+type IntegrityReport struct {
+	DB       string             `json:"db"`
+	Problems []IntegrityProblem `json:"problems"`
+	// Number of problems by category
+	Categories map[string]int `json:"categories"`
+	Repaired   int            `json:"repaired"`
+}
+
+// OK returns true if no problems were found, or all problems were repaired
+func (r IntegrityReport) OK() bool {
+	return len(r.Problems) == r.Repaired
+}
+
+// CheckIntegrity checks the database for inconsistencies, such as orphan lemmas, entries with zero or several current statuses, several preferred entries for the same orthography, and empty transcriptions. Nothing is changed in the database. See the Integrity* constants for the problem categories.
+func (dbm *DBManager) CheckIntegrity(dbRef lex.DBRef) (IntegrityReport, error) {
+	return dbm.integrity(dbRef, false)
+}
+
+// RepairIntegrity checks the database like CheckIntegrity, and repairs the problems that can be repaired mechanically, in a single transaction. Problems that cannot be repaired are reported, but left as is.
+func (dbm *DBManager) RepairIntegrity(dbRef lex.DBRef) (IntegrityReport, error) {
+	return dbm.integrity(dbRef, true)
+}
+
+func (dbm *DBManager) integrity(dbRef lex.DBRef, repair bool) (IntegrityReport, error) {
+	if repair {
+		dbm.Lock()
+		defer dbm.Unlock()
+	} else {
+		dbm.RLock()
+		defer dbm.RUnlock()
+	}
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return IntegrityReport{}, fmt.Errorf("DBManager.CheckIntegrity: no such db '%s'", dbRef)
+	}
+	if repair {
+		dbm.invalidateDB(dbRef)
+	}
+	res, err := checkIntegrity(db, repair)
+	res.DB = string(dbRef)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.CheckIntegrity: %v", err)
+	}
+	return res, nil
+}
+
+// The SQL for integrity checks is the same for Sqlite and MariaDB, so the functions below are called directly by DBManager for both db engines.
+
+var integrityChecks = []struct {
+	name   string
+	check  func(tx *sql.Tx) ([]IntegrityProblem, error)
+	repair func(tx *sql.Tx, p IntegrityProblem) error
+}{
+	{name: "orphan lemmas", check: checkOrphanLemmas, repair: func(tx *sql.Tx, p IntegrityProblem) error {
+		_, err := tx.Exec("DELETE FROM Lemma WHERE id = ?", p.ID)
+		return err
+	}},
+	{name: "entry tag word forms", check: checkTagWordForms, repair: func(tx *sql.Tx, p IntegrityProblem) error {
+		_, err := tx.Exec("UPDATE EntryTag SET wordForm = (SELECT strn FROM Entry WHERE Entry.id = EntryTag.entryId) WHERE entryId = ?", p.EntryID)
+		return err
+	}},
+	{name: "current statuses", check: checkCurrentStatuses, repair: func(tx *sql.Tx, p IntegrityProblem) error {
+		// p.ID is the latest status id: it is set as the only current status
+		_, err := tx.Exec("UPDATE EntryStatus SET current = 0 WHERE entryId = ? AND id <> ?", p.EntryID, p.ID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE EntryStatus SET current = 1 WHERE id = ?", p.ID)
+		return err
+	}},
+	{name: "preferred entries", check: checkMultiplePreferred},
+	{name: "empty transcriptions", check: checkEmptyTranscriptions, repair: func(tx *sql.Tx, p IntegrityProblem) error {
+		_, err := tx.Exec("DELETE FROM Transcription WHERE id = ?", p.ID)
+		return err
+	}},
+	{name: "entries without transcriptions", check: checkNoTranscriptions},
+}
+
+func checkIntegrity(db *sql.DB, repair bool) (IntegrityReport, error) {
+	res := IntegrityReport{Problems: []IntegrityProblem{}, Categories: make(map[string]int)}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return res, fmt.Errorf("checkIntegrity failed to start db transaction : %v", err)
+	}
+	// nothing is changed unless repair is true, so the transaction is rolled back by default
+	defer tx.Rollback()
+
+	rollback := func(msg string) error {
+		err2 := tx.Rollback()
+		if err2 != nil {
+			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
+		}
+		return errors.New(msg)
+	}
+
+	for _, c := range integrityChecks {
+		ps, err := c.check(tx)
+		if err != nil {
+			return res, rollback(fmt.Sprintf("checkIntegrity failed to check %s : %v", c.name, err))
+		}
+		// All rows are read before repairing, since the driver may not allow statements to be executed while a result set is open
+		for i, p := range ps {
+			if repair && p.Repairable && c.repair != nil {
+				err = c.repair(tx, p)
+				if err != nil {
+					// e.g. a tag conflict: left unrepaired
+					ps[i].Message = fmt.Sprintf("%s : repair failed : %v", p.Message, err)
+				} else {
+					ps[i].Repaired = true
+					res.Repaired++
+				}
+			}
+			res.Categories[p.Category]++
+		}
+		res.Problems = append(res.Problems, ps...)
+	}
+
+	if repair {
+		err = tx.Commit()
+		if err != nil {
+			return res, fmt.Errorf("checkIntegrity failed to commit repairs : %v", err)
+		}
+	}
+	sort.SliceStable(res.Problems, func(i, j int) bool { return res.Problems[i].Lexicon < res.Problems[j].Lexicon })
+	return res, nil
+}
+
+func checkOrphanLemmas(tx *sql.Tx) ([]IntegrityProblem, error) {
+	res := []IntegrityProblem{}
+	rows, err := tx.Query("SELECT Lemma.id, Lemma.strn FROM Lemma WHERE NOT EXISTS (SELECT * FROM Lemma2Entry WHERE Lemma2Entry.lemmaId = Lemma.id)")
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var strn string
+		err = rows.Scan(&id, &strn)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, IntegrityProblem{Category: IntegrityOrphanLemma, ID: id, Message: fmt.Sprintf("lemma '%s' is not linked to any entry", strn), Repairable: true})
+	}
+	return res, rows.Err()
+}
+
+func checkTagWordForms(tx *sql.Tx) ([]IntegrityProblem, error) {
+	res := []IntegrityProblem{}
+	rows, err := tx.Query("SELECT Lexicon.name, Entry.id, Entry.strn, EntryTag.tag, EntryTag.wordForm FROM EntryTag, Entry, Lexicon WHERE EntryTag.entryId = Entry.id AND Entry.lexiconId = Lexicon.id AND (EntryTag.wordForm IS NULL OR EntryTag.wordForm <> Entry.strn)")
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lexName, strn, tag string
+		var id int64
+		var wordForm sql.NullString
+		err = rows.Scan(&lexName, &id, &strn, &tag, &wordForm)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, IntegrityProblem{Category: IntegrityTagWordForm, Lexicon: lexName, EntryID: id, Message: fmt.Sprintf("entry tag '%s' has word form '%s', but the entry's orthography is '%s'", tag, wordForm.String, strn), Repairable: true})
+	}
+	return res, rows.Err()
+}
+
+func checkCurrentStatuses(tx *sql.Tx) ([]IntegrityProblem, error) {
+	res := []IntegrityProblem{}
+	rows, err := tx.Query(`SELECT Lexicon.name, Entry.id, Entry.strn,
+(SELECT COUNT(*) FROM EntryStatus WHERE EntryStatus.entryId = Entry.id AND EntryStatus.current = 1),
+(SELECT MAX(EntryStatus.id) FROM EntryStatus WHERE EntryStatus.entryId = Entry.id)
+FROM Entry, Lexicon WHERE Entry.lexiconId = Lexicon.id AND (SELECT COUNT(*) FROM EntryStatus WHERE EntryStatus.entryId = Entry.id AND EntryStatus.current = 1) <> 1`)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lexName, strn string
+		var id, nCurrent int64
+		var latest sql.NullInt64
+		err = rows.Scan(&lexName, &id, &strn, &nCurrent, &latest)
+		if err != nil {
+			return res, err
+		}
+		p := IntegrityProblem{Lexicon: lexName, EntryID: id, ID: latest.Int64, Repairable: latest.Valid}
+		if nCurrent == 0 {
+			p.Category = IntegrityNoCurrentStatus
+			if latest.Valid {
+				p.Message = fmt.Sprintf("entry '%s' has no current status", strn)
+			} else {
+				p.Message = fmt.Sprintf("entry '%s' has no status", strn)
+			}
+		} else {
+			p.Category = IntegrityMultipleCurrentStatuses
+			p.Message = fmt.Sprintf("entry '%s' has %d current statuses", strn, nCurrent)
+		}
+		res = append(res, p)
+	}
+	return res, rows.Err()
+}
+
+func checkMultiplePreferred(tx *sql.Tx) ([]IntegrityProblem, error) {
+	res := []IntegrityProblem{}
+	rows, err := tx.Query("SELECT Lexicon.name, Entry.strn, COUNT(*) FROM Entry, Lexicon WHERE Entry.lexiconId = Lexicon.id AND Entry.preferred <> 0 GROUP BY Lexicon.name, Entry.strn HAVING COUNT(*) > 1")
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lexName, strn string
+		var n int64
+		err = rows.Scan(&lexName, &strn, &n)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, IntegrityProblem{Category: IntegrityMultiplePreferred, Lexicon: lexName, Message: fmt.Sprintf("'%s' has %d preferred entries", strn, n)})
+	}
+	return res, rows.Err()
+}
+
+func checkEmptyTranscriptions(tx *sql.Tx) ([]IntegrityProblem, error) {
+	res := []IntegrityProblem{}
+	rows, err := tx.Query(`SELECT Lexicon.name, Entry.id, Entry.strn, Transcription.id,
+(SELECT COUNT(*) FROM Transcription t2 WHERE t2.entryId = Entry.id AND TRIM(t2.strn) <> '')
+FROM Transcription, Entry, Lexicon WHERE Transcription.entryId = Entry.id AND Entry.lexiconId = Lexicon.id AND TRIM(Transcription.strn) = ''`)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lexName, strn string
+		var id, tID, nOther int64
+		err = rows.Scan(&lexName, &id, &strn, &tID, &nOther)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, IntegrityProblem{Category: IntegrityEmptyTranscription, Lexicon: lexName, EntryID: id, ID: tID, Message: fmt.Sprintf("entry '%s' has an empty transcription", strn), Repairable: nOther > 0})
+	}
+	return res, rows.Err()
+}
+
+func checkNoTranscriptions(tx *sql.Tx) ([]IntegrityProblem, error) {
+	res := []IntegrityProblem{}
+	rows, err := tx.Query("SELECT Lexicon.name, Entry.id, Entry.strn FROM Entry, Lexicon WHERE Entry.lexiconId = Lexicon.id AND NOT EXISTS (SELECT * FROM Transcription WHERE Transcription.entryId = Entry.id)")
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lexName, strn string
+		var id int64
+		err = rows.Scan(&lexName, &id, &strn)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, IntegrityProblem{Category: IntegrityNoTranscription, Lexicon: lexName, EntryID: id, Message: fmt.Sprintf("entry '%s' has no transcriptions", strn)})
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,101 @@
+package dbapi
+
+import (
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestIntegritySqlite(t *testing.T) {
+	dbRef := lex.DBRef("integrity_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	es := []lex.Entry{newTestEntry("hund", `" A`), newTestEntry("katt", `" A`), newTestEntry("mus", `" A`), newTestEntry("råtta", `" A`), newTestEntry("häst", `" A`)}
+	es[0].Tag = "djur"
+	es[0].Lemma = lex.Lemma{Strn: "hund"}
+	ids, err := dbm.InsertEntries(lexRef, es)
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	report, err := dbm.CheckIntegrity(dbRef)
+	if err != nil {
+		t.Fatalf("integrity check failed : %v", err)
+	}
+	if len(report.Problems) != 0 {
+		t.Fatalf("expected no problems in a new db, got %#v", report.Problems)
+	}
+
+	// Introduce inconsistencies, bypassing the triggers that normally prevent some of them
+	db := dbm.dbs[dbRef]
+	for _, stmt := range []string{
+		"DROP TRIGGER updateEntryStatus",
+		"DROP TRIGGER insertEntryStatus",
+		"INSERT INTO Lemma (strn, reading, paradigm) VALUES ('orphan', '', '')",
+		"UPDATE Entry SET strn = 'hundar' WHERE strn = 'hund'",
+		"UPDATE EntryStatus SET current = 0 WHERE entryId = ?",
+		"INSERT INTO EntryStatus (entryId, name, source, current) VALUES (?, 'ok', 'test', 1)",
+		"UPDATE Entry SET preferred = 1, strn = 'mus' WHERE id IN (?, ?)",
+		"INSERT INTO Transcription (entryId, strn, language, sources) VALUES (?, '  ', 'sv-se', '')",
+		"UPDATE Transcription SET strn = '' WHERE entryId = ?",
+	} {
+		var args []interface{}
+		switch stmt {
+		case "UPDATE EntryStatus SET current = 0 WHERE entryId = ?":
+			args = []interface{}{ids[1]}
+		case "INSERT INTO EntryStatus (entryId, name, source, current) VALUES (?, 'ok', 'test', 1)":
+			args = []interface{}{ids[2]}
+		case "UPDATE Entry SET preferred = 1, strn = 'mus' WHERE id IN (?, ?)":
+			args = []interface{}{ids[2], ids[3]}
+		case "INSERT INTO Transcription (entryId, strn, language, sources) VALUES (?, '  ', 'sv-se', '')":
+			args = []interface{}{ids[3]}
+		case "UPDATE Transcription SET strn = '' WHERE entryId = ?":
+			args = []interface{}{ids[4]}
+		}
+		_, err = db.Exec(stmt, args...)
+		if err != nil {
+			t.Fatalf("failed to run '%s' : %v", stmt, err)
+		}
+	}
+
+	expect := map[string]int{
+		IntegrityOrphanLemma:             1,
+		IntegrityTagWordForm:             1,
+		IntegrityNoCurrentStatus:         1,
+		IntegrityMultipleCurrentStatuses: 1,
+		IntegrityMultiplePreferred:       1,
+		IntegrityEmptyTranscription:      2,
+	}
+	report, err = dbm.CheckIntegrity(dbRef)
+	if err != nil {
+		t.Fatalf("integrity check failed : %v", err)
+	}
+	for cat, n := range expect {
+		if report.Categories[cat] != n {
+			t.Errorf("expected %d problem(s) of category %s, got %d : %#v", n, cat, report.Categories[cat], report.Problems)
+		}
+	}
+	if report.Repaired != 0 || report.OK() {
+		t.Errorf("expected no repairs from CheckIntegrity")
+	}
+
+	report, err = dbm.RepairIntegrity(dbRef)
+	if err != nil {
+		t.Fatalf("integrity repair failed : %v", err)
+	}
+	// all but the preferred entries and the empty transcription of 'häst' (its only transcription) should be repaired
+	if report.Repaired != 5 {
+		t.Errorf("expected 5 repairs, got %d : %#v", report.Repaired, report.Problems)
+	}
+
+	report, err = dbm.CheckIntegrity(dbRef)
+	if err != nil {
+		t.Fatalf("integrity check failed : %v", err)
+	}
+	if len(report.Problems) != 2 || report.Categories[IntegrityMultiplePreferred] != 1 || report.Categories[IntegrityEmptyTranscription] != 1 {
+		t.Errorf("unexpected problems after repair : %#v", report.Problems)
+	}
+}
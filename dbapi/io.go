@@ -19,25 +19,30 @@ import (
 
 // ImportSqliteLexiconFile is intended for 'clean' imports. It doesn't check whether the words already exist and so on. It does not do any sanity checks whatsoever of the transcriptions before they are added. If the validator parameter is initialized, each entry will be validated before import, and the validation result will be added to the db.
 func ImportSqliteLexiconFile(db *sql.DB, lexiconName lex.LexName, logger Logger, lexiconFileName string, validator *validation.Validator) error {
-	return importLexiconFile(sqliteDBIF{}, db, lexiconName, logger, lexiconFileName, validator)
+	_, err := importLexiconFile(sqliteDBIF{}, db, lexiconName, logger, lexiconFileName, validator, "")
+	return err
 }
 
 // ImportMariDBLexiconFile is intended for 'clean' imports. It doesn't check whether the words already exist and so on. It does not do any sanity checks whatsoever of the transcriptions before they are added. If the validator parameter is initialized, each entry will be validated before import, and the validation result will be added to the db.
 func ImportMariaDBLexiconFile(db *sql.DB, lexiconName lex.LexName, logger Logger, lexiconFileName string, validator *validation.Validator) error {
-	return importLexiconFile(mariaDBIF{}, db, lexiconName, logger, lexiconFileName, validator)
+	_, err := importLexiconFile(mariaDBIF{}, db, lexiconName, logger, lexiconFileName, validator, "")
+	return err
 }
 
 // importLexiconFile is intended for 'clean' imports. It doesn't check whether the words already exist and so on. It does not do any sanity checks whatsoever of the transcriptions before they are added. If the validator parameter is initialized, each entry will be validated before import, and the validation result will be added to the db.
-func importLexiconFile(dbif DBIF, db *sql.DB, lexiconName lex.LexName, logger Logger, lexiconFileName string, validator *validation.Validator) error {
+// The import is recorded as an import batch on behalf of the user (see ImportBatch), and the id of the batch is returned. If the import fails, the batch holds the entries saved before the failure.
+func importLexiconFile(dbif DBIF, db *sql.DB, lexiconName lex.LexName, logger Logger, lexiconFileName string, validator *validation.Validator, user string) (int64, error) {
 
 	logger.Write(fmt.Sprintf("lexiconName: %v", lexiconName))
 	logger.Write(fmt.Sprintf("lexiconFileName: %v", lexiconFileName))
 	logger.Write(fmt.Sprintf("dbif name: %v", dbif.name()))
 
+	var batchID int64
+
 	if _, err := os.Stat(lexiconFileName); os.IsNotExist(err) {
 		var msg = fmt.Sprintf("ImportLexiconFile failed to open file : %v", err)
 		logger.Write(msg)
-		return fmt.Errorf("%v", msg)
+		return batchID, fmt.Errorf("%v", msg)
 	}
 
 	// TODO sanitise lexiconFileName
@@ -45,7 +50,7 @@ func importLexiconFile(dbif DBIF, db *sql.DB, lexiconName lex.LexName, logger Lo
 	if err != nil {
 		var msg = fmt.Sprintf("ImportLexiconFile failed to open file : %v", err)
 		logger.Write(msg)
-		return fmt.Errorf("%v", msg)
+		return batchID, fmt.Errorf("%v", msg)
 	}
 	/* #nosec G307 */
 	defer fh.Close()
@@ -56,7 +61,7 @@ func importLexiconFile(dbif DBIF, db *sql.DB, lexiconName lex.LexName, logger Lo
 		if err != nil {
 			var msg = fmt.Sprintf("ImportLexiconFile failed to open gz reader : %v", err)
 			logger.Write(msg)
-			return fmt.Errorf("%v", msg)
+			return batchID, fmt.Errorf("%v", msg)
 		}
 		s = bufio.NewScanner(gz)
 	} else {
@@ -67,32 +72,67 @@ func importLexiconFile(dbif DBIF, db *sql.DB, lexiconName lex.LexName, logger Lo
 	if err != nil {
 		var msg = fmt.Sprintf("ImportLexiconFile failed to instantiate lexicon line parser : %v", err)
 		logger.Write(msg)
-		return fmt.Errorf("%v", msg)
+		return batchID, fmt.Errorf("%v", msg)
 	}
 
 	lexicon, err := dbif.getLexicon(db, string(lexiconName))
 	if err != nil {
 		var msg = fmt.Sprintf("ImportLexiconFile failed to get lexicon id for lexicon: %s : %v", lexiconName, err)
 		logger.Write(msg)
-		return fmt.Errorf("%v", msg)
+		return batchID, fmt.Errorf("%v", msg)
 	}
 
-	msg := fmt.Sprintf("Trying to load file: %s", lexiconFileName)
+	err = checkNotFrozen(db, lexicon.id)
+	if err != nil {
+		logger.Write(fmt.Sprintf("ImportLexiconFile failed : %v", err))
+		return batchID, err
+	}
+
+	checksum, err := fileChecksum(lexiconFileName)
+	if err != nil {
+		var msg = fmt.Sprintf("ImportLexiconFile failed to compute file checksum : %v", err)
+		logger.Write(msg)
+		return batchID, fmt.Errorf("%v", msg)
+	}
+	batchID, err = createImportBatch(db, lexicon.id, filepath.Base(lexiconFileName), checksum, user)
+	if err != nil {
+		var msg = fmt.Sprintf("ImportLexiconFile failed to create import batch : %v", err)
+		logger.Write(msg)
+		return batchID, fmt.Errorf("%v", msg)
+	}
+
+	msg := fmt.Sprintf("Trying to load file: %s (import batch %d)", lexiconFileName, batchID)
 	logger.Write(msg)
 
+	// insert saves the buffered entries, and adds them to the import batch
+	var eBuf []lex.Entry
+	var lineBuf []int
+	insert := func() error {
+		ids, err := dbif.insertEntries(db, lexicon, eBuf)
+		if err != nil {
+			return fmt.Errorf("ImportLexiconFile failed to insert entries : %w", err)
+		}
+		err = addImportBatchEntries(dbif, db, lexiconName, batchID, ids, lineBuf)
+		if err != nil {
+			return fmt.Errorf("ImportLexiconFile failed to add entries to import batch : %v", err)
+		}
+		return nil
+	}
+
 	var nImported = 0
 	var nSkippedDups = 0
 	var nTotal = 0
-	var eBuf []lex.Entry
+	var lineNumber = 0
 	var readLines = make(map[string]bool)
 	var readEntries = make(map[string]bool)
 	for s.Scan() {
 		if err := s.Err(); err != nil {
 			var msg = fmt.Sprintf("error when reading lines from lexicon file : %v", err)
 			logger.Write(msg)
-			return fmt.Errorf("%v", msg)
+			return batchID, fmt.Errorf("%v", msg)
 		}
 		l := s.Text()
+		lineNumber++
 
 		if strings.HasPrefix(l, "#") {
 			continue
@@ -112,14 +152,14 @@ func importLexiconFile(dbif DBIF, db *sql.DB, lexiconName lex.LexName, logger Lo
 		if err != nil {
 			var msg = fmt.Sprintf("couldn't parse line to entry : %v", err)
 			logger.Write(msg)
-			return fmt.Errorf("%v", msg)
+			return batchID, fmt.Errorf("%v", msg)
 		}
 		readLines[l] = true
 		eToString, err := wsFmt.Entry2String(e)
 		if err != nil {
 			var msg = fmt.Sprintf("couldn't convert entry to string : %v", err)
 			logger.Write(msg)
-			return fmt.Errorf("%v", msg)
+			return batchID, fmt.Errorf("%v", msg)
 		}
 		if _, ok := readEntries[eToString]; ok {
 			//var msg = fmt.Sprintf("Skipping duplicate input entry : %v", e)
@@ -135,28 +175,28 @@ func importLexiconFile(dbif DBIF, db *sql.DB, lexiconName lex.LexName, logger Lo
 		}
 
 		eBuf = append(eBuf, e)
+		lineBuf = append(lineBuf, lineNumber)
 		if nTotal%1000 == 0 {
-			_, err = dbif.insertEntries(db, lexicon, eBuf)
+			err = insert()
 			if err != nil {
-				var msg = fmt.Sprintf("ImportLexiconFile failed to insert entries : %v", err)
-				logger.Write(msg)
-				return fmt.Errorf("%v", msg)
+				logger.Write(err.Error())
+				return batchID, err
 			}
 			nImported = nImported + len(eBuf)
 			msg2 := fmt.Sprintf("ImportLexiconFile: Inserted entries (total lines imported: %d)", nImported)
 			logger.Progress(msg2)
 			eBuf = make([]lex.Entry, 0)
+			lineBuf = make([]int, 0)
 		}
 		if logger.LogInterval() > 0 && nTotal%logger.LogInterval() == 0 {
 			msg2 := fmt.Sprintf("ImportLexiconFile: Lines read: %d                         ", nTotal)
 			logger.Progress(msg2)
 		}
 	}
-	_, err = dbif.insertEntries(db, lexicon, eBuf) // flushing the buffer
+	err = insert() // flushing the buffer
 	if err != nil {
-		var msg = fmt.Sprintf("ImportLexiconFile failed to insert entries : %v", err)
-		logger.Write(msg)
-		return fmt.Errorf("%v", msg)
+		logger.Write(err.Error())
+		return batchID, err
 	} // else
 	nImported = nImported + len(eBuf)
 	msg2 := fmt.Sprintf("ImportLexiconFile: Inserted entries (total lines imported: %d)", nImported)
@@ -170,7 +210,7 @@ func importLexiconFile(dbif DBIF, db *sql.DB, lexiconName lex.LexName, logger Lo
 		if err != nil {
 			var msg = fmt.Sprintf("failed to exec analyze cmd to db : %v", err)
 			logger.Write(msg)
-			return fmt.Errorf("%v", msg)
+			return batchID, fmt.Errorf("%v", msg)
 		}
 	}
 
@@ -184,10 +224,10 @@ func importLexiconFile(dbif DBIF, db *sql.DB, lexiconName lex.LexName, logger Lo
 	if err := s.Err(); err != nil {
 		msg4 := fmt.Sprintf("ImportLexiconFile failed to instantiate lexicon line parser : %v", err)
 		logger.Write(msg4)
-		return fmt.Errorf("%v", msg4)
+		return batchID, fmt.Errorf("%v", msg4)
 	}
 
-	return nil
+	return batchID, nil
 }
 
 // PrintMode specified the type of output to print (all/valid/invalid)
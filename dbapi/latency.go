@@ -0,0 +1,105 @@
+package dbapi
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the buckets of a LatencyHistogram
+var LatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// LatencyHistogram counts durations (e.g., of database lookups) in buckets (see LatencyBuckets). It is safe for concurrent use.
+type LatencyHistogram struct {
+	mutex sync.Mutex
+	// counts holds the number of observations per bucket, with an extra bucket for durations above the largest bucket bound
+	counts []uint64
+	sum    float64
+}
+
+// LatencySnapshot holds the counts of a LatencyHistogram at some point in time
+type LatencySnapshot struct {
+	// Buckets are the bucket upper bounds in seconds (see LatencyBuckets)
+	Buckets []float64 `json:"buckets"`
+	// Counts are the cumulative counts for each bucket, i.e., the number of observations less than or equal to the bucket bound
+	Counts []uint64 `json:"counts"`
+	// Count is the total number of observations
+	Count uint64 `json:"count"`
+	// Sum is the sum of all observations in seconds
+	Sum float64 `json:"sum"`
+}
+
+// NewLatencyHistogram creates an empty histogram
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{counts: make([]uint64, len(LatencyBuckets)+1)}
+}
+
+// Observe adds a duration to the histogram
+func (h *LatencyHistogram) Observe(d time.Duration) {
+	s := d.Seconds()
+	i := sort.SearchFloat64s(LatencyBuckets, s)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.counts[i]++
+	h.sum += s
+}
+
+// Snapshot returns the current counts of the histogram
+func (h *LatencyHistogram) Snapshot() LatencySnapshot {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	res := LatencySnapshot{Buckets: LatencyBuckets, Counts: make([]uint64, len(LatencyBuckets)), Sum: h.sum}
+	for i, n := range h.counts {
+		res.Count += n
+		if i < len(res.Counts) {
+			res.Counts[i] = res.Count
+		}
+	}
+	return res
+}
+
+// lookupLatencies holds a lookup latency histogram for each database (see DBManager.LookupLatencies)
+type lookupLatencies struct {
+	mutex sync.Mutex
+	dbs   map[lex.DBRef]*LatencyHistogram
+}
+
+func newLookupLatencies() *lookupLatencies {
+	return &lookupLatencies{dbs: make(map[lex.DBRef]*LatencyHistogram)}
+}
+
+func (l *lookupLatencies) observe(dbRef lex.DBRef, d time.Duration) {
+	l.mutex.Lock()
+	h, ok := l.dbs[dbRef]
+	if !ok {
+		h = NewLatencyHistogram()
+		l.dbs[dbRef] = h
+	}
+	l.mutex.Unlock()
+	h.Observe(d)
+}
+
+// LookupLatencies returns the latency of the database lookups made by LookUp (and LookUpIntoSlice, etc), for each database. Lookups answered from the lookup cache (see SetLookupCacheSize) are not included.
+func (dbm *DBManager) LookupLatencies() map[lex.DBRef]LatencySnapshot {
+	res := make(map[lex.DBRef]LatencySnapshot)
+	dbm.lookupLatencies.mutex.Lock()
+	defer dbm.lookupLatencies.mutex.Unlock()
+	for dbRef, h := range dbm.lookupLatencies.dbs {
+		res[dbRef] = h.Snapshot()
+	}
+	return res
+}
+
+// DBStats returns the connection pool statistics of each open database
+func (dbm *DBManager) DBStats() map[lex.DBRef]sql.DBStats {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	res := make(map[lex.DBRef]sql.DBStats)
+	for dbRef, db := range dbm.dbs {
+		res[dbRef] = db.Stats()
+	}
+	return res
+}
@@ -0,0 +1,51 @@
+package dbapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	h := NewLatencyHistogram()
+	h.Observe(500 * time.Microsecond)
+	h.Observe(3 * time.Millisecond)
+	h.Observe(time.Minute)
+	s := h.Snapshot()
+	if s.Count != 3 || len(s.Counts) != len(LatencyBuckets) {
+		t.Fatalf("unexpected snapshot : %#v", s)
+	}
+	// counts are cumulative: 1 observation <= 1 ms, 2 <= 5 ms, and one above the largest bucket
+	if s.Counts[0] != 1 || s.Counts[1] != 1 || s.Counts[2] != 2 || s.Counts[len(s.Counts)-1] != 2 {
+		t.Errorf("unexpected bucket counts : %v", s.Counts)
+	}
+	if s.Sum < 60 || s.Sum > 60.01 {
+		t.Errorf("unexpected sum : %f", s.Sum)
+	}
+}
+
+func TestLookupLatenciesSqlite(t *testing.T) {
+	dbRef := lex.DBRef("lookup_latency_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	if n := len(dbm.LookupLatencies()); n != 0 {
+		t.Errorf("expected no lookup latencies, got %d", n)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{Words: []string{"hund"}}})
+		if err != nil {
+			t.Fatalf("lookup failed : %v", err)
+		}
+	}
+	ls := dbm.LookupLatencies()
+	if s, ok := ls[dbRef]; !ok || s.Count != 2 {
+		t.Errorf("expected 2 lookups for %s, got %#v", dbRef, ls)
+	}
+	if st, ok := dbm.DBStats()[dbRef]; !ok || st.OpenConnections == 0 {
+		t.Errorf("expected db stats for %s, got %#v", dbRef, st)
+	}
+}
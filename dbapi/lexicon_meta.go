@@ -0,0 +1,116 @@
+package dbapi
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// The SQL for lexicon meta data is the same for Sqlite and MariaDB, so the Tx functions below are shared by both DBIF implementations
+
+func getLexiconMetaTx(tx *sql.Tx, lexiconID int64) (LexiconMeta, error) {
+	res := LexiconMeta{Properties: make(map[string]string)}
+
+	err := tx.QueryRow("SELECT description, license, sourceUrl, sourceVersion, owner, created, modified FROM LexiconMeta WHERE lexiconId = ?", lexiconID).Scan(&res.Description, &res.License, &res.SourceURL, &res.SourceVersion, &res.Owner, &res.Created, &res.Modified)
+	// Lexicons created before meta data was added to the schema have no meta data row
+	if err != nil && err != sql.ErrNoRows {
+		return res, fmt.Errorf("getLexiconMetaTx query failed : %v", err)
+	}
+
+	// The lexicon is modified whenever one of its entries is updated
+	var latestEntry sql.NullString
+	err = tx.QueryRow("SELECT MAX(EntryStatus.Timestamp) FROM EntryStatus, Entry WHERE EntryStatus.entryId = Entry.id AND Entry.lexiconId = ?", lexiconID).Scan(&latestEntry)
+	if err != nil {
+		return res, fmt.Errorf("getLexiconMetaTx failed to get latest entry update : %v", err)
+	}
+	// The drivers return timestamps in different formats, so they are parsed before they are compared, and all timestamps are returned in RFC3339
+	var created, modified time.Time
+	if res.Created != "" {
+		created, err = parseDBTimestamp(res.Created)
+		if err != nil {
+			return res, fmt.Errorf("getLexiconMetaTx : %v", err)
+		}
+		res.Created = created.UTC().Format(time.RFC3339)
+	}
+	if res.Modified != "" {
+		modified, err = parseDBTimestamp(res.Modified)
+		if err != nil {
+			return res, fmt.Errorf("getLexiconMetaTx : %v", err)
+		}
+	}
+	if latestEntry.Valid && latestEntry.String != "" {
+		latest, err := parseDBTimestamp(latestEntry.String)
+		if err != nil {
+			return res, fmt.Errorf("getLexiconMetaTx : %v", err)
+		}
+		if latest.After(modified) {
+			modified = latest
+		}
+	}
+	if !modified.IsZero() {
+		res.Modified = modified.UTC().Format(time.RFC3339)
+	}
+
+	rows, err := tx.Query("SELECT name, value FROM LexiconProperty WHERE lexiconId = ?", lexiconID)
+	if err != nil {
+		return res, fmt.Errorf("getLexiconMetaTx failed to list properties : %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, value string
+		err = rows.Scan(&name, &value)
+		if err != nil {
+			return res, fmt.Errorf("getLexiconMetaTx failed to scan row : %v", err)
+		}
+		res.Properties[name] = value
+	}
+	return res, rows.Err()
+}
+
+// setLexiconMetaTx replaces the meta data of the lexicon, including the properties. Created and Modified are ignored.
+func setLexiconMetaTx(tx *sql.Tx, lexiconID int64, meta LexiconMeta) error {
+	rollback := func(msg string) error {
+		err2 := tx.Rollback()
+		if err2 != nil {
+			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
+		}
+		return errors.New(msg)
+	}
+
+	res, err := tx.Exec("UPDATE LexiconMeta SET description = ?, license = ?, sourceUrl = ?, sourceVersion = ?, owner = ?, modified = CURRENT_TIMESTAMP WHERE lexiconId = ?", meta.Description, meta.License, meta.SourceURL, meta.SourceVersion, meta.Owner, lexiconID)
+	if err != nil {
+		return rollback(fmt.Sprintf("setLexiconMetaTx failed to update meta data : %v", err))
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return rollback(fmt.Sprintf("setLexiconMetaTx failed to get rows affected : %v", err))
+	}
+	if n == 0 {
+		_, err = tx.Exec("INSERT INTO LexiconMeta (lexiconId, description, license, sourceUrl, sourceVersion, owner) VALUES (?, ?, ?, ?, ?, ?)", lexiconID, meta.Description, meta.License, meta.SourceURL, meta.SourceVersion, meta.Owner)
+		if err != nil {
+			return rollback(fmt.Sprintf("setLexiconMetaTx failed to insert meta data : %v", err))
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM LexiconProperty WHERE lexiconId = ?", lexiconID)
+	if err != nil {
+		return rollback(fmt.Sprintf("setLexiconMetaTx failed to delete properties : %v", err))
+	}
+	names := []string{}
+	for name := range meta.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "" {
+			return rollback("setLexiconMetaTx: empty property name")
+		}
+		_, err = tx.Exec("INSERT INTO LexiconProperty (lexiconId, name, value) VALUES (?, ?, ?)", lexiconID, name, meta.Properties[name])
+		if err != nil {
+			return rollback(fmt.Sprintf("setLexiconMetaTx failed to insert property '%s' : %v", name, err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package dbapi
+
+import (
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestLexiconMetaSqlite(t *testing.T) {
+	dbRef := lex.DBRef("lexiconmeta_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+
+	// a new lexicon has empty meta data, with timestamps set
+	meta, err := dbm.GetLexiconMeta(lexRef)
+	if err != nil {
+		t.Fatalf("failed to get lexicon meta : %v", err)
+	}
+	if meta.Description != "" || len(meta.Properties) != 0 {
+		t.Errorf("expected empty meta data, got %#v", meta)
+	}
+	if meta.Created == "" || meta.Modified == "" {
+		t.Errorf("expected created and modified timestamps, got %#v", meta)
+	}
+
+	err = dbm.SetLexiconMeta(lexRef, LexiconMeta{
+		Description:   "Test lexicon",
+		License:       "CC0",
+		SourceURL:     "https://example.com/lex1",
+		SourceVersion: "1.0",
+		Owner:         "tester",
+		Properties:    map[string]string{"derived_from": "NST", "note": "x"},
+	})
+	if err != nil {
+		t.Fatalf("failed to set lexicon meta : %v", err)
+	}
+	meta, err = dbm.GetLexiconMeta(lexRef)
+	if err != nil {
+		t.Fatalf("failed to get lexicon meta : %v", err)
+	}
+	if w, g := "Test lexicon", meta.Description; w != g {
+		t.Errorf(fs, w, g)
+	}
+	if w, g := "CC0", meta.License; w != g {
+		t.Errorf(fs, w, g)
+	}
+	if w, g := "https://example.com/lex1", meta.SourceURL; w != g {
+		t.Errorf(fs, w, g)
+	}
+	if w, g := "NST", meta.Properties["derived_from"]; w != g {
+		t.Errorf(fs, w, g)
+	}
+
+	// properties are replaced
+	meta.Properties = map[string]string{"note": "y"}
+	err = dbm.SetLexiconMeta(lexRef, meta)
+	if err != nil {
+		t.Fatalf("failed to set lexicon meta : %v", err)
+	}
+	meta, err = dbm.GetLexiconMeta(lexRef)
+	if err != nil {
+		t.Fatalf("failed to get lexicon meta : %v", err)
+	}
+	if w, g := 1, len(meta.Properties); w != g {
+		t.Errorf(fs, w, g)
+	}
+	if w, g := "y", meta.Properties["note"]; w != g {
+		t.Errorf(fs, w, g)
+	}
+
+	// modified is the latest entry update, if later than the meta data update, also on the same day
+	lexRef2 := lex.NewLexRef(string(dbRef), "lex2")
+	defineTestLexicons(t, dbm, lexRef2)
+	_, err = dbm.InsertEntries(lexRef2, []lex.Entry{{Strn: "hund", Language: "sv-se", Transcriptions: []lex.Transcription{{Strn: `" h u0 n d`}}, EntryStatus: lex.EntryStatus{Name: "imported", Source: "test"}}})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	_, err = dbm.dbs[dbRef].Exec("UPDATE LexiconMeta SET created = '2030-01-01 08:00:00', modified = '2030-01-02 10:00:00'")
+	if err != nil {
+		t.Fatalf("failed to update meta data : %v", err)
+	}
+	_, err = dbm.dbs[dbRef].Exec("UPDATE EntryStatus SET Timestamp = '2030-01-02 11:00:00'")
+	if err != nil {
+		t.Fatalf("failed to update entry status : %v", err)
+	}
+	meta, err = dbm.GetLexiconMeta(lexRef2)
+	if err != nil {
+		t.Fatalf("failed to get lexicon meta : %v", err)
+	}
+	if w, g := "2030-01-01T08:00:00Z", meta.Created; w != g {
+		t.Errorf(fs, w, g)
+	}
+	if w, g := "2030-01-02T11:00:00Z", meta.Modified; w != g {
+		t.Errorf(fs, w, g)
+	}
+	_, err = dbm.dbs[dbRef].Exec("UPDATE EntryStatus SET Timestamp = '2030-01-02 09:00:00'")
+	if err != nil {
+		t.Fatalf("failed to update entry status : %v", err)
+	}
+	meta, err = dbm.GetLexiconMeta(lexRef2)
+	if err != nil {
+		t.Fatalf("failed to get lexicon meta : %v", err)
+	}
+	if w, g := "2030-01-02T10:00:00Z", meta.Modified; w != g {
+		t.Errorf(fs, w, g)
+	}
+
+	_, err = dbm.GetLexiconMeta(lex.NewLexRef(string(dbRef), "nosuchlex"))
+	if err == nil {
+		t.Errorf("expected error for unknown lexicon")
+	}
+
+	// a lexicon with meta data can be deleted (the meta data is deleted by cascade)
+	err = dbm.DeleteLexicon(lexRef)
+	if err != nil {
+		t.Fatalf("failed to delete lexicon : %v", err)
+	}
+}
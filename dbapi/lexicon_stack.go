@@ -0,0 +1,246 @@
+package dbapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// LexiconStack is a named, ordered list of lexicons, used for layered lookup: for each word, only the entries of the first lexicon in the stack containing the word are returned. A typical use is a small, hand-curated override lexicon on top of a large base lexicon.
+//
+// Lookup in a stack is made using DBManager.LookUpStack. Stack names cannot contain colons, so they are never confused with lexicon references (db:lexicon).
+type LexiconStack struct {
+	Name     string       `json:"name"`
+	Lexicons []lex.LexRef `json:"lexicons"`
+}
+
+func (s LexiconStack) String() string {
+	refs := []string{}
+	for _, ref := range s.Lexicons {
+		refs = append(refs, ref.String())
+	}
+	return fmt.Sprintf("%s[%s]", s.Name, strings.Join(refs, " > "))
+}
+
+// SetLexiconStackFile sets the JSON file used to persist lexicon stacks. If the file exists, the stacks are loaded from it, replacing any stacks already defined. After this call, the file is re-written each time a stack is defined or deleted.
+func (dbm *DBManager) SetLexiconStackFile(fileName string) error {
+	dbm.stackMutex.Lock()
+	defer dbm.stackMutex.Unlock()
+
+	dbm.stackFile = fileName
+	stacks := make(map[string]LexiconStack)
+	bts, err := os.ReadFile(filepath.Clean(fileName))
+	if os.IsNotExist(err) {
+		dbm.stacks = stacks
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("DBManager.SetLexiconStackFile: couldn't read file : %v", err)
+	}
+	var list []LexiconStack
+	err = json.Unmarshal(bts, &list)
+	if err != nil {
+		return fmt.Errorf("DBManager.SetLexiconStackFile: couldn't unmarshal file '%s' : %v", fileName, err)
+	}
+	for _, s := range list {
+		stacks[s.Name] = s
+	}
+	dbm.stacks = stacks
+	return nil
+}
+
+// saveLexiconStacks should be called with the stack mutex locked
+func (dbm *DBManager) saveLexiconStacks() error {
+	if dbm.stackFile == "" {
+		return nil
+	}
+	bts, err := json.MarshalIndent(dbm.listLexiconStacks(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal lexicon stacks : %v", err)
+	}
+	err = os.WriteFile(dbm.stackFile, bts, 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't save lexicon stacks : %v", err)
+	}
+	return nil
+}
+
+// DefineLexiconStack adds a lexicon stack, or replaces an existing stack with the same name. All lexicons in the stack must exist.
+func (dbm *DBManager) DefineLexiconStack(s LexiconStack) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return fmt.Errorf("DBManager.DefineLexiconStack: empty stack name")
+	}
+	if strings.Contains(s.Name, ":") {
+		return fmt.Errorf("DBManager.DefineLexiconStack: stack name cannot contain ':' : '%s'", s.Name)
+	}
+	if len(s.Lexicons) == 0 {
+		return fmt.Errorf("DBManager.DefineLexiconStack: stack '%s' has no lexicons", s.Name)
+	}
+	seen := make(map[lex.LexRef]bool)
+	for _, ref := range s.Lexicons {
+		if seen[ref] {
+			return fmt.Errorf("DBManager.DefineLexiconStack: lexicon '%s' occurs more than once in stack '%s'", ref, s.Name)
+		}
+		seen[ref] = true
+		exists, err := dbm.LexiconExists(ref)
+		if err != nil {
+			return fmt.Errorf("DBManager.DefineLexiconStack: %v", err)
+		}
+		if !exists {
+			return fmt.Errorf("DBManager.DefineLexiconStack: no such lexicon '%s'", ref)
+		}
+	}
+
+	dbm.stackMutex.Lock()
+	defer dbm.stackMutex.Unlock()
+	dbm.stacks[s.Name] = s
+	return dbm.saveLexiconStacks()
+}
+
+// DeleteLexiconStack deletes the named lexicon stack. The lexicons themselves are not affected.
+func (dbm *DBManager) DeleteLexiconStack(name string) error {
+	dbm.stackMutex.Lock()
+	defer dbm.stackMutex.Unlock()
+	if _, ok := dbm.stacks[name]; !ok {
+		return fmt.Errorf("DBManager.DeleteLexiconStack: no such stack '%s'", name)
+	}
+	delete(dbm.stacks, name)
+	return dbm.saveLexiconStacks()
+}
+
+// removeFromLexiconStacks removes the lexicons matching remove from all stacks, e.g. after a lexicon has been deleted. Stacks left without lexicons are deleted.
+func (dbm *DBManager) removeFromLexiconStacks(remove func(lex.LexRef) bool) error {
+	dbm.stackMutex.Lock()
+	defer dbm.stackMutex.Unlock()
+	changed := false
+	for name, s := range dbm.stacks {
+		refs := []lex.LexRef{}
+		for _, ref := range s.Lexicons {
+			if !remove(ref) {
+				refs = append(refs, ref)
+			}
+		}
+		if len(refs) == len(s.Lexicons) {
+			continue
+		}
+		changed = true
+		if len(refs) == 0 {
+			delete(dbm.stacks, name)
+			continue
+		}
+		s.Lexicons = refs
+		dbm.stacks[name] = s
+	}
+	if !changed {
+		return nil
+	}
+	return dbm.saveLexiconStacks()
+}
+
+// GetLexiconStack returns the named lexicon stack, and false if there is no such stack
+func (dbm *DBManager) GetLexiconStack(name string) (LexiconStack, bool) {
+	dbm.stackMutex.RLock()
+	defer dbm.stackMutex.RUnlock()
+	s, ok := dbm.stacks[name]
+	return s, ok
+}
+
+// ListLexiconStacks returns all lexicon stacks, sorted by name
+func (dbm *DBManager) ListLexiconStacks() []LexiconStack {
+	dbm.stackMutex.RLock()
+	defer dbm.stackMutex.RUnlock()
+	return dbm.listLexiconStacks()
+}
+
+func (dbm *DBManager) listLexiconStacks() []LexiconStack {
+	res := []LexiconStack{}
+	for _, s := range dbm.stacks {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// LookUpStack runs the query on each lexicon in the named stack. For each word (Entry.Strn), only the entries from the first lexicon in the stack containing the word are kept, even if the word's entries in that lexicon do not match the query. Paging is applied to the merged result. The result is written to a lex.EntryWriter.
+func (dbm *DBManager) LookUpStack(stackName string, q Query, out lex.EntryWriter) error {
+	stack, ok := dbm.GetLexiconStack(stackName)
+	if !ok {
+		return fmt.Errorf("DBManager.LookUpStack failed: no such lexicon stack '%s'", stackName)
+	}
+
+	layerQ := q
+	layerQ.Page = 0
+	layerQ.PageLength = 0
+
+	hits := make([][]lex.Entry, len(stack.Lexicons))
+	words := make(map[string]bool)
+	for i, ref := range stack.Lexicons {
+		es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{ref}, Query: layerQ})
+		if err != nil {
+			return err
+		}
+		hits[i] = es
+		for _, e := range es {
+			words[strings.ToLower(e.Strn)] = true
+		}
+	}
+
+	// owner maps each word to the index of the first lexicon in the stack containing it
+	owner := make(map[string]int)
+	for i, ref := range stack.Lexicons {
+		remaining := []string{}
+		for w := range words {
+			if _, ok := owner[w]; !ok {
+				remaining = append(remaining, w)
+			}
+		}
+		if len(remaining) == 0 {
+			break
+		}
+		es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{ref}, Query: Query{Words: remaining}})
+		if err != nil {
+			return err
+		}
+		for _, e := range es {
+			w := strings.ToLower(e.Strn)
+			if _, ok := owner[w]; !ok {
+				owner[w] = i
+			}
+		}
+	}
+
+	res := []lex.Entry{}
+	for i, es := range hits {
+		for _, e := range es {
+			// Entries from outside the stack lexicon (i.e., related entries) are kept as is
+			if e.LexRef != stack.Lexicons[i] || owner[strings.ToLower(e.Strn)] == i {
+				res = append(res, e)
+			}
+		}
+	}
+
+	if q.PageLength > 0 || q.Page > 0 {
+		from := q.PageLength * q.Page
+		to := from + q.PageLength
+		if from > int64(len(res)) {
+			from = int64(len(res))
+		}
+		if to > int64(len(res)) {
+			to = int64(len(res))
+		}
+		res = res[from:to]
+	}
+	for _, e := range res {
+		err := out.Write(e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package dbapi
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestLexiconStackSqlite(t *testing.T) {
+	dbRef := lex.DBRef("lexiconstack_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	stackFile := filepath.Join(t.TempDir(), "stacks.json")
+	err := dbm.SetLexiconStackFile(stackFile)
+	if err != nil {
+		t.Fatalf("failed to set stack file : %v", err)
+	}
+
+	base := lex.NewLexRef(string(dbRef), "base")
+	override := lex.NewLexRef(string(dbRef), "override")
+	defineTestLexicons(t, dbm, base, override)
+	_, err = dbm.InsertEntries(base, []lex.Entry{
+		newTestEntry("hund", `" h u n d`),
+		newTestEntry("katt", `" k a t`),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	_, err = dbm.InsertEntries(override, []lex.Entry{
+		newTestEntry("hund", `" h u0 n d`),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	err = dbm.DefineLexiconStack(LexiconStack{Name: "a:b", Lexicons: []lex.LexRef{base}})
+	if err == nil {
+		t.Errorf("expected error for stack name with colon")
+	}
+	err = dbm.DefineLexiconStack(LexiconStack{Name: "tts", Lexicons: []lex.LexRef{override, lex.NewLexRef(string(dbRef), "nosuchlex")}})
+	if err == nil {
+		t.Errorf("expected error for unknown lexicon")
+	}
+	err = dbm.DefineLexiconStack(LexiconStack{Name: "tts", Lexicons: []lex.LexRef{override, base}})
+	if err != nil {
+		t.Fatalf("failed to define stack : %v", err)
+	}
+
+	// the union, without stack
+	res, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{override, base}, Query: Query{Words: []string{"hund", "katt"}}})
+	if err != nil {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	if w, g := 3, len(res); w != g {
+		t.Errorf(fs, w, g)
+	}
+
+	var w lex.EntrySliceWriter
+	err = dbm.LookUpStack("tts", Query{Words: []string{"hund", "katt"}}, &w)
+	res = w.Entries
+	if err != nil {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	if w, g := 2, len(res); w != g {
+		t.Fatalf(fs, w, g)
+	}
+	for _, e := range res {
+		switch e.Strn {
+		case "hund":
+			if w, g := override, e.LexRef; w != g {
+				t.Errorf(fs, w, g)
+			}
+		case "katt":
+			if w, g := base, e.LexRef; w != g {
+				t.Errorf(fs, w, g)
+			}
+		default:
+			t.Errorf("unexpected entry: %v", e)
+		}
+	}
+
+	// base 'hund' is shadowed by the override lexicon, even if the override entry doesn't match the query
+	w = lex.EntrySliceWriter{}
+	err = dbm.LookUpStack("tts", Query{TranscriptionLike: `%h u n d%`}, &w)
+	res = w.Entries
+	if err != nil {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	if w, g := 0, len(res); w != g {
+		t.Errorf(fs, w, g)
+	}
+
+	err = dbm.LookUpStack("nosuchstack", Query{Words: []string{"hund"}}, &lex.EntrySliceWriter{})
+	if err == nil {
+		t.Errorf("expected error for unknown stack")
+	}
+
+	// stacks are persisted
+	dbm2 := NewSqliteDBManager()
+	err = dbm2.SetLexiconStackFile(stackFile)
+	if err != nil {
+		t.Fatalf("failed to load stack file : %v", err)
+	}
+	stacks := dbm2.ListLexiconStacks()
+	if w, g := 1, len(stacks); w != g {
+		t.Fatalf(fs, w, g)
+	}
+	if w, g := "tts[lexiconstack_test:override > lexiconstack_test:base]", stacks[0].String(); w != g {
+		t.Errorf(fs, w, g)
+	}
+
+	// a deleted lexicon is removed from the stacks, and stacks left without lexicons are deleted
+	empty := lex.NewLexRef(string(dbRef), "empty")
+	defineTestLexicons(t, dbm, empty)
+	for _, s := range []LexiconStack{{Name: "with_empty", Lexicons: []lex.LexRef{empty, base}}, {Name: "only_empty", Lexicons: []lex.LexRef{empty}}} {
+		err = dbm.DefineLexiconStack(s)
+		if err != nil {
+			t.Fatalf("failed to define stack : %v", err)
+		}
+	}
+	err = dbm.DeleteLexicon(empty)
+	if err != nil {
+		t.Fatalf("failed to delete lexicon : %v", err)
+	}
+	if _, ok := dbm.GetLexiconStack("only_empty"); ok {
+		t.Errorf("expected stack without lexicons to be deleted")
+	}
+	err = dbm2.SetLexiconStackFile(stackFile)
+	if err != nil {
+		t.Fatalf("failed to load stack file : %v", err)
+	}
+	s, ok := dbm2.GetLexiconStack("with_empty")
+	if !ok {
+		t.Fatalf("expected persisted stack 'with_empty'")
+	}
+	if w, g := "with_empty[lexiconstack_test:base]", s.String(); w != g {
+		t.Errorf(fs, w, g)
+	}
+
+	err = dbm.DeleteLexiconStack("tts")
+	if err != nil {
+		t.Fatalf("failed to delete stack : %v", err)
+	}
+	if _, ok := dbm.GetLexiconStack("tts"); ok {
+		t.Errorf("expected stack to be deleted")
+	}
+}
@@ -0,0 +1,75 @@
+package dbapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/pronlex/validation"
+)
+
+// BindValidator binds a validator to a lexicon. Entries inserted using DBManager.InsertEntries, or updated using DBManager.UpdateEntry, in a lexicon with a bound validator are validated before they are saved, and the resulting validation messages replace any validation messages in the input entries. The messages are saved in the same transaction as the entries themselves.
+// Existing entries are not revalidated by BindValidator; use DBManager.Validate for that.
+// Validator bindings are kept in memory only, and are not saved in the database.
+func (dbm *DBManager) BindValidator(lexRef lex.LexRef, v validation.Validator) error {
+	if !v.IsDefined() {
+		return fmt.Errorf("DBManager.BindValidator: undefined validator")
+	}
+	exists, err := dbm.LexiconExists(lexRef)
+	if err != nil {
+		return fmt.Errorf("DBManager.BindValidator: %v", err)
+	}
+	if !exists {
+		return fmt.Errorf("DBManager.BindValidator: no such lexicon '%s'", lexRef)
+	}
+	dbm.validatorMutex.Lock()
+	defer dbm.validatorMutex.Unlock()
+	dbm.validators[lexRef] = v
+	return nil
+}
+
+// UnbindValidator removes the validator bound to a lexicon, if any. Returns true if there was a bound validator.
+func (dbm *DBManager) UnbindValidator(lexRef lex.LexRef) bool {
+	dbm.validatorMutex.Lock()
+	defer dbm.validatorMutex.Unlock()
+	_, ok := dbm.validators[lexRef]
+	delete(dbm.validators, lexRef)
+	return ok
+}
+
+// BoundValidator returns the validator bound to a lexicon, and false if there is none
+func (dbm *DBManager) BoundValidator(lexRef lex.LexRef) (validation.Validator, bool) {
+	dbm.validatorMutex.RLock()
+	defer dbm.validatorMutex.RUnlock()
+	v, ok := dbm.validators[lexRef]
+	return v, ok
+}
+
+// BoundValidators returns the names of all bound validators, by lexicon
+func (dbm *DBManager) BoundValidators() map[lex.LexRef]string {
+	dbm.validatorMutex.RLock()
+	defer dbm.validatorMutex.RUnlock()
+	res := make(map[lex.LexRef]string)
+	for ref, v := range dbm.validators {
+		res[ref] = v.Name
+	}
+	return res
+}
+
+// revalidate returns copies of the entries, validated by the validator bound to the lexicon. If there is no bound validator, the input entries are returned as is.
+func (dbm *DBManager) revalidate(lexRef lex.LexRef, es []lex.Entry) []lex.Entry {
+	v, ok := dbm.BoundValidator(lexRef)
+	if !ok {
+		return es
+	}
+	res := make([]lex.Entry, len(es))
+	for i, e := range es {
+		v.ValidateEntry(&e)
+		// levels are saved in lower case, so they are lower cased here too, to avoid spurious updates (see newValidations)
+		for j := range e.EntryValidations {
+			e.EntryValidations[j].Level = strings.ToLower(e.EntryValidations[j].Level)
+		}
+		res[i] = e
+	}
+	return res
+}
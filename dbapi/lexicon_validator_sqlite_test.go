@@ -0,0 +1,95 @@
+package dbapi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/pronlex/validation"
+)
+
+// noSpaceInOrth rejects entries with a space in the orthography
+type noSpaceInOrth struct{}
+
+func (r noSpaceInOrth) Validate(e lex.Entry) (validation.Result, error) {
+	res := validation.Result{RuleName: r.Name(), Level: r.Level()}
+	if strings.Contains(e.Strn, " ") {
+		res.Messages = append(res.Messages, "Space in orthography: "+e.Strn)
+	}
+	return res, nil
+}
+func (r noSpaceInOrth) ShouldAccept() []lex.Entry { return []lex.Entry{} }
+func (r noSpaceInOrth) ShouldReject() []lex.Entry { return []lex.Entry{} }
+func (r noSpaceInOrth) Name() string              { return "NoSpaceInOrth" }
+func (r noSpaceInOrth) Level() string             { return "Fatal" }
+
+func TestBindValidatorSqlite(t *testing.T) {
+	dbRef := lex.DBRef("bind_validator_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+
+	err := dbm.BindValidator(lex.NewLexRef(string(dbRef), "nolex"), validation.Validator{Name: "test", Rules: []validation.Rule{noSpaceInOrth{}}})
+	if err == nil {
+		t.Errorf("expected error for non-existing lexicon")
+	}
+	err = dbm.BindValidator(lexRef, validation.Validator{Name: "test", Rules: []validation.Rule{noSpaceInOrth{}}})
+	if err != nil {
+		t.Fatalf("failed to bind validator : %v", err)
+	}
+
+	newEntry := func(strn string) lex.Entry {
+		e := newTestEntry(strn, `" A`)
+		// stale input validation, should be replaced
+		e.EntryValidations = []lex.EntryValidation{{Level: "Warning", RuleName: "Stale", Message: "stale"}}
+		return e
+	}
+	_, err = dbm.InsertEntries(lexRef, []lex.Entry{newEntry("hund"), newEntry("stor hund")})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	lookUp := func(w string) lex.Entry {
+		t.Helper()
+		es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{Words: []string{w}}})
+		if err != nil {
+			t.Fatalf("lookup failed : %v", err)
+		}
+		if len(es) != 1 {
+			t.Fatalf("expected one entry for '%s', got %d", w, len(es))
+		}
+		return es[0]
+	}
+	if e := lookUp("hund"); len(e.EntryValidations) != 0 {
+		t.Errorf("expected no validations for 'hund', got %v", e.EntryValidations)
+	}
+	e := lookUp("stor hund")
+	if len(e.EntryValidations) != 1 || e.EntryValidations[0].RuleName != "NoSpaceInOrth" {
+		t.Fatalf("expected one NoSpaceInOrth validation for 'stor hund', got %v", e.EntryValidations)
+	}
+
+	// fixing the entry should remove the validation
+	e.Strn = "storhund"
+	e.WordParts = "storhund"
+	res, _, err := dbm.UpdateEntry(e)
+	if err != nil {
+		t.Fatalf("failed to update entry : %v", err)
+	}
+	if len(res.EntryValidations) != 0 {
+		t.Errorf("expected no validations after update, got %v", res.EntryValidations)
+	}
+
+	// without a bound validator, input validations are saved as is
+	if !dbm.UnbindValidator(lexRef) {
+		t.Errorf("expected a bound validator")
+	}
+	_, err = dbm.InsertEntries(lexRef, []lex.Entry{newEntry("liten hund")})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	if e := lookUp("liten hund"); len(e.EntryValidations) != 1 || e.EntryValidations[0].RuleName != "Stale" {
+		t.Errorf("expected input validation for 'liten hund', got %v", e.EntryValidations)
+	}
+}
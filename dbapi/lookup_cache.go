@@ -0,0 +1,260 @@
+package dbapi
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// The lookup cache is a size-bounded LRU cache for the results of DBManager.LookUp. Only queries for a list of words (Query.Words, optionally with IgnoreCase or IgnoreDiacritics) without any other search criteria, paging or sorting are cached, since these make up most of the lookups from TTS clients.
+//
+// Cached results are invalidated per lexicon by the DBManager methods that change entries (InsertEntries, UpdateEntry, DeleteEntry, MoveNewEntries, ImportLexiconFile, etc), and per database by methods that change a whole database (RepairIntegrity, Migrate, CloseDB, etc). Lookups hold the DBManager read lock while reading from and adding to the cache, and writes hold the write lock while invalidating, so a lookup cannot cache a result that is stale with respect to a write made through the same DBManager.
+// Changes made to a database by other processes (e.g., command line tools writing to a database that is also used by the lexserver) are NOT detected by the cache.
+
+// LookupCacheStats holds statistics for the lookup cache (see DBManager.SetLookupCacheSize)
+type LookupCacheStats struct {
+	Enabled bool `json:"enabled"`
+	// MaxSize is the max number of cached queries
+	MaxSize int `json:"maxSize"`
+	// Size is the current number of cached queries
+	Size          int     `json:"size"`
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	HitRate       float64 `json:"hitRate"`
+	Evictions     int64   `json:"evictions"`
+	Invalidations int64   `json:"invalidations"`
+}
+
+type lookupCacheItem struct {
+	key     string
+	lexRefs []lex.LexRef
+	entries []lex.Entry
+}
+
+type lookupCache struct {
+	mutex   sync.Mutex
+	maxSize int
+	lru     *list.List // most recently used first
+	items   map[string]*list.Element
+	// keys of the cached queries for each lexicon
+	byLex map[lex.LexRef]map[string]bool
+
+	hits, misses, evictions, invalidations int64
+}
+
+func newLookupCache(maxSize int) *lookupCache {
+	return &lookupCache{
+		maxSize: maxSize,
+		lru:     list.New(),
+		items:   make(map[string]*list.Element),
+		byLex:   make(map[lex.LexRef]map[string]bool),
+	}
+}
+
+// cacheLexRef returns the lexicon reference used in the cache. Lexicon names are case-insensitive in the db, but db names are not.
+func cacheLexRef(ref lex.LexRef) lex.LexRef {
+	return lex.LexRef{DBRef: ref.DBRef, LexName: lex.LexName(strings.ToLower(string(ref.LexName)))}
+}
+
+// lookupCacheKey returns the cache key for a query, and false if the query should not be cached
+func lookupCacheKey(q DBMQuery) (string, bool) {
+	if len(q.Query.Words) == 0 {
+		return "", false
+	}
+	criteria := q.Query
+	criteria.Words = nil
+	// NB: if new search criteria are added to Query, they must also be added to Query.Empty
+	if !criteria.Empty() || q.Query.Page != 0 || q.Query.PageLength != 0 || q.Query.IncludeRelated || q.Query.OrderByUsage {
+		return "", false
+	}
+
+	refs := []string{}
+	for _, ref := range q.LexRefs {
+		refs = append(refs, cacheLexRef(ref).String())
+	}
+	sort.Strings(refs)
+
+	seen := make(map[string]bool)
+	words := []string{}
+	for _, w := range q.Query.Words {
+		k := q.Query.WordKey(w)
+		if !seen[k] {
+			words = append(words, k)
+			seen[k] = true
+		}
+	}
+	sort.Strings(words)
+
+	mode := "exact"
+	switch {
+	case q.Query.IgnoreDiacritics:
+		mode = "ignore_diacritics"
+	case q.Query.IgnoreCase:
+		mode = "ignore_case"
+	}
+	return strings.Join(refs, " ") + "\t" + mode + "\t" + strings.Join(words, "\t"), true
+}
+
+// copyEntry returns a copy of an entry that doesn't share any slices with the original, so that callers cannot modify cached entries
+func copyEntry(e lex.Entry) lex.Entry {
+	if e.Transcriptions != nil {
+		ts := make([]lex.Transcription, len(e.Transcriptions))
+		for i, t := range e.Transcriptions {
+			if t.Sources != nil {
+				t.Sources = append([]string{}, t.Sources...)
+			}
+			ts[i] = t
+		}
+		e.Transcriptions = ts
+	}
+	if e.EntryValidations != nil {
+		e.EntryValidations = append([]lex.EntryValidation{}, e.EntryValidations...)
+	}
+	if e.Comments != nil {
+		e.Comments = append([]lex.EntryComment{}, e.Comments...)
+	}
+	if e.Relations != nil {
+		e.Relations = append([]lex.EntryRelation{}, e.Relations...)
+	}
+	return e
+}
+
+func (c *lookupCache) get(key string) ([]lex.Entry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(el)
+	item := el.Value.(*lookupCacheItem)
+	res := make([]lex.Entry, len(item.entries))
+	for i, e := range item.entries {
+		res[i] = copyEntry(e)
+	}
+	return res, true
+}
+
+func (c *lookupCache) put(key string, lexRefs []lex.LexRef, entries []lex.Entry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	item := &lookupCacheItem{key: key, entries: make([]lex.Entry, len(entries))}
+	for i, e := range entries {
+		item.entries[i] = copyEntry(e)
+	}
+	for _, ref := range lexRefs {
+		ref = cacheLexRef(ref)
+		item.lexRefs = append(item.lexRefs, ref)
+		keys, ok := c.byLex[ref]
+		if !ok {
+			keys = make(map[string]bool)
+			c.byLex[ref] = keys
+		}
+		keys[key] = true
+	}
+	c.items[key] = c.lru.PushFront(item)
+
+	for c.lru.Len() > c.maxSize {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// remove removes an element from the cache. The caller must hold the cache mutex.
+func (c *lookupCache) remove(el *list.Element) {
+	item := el.Value.(*lookupCacheItem)
+	c.lru.Remove(el)
+	delete(c.items, item.key)
+	for _, ref := range item.lexRefs {
+		if keys, ok := c.byLex[ref]; ok {
+			delete(keys, item.key)
+			if len(keys) == 0 {
+				delete(c.byLex, ref)
+			}
+		}
+	}
+}
+
+// invalidateLexicons removes all cached queries involving any of the lexicons
+func (c *lookupCache) invalidateLexicons(lexRefs ...lex.LexRef) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, ref := range lexRefs {
+		for key := range c.byLex[cacheLexRef(ref)] {
+			if el, ok := c.items[key]; ok {
+				c.remove(el)
+				c.invalidations++
+			}
+		}
+	}
+}
+
+// invalidateDB removes all cached queries involving any lexicon in the database
+func (c *lookupCache) invalidateDB(dbRef lex.DBRef) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for ref, keys := range c.byLex {
+		if ref.DBRef != dbRef {
+			continue
+		}
+		for key := range keys {
+			if el, ok := c.items[key]; ok {
+				c.remove(el)
+				c.invalidations++
+			}
+		}
+	}
+}
+
+func (c *lookupCache) stats() LookupCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	res := LookupCacheStats{Enabled: true, MaxSize: c.maxSize, Size: c.lru.Len(), Hits: c.hits, Misses: c.misses, Evictions: c.evictions, Invalidations: c.invalidations}
+	if c.hits+c.misses > 0 {
+		res.HitRate = float64(c.hits) / float64(c.hits+c.misses)
+	}
+	return res
+}
+
+// SetLookupCacheSize enables the lookup cache, holding the results of at most size queries. If size is 0, the cache is disabled. Any previously cached results and statistics are discarded.
+func (dbm *DBManager) SetLookupCacheSize(size int) {
+	dbm.Lock()
+	defer dbm.Unlock()
+	if size <= 0 {
+		dbm.lookupCache = nil
+		return
+	}
+	dbm.lookupCache = newLookupCache(size)
+}
+
+// LookupCacheStats returns statistics for the lookup cache
+func (dbm *DBManager) LookupCacheStats() LookupCacheStats {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	if dbm.lookupCache == nil {
+		return LookupCacheStats{}
+	}
+	return dbm.lookupCache.stats()
+}
+
+// invalidateLexicons removes cached lookups for the lexicons. The caller must hold the DBManager write lock.
+func (dbm *DBManager) invalidateLexicons(lexRefs ...lex.LexRef) {
+	if dbm.lookupCache != nil {
+		dbm.lookupCache.invalidateLexicons(lexRefs...)
+	}
+}
+
+// invalidateDB removes cached lookups for all lexicons in the database. The caller must hold the DBManager write lock.
+func (dbm *DBManager) invalidateDB(dbRef lex.DBRef) {
+	if dbm.lookupCache != nil {
+		dbm.lookupCache.invalidateDB(dbRef)
+	}
+}
@@ -0,0 +1,163 @@
+package dbapi
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func createLookupCacheTestDB(t testing.TB, dbRef lex.DBRef, nEntries int) (*DBManager, lex.LexRef, lex.LexRef) {
+	dbm := createTestSqliteDBManager(t, dbRef)
+	lexRef1 := lex.NewLexRef(string(dbRef), "lex1")
+	lexRef2 := lex.NewLexRef(string(dbRef), "lex2")
+	defineTestLexicons(t, dbm, lexRef1, lexRef2)
+	es := []lex.Entry{}
+	for i := 0; i < nEntries; i++ {
+		es = append(es, lex.Entry{Strn: fmt.Sprintf("ord%d", i),
+			Language:       "sv-se",
+			Transcriptions: []lex.Transcription{{Strn: `" u: r d`, Sources: []string{"test"}}},
+			EntryStatus:    lex.EntryStatus{Name: "imported", Source: "test"},
+		})
+	}
+	_, err := dbm.InsertEntries(lexRef1, es)
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	return dbm, lexRef1, lexRef2
+}
+
+func TestLookupCacheSqlite(t *testing.T) {
+	dbRef := lex.DBRef("lookup_cache_test")
+	dbm, lexRef1, lexRef2 := createLookupCacheTestDB(t, dbRef, 3)
+	defer dbm.CloseDB(dbRef)
+	dbm.SetLookupCacheSize(2)
+
+	lookUp := func(q Query) []lex.Entry {
+		es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef1}, Query: q})
+		if err != nil {
+			t.Fatalf("lookup failed : %v", err)
+		}
+		return es
+	}
+	expectStats := func(hits, misses int64, size int) {
+		t.Helper()
+		stats := dbm.LookupCacheStats()
+		if stats.Hits != hits || stats.Misses != misses || stats.Size != size {
+			t.Errorf("expected %d hits, %d misses and size %d, got %#v", hits, misses, size, stats)
+		}
+	}
+
+	// Only pure word lookups are cached
+	lookUp(Query{WordLike: "ord%"})
+	lookUp(Query{Words: []string{"ord0"}, TranscriptionLike: "%"})
+	expectStats(0, 0, 0)
+
+	es := lookUp(Query{Words: []string{"ord0", "ord1"}})
+	if len(es) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(es))
+	}
+	expectStats(0, 1, 1)
+	// Word order, duplicates and case don't matter
+	lookUp(Query{Words: []string{"ORD1", "ord0", "ord1"}})
+	expectStats(1, 1, 1)
+	// ... but the lookup mode does
+	lookUp(Query{Words: []string{"ord0", "ord1"}, IgnoreCase: true})
+	expectStats(1, 2, 2)
+
+	// Callers cannot modify the cached entries
+	es = lookUp(Query{Words: []string{"ord0", "ord1"}})
+	es[0].Transcriptions[0].Strn = "modified"
+	es = lookUp(Query{Words: []string{"ord0", "ord1"}})
+	if es[0].Transcriptions[0].Strn == "modified" {
+		t.Errorf("cached entry was modified by caller")
+	}
+	expectStats(3, 2, 2)
+
+	// Writes to another lexicon don't invalidate
+	_, err := dbm.InsertEntries(lexRef2, []lex.Entry{{Strn: "ord0", Language: "sv-se", Transcriptions: []lex.Transcription{{Strn: `" u: r d`}}, EntryStatus: lex.EntryStatus{Name: "imported", Source: "test"}}})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	expectStats(3, 2, 2)
+
+	// Updates invalidate
+	e := es[0]
+	e.Transcriptions[0].Strn = `" u: r t`
+	_, _, err = dbm.UpdateEntry(e)
+	if err != nil {
+		t.Fatalf("failed to update entry : %v", err)
+	}
+	expectStats(3, 2, 0)
+	es = lookUp(Query{Words: []string{e.Strn}})
+	if len(es) != 1 || es[0].Transcriptions[0].Strn != `" u: r t` {
+		t.Errorf("expected updated entry, got %#v", es)
+	}
+
+	// Deletes invalidate
+	_, err = dbm.DeleteEntry(e.ID, lexRef1)
+	if err != nil {
+		t.Fatalf("failed to delete entry : %v", err)
+	}
+	if es = lookUp(Query{Words: []string{e.Strn}}); len(es) != 0 {
+		t.Errorf("expected no entries after delete, got %#v", es)
+	}
+
+	// Moving entries invalidates both lexicons
+	lookUp(Query{Words: []string{"ord2"}})
+	_, err = dbm.MoveNewEntries(dbRef, lexRef1.LexName, lexRef2.LexName, "moved", "moved")
+	if err != nil {
+		t.Fatalf("failed to move entries : %v", err)
+	}
+	if es = lookUp(Query{Words: []string{"ord2"}}); len(es) != 0 {
+		t.Errorf("expected no entries after move, got %#v", es)
+	}
+
+	// LRU eviction
+	lookUp(Query{Words: []string{"a"}})
+	lookUp(Query{Words: []string{"b"}})
+	lookUp(Query{Words: []string{"c"}})
+	if stats := dbm.LookupCacheStats(); stats.Size != 2 || stats.Evictions == 0 {
+		t.Errorf("expected evictions, got %#v", stats)
+	}
+
+	dbm.SetLookupCacheSize(0)
+	lookUp(Query{Words: []string{"ord0"}})
+	if stats := dbm.LookupCacheStats(); stats.Enabled || stats.Misses != 0 {
+		t.Errorf("expected disabled cache, got %#v", stats)
+	}
+}
+
+func benchmarkLookUp(b *testing.B, cacheSize int) {
+	dbRef := lex.DBRef(fmt.Sprintf("lookup_cache_bench_%d", cacheSize))
+	nEntries := 500
+	dbm, lexRef, _ := createLookupCacheTestDB(b, dbRef, nEntries)
+	defer dbm.CloseDB(dbRef)
+	dbm.SetLookupCacheSize(cacheSize)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			// a skewed word distribution, like in running text
+			w := fmt.Sprintf("ord%d", (i*i)%nEntries)
+			i++
+			_, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{Words: []string{w}}})
+			if err != nil {
+				b.Fatalf("lookup failed : %v", err)
+			}
+		}
+	})
+	b.StopTimer()
+	if cacheSize > 0 {
+		b.ReportMetric(dbm.LookupCacheStats().HitRate, "hitrate")
+	}
+}
+
+func BenchmarkLookUpUncached(b *testing.B) {
+	benchmarkLookUp(b, 0)
+}
+
+func BenchmarkLookUpCached(b *testing.B) {
+	benchmarkLookUp(b, 1000)
+}
@@ -0,0 +1,333 @@
+package dbapi
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// NormalisationCollision is a set of entries in the same lexicon with orthographies that were different before Unicode normalisation, but are identical after
+type NormalisationCollision struct {
+	Lexicon string `json:"lexicon"`
+	// Strn is the normalised orthography
+	Strn string `json:"strn"`
+	// Variants are the different orthographies before normalisation
+	Variants []string `json:"variants"`
+	EntryIDs []int64  `json:"entryIds"`
+}
+
+// MigrationReport holds the result of a call to DBManager.Migrate
+type MigrationReport struct {
+	DB          string `json:"db"`
+	FromVersion string `json:"fromVersion"`
+	ToVersion   string `json:"toVersion"`
+	// Migrated is false if the database was already up to date
+	Migrated bool `json:"migrated"`
+	// NormalisedEntries is the number of entries with an orthography or word parts string changed by Unicode normalisation
+	NormalisedEntries int                      `json:"normalisedEntries"`
+	Collisions        []NormalisationCollision `json:"collisions"`
+	// TagConflicts lists entries whose tag word form could not be updated after normalisation, since another entry has the same tag and word form (these are reported by CheckIntegrity)
+	TagConflicts []int64 `json:"tagConflicts"`
+	// ConvertedAssignComments is the number of assign_to comments converted into assignments
+	ConvertedAssignComments int `json:"convertedAssignComments"`
+}
+
+// Migrate upgrades a database created with an older (compatible) schema version to SchemaVersion. Databases with the current schema version are not changed.
+//
+// From schema version 3.2, the database has an EntryRelation table (see entry_relation.go), from 3.3, LexiconMeta and LexiconProperty tables (see lexicon_meta.go), and from 3.4, EntryUsage and MissedWord tables (see usage.go). The migration creates the tables, and an empty meta data row for each existing lexicon.
+//
+// From schema version 3.5, orthographies are stored NFC normalised, and each entry has lookup columns for case-insensitive and diacritic-insensitive lookup (see normalisation.go). The migration adds these columns if needed, normalises all existing entries, and reports entries that are identical after normalisation (collisions). Such entries are not merged, since this requires a manual decision.
+//
+// From schema version 3.6, the database has a ChangeEvent table for the change feed (see changes.go), from 3.7, a ReplicationState table (see replication.go), from 3.8, an EntryProposal table (see proposal.go), from 3.9, an Assignment table (see assignment.go), from 3.10, ImportBatch and ImportBatchEntry tables (see import_batch.go), and from 3.11, a FrozenLexicon table (see frozen_lexicon.go). The migration creates the tables (empty); entries imported before the migration are not part of any import batch, and no lexicon is frozen.
+//
+// Before schema version 3.9, entries were assigned to annotators using comments with the label assign_to. The migration converts these comments into assignments (see ConvertAssignComments).
+func (dbm *DBManager) Migrate(dbRef lex.DBRef) (MigrationReport, error) {
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return MigrationReport{}, fmt.Errorf("DBManager.Migrate: no such db '%s'", dbRef)
+	}
+
+	version, err := dbm.dbifOf(db).getSchemaVersion(db)
+	if err != nil {
+		return MigrationReport{}, fmt.Errorf("DBManager.Migrate: %v", err)
+	}
+	res := MigrationReport{DB: string(dbRef), FromVersion: version, ToVersion: SchemaVersion, Collisions: []NormalisationCollision{}, TagConflicts: []int64{}}
+	if version == SchemaVersion {
+		return res, nil
+	}
+	err = compatibleSchemaVersion(version)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.Migrate: %v", err)
+	}
+
+	dbm.invalidateDB(dbRef)
+	err = addTables(db, dbm.dbifOf(db).engine(), version)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.Migrate: %v", err)
+	}
+	if schemaVersionBefore(version, "3.5") {
+		err = addLookupColumns(db, dbm.dbifOf(db).engine())
+		if err != nil {
+			return res, fmt.Errorf("DBManager.Migrate: %v", err)
+		}
+		err = normaliseEntriesTx(db, &res)
+		if err != nil {
+			return res, fmt.Errorf("DBManager.Migrate: %v", err)
+		}
+	}
+	if schemaVersionBefore(version, "3.9") {
+		res.ConvertedAssignComments, err = convertAssignComments(db)
+		if err != nil {
+			return res, fmt.Errorf("DBManager.Migrate: %v", err)
+		}
+	}
+	_, err = db.Exec("UPDATE SchemaVersion SET name = ?", SchemaVersion)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.Migrate: failed to update schema version : %v", err)
+	}
+	res.Migrated = true
+	log.Printf("DBManager.Migrate: migrated db '%s' from schema version %s to %s", dbRef, version, SchemaVersion)
+	return res, nil
+}
+
+// schemaVersionBefore returns true if the schema version v is older than the version w. Both versions are assumed to have the same major version (see compatibleSchemaVersion).
+func schemaVersionBefore(v, w string) bool {
+	minor := func(v string) int {
+		fs := strings.SplitN(strings.TrimSpace(v), ".", 2)
+		if len(fs) < 2 {
+			return 0
+		}
+		n, _ := strconv.Atoi(fs[1])
+		return n
+	}
+	return minor(v) < minor(w)
+}
+
+// addTables creates the tables added to the schema after the schema version version, unless they are already defined (as in a database restored from a backup)
+func addTables(db *sql.DB, engine DBEngine, version string) error {
+	var stmts []string
+	// add appends the statements for the engine. On MariaDB, each statement must be run separately.
+	add := func(sqlite string, mariaDB ...string) {
+		if engine == MariaDB {
+			stmts = append(stmts, mariaDB...)
+		} else {
+			stmts = append(stmts, sqlite)
+		}
+	}
+	if schemaVersionBefore(version, "3.2") {
+		add(entryRelationTableSqlite, entryRelationTableMariaDB,
+			"CREATE INDEX IF NOT EXISTS erfrom ON EntryRelation (fromEntryId)",
+			"CREATE INDEX IF NOT EXISTS erto ON EntryRelation (toEntryId)",
+			"CREATE INDEX IF NOT EXISTS ertype ON EntryRelation (type)")
+	}
+	if schemaVersionBefore(version, "3.3") {
+		add(lexiconMetaTablesSqlite, lexiconMetaTableMariaDB, lexiconPropertyTableMariaDB)
+		// each lexicon has a meta data row, created along with the lexicon
+		stmts = append(stmts, "INSERT INTO LexiconMeta (lexiconId) SELECT id FROM Lexicon WHERE id NOT IN (SELECT lexiconId FROM LexiconMeta)")
+	}
+	if schemaVersionBefore(version, "3.4") {
+		add(usageTablesSqlite, entryUsageTableMariaDB, "CREATE INDEX IF NOT EXISTS euhits ON EntryUsage (hits)",
+			missedWordTableMariaDB, "CREATE INDEX IF NOT EXISTS mwhits ON MissedWord (hits)")
+	}
+	if schemaVersionBefore(version, "3.6") {
+		add(changeEventTableSqlite, changeEventTableMariaDB, "CREATE INDEX IF NOT EXISTS celexiconname ON ChangeEvent (lexiconName, seq)")
+	}
+	if schemaVersionBefore(version, "3.7") {
+		add(replicationStateTableSqlite, replicationStateTableMariaDB)
+	}
+	if schemaVersionBefore(version, "3.8") {
+		add(entryProposalTableSqlite, entryProposalTableMariaDB, "CREATE INDEX IF NOT EXISTS epentrystatus ON EntryProposal (entryId, status)")
+	}
+	if schemaVersionBefore(version, "3.9") {
+		add(assignmentTableSqlite, assignmentTableMariaDB,
+			"CREATE INDEX IF NOT EXISTS asgassigneestatus ON Assignment (assignee, status)",
+			"CREATE INDEX IF NOT EXISTS asgentrystatus ON Assignment (entryId, status)")
+	}
+	if schemaVersionBefore(version, "3.10") {
+		add(importBatchTablesSqlite, importBatchTableMariaDB, importBatchEntryTableMariaDB,
+			"CREATE INDEX IF NOT EXISTS ibebatch ON ImportBatchEntry (batchId)",
+			"CREATE INDEX IF NOT EXISTS ibeentry ON ImportBatchEntry (entryId)")
+	}
+	if schemaVersionBefore(version, "3.11") {
+		add(frozenLexiconTableSqlite, frozenLexiconTableMariaDB)
+	}
+	for _, s := range stmts {
+		_, err := db.Exec(s)
+		if err != nil {
+			return fmt.Errorf("failed to add tables : %v", err)
+		}
+	}
+	return nil
+}
+
+// addLookupColumns adds the Entry.strnFold and Entry.strnBase columns and their indices, unless they are already defined (as in a MariaDB database restored from a backup)
+func addLookupColumns(db *sql.DB, engine DBEngine) error {
+	rows, err := db.Query("SELECT strnFold, strnBase FROM Entry LIMIT 1")
+	if err == nil {
+		rows.Close()
+		return nil
+	}
+
+	// the DDL statements below cannot be run in a transaction on MariaDB (they cause an implicit commit), so they are run one by one
+	indexCol := "%s"
+	if engine == MariaDB {
+		indexCol = "%s(255)"
+	}
+	stmts := []string{
+		"ALTER TABLE Entry ADD COLUMN strnFold text not null default ''",
+		"ALTER TABLE Entry ADD COLUMN strnBase text not null default ''",
+		"CREATE INDEX IF NOT EXISTS entrystrnfold ON Entry (" + fmt.Sprintf(indexCol, "strnFold") + ")",
+		"CREATE INDEX IF NOT EXISTS entrystrnbase ON Entry (" + fmt.Sprintf(indexCol, "strnBase") + ")",
+	}
+	for _, s := range stmts {
+		_, err := db.Exec(s)
+		if err != nil {
+			return fmt.Errorf("failed to add lookup columns : %v", err)
+		}
+	}
+	return nil
+}
+
+// normaliseEntriesTx normalises the orthography and word parts of all entries, and sets their lookup columns, in a single transaction
+func normaliseEntriesTx(db *sql.DB, res *MigrationReport) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start db transaction : %v", err)
+	}
+	rollback := func(msg string) error {
+		err := tx.Rollback()
+		if err != nil {
+			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err)
+		}
+		return errors.New(msg)
+	}
+
+	lexNames := make(map[int64]string)
+	rows, err := tx.Query("SELECT id, name FROM Lexicon")
+	if err != nil {
+		return rollback(fmt.Sprintf("failed to list lexicons : %v", err))
+	}
+	for rows.Next() {
+		var id int64
+		var name string
+		err = rows.Scan(&id, &name)
+		if err != nil {
+			rows.Close()
+			return rollback(fmt.Sprintf("failed to scan lexicon : %v", err))
+		}
+		lexNames[id] = name
+	}
+	rows.Close()
+
+	type entry struct {
+		id, lexiconID   int64
+		strn, wordParts string
+		hasWordParts    bool
+	}
+	entries := []entry{}
+	rows, err = tx.Query("SELECT id, lexiconId, strn, wordParts FROM Entry ORDER BY id")
+	if err != nil {
+		return rollback(fmt.Sprintf("failed to list entries : %v", err))
+	}
+	for rows.Next() {
+		var e entry
+		var wordParts sql.NullString
+		err = rows.Scan(&e.id, &e.lexiconID, &e.strn, &wordParts)
+		if err != nil {
+			rows.Close()
+			return rollback(fmt.Sprintf("failed to scan entry : %v", err))
+		}
+		e.wordParts, e.hasWordParts = wordParts.String, wordParts.Valid
+		entries = append(entries, e)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return rollback(fmt.Sprintf("failed to list entries : %v", err))
+	}
+
+	type collisionKey struct {
+		lexiconID int64
+		strn      string
+	}
+	variants := make(map[collisionKey]map[string]bool)
+	ids := make(map[collisionKey][]int64)
+	changedStrn := []entry{}
+	for _, e := range entries {
+		strn := NormaliseWord(e.strn)
+		wordParts := norm.NFC.String(e.wordParts)
+		if strn != e.strn || wordParts != e.wordParts {
+			res.NormalisedEntries++
+		}
+		var wp any
+		if e.hasWordParts {
+			wp = wordParts
+		}
+		_, err = tx.Exec("UPDATE Entry SET strn = ?, wordParts = ?, strnFold = ?, strnBase = ? WHERE id = ?", strn, wp, FoldCase(strn), RemoveDiacritics(strn), e.id)
+		if err != nil {
+			return rollback(fmt.Sprintf("failed to update entry %d : %v", e.id, err))
+		}
+
+		k := collisionKey{lexiconID: e.lexiconID, strn: strn}
+		if _, ok := variants[k]; !ok {
+			variants[k] = make(map[string]bool)
+		}
+		variants[k][e.strn] = true
+		ids[k] = append(ids[k], e.id)
+		if strn != e.strn {
+			e.strn = strn
+			changedStrn = append(changedStrn, e)
+		}
+	}
+
+	for k, vs := range variants {
+		if len(vs) < 2 {
+			continue
+		}
+		c := NormalisationCollision{Lexicon: lexNames[k.lexiconID], Strn: k.strn, EntryIDs: ids[k]}
+		for v := range vs {
+			c.Variants = append(c.Variants, v)
+		}
+		sort.Strings(c.Variants)
+		res.Collisions = append(res.Collisions, c)
+	}
+	sort.Slice(res.Collisions, func(i, j int) bool {
+		if res.Collisions[i].Lexicon != res.Collisions[j].Lexicon {
+			return res.Collisions[i].Lexicon < res.Collisions[j].Lexicon
+		}
+		return res.Collisions[i].Strn < res.Collisions[j].Strn
+	})
+
+	// The word form of an entry tag is a copy of the entry's orthography, and tags are unique per word form
+	for _, e := range changedStrn {
+		var n int
+		err = tx.QueryRow("SELECT COUNT(*) FROM EntryTag t1, EntryTag t2 WHERE t1.entryId = ? AND t2.entryId != t1.entryId AND t2.tag = t1.tag AND t2.wordForm = ?", e.id, e.strn).Scan(&n)
+		if err != nil {
+			return rollback(fmt.Sprintf("failed to check entry tag for entry %d : %v", e.id, err))
+		}
+		if n > 0 {
+			res.TagConflicts = append(res.TagConflicts, e.id)
+			continue
+		}
+		_, err = tx.Exec("UPDATE EntryTag SET wordForm = ? WHERE entryId = ?", e.strn, e.id)
+		if err != nil {
+			return rollback(fmt.Sprintf("failed to update entry tag for entry %d : %v", e.id, err))
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit migration : %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package dbapi
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// createSchema31SqliteDB creates a database with schema version 3.1, containing one lexicon with two entries
+func createSchema31SqliteDB(t *testing.T, dbRef lex.DBRef) {
+	dbPath := string(dbRef) + ".db"
+	for _, f := range []string{dbPath, dbPath + "-shm", dbPath + "-wal"} {
+		err := os.Remove(f)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("failed to remove db file : %v", err)
+		}
+	}
+	schema, err := os.ReadFile("./test_data/schema_3.1_sqlite.sql")
+	if err != nil {
+		t.Fatalf("failed to read schema : %v", err)
+	}
+	db, err := sql.Open("sqlite3_with_regexp", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db : %v", err)
+	}
+	defer db.Close()
+	for _, s := range []string{
+		string(schema),
+		"INSERT INTO Lexicon (id, name, symbolSetName, locale) VALUES (1, 'lex1', 'sv-se_ws-sampa', 'sv_SE')",
+		"INSERT INTO Entry (id, lexiconId, strn, language, wordParts, label, partOfSpeech, morphology) VALUES (1, 1, 'Stockholm', 'sv-se', 'Stockholm', '', '', '')",
+		"INSERT INTO Entry (id, lexiconId, strn, language, wordParts, label, partOfSpeech, morphology) VALUES (2, 1, 'stad', 'sv-se', 'stad', '', '', '')",
+		"INSERT INTO Transcription (entryId, strn, language, sources, label) VALUES (1, '\" s t O k . h O l m', 'sv-se', '', '')",
+		"INSERT INTO Transcription (entryId, strn, language, sources, label) VALUES (2, '\" s t A: d', 'sv-se', '', '')",
+		"INSERT INTO EntryStatus (entryId, name, source) VALUES (1, 'imported', 'test')",
+		"INSERT INTO EntryStatus (entryId, name, source) VALUES (2, 'imported', 'test')",
+	} {
+		_, err = db.Exec(s)
+		if err != nil {
+			t.Fatalf("failed to create 3.1 db : %v", err)
+		}
+	}
+}
+
+func TestMigrateFromSchema31Sqlite(t *testing.T) {
+	dbRef := lex.DBRef("migration31_test")
+	createSchema31SqliteDB(t, dbRef)
+	dbm := NewSqliteDBManager()
+	err := dbm.OpenDB(".", dbRef)
+	if err != nil {
+		t.Fatalf("failed to open db : %v", err)
+	}
+	defer dbm.CloseDB(dbRef)
+
+	report, err := dbm.Migrate(dbRef)
+	if err != nil {
+		t.Fatalf("migration failed : %v", err)
+	}
+	if !report.Migrated || report.FromVersion != "3.1" || report.ToVersion != SchemaVersion {
+		t.Errorf("unexpected migration report : %#v", report)
+	}
+
+	// 3.3: the existing lexicon has a meta data row, and new lexicons can be defined
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	err = dbm.SetLexiconMeta(lexRef, LexiconMeta{Description: "migrated"})
+	if err != nil {
+		t.Fatalf("failed to set lexicon meta : %v", err)
+	}
+	meta, err := dbm.GetLexiconMeta(lexRef)
+	if err != nil {
+		t.Fatalf("failed to get lexicon meta : %v", err)
+	}
+	if meta.Description != "migrated" {
+		t.Errorf("expected description 'migrated', got '%s'", meta.Description)
+	}
+	err = dbm.DefineLexicon(lex.NewLexRef(string(dbRef), "lex2"), "sv-se_ws-sampa", "sv_SE")
+	if err != nil {
+		t.Fatalf("failed to define lexicon after migration : %v", err)
+	}
+
+	// 3.2: entry relations
+	_, err = dbm.InsertEntryRelation(dbRef, lex.EntryRelation{Type: "compound_part", FromEntryID: 1, ToEntryID: 2})
+	if err != nil {
+		t.Fatalf("failed to insert entry relation : %v", err)
+	}
+	rels, err := dbm.ListEntryRelations(dbRef, 1)
+	if err != nil {
+		t.Fatalf("failed to list entry relations : %v", err)
+	}
+	if len(rels) != 1 {
+		t.Errorf("expected 1 entry relation, got %d", len(rels))
+	}
+
+	// 3.4: usage
+	err = dbm.AddUsage(dbRef, map[int64]int64{1: 3}, map[lex.LexName]map[string]int64{"lex1": {"uppsala": 2}})
+	if err != nil {
+		t.Fatalf("failed to add usage : %v", err)
+	}
+	usage, err := dbm.UsageReport(lexRef, 10)
+	if err != nil {
+		t.Fatalf("failed to get usage report : %v", err)
+	}
+	if len(usage.TopHits) != 1 || len(usage.TopMisses) != 1 {
+		t.Errorf("unexpected usage report : %#v", usage)
+	}
+
+	// 3.5: lookup columns
+	var w lex.EntrySliceWriter
+	err = dbm.LookUp(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{Words: []string{"STOCKHOLM"}, IgnoreCase: true}}, &w)
+	if err != nil {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	if len(w.Entries) != 1 {
+		t.Errorf("expected 1 entry after migration, got %d", len(w.Entries))
+	}
+}
+
+// newerSchemaVersion returns a schema version with the same major version as SchemaVersion, but a newer minor version
+func newerSchemaVersion(t *testing.T) string {
+	fs := strings.SplitN(SchemaVersion, ".", 2)
+	minor, err := strconv.Atoi(fs[1])
+	if err != nil {
+		t.Fatalf("invalid schema version %s : %v", SchemaVersion, err)
+	}
+	return fmt.Sprintf("%s.%d", fs[0], minor+1)
+}
+
+func TestMigrateNewerSchemaSqlite(t *testing.T) {
+	dbRef := lex.DBRef("migration_newer_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	newer := newerSchemaVersion(t)
+	_, err := dbm.dbs[dbRef].Exec("UPDATE SchemaVersion SET name = ?", newer)
+	if err != nil {
+		t.Fatalf("failed to update schema version : %v", err)
+	}
+	_, err = dbm.Migrate(dbRef)
+	if err == nil {
+		t.Errorf("expected error when migrating a db with a newer schema version")
+	}
+	version, err := dbm.GetSchemaVersion(dbRef)
+	if err != nil {
+		t.Fatalf("failed to get schema version : %v", err)
+	}
+	if version != newer {
+		t.Errorf("expected schema version %s to be kept, got %s", newer, version)
+	}
+}
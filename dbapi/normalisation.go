@@ -0,0 +1,76 @@
+package dbapi
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// Orthographic strings are stored in Unicode normalisation form NFC, so that, e.g., "café" typed with a combining accent (NFD) and with a precomposed é are the same word.
+// In addition to Entry.strn, each entry has two indexed lookup columns: Entry.strnFold (Unicode case folded) and Entry.strnBase (case folded, with diacritics removed).
+// These are used for case-insensitive and diacritic-insensitive lookup (see Query.IgnoreCase and Query.IgnoreDiacritics).
+
+// NormaliseWord returns the form of an orthographic word as stored in Entry.strn: NFC normalised and lower-cased
+func NormaliseWord(s string) string {
+	return strings.ToLower(norm.NFC.String(s))
+}
+
+// FoldCase returns the form of a word used for case-insensitive lookup: NFC normalised and Unicode case folded (e.g., "Straße" and "STRASSE" are both folded to "strasse")
+func FoldCase(s string) string {
+	// cases.Caser is not safe for concurrent use, so a new one is created for each call
+	return norm.NFC.String(cases.Fold().String(norm.NFC.String(s)))
+}
+
+// RemoveDiacritics returns the form of a word used for diacritic-insensitive lookup: case folded (see FoldCase), with all combining marks removed (e.g., "Café" and "cafe" are both folded to "cafe"). Letters that have no decomposition in Unicode, such as 'ø' and 'æ', are not changed.
+func RemoveDiacritics(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	res, _, err := transform.String(t, FoldCase(s))
+	if err != nil {
+		// shouldn't happen, since the transformers never fail on valid input
+		return FoldCase(s)
+	}
+	return res
+}
+
+// WordKey returns the form of a word that is compared against the database when looking up Query.Words, depending on the lookup mode (exact match, IgnoreCase or IgnoreDiacritics)
+func (q Query) WordKey(w string) string {
+	switch {
+	case q.IgnoreDiacritics:
+		return RemoveDiacritics(w)
+	case q.IgnoreCase:
+		return FoldCase(w)
+	}
+	return NormaliseWord(w)
+}
+
+// wordColumn returns the Entry column to match Query.Words and Query.WordLike against, depending on the lookup mode
+func (q Query) wordColumn() string {
+	switch {
+	case q.IgnoreDiacritics:
+		return "Entry.strnBase"
+	case q.IgnoreCase:
+		return "Entry.strnFold"
+	}
+	return "Entry.strn"
+}
+
+// normaliseEntry NFC normalises the orthographic fields of an entry before it is saved
+func normaliseEntry(e lex.Entry) lex.Entry {
+	e.Strn = norm.NFC.String(e.Strn)
+	e.WordParts = norm.NFC.String(e.WordParts)
+	return e
+}
+
+func normaliseEntries(es []lex.Entry) []lex.Entry {
+	res := make([]lex.Entry, len(es))
+	for i, e := range es {
+		res[i] = normaliseEntry(e)
+	}
+	return res
+}
@@ -0,0 +1,181 @@
+package dbapi
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestNormalisationFunctions(t *testing.T) {
+	nfd := "Cafe\u0301"
+	for _, tc := range []struct {
+		f          func(string) string
+		in, expect string
+	}{
+		{NormaliseWord, nfd, "café"},
+		{FoldCase, "STRASSE", "strasse"},
+		{FoldCase, "Straße", "strasse"},
+		{FoldCase, nfd, "café"},
+		{RemoveDiacritics, nfd, "cafe"},
+		{RemoveDiacritics, "Ångström", "angstrom"},
+		{RemoveDiacritics, "Ørsted", "ørsted"},
+	} {
+		if res := tc.f(tc.in); res != tc.expect {
+			t.Errorf("expected '%s' for '%s', got '%s'", tc.expect, tc.in, res)
+		}
+	}
+}
+
+func TestNormalisedLookupSqlite(t *testing.T) {
+	dbRef := lex.DBRef("normalisation_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	_, err := dbm.InsertEntries(lexRef, []lex.Entry{
+		newTestEntry("Cafe\u0301", `k a . "f e:`), // NFD
+		newTestEntry("cafe", `" k a . f e`),
+		newTestEntry("straße", `" s t r a: . s @`),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	lookUp := func(q Query) []string {
+		var w lex.EntrySliceWriter
+		err := dbm.LookUp(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: q}, &w)
+		if err != nil {
+			t.Fatalf("lookup failed : %v", err)
+		}
+		res := []string{}
+		for _, e := range w.Entries {
+			res = append(res, e.Strn)
+		}
+		sort.Strings(res)
+		return res
+	}
+
+	for _, tc := range []struct {
+		q      Query
+		expect []string
+	}{
+		// NFC input matches the NFD entry, which is stored as NFC
+		{Query{Words: []string{"café"}}, []string{"café"}},
+		{Query{Words: []string{"Cafe\u0301"}}, []string{"café"}},
+		{Query{Words: []string{"STRASSE"}}, []string{}},
+		{Query{Words: []string{"STRASSE"}, IgnoreCase: true}, []string{"straße"}},
+		{Query{Words: []string{"cafe"}, IgnoreCase: true}, []string{"cafe"}},
+		{Query{Words: []string{"CAFE"}, IgnoreDiacritics: true}, []string{"cafe", "café"}},
+		{Query{WordLike: "caf%", IgnoreDiacritics: true}, []string{"cafe", "café"}},
+		{Query{WordLike: "cafe%"}, []string{"cafe"}},
+	} {
+		res := lookUp(tc.q)
+		if len(res) != len(tc.expect) {
+			t.Errorf("expected %v for %#v, got %v", tc.expect, tc.q, res)
+			continue
+		}
+		for i := range res {
+			if res[i] != tc.expect[i] {
+				t.Errorf("expected %v for %#v, got %v", tc.expect, tc.q, res)
+				break
+			}
+		}
+	}
+}
+
+func TestMigrateSqlite(t *testing.T) {
+	dbRef := lex.DBRef("migration_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	ids, err := dbm.InsertEntries(lexRef, []lex.Entry{
+		newTestEntry("café", `k a . "f e:`),
+		newTestEntry("café", `" k a . f e`),
+		newTestEntry("hund", `" h u0 n d`),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	// Simulate a database from schema version 3.4: no lookup columns, and an entry stored as NFD
+	db := dbm.dbs[dbRef]
+	for _, s := range []string{
+		"DROP INDEX entrystrnfold",
+		"DROP INDEX entrystrnbase",
+		"ALTER TABLE Entry DROP COLUMN strnFold",
+		"ALTER TABLE Entry DROP COLUMN strnBase",
+		"DROP TABLE ChangeEvent",
+		"DROP TABLE ReplicationState",
+		"DROP TABLE EntryProposal",
+		"DROP TABLE Assignment",
+		"DROP TABLE ImportBatchEntry",
+		"DROP TABLE ImportBatch",
+		"DROP TABLE FrozenLexicon",
+		"UPDATE SchemaVersion SET name = '3.4'",
+	} {
+		_, err = db.Exec(s)
+		if err != nil {
+			t.Fatalf("failed to exec '%s' : %v", s, err)
+		}
+	}
+	_, err = db.Exec("UPDATE Entry SET strn = ? WHERE id = ?", "cafe\u0301", ids[0])
+	if err != nil {
+		t.Fatalf("failed to update entry : %v", err)
+	}
+	_, err = db.Exec("INSERT INTO EntryComment (entryId, label, source, comment) VALUES (?, 'assign_to', 'anna', 'bertil')", ids[2])
+	if err != nil {
+		t.Fatalf("failed to insert comment : %v", err)
+	}
+
+	report, err := dbm.Migrate(dbRef)
+	if err != nil {
+		t.Fatalf("migration failed : %v", err)
+	}
+	if !report.Migrated || report.FromVersion != "3.4" || report.ToVersion != SchemaVersion {
+		t.Errorf("unexpected migration report : %#v", report)
+	}
+	if report.NormalisedEntries != 1 {
+		t.Errorf("expected 1 normalised entry, got %d", report.NormalisedEntries)
+	}
+	if report.ConvertedAssignComments != 1 {
+		t.Errorf("expected 1 converted assign comment, got %d", report.ConvertedAssignComments)
+	}
+	if len(report.Collisions) != 1 || report.Collisions[0].Strn != "café" || len(report.Collisions[0].Variants) != 2 || len(report.Collisions[0].EntryIDs) != 2 {
+		t.Errorf("unexpected collisions : %#v", report.Collisions)
+	}
+
+	version, err := dbm.GetSchemaVersion(dbRef)
+	if err != nil {
+		t.Fatalf("failed to get schema version : %v", err)
+	}
+	if version != SchemaVersion {
+		t.Errorf("expected schema version %s, got %s", SchemaVersion, version)
+	}
+
+	var w lex.EntrySliceWriter
+	err = dbm.LookUp(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{Words: []string{"CAFE"}, IgnoreDiacritics: true}}, &w)
+	if err != nil {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	if len(w.Entries) != 2 {
+		t.Errorf("expected 2 entries after migration, got %d", len(w.Entries))
+	}
+
+	_, err = dbm.ListChanges(dbRef, 0, nil, 0)
+	if err != nil {
+		t.Errorf("expected change event table after migration : %v", err)
+	}
+
+	// A second migration does nothing
+	report, err = dbm.Migrate(dbRef)
+	if err != nil {
+		t.Fatalf("migration failed : %v", err)
+	}
+	if report.Migrated {
+		t.Errorf("expected no migration for an up to date db")
+	}
+}
@@ -0,0 +1,457 @@
+package dbapi
+
+// Proposals are entry updates suggested by one user and reviewed by another before they are applied to the lexicon.
+// A proposal stores the entry as it was when the proposal was made (the base), and the proposed entry. The difference between the two is shown to the reviewer (see Proposal.Diff).
+// If the entry has been changed since the proposal was made, the proposal is stale, and cannot be approved until it has been amended (rebased on the current entry).
+// Proposals are stored in the EntryProposal table of each database. They are local to the database, and not replicated (see ApplyChanges); an approved proposal is replicated as an ordinary entry update.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// ProposalStatus is the review status of a Proposal
+type ProposalStatus string
+
+const (
+	// ProposalPending is used for a proposal that has not yet been reviewed
+	ProposalPending ProposalStatus = "pending"
+	// ProposalApproved is used for a proposal that has been applied to the lexicon
+	ProposalApproved ProposalStatus = "approved"
+	// ProposalRejected is used for a proposal that was rejected by a reviewer
+	ProposalRejected ProposalStatus = "rejected"
+)
+
+// ProposalChange is a field of an entry that is changed by a proposal. Old and New hold the values before and after the change, with the same JSON representation as in lex.Entry.
+type ProposalChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// Proposal is a proposed update of an entry, pending review
+type Proposal struct {
+	ID            int64          `json:"id"`
+	LexRef        lex.LexRef     `json:"lexRef"`
+	EntryID       int64          `json:"entryId"`
+	Status        ProposalStatus `json:"status"`
+	Proposer      string         `json:"proposer"`
+	Comment       string         `json:"comment,omitempty"`
+	Reviewer      string         `json:"reviewer,omitempty"`
+	ReviewComment string         `json:"reviewComment,omitempty"`
+	Created       string         `json:"created"`
+	Modified      string         `json:"modified"`
+
+	// Base is the entry when the proposal was made (or last amended)
+	Base lex.Entry `json:"base"`
+	// Proposed is the proposed entry
+	Proposed lex.Entry `json:"proposed"`
+	// Diff lists the fields changed by the proposal
+	Diff []ProposalChange `json:"diff"`
+	// Stale is true for a pending proposal if the entry has been changed, or has got a new entry status, since the proposal was made
+	Stale bool `json:"stale,omitempty"`
+}
+
+// ProposalConflictError is returned when approving a proposal whose base entry has been changed since the proposal was made. The proposal must be amended before it can be approved.
+type ProposalConflictError struct {
+	Proposal Proposal
+	// Changes are the changes made to the entry since the proposal was made
+	Changes []ProposalChange
+}
+
+func (e *ProposalConflictError) Error() string {
+	fields := []string{}
+	for _, c := range e.Changes {
+		fields = append(fields, c.Field)
+	}
+	return fmt.Sprintf("entry %d has been changed since proposal %d was made (changed fields: %s)", e.Proposal.EntryID, e.Proposal.ID, strings.Join(fields, ", "))
+}
+
+// proposalFields returns the reviewable fields of an entry, in display order. IDs and other values assigned by the database are left out, so that two entries with the same content have the same fields.
+func proposalFields(e lex.Entry) []ProposalChange {
+	lemma := e.Lemma
+	lemma.ID = 0
+	ts := []lex.Transcription{}
+	for _, t := range e.Transcriptions {
+		t = lex.Transcription{Strn: t.Strn, Language: t.Language, Sources: t.Sources}
+		if len(t.Sources) == 0 {
+			t.Sources = nil
+		}
+		ts = append(ts, t)
+	}
+	cs := []lex.EntryComment{}
+	for _, c := range e.Comments {
+		cs = append(cs, lex.EntryComment{Source: c.Source, Label: c.Label, Comment: c.Comment})
+	}
+	return []ProposalChange{
+		{Field: "strn", New: NormaliseWord(e.Strn)},
+		{Field: "language", New: e.Language},
+		{Field: "partOfSpeech", New: e.PartOfSpeech},
+		{Field: "morphology", New: e.Morphology},
+		{Field: "wordParts", New: normaliseEntry(e).WordParts},
+		{Field: "lemma", New: lemma},
+		{Field: "transcriptions", New: ts},
+		{Field: "tag", New: e.Tag},
+		{Field: "comments", New: cs},
+		{Field: "preferred", New: e.Preferred},
+	}
+}
+
+// diffEntries lists the reviewable fields that differ between two entries
+func diffEntries(from, to lex.Entry) []ProposalChange {
+	res := []ProposalChange{}
+	fs, ts := proposalFields(from), proposalFields(to)
+	for i, f := range fs {
+		if !reflect.DeepEqual(f.New, ts[i].New) {
+			res = append(res, ProposalChange{Field: f.Field, Old: f.New, New: ts[i].New})
+		}
+	}
+	return res
+}
+
+// entryChanges lists the changes made to an entry since the base entry of a proposal was read. In addition to the reviewable fields (see diffEntries), a new entry status counts as a change, like in entryFingerprint.
+func entryChanges(base, current lex.Entry) []ProposalChange {
+	res := diffEntries(base, current)
+	if base.EntryStatus.ID != current.EntryStatus.ID {
+		status := func(s lex.EntryStatus) lex.EntryStatus { return lex.EntryStatus{Name: s.Name, Source: s.Source} }
+		res = append(res, ProposalChange{Field: "entryStatus", Old: status(base.EntryStatus), New: status(current.EntryStatus)})
+	}
+	return res
+}
+
+// proposalEntry prepares an entry for storage in a proposal
+func proposalEntry(e lex.Entry, entryID int64, lexRef lex.LexRef) lex.Entry {
+	e = normaliseEntry(e)
+	e.ID = entryID
+	e.LexRef = lexRef
+	e.Lock = nil
+	e.Relations = nil
+	return e
+}
+
+// lookUpEntry returns the entry with the id in the lexicon, and false if there is no such entry
+func (dbm *DBManager) lookUpEntry(db *sql.DB, lexRef lex.LexRef, entryID int64) (lex.Entry, bool, error) {
+	var w lex.EntrySliceWriter
+	err := dbm.dbifOf(db).lookUp(db, []lex.LexName{lexRef.LexName}, Query{EntryIDs: []int64{entryID}}, &w)
+	if err != nil {
+		return lex.Entry{}, false, err
+	}
+	if len(w.Entries) != 1 {
+		return lex.Entry{}, false, nil
+	}
+	return w.Entries[0], true, nil
+}
+
+const proposalColumns = "EntryProposal.id, EntryProposal.entryId, EntryProposal.status, EntryProposal.proposer, EntryProposal.comment, EntryProposal.reviewer, EntryProposal.reviewComment, EntryProposal.created, EntryProposal.modified, EntryProposal.base, EntryProposal.proposed"
+
+// listProposals lists the proposals of a lexicon matching the SQL condition (may be empty), ordered by id. Diff is set, but not Stale.
+func listProposals(db *sql.DB, lexRef lex.LexRef, cond string, args ...interface{}) ([]Proposal, error) {
+	res := []Proposal{}
+	q := "SELECT " + proposalColumns + " FROM EntryProposal, Entry, Lexicon WHERE EntryProposal.entryId = Entry.id AND Entry.lexiconId = Lexicon.id AND Lexicon.name = ?"
+	if cond != "" {
+		q += " AND " + cond
+	}
+	rows, err := db.Query(q+" ORDER BY EntryProposal.id", append([]interface{}{string(lexRef.LexName)}, args...)...)
+	if err != nil {
+		return res, fmt.Errorf("failed to list proposals : %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Proposal{LexRef: lexRef}
+		var base, proposed string
+		err = rows.Scan(&p.ID, &p.EntryID, &p.Status, &p.Proposer, &p.Comment, &p.Reviewer, &p.ReviewComment, &p.Created, &p.Modified, &base, &proposed)
+		if err != nil {
+			return res, fmt.Errorf("failed to scan proposal : %v", err)
+		}
+		err = json.Unmarshal([]byte(base), &p.Base)
+		if err != nil {
+			return res, fmt.Errorf("failed to unmarshal base entry of proposal %d : %v", p.ID, err)
+		}
+		err = json.Unmarshal([]byte(proposed), &p.Proposed)
+		if err != nil {
+			return res, fmt.Errorf("failed to unmarshal proposed entry of proposal %d : %v", p.ID, err)
+		}
+		p.Diff = diffEntries(p.Base, p.Proposed)
+		res = append(res, p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return res, fmt.Errorf("failed to list proposals : %v", err)
+	}
+	return res, nil
+}
+
+// getProposal returns the proposal with the id in the lexicon, with Stale set
+func (dbm *DBManager) getProposal(db *sql.DB, lexRef lex.LexRef, id int64) (Proposal, error) {
+	ps, err := listProposals(db, lexRef, "EntryProposal.id = ?", id)
+	if err != nil {
+		return Proposal{}, err
+	}
+	if len(ps) != 1 {
+		return Proposal{}, fmt.Errorf("no proposal with id %d in lexicon '%s'", id, lexRef)
+	}
+	err = dbm.setStale(db, lexRef, ps)
+	if err != nil {
+		return Proposal{}, err
+	}
+	return ps[0], nil
+}
+
+// setStale sets the Stale flag of pending proposals whose entries have been changed since the proposals were made
+func (dbm *DBManager) setStale(db *sql.DB, lexRef lex.LexRef, ps []Proposal) error {
+	ids := []int64{}
+	for _, p := range ps {
+		if p.Status == ProposalPending {
+			ids = append(ids, p.EntryID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	var w lex.EntrySliceWriter
+	err := dbm.dbifOf(db).lookUp(db, []lex.LexName{lexRef.LexName}, Query{EntryIDs: ids}, &w)
+	if err != nil {
+		return err
+	}
+	current := make(map[int64]lex.Entry)
+	for _, e := range w.Entries {
+		current[e.ID] = e
+	}
+	for i, p := range ps {
+		if p.Status == ProposalPending {
+			ps[i].Stale = len(entryChanges(p.Base, current[p.EntryID])) > 0
+		}
+	}
+	return nil
+}
+
+// ProposeUpdate stores an update of an existing entry as a pending proposal, to be reviewed (see ApproveProposal and RejectProposal). The entry is identified by e.ID and e.LexRef. The entry status of e is ignored, since it is set on approval. Returns an error if the proposed entry doesn't differ from the current entry.
+func (dbm *DBManager) ProposeUpdate(e lex.Entry, proposer, comment string) (Proposal, error) {
+	proposer = strings.TrimSpace(proposer)
+	if proposer == "" {
+		return Proposal{}, fmt.Errorf("DBManager.ProposeUpdate: no proposer")
+	}
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[e.LexRef.DBRef]
+	if !ok {
+		return Proposal{}, fmt.Errorf("DBManager.ProposeUpdate: no such db '%s'", e.LexRef.DBRef)
+	}
+	base, ok, err := dbm.lookUpEntry(db, e.LexRef, e.ID)
+	if err != nil {
+		return Proposal{}, fmt.Errorf("DBManager.ProposeUpdate: %v", err)
+	}
+	if !ok {
+		return Proposal{}, fmt.Errorf("DBManager.ProposeUpdate: no entry with id %d in lexicon '%s'", e.ID, e.LexRef)
+	}
+	base = proposalEntry(base, e.ID, e.LexRef)
+	proposed := proposalEntry(e, e.ID, e.LexRef)
+	if len(diffEntries(base, proposed)) == 0 {
+		return Proposal{}, fmt.Errorf("DBManager.ProposeUpdate: the proposal doesn't change entry %d", e.ID)
+	}
+	baseJSON, err := json.Marshal(base)
+	if err != nil {
+		return Proposal{}, fmt.Errorf("DBManager.ProposeUpdate: failed marshalling : %v", err)
+	}
+	proposedJSON, err := json.Marshal(proposed)
+	if err != nil {
+		return Proposal{}, fmt.Errorf("DBManager.ProposeUpdate: failed marshalling : %v", err)
+	}
+	ts := time.Now().UTC().Format(time.RFC3339)
+	res, err := db.Exec("INSERT INTO EntryProposal (entryId, status, proposer, comment, base, proposed, created, modified) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", e.ID, ProposalPending, proposer, strings.TrimSpace(comment), string(baseJSON), string(proposedJSON), ts, ts)
+	if err != nil {
+		return Proposal{}, fmt.Errorf("DBManager.ProposeUpdate: failed to insert proposal : %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Proposal{}, fmt.Errorf("DBManager.ProposeUpdate: failed to get proposal id : %v", err)
+	}
+	p, err := dbm.getProposal(db, e.LexRef, id)
+	if err != nil {
+		return p, fmt.Errorf("DBManager.ProposeUpdate: %v", err)
+	}
+	return p, nil
+}
+
+// ListProposals returns the review queue of a lexicon: the proposals with the status (all proposals if status is empty), ordered by id
+func (dbm *DBManager) ListProposals(lexRef lex.LexRef, status ProposalStatus) ([]Proposal, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return []Proposal{}, fmt.Errorf("DBManager.ListProposals: no such db '%s'", lexRef.DBRef)
+	}
+	var ps []Proposal
+	var err error
+	if status == "" {
+		ps, err = listProposals(db, lexRef, "")
+	} else {
+		ps, err = listProposals(db, lexRef, "EntryProposal.status = ?", status)
+	}
+	if err != nil {
+		return ps, fmt.Errorf("DBManager.ListProposals: %v", err)
+	}
+	err = dbm.setStale(db, lexRef, ps)
+	if err != nil {
+		return ps, fmt.Errorf("DBManager.ListProposals: %v", err)
+	}
+	return ps, nil
+}
+
+// GetProposal returns the proposal with the id in the lexicon
+func (dbm *DBManager) GetProposal(lexRef lex.LexRef, id int64) (Proposal, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return Proposal{}, fmt.Errorf("DBManager.GetProposal: no such db '%s'", lexRef.DBRef)
+	}
+	p, err := dbm.getProposal(db, lexRef, id)
+	if err != nil {
+		return p, fmt.Errorf("DBManager.GetProposal: %v", err)
+	}
+	return p, nil
+}
+
+// pendingProposal returns the pending proposal with the id in the lexicon
+func (dbm *DBManager) pendingProposal(db *sql.DB, lexRef lex.LexRef, id int64) (Proposal, error) {
+	p, err := dbm.getProposal(db, lexRef, id)
+	if err != nil {
+		return p, err
+	}
+	if p.Status != ProposalPending {
+		return p, fmt.Errorf("proposal %d is already %s", id, p.Status)
+	}
+	return p, nil
+}
+
+// AmendProposal replaces the proposed entry of a pending proposal, and rebases the proposal on the current entry. This is also how a stale proposal is brought up to date. If comment is not empty, it replaces the proposal comment.
+func (dbm *DBManager) AmendProposal(lexRef lex.LexRef, id int64, e lex.Entry, comment string) (Proposal, error) {
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return Proposal{}, fmt.Errorf("DBManager.AmendProposal: no such db '%s'", lexRef.DBRef)
+	}
+	p, err := dbm.pendingProposal(db, lexRef, id)
+	if err != nil {
+		return p, fmt.Errorf("DBManager.AmendProposal: %v", err)
+	}
+	base, ok, err := dbm.lookUpEntry(db, lexRef, p.EntryID)
+	if err != nil {
+		return p, fmt.Errorf("DBManager.AmendProposal: %v", err)
+	}
+	if !ok {
+		return p, fmt.Errorf("DBManager.AmendProposal: no entry with id %d in lexicon '%s'", p.EntryID, lexRef)
+	}
+	base = proposalEntry(base, p.EntryID, lexRef)
+	proposed := proposalEntry(e, p.EntryID, lexRef)
+	if len(diffEntries(base, proposed)) == 0 {
+		return p, fmt.Errorf("DBManager.AmendProposal: the proposal doesn't change entry %d", p.EntryID)
+	}
+	if c := strings.TrimSpace(comment); c != "" {
+		p.Comment = c
+	}
+	baseJSON, err := json.Marshal(base)
+	if err != nil {
+		return p, fmt.Errorf("DBManager.AmendProposal: failed marshalling : %v", err)
+	}
+	proposedJSON, err := json.Marshal(proposed)
+	if err != nil {
+		return p, fmt.Errorf("DBManager.AmendProposal: failed marshalling : %v", err)
+	}
+	_, err = db.Exec("UPDATE EntryProposal SET base = ?, proposed = ?, comment = ?, modified = ? WHERE id = ?", string(baseJSON), string(proposedJSON), p.Comment, time.Now().UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return p, fmt.Errorf("DBManager.AmendProposal: failed to update proposal : %v", err)
+	}
+	p, err = dbm.getProposal(db, lexRef, id)
+	if err != nil {
+		return p, fmt.Errorf("DBManager.AmendProposal: %v", err)
+	}
+	return p, nil
+}
+
+// ApproveProposal applies a pending proposal to the lexicon, as an entry update (see UpdateEntryAs) with the entry status, on behalf of the reviewer. If status.Source is empty, the reviewer is used as source.
+// Returns a *ProposalConflictError if the entry has been changed since the proposal was made, and an *EntryLockedError if the entry is locked by someone other than the reviewer.
+func (dbm *DBManager) ApproveProposal(lexRef lex.LexRef, id int64, reviewer string, status lex.EntryStatus, comment string) (Proposal, error) {
+	reviewer = strings.TrimSpace(reviewer)
+	if reviewer == "" {
+		return Proposal{}, fmt.Errorf("DBManager.ApproveProposal: no reviewer")
+	}
+	if strings.TrimSpace(status.Name) == "" {
+		return Proposal{}, fmt.Errorf("DBManager.ApproveProposal: no entry status")
+	}
+	if strings.TrimSpace(status.Source) == "" {
+		status.Source = reviewer
+	}
+
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return Proposal{}, fmt.Errorf("DBManager.ApproveProposal: no such db '%s'", lexRef.DBRef)
+	}
+	p, err := dbm.pendingProposal(db, lexRef, id)
+	if err != nil {
+		return p, fmt.Errorf("DBManager.ApproveProposal: %v", err)
+	}
+	current, ok, err := dbm.lookUpEntry(db, lexRef, p.EntryID)
+	if err != nil {
+		return p, fmt.Errorf("DBManager.ApproveProposal: %v", err)
+	}
+	if !ok {
+		return p, fmt.Errorf("DBManager.ApproveProposal: no entry with id %d in lexicon '%s'", p.EntryID, lexRef)
+	}
+	if changes := entryChanges(p.Base, current); len(changes) > 0 {
+		return p, &ProposalConflictError{Proposal: p, Changes: changes}
+	}
+
+	e := p.Proposed
+	e.EntryStatus = lex.EntryStatus{Name: status.Name, Source: status.Source}
+	_, _, err = dbm.updateEntryAs(db, e, reviewer)
+	if _, ok := err.(*EntryLockedError); ok {
+		return p, err
+	}
+	if err != nil {
+		return p, fmt.Errorf("DBManager.ApproveProposal: %w", err)
+	}
+	return dbm.reviewProposal(db, lexRef, p, ProposalApproved, reviewer, comment)
+}
+
+// RejectProposal rejects a pending proposal. A comment explaining the rejection is required.
+func (dbm *DBManager) RejectProposal(lexRef lex.LexRef, id int64, reviewer, comment string) (Proposal, error) {
+	reviewer = strings.TrimSpace(reviewer)
+	if reviewer == "" {
+		return Proposal{}, fmt.Errorf("DBManager.RejectProposal: no reviewer")
+	}
+	if strings.TrimSpace(comment) == "" {
+		return Proposal{}, fmt.Errorf("DBManager.RejectProposal: no comment")
+	}
+
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return Proposal{}, fmt.Errorf("DBManager.RejectProposal: no such db '%s'", lexRef.DBRef)
+	}
+	p, err := dbm.pendingProposal(db, lexRef, id)
+	if err != nil {
+		return p, fmt.Errorf("DBManager.RejectProposal: %v", err)
+	}
+	return dbm.reviewProposal(db, lexRef, p, ProposalRejected, reviewer, comment)
+}
+
+// reviewProposal sets the review status of a proposal
+func (dbm *DBManager) reviewProposal(db *sql.DB, lexRef lex.LexRef, p Proposal, status ProposalStatus, reviewer, comment string) (Proposal, error) {
+	_, err := db.Exec("UPDATE EntryProposal SET status = ?, reviewer = ?, reviewComment = ?, modified = ? WHERE id = ?", status, reviewer, strings.TrimSpace(comment), time.Now().UTC().Format(time.RFC3339), p.ID)
+	if err != nil {
+		return p, fmt.Errorf("failed to update proposal %d : %v", p.ID, err)
+	}
+	return dbm.getProposal(db, lexRef, p.ID)
+}
@@ -0,0 +1,196 @@
+package dbapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestProposalsSqlite(t *testing.T) {
+	dbRef := lex.DBRef("proposal_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	newEntry := func(strn, trans string) lex.Entry {
+		e := newTestEntry(strn, trans)
+		e.Transcriptions[0].Sources = []string{"test"}
+		return e
+	}
+	ids, err := dbm.InsertEntries(lexRef, []lex.Entry{newEntry("hund", `" h u0 n d`), newEntry("katt", `" k a t`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	hund, katt := ids[0], ids[1]
+	lookUp := func(id int64) lex.Entry {
+		t.Helper()
+		es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{EntryIDs: []int64{id}}})
+		if err != nil || len(es) != 1 {
+			t.Fatalf("lookup failed : %v", err)
+		}
+		return es[0]
+	}
+
+	// a proposal that doesn't change anything is refused
+	_, err = dbm.ProposeUpdate(lookUp(hund), "anna", "")
+	if err == nil {
+		t.Errorf("expected error for empty proposal")
+	}
+
+	e := lookUp(hund)
+	e.Transcriptions[0].Strn = `" h u0 n t`
+	e.PartOfSpeech = "NN"
+	p1, err := dbm.ProposeUpdate(e, "anna", "devoiced")
+	if err != nil {
+		t.Fatalf("failed to propose update : %v", err)
+	}
+	if p1.Status != ProposalPending || p1.Proposer != "anna" || p1.Stale {
+		t.Errorf("unexpected proposal : %#v", p1)
+	}
+	if len(p1.Diff) != 2 || p1.Diff[0].Field != "partOfSpeech" || p1.Diff[1].Field != "transcriptions" {
+		t.Errorf("unexpected diff : %#v", p1.Diff)
+	}
+	// the entry is not changed until the proposal is approved
+	if e := lookUp(hund); e.Transcriptions[0].Strn != `" h u0 n d` {
+		t.Errorf("expected unchanged entry, got %s", e.Transcriptions[0].Strn)
+	}
+
+	e = lookUp(katt)
+	e.Transcriptions[0].Strn = `" k a t:`
+	p2, err := dbm.ProposeUpdate(e, "bertil", "")
+	if err != nil {
+		t.Fatalf("failed to propose update : %v", err)
+	}
+	ps, err := dbm.ListProposals(lexRef, ProposalPending)
+	if err != nil || len(ps) != 2 || ps[0].ID != p1.ID || ps[1].ID != p2.ID {
+		t.Fatalf("expected two pending proposals, got %#v : %v", ps, err)
+	}
+
+	// a change made after the proposal makes it stale, and it can't be approved
+	e = lookUp(katt)
+	e.Transcriptions[0].Strn = `" k a t t`
+	_, _, err = dbm.UpdateEntry(e)
+	if err != nil {
+		t.Fatalf("failed to update entry : %v", err)
+	}
+	p2, err = dbm.GetProposal(lexRef, p2.ID)
+	if err != nil || !p2.Stale {
+		t.Errorf("expected stale proposal, got %#v : %v", p2, err)
+	}
+	_, err = dbm.ApproveProposal(lexRef, p2.ID, "cecilia", lex.EntryStatus{Name: "ok"}, "")
+	if _, ok := err.(*ProposalConflictError); !ok {
+		t.Errorf("expected ProposalConflictError, got %v", err)
+	}
+	// until it is amended
+	e = lookUp(katt)
+	e.Transcriptions[0].Strn = `" k a t:`
+	p2, err = dbm.AmendProposal(lexRef, p2.ID, e, "rebased")
+	if err != nil || p2.Stale || p2.Comment != "rebased" {
+		t.Fatalf("expected amended proposal, got %#v : %v", p2, err)
+	}
+	if len(p2.Diff) != 1 || p2.Diff[0].Old.([]lex.Transcription)[0].Strn != `" k a t t` {
+		t.Errorf("unexpected diff : %#v", p2.Diff)
+	}
+
+	// approval applies the proposal
+	p2, err = dbm.ApproveProposal(lexRef, p2.ID, "cecilia", lex.EntryStatus{Name: "ok"}, "fine")
+	if err != nil {
+		t.Fatalf("failed to approve proposal : %v", err)
+	}
+	if p2.Status != ProposalApproved || p2.Reviewer != "cecilia" || p2.ReviewComment != "fine" {
+		t.Errorf("unexpected proposal : %#v", p2)
+	}
+	e = lookUp(katt)
+	if e.Transcriptions[0].Strn != `" k a t:` || e.EntryStatus.Name != "ok" || e.EntryStatus.Source != "cecilia" {
+		t.Errorf("expected updated entry, got %#v", e)
+	}
+	_, err = dbm.ApproveProposal(lexRef, p2.ID, "cecilia", lex.EntryStatus{Name: "ok"}, "")
+	if err == nil {
+		t.Errorf("expected error for already approved proposal")
+	}
+
+	// locked entries can only be approved by the lock owner
+	_, err = dbm.LockEntry(lexRef, hund, "david", time.Minute)
+	if err != nil {
+		t.Fatalf("failed to lock entry : %v", err)
+	}
+	_, err = dbm.ApproveProposal(lexRef, p1.ID, "cecilia", lex.EntryStatus{Name: "ok"}, "")
+	if _, ok := err.(*EntryLockedError); !ok {
+		t.Errorf("expected EntryLockedError, got %v", err)
+	}
+
+	// rejection requires a comment
+	_, err = dbm.RejectProposal(lexRef, p1.ID, "cecilia", "")
+	if err == nil {
+		t.Errorf("expected error for rejection without comment")
+	}
+	p1, err = dbm.RejectProposal(lexRef, p1.ID, "cecilia", "not a noun")
+	if err != nil || p1.Status != ProposalRejected || p1.ReviewComment != "not a noun" {
+		t.Errorf("expected rejected proposal, got %#v : %v", p1, err)
+	}
+	if e := lookUp(hund); e.Transcriptions[0].Strn != `" h u0 n d` {
+		t.Errorf("expected unchanged entry, got %s", e.Transcriptions[0].Strn)
+	}
+	_, err = dbm.AmendProposal(lexRef, p1.ID, e, "")
+	if err == nil {
+		t.Errorf("expected error for amending rejected proposal")
+	}
+
+	ps, err = dbm.ListProposals(lexRef, ProposalPending)
+	if err != nil || len(ps) != 0 {
+		t.Errorf("expected no pending proposals, got %#v : %v", ps, err)
+	}
+	ps, err = dbm.ListProposals(lexRef, "")
+	if err != nil || len(ps) != 2 {
+		t.Errorf("expected two proposals, got %#v : %v", ps, err)
+	}
+}
+
+func TestProposalStaleStatusSqlite(t *testing.T) {
+	dbRef := lex.DBRef("proposal_status_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	ids, err := dbm.InsertEntries(lexRef, []lex.Entry{{Strn: "hund", Language: "sv-se", Transcriptions: []lex.Transcription{{Strn: `" h u0 n d`}}, EntryStatus: lex.EntryStatus{Name: "imported", Source: "test"}}})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	lookUp := func() lex.Entry {
+		t.Helper()
+		es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{EntryIDs: ids}})
+		if err != nil || len(es) != 1 {
+			t.Fatalf("lookup failed : %v", err)
+		}
+		return es[0]
+	}
+	e := lookUp()
+	e.PartOfSpeech = "NN"
+	p, err := dbm.ProposeUpdate(e, "anna", "")
+	if err != nil {
+		t.Fatalf("failed to propose update : %v", err)
+	}
+
+	// a new entry status, without other changes, makes the proposal stale
+	e = lookUp()
+	e.EntryStatus = lex.EntryStatus{Name: "skip", Source: "bertil"}
+	_, _, err = dbm.UpdateEntry(e)
+	if err != nil {
+		t.Fatalf("failed to update entry : %v", err)
+	}
+	p, err = dbm.GetProposal(lexRef, p.ID)
+	if err != nil || !p.Stale {
+		t.Errorf("expected stale proposal, got %#v : %v", p, err)
+	}
+	_, err = dbm.ApproveProposal(lexRef, p.ID, "cecilia", lex.EntryStatus{Name: "ok"}, "")
+	ce, ok := err.(*ProposalConflictError)
+	if !ok {
+		t.Fatalf("expected ProposalConflictError, got %v", err)
+	}
+	if len(ce.Changes) != 1 || ce.Changes[0].Field != "entryStatus" {
+		t.Errorf("unexpected changes : %#v", ce.Changes)
+	}
+}
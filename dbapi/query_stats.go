@@ -0,0 +1,156 @@
+package dbapi
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// QueryStats counts the entries matching the query, along with facet counts (by current status, status source, part of speech, language, validation level and rule, and comment label) for the matching entries. Paging is ignored. If the query spans several databases, the counts are summed.
+func (dbm *DBManager) QueryStats(q DBMQuery) (QueryStats, error) {
+	res := QueryStats{Query: q.Query}
+	if len(q.LexRefs) == 0 {
+		return res, fmt.Errorf("DBManager.QueryStats cannot perform a search without at least one lexicon specified (using the 'lexicons' parameter)")
+	}
+
+	dbz := make(map[lex.DBRef][]lex.LexName)
+	for _, l := range q.LexRefs {
+		dbz[l.DBRef] = append(dbz[l.DBRef], l.LexName)
+	}
+
+	dbm.RLock()
+	defer dbm.RUnlock()
+
+	var all []QueryStats
+	for dbRef, lexNames := range dbz {
+		db, ok := dbm.dbs[dbRef]
+		if !ok {
+			return res, fmt.Errorf("DBManager.QueryStats: no such db '%s'", dbRef)
+		}
+		stats, err := dbm.dbifOf(db).queryStats(db, lexNames, q.Query)
+		if err != nil {
+			return res, fmt.Errorf("DBManager.QueryStats failed for %v:%v : %v", dbRef, lexNames, err)
+		}
+		all = append(all, stats)
+	}
+	return mergeQueryStats(res, all), nil
+}
+
+// facetSQL is used to count the matching entries per facet value. Each statement is completed with the entry id select statement for the query (selectEntryIdsSQL) and a closing parenthesis, followed by the GROUP BY.
+var facetSQL = []struct {
+	name   string
+	sql    string
+	suffix string
+}{
+	{name: "status", sql: "SELECT EntryStatus.name, COUNT(DISTINCT EntryStatus.entryId) FROM EntryStatus WHERE EntryStatus.current = 1 AND EntryStatus.entryId IN (", suffix: ") GROUP BY EntryStatus.name"},
+	{name: "status source", sql: "SELECT EntryStatus.source, COUNT(DISTINCT EntryStatus.entryId) FROM EntryStatus WHERE EntryStatus.current = 1 AND EntryStatus.entryId IN (", suffix: ") GROUP BY EntryStatus.source"},
+	{name: "part of speech", sql: "SELECT Entry.partOfSpeech, COUNT(*) FROM Entry WHERE Entry.id IN (", suffix: ") GROUP BY Entry.partOfSpeech"},
+	{name: "language", sql: "SELECT Entry.language, COUNT(*) FROM Entry WHERE Entry.id IN (", suffix: ") GROUP BY Entry.language"},
+	{name: "validation level", sql: "SELECT EntryValidation.level, COUNT(DISTINCT EntryValidation.entryId) FROM EntryValidation WHERE EntryValidation.entryId IN (", suffix: ") GROUP BY EntryValidation.level"},
+	{name: "validation rule", sql: "SELECT EntryValidation.name, COUNT(DISTINCT EntryValidation.entryId) FROM EntryValidation WHERE EntryValidation.entryId IN (", suffix: ") GROUP BY EntryValidation.name"},
+	{name: "comment label", sql: "SELECT EntryComment.label, COUNT(DISTINCT EntryComment.entryId) FROM EntryComment WHERE EntryComment.entryId IN (", suffix: ") GROUP BY EntryComment.label"},
+}
+
+// The SQL for query stats is the same for Sqlite and MariaDB, so queryStatsTx is shared by both DBIF implementations
+
+func queryStatsTx(tx *sql.Tx, lexNames []lex.LexName, q Query) (QueryStats, error) {
+	res := QueryStats{Query: q}
+
+	// paging doesn't apply to stats
+	q.Page = 0
+	q.PageLength = 0
+
+	count := countEntriesSQL(lexNames, q)
+	err := tx.QueryRow(count.sql, count.values...).Scan(&res.Entries)
+	if err != nil {
+		return res, fmt.Errorf("queryStats failed to count entries : %v", err)
+	}
+
+	ids := selectEntryIdsSQL(lexNames, q)
+	facets := make([][]FacetCount, len(facetSQL))
+	for i, f := range facetSQL {
+		fs, err := facetCounts(tx, f.sql+ids.sql+f.suffix, ids.values)
+		if err != nil {
+			return res, fmt.Errorf("queryStats failed to count %s facet : %v", f.name, err)
+		}
+		facets[i] = fs
+	}
+	res.Statuses = facets[0]
+	res.StatusSources = facets[1]
+	res.PartsOfSpeech = facets[2]
+	res.Languages = facets[3]
+	res.ValidationLevels = facets[4]
+	res.ValidationRules = facets[5]
+	res.CommentLabels = facets[6]
+
+	return res, nil
+}
+
+func facetCounts(tx *sql.Tx, query string, values []interface{}) ([]FacetCount, error) {
+	res := []FacetCount{}
+	rows, err := tx.Query(query, values...)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value sql.NullString
+		var count int64
+		err = rows.Scan(&value, &count)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, FacetCount{Value: value.String, Count: count})
+	}
+	err = rows.Err()
+	if err != nil {
+		return res, err
+	}
+	sortFacet(res)
+	return res, nil
+}
+
+func sortFacet(f []FacetCount) {
+	sort.Slice(f, func(i, j int) bool {
+		if f[i].Count == f[j].Count {
+			return f[i].Value < f[j].Value
+		}
+		return f[i].Count > f[j].Count
+	})
+}
+
+// mergeQueryStats sums the counts of stats from different databases
+func mergeQueryStats(res QueryStats, stats []QueryStats) QueryStats {
+	if len(stats) == 1 {
+		s := stats[0]
+		s.Query = res.Query
+		return s
+	}
+	merge := func(get func(QueryStats) []FacetCount) []FacetCount {
+		counts := make(map[string]int64)
+		for _, s := range stats {
+			for _, f := range get(s) {
+				counts[f.Value] += f.Count
+			}
+		}
+		fs := []FacetCount{}
+		for v, c := range counts {
+			fs = append(fs, FacetCount{Value: v, Count: c})
+		}
+		sortFacet(fs)
+		return fs
+	}
+	for _, s := range stats {
+		res.Entries += s.Entries
+	}
+	res.Statuses = merge(func(s QueryStats) []FacetCount { return s.Statuses })
+	res.StatusSources = merge(func(s QueryStats) []FacetCount { return s.StatusSources })
+	res.PartsOfSpeech = merge(func(s QueryStats) []FacetCount { return s.PartsOfSpeech })
+	res.Languages = merge(func(s QueryStats) []FacetCount { return s.Languages })
+	res.ValidationLevels = merge(func(s QueryStats) []FacetCount { return s.ValidationLevels })
+	res.ValidationRules = merge(func(s QueryStats) []FacetCount { return s.ValidationRules })
+	res.CommentLabels = merge(func(s QueryStats) []FacetCount { return s.CommentLabels })
+	return res
+}
@@ -0,0 +1,81 @@
+package dbapi
+
+import (
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestQueryStatsSqlite(t *testing.T) {
+	dbRef := lex.DBRef("query_stats_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	newEntry := func(strn, pos, status, source string) lex.Entry {
+		return lex.Entry{Strn: strn,
+			Language:       "sv-se",
+			PartOfSpeech:   pos,
+			Transcriptions: []lex.Transcription{{Strn: `" A`}, {Strn: `" B`}},
+			EntryStatus:    lex.EntryStatus{Name: status, Source: source},
+		}
+	}
+	es := []lex.Entry{
+		newEntry("hund", "NN", "ok", "anna"),
+		newEntry("hundar", "NN", "imported", "nst"),
+		newEntry("hundig", "JJ", "imported", "nst"),
+		newEntry("katt", "NN", "imported", "nst"),
+	}
+	es[0].Comments = []lex.EntryComment{{Label: "check", Source: "anna", Comment: "1"}, {Label: "check", Source: "anna", Comment: "2"}}
+	es[1].EntryValidations = []lex.EntryValidation{{Level: "Fatal", RuleName: "Rule1", Message: "m1"}, {Level: "Warning", RuleName: "Rule2", Message: "m2"}}
+	_, err := dbm.InsertEntries(lexRef, es)
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	q := DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{WordLike: "hund%", PageLength: 1}}
+	res, err := dbm.QueryStats(q)
+	if err != nil {
+		t.Fatalf("query stats failed : %v", err)
+	}
+	if res.Entries != 3 {
+		t.Errorf("expected 3 entries, got %d", res.Entries)
+	}
+	assertFacet := func(name string, got []FacetCount, expect []FacetCount) {
+		t.Helper()
+		if len(got) != len(expect) {
+			t.Errorf("%s: expected %v, got %v", name, expect, got)
+			return
+		}
+		for i := range got {
+			if got[i] != expect[i] {
+				t.Errorf("%s: expected %v, got %v", name, expect, got)
+				return
+			}
+		}
+	}
+	assertFacet("statuses", res.Statuses, []FacetCount{{"imported", 2}, {"ok", 1}})
+	assertFacet("sources", res.StatusSources, []FacetCount{{"nst", 2}, {"anna", 1}})
+	assertFacet("pos", res.PartsOfSpeech, []FacetCount{{"NN", 2}, {"JJ", 1}})
+	assertFacet("languages", res.Languages, []FacetCount{{"sv-se", 3}})
+	assertFacet("validation levels", res.ValidationLevels, []FacetCount{{"fatal", 1}, {"warning", 1}})
+	assertFacet("validation rules", res.ValidationRules, []FacetCount{{"Rule1", 1}, {"Rule2", 1}})
+	assertFacet("comment labels", res.CommentLabels, []FacetCount{{"check", 1}})
+
+	q = DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{EntryStatus: []string{"imported"}}}
+	res, err = dbm.QueryStats(q)
+	if err != nil {
+		t.Fatalf("query stats failed : %v", err)
+	}
+	if res.Entries != 3 {
+		t.Errorf("expected 3 entries, got %d", res.Entries)
+	}
+	assertFacet("pos", res.PartsOfSpeech, []FacetCount{{"NN", 2}, {"JJ", 1}})
+	assertFacet("comment labels", res.CommentLabels, []FacetCount{})
+
+	_, err = dbm.QueryStats(DBMQuery{Query: Query{WordLike: "%"}})
+	if err == nil {
+		t.Errorf("expected error for query without lexicons")
+	}
+}
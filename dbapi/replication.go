@@ -0,0 +1,903 @@
+package dbapi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// Incremental replication copies the modifications of a (primary) database to another (replica) database, using the change feed of the primary (see changes.go).
+//
+// The primary exports a ChangeSet with the current contents of all entries modified after a sequence number (ExportChanges), and the replica applies it (ApplyChanges). Entries are identified by their entry id in the primary database, and are saved in the replica using the same ids, along with their full status history. The replica should therefore start as a copy of the primary (e.g., restored from a backup, see InitReplication), and should not be edited by other means than ApplyChanges, since its own entry ids would then clash with the primary's.
+//
+// Applying a change set is idempotent, so a change set may safely be applied again. The replica saves its position in the primary's change feed in the ReplicationState table, so that replication can resume from where it left off.
+// Entry usage statistics (see UsageRecorder) are local to each database, and are not replicated.
+
+// ReplicatedLexicon is the definition of a lexicon in a ChangeSet
+type ReplicatedLexicon struct {
+	Name          string `json:"name"`
+	SymbolSetName string `json:"symbolSetName,omitempty"`
+	Locale        string `json:"locale,omitempty"`
+	// Deleted is true if the lexicon has been deleted from the primary
+	Deleted bool `json:"deleted,omitempty"`
+	// Complete is true if the change set contains all entries of the lexicon, after a bulk modification (see ChangeLexicon). When the change set is applied, any other entries in the replica's lexicon are deleted.
+	Complete bool `json:"complete,omitempty"`
+}
+
+// ReplicatedEntry holds the database rows of an entry in a ChangeSet: the Entry row, and the related rows of the EntryStatus, Transcription, Lemma, etc, tables
+type ReplicatedEntry struct {
+	ID      int64  `json:"id"`
+	Lexicon string `json:"lexicon"`
+	// Rows maps a table name to the table rows of the entry (column name => value)
+	Rows map[string][]map[string]any `json:"rows"`
+}
+
+// ChangeSet holds the modifications of a database after a sequence number in its change feed (see ExportChanges and ApplyChanges)
+type ChangeSet struct {
+	DB            string `json:"db"`
+	SchemaVersion string `json:"schemaVersion"`
+	// Since is the sequence number that the change set starts after
+	Since int64 `json:"since"`
+	// Until is the sequence number of the last change event included in the change set
+	Until int64 `json:"until"`
+	// More is true if there are more change events after Until
+	More           bool                `json:"more"`
+	Lexicons       []ReplicatedLexicon `json:"lexicons"`
+	Entries        []ReplicatedEntry   `json:"entries"`
+	DeletedEntries []int64             `json:"deletedEntries"`
+}
+
+// ApplyResult holds the result of a call to DBManager.ApplyChanges
+type ApplyResult struct {
+	// Position is the replica's position in the primary's change feed, after the change set was applied
+	Position        int64 `json:"position"`
+	InsertedEntries int   `json:"insertedEntries"`
+	UpdatedEntries  int   `json:"updatedEntries"`
+	DeletedEntries  int   `json:"deletedEntries"`
+}
+
+// ReadChangeSet decodes a JSON encoded change set. Numeric values are kept as integers, which is needed for entry rows to be restored exactly.
+func ReadChangeSet(r io.Reader) (ChangeSet, error) {
+	var res ChangeSet
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	err := dec.Decode(&res)
+	if err != nil {
+		return res, fmt.Errorf("couldn't read change set : %v", err)
+	}
+	return res, nil
+}
+
+// replicatedEntryTables lists the tables holding the rows of an entry (in addition to Entry and Lemma), in the order they are inserted. EntryRelation rows are inserted after all entries have been saved, since they may refer to other entries in the change set.
+var replicatedEntryTables = []string{"Lemma2Entry", "Transcription", "EntryTag", "EntryComment", "EntryValidation", "EntryStatus"}
+
+// ExportChanges returns a change set with the modifications of the database after the sequence number since. If limit is greater than 0, at most limit change events are included (use ChangeSet.More and ChangeSet.Until to fetch the rest). Returns an error if some of the change events after since have been pruned (see PruneChanges).
+func (dbm *DBManager) ExportChanges(dbRef lex.DBRef, since int64, limit int) (ChangeSet, error) {
+	dbm.RLock()
+	db, ok := dbm.dbs[dbRef]
+	dbm.RUnlock()
+	if !ok {
+		return ChangeSet{}, fmt.Errorf("DBManager.ExportChanges: no such db '%s'", dbRef)
+	}
+	res, err := exportChanges(db, dbm.dbifOf(db).engine(), since, limit)
+	if err != nil {
+		return res, fmt.Errorf("DBManager.ExportChanges: %v", err)
+	}
+	res.DB = string(dbRef)
+	return res, nil
+}
+
+func exportChanges(db *sql.DB, engine DBEngine, since int64, limit int) (ChangeSet, error) {
+	res := ChangeSet{Since: since, Until: since, Lexicons: []ReplicatedLexicon{}, Entries: []ReplicatedEntry{}, DeletedEntries: []int64{}}
+
+	// all reads are made in a single transaction, so that the entries are consistent with each other, and at least as recent as the change events
+	var opts *sql.TxOptions
+	if engine == MariaDB {
+		opts = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+	}
+	tx, err := db.BeginTx(context.Background(), opts)
+	if err != nil {
+		return res, fmt.Errorf("failed to start db transaction : %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("SELECT name FROM SchemaVersion").Scan(&res.SchemaVersion)
+	if err != nil {
+		return res, fmt.Errorf("failed to get schema version : %v", err)
+	}
+
+	// a replica that is behind the events pruned from the change feed (see PruneChanges) would miss modifications
+	var oldest sql.NullInt64
+	err = tx.QueryRow("SELECT MIN(seq) FROM ChangeEvent").Scan(&oldest)
+	if err != nil {
+		return res, fmt.Errorf("failed to list change events : %v", err)
+	}
+	if oldest.Valid && oldest.Int64 > since+1 {
+		return res, fmt.Errorf("the change events after %d have been pruned (the oldest event is %d), the replica must be copied from a new backup", since, oldest.Int64)
+	}
+
+	q := "SELECT seq, lexiconName, type, entryId FROM ChangeEvent WHERE seq > ? ORDER BY seq"
+	args := []any{since}
+	if limit > 0 {
+		// one extra event, to tell if there are more
+		q += " LIMIT ?"
+		args = append(args, limit+1)
+	}
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return res, fmt.Errorf("failed to list change events : %v", err)
+	}
+	lexNames := []string{}
+	completeLexicons := make(map[string]bool)
+	entryIDs := []int64{}
+	seenEntryIDs := make(map[int64]bool)
+	n := 0
+	for rows.Next() {
+		var seq int64
+		var lexName, changeType string
+		var entryID sql.NullInt64
+		err = rows.Scan(&seq, &lexName, &changeType, &entryID)
+		if err != nil {
+			rows.Close()
+			return res, fmt.Errorf("failed to scan change event : %v", err)
+		}
+		n++
+		if limit > 0 && n > limit {
+			res.More = true
+			break
+		}
+		res.Until = seq
+		if _, ok := completeLexicons[lexName]; !ok {
+			completeLexicons[lexName] = false
+			lexNames = append(lexNames, lexName)
+		}
+		if ChangeType(changeType) == ChangeLexicon {
+			completeLexicons[lexName] = true
+		}
+		if entryID.Valid && !seenEntryIDs[entryID.Int64] {
+			seenEntryIDs[entryID.Int64] = true
+			entryIDs = append(entryIDs, entryID.Int64)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return res, fmt.Errorf("failed to list change events : %v", err)
+	}
+
+	for _, name := range lexNames {
+		l := ReplicatedLexicon{Name: name}
+		var lexiconID int64
+		err = tx.QueryRow("SELECT id, symbolSetName, locale FROM Lexicon WHERE name = ?", name).Scan(&lexiconID, &l.SymbolSetName, &l.Locale)
+		if err == sql.ErrNoRows {
+			l.Deleted = true
+			res.Lexicons = append(res.Lexicons, l)
+			continue
+		}
+		if err != nil {
+			return res, fmt.Errorf("failed to get lexicon '%s' : %v", name, err)
+		}
+		if completeLexicons[name] {
+			l.Complete = true
+			ids, err := queryIDs(tx, "SELECT id FROM Entry WHERE lexiconId = ? ORDER BY id", lexiconID)
+			if err != nil {
+				return res, fmt.Errorf("failed to list entries of lexicon '%s' : %v", name, err)
+			}
+			for _, id := range ids {
+				if !seenEntryIDs[id] {
+					seenEntryIDs[id] = true
+					entryIDs = append(entryIDs, id)
+				}
+			}
+		}
+		res.Lexicons = append(res.Lexicons, l)
+	}
+
+	sort.Slice(entryIDs, func(i, j int) bool { return entryIDs[i] < entryIDs[j] })
+	for _, id := range entryIDs {
+		e, ok, err := exportEntry(tx, id)
+		if err != nil {
+			return res, err
+		}
+		if !ok {
+			res.DeletedEntries = append(res.DeletedEntries, id)
+			continue
+		}
+		res.Entries = append(res.Entries, e)
+	}
+	return res, nil
+}
+
+// exportEntry returns the rows of an entry, and false if there is no entry with the id
+func exportEntry(tx *sql.Tx, id int64) (ReplicatedEntry, bool, error) {
+	res := ReplicatedEntry{ID: id, Rows: make(map[string][]map[string]any)}
+	err := tx.QueryRow("SELECT Lexicon.name FROM Entry, Lexicon WHERE Entry.lexiconId = Lexicon.id AND Entry.id = ?", id).Scan(&res.Lexicon)
+	if err == sql.ErrNoRows {
+		return res, false, nil
+	}
+	if err != nil {
+		return res, false, fmt.Errorf("failed to get entry %d : %v", id, err)
+	}
+
+	queries := map[string]string{
+		"Entry":         "SELECT * FROM Entry WHERE id = ?",
+		"Lemma":         "SELECT Lemma.* FROM Lemma, Lemma2Entry WHERE Lemma.id = Lemma2Entry.lemmaId AND Lemma2Entry.entryId = ?",
+		"EntryRelation": "SELECT * FROM EntryRelation WHERE fromEntryId = ? OR toEntryId = ? ORDER BY id",
+	}
+	for _, table := range replicatedEntryTables {
+		queries[table] = "SELECT * FROM " + table + " WHERE entryId = ?"
+		if table != "Lemma2Entry" && table != "EntryTag" {
+			queries[table] += " ORDER BY id"
+		}
+	}
+	for table, q := range queries {
+		args := []any{id}
+		if table == "EntryRelation" {
+			args = append(args, id)
+		}
+		rows, err := queryRows(tx, q, args...)
+		if err != nil {
+			return res, false, fmt.Errorf("failed to export %s rows of entry %d : %v", table, id, err)
+		}
+		if table == "Entry" {
+			// the lexicon is identified by name, since lexicon ids may differ between databases
+			for _, row := range rows {
+				delete(row, "lexiconId")
+			}
+		}
+		res.Rows[table] = rows
+	}
+	return res, true, nil
+}
+
+// queryRows returns the result rows of a query as column name => value maps. Text values are returned as strings, and time values in the format used by the databases (CURRENT_TIMESTAMP).
+func queryRows(tx *sql.Tx, q string, args ...any) ([]map[string]any, error) {
+	res := []map[string]any{}
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return res, err
+	}
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return res, err
+		}
+		row := make(map[string]any)
+		for i, col := range cols {
+			switch v := vals[i].(type) {
+			case []byte:
+				row[col] = string(v)
+			case time.Time:
+				row[col] = v.UTC().Format("2006-01-02 15:04:05")
+			default:
+				row[col] = v
+			}
+		}
+		res = append(res, row)
+	}
+	return res, rows.Err()
+}
+
+func queryIDs(tx *sql.Tx, q string, args ...any) ([]int64, error) {
+	res := []int64{}
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
+}
+
+// ReplicationPosition returns the position of the database in the change feed of the replication source (0 if the database has not been replicated from the source)
+func (dbm *DBManager) ReplicationPosition(dbRef lex.DBRef, source string) (int64, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return 0, fmt.Errorf("DBManager.ReplicationPosition: no such db '%s'", dbRef)
+	}
+	var res int64
+	err := db.QueryRow("SELECT seq FROM ReplicationState WHERE source = ?", source).Scan(&res)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("DBManager.ReplicationPosition: %v", err)
+	}
+	return res, nil
+}
+
+// InitReplication sets the position of a database that has been copied from the replication source (e.g., restored from a backup of the source). The position is set to the last change event in the database, i.e., the last change event of the source at the time of the copy. Returns the position.
+func (dbm *DBManager) InitReplication(dbRef lex.DBRef, source string) (int64, error) {
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return 0, fmt.Errorf("DBManager.InitReplication: no such db '%s'", dbRef)
+	}
+	var seq sql.NullInt64
+	err := db.QueryRow("SELECT MAX(seq) FROM ChangeEvent").Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("DBManager.InitReplication: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("DBManager.InitReplication: failed to start db transaction : %v", err)
+	}
+	defer tx.Rollback()
+	err = setReplicationPosition(tx, source, seq.Int64)
+	if err != nil {
+		return 0, fmt.Errorf("DBManager.InitReplication: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("DBManager.InitReplication: failed to commit : %v", err)
+	}
+	return seq.Int64, nil
+}
+
+func setReplicationPosition(tx *sql.Tx, source string, seq int64) error {
+	ts := time.Now().UTC().Format(time.RFC3339)
+	res, err := tx.Exec("UPDATE ReplicationState SET seq = ?, modified = ? WHERE source = ?", seq, ts, source)
+	if err != nil {
+		return fmt.Errorf("failed to update replication state : %v", err)
+	}
+	// MariaDB returns 0 rows affected if the values are unchanged, so the existence is checked separately
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	var exists int
+	err = tx.QueryRow("SELECT COUNT(*) FROM ReplicationState WHERE source = ?", source).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to update replication state : %v", err)
+	}
+	if exists > 0 {
+		return nil
+	}
+	_, err = tx.Exec("INSERT INTO ReplicationState (source, seq, modified) VALUES (?, ?, ?)", source, seq, ts)
+	if err != nil {
+		return fmt.Errorf("failed to insert replication state : %v", err)
+	}
+	return nil
+}
+
+// ApplyChanges saves a change set from the replication source (see ExportChanges) to the database, in a single transaction, and updates the database's position in the source's change feed. The change set must start at or before the current position (see ReplicationPosition). Change sets that have already been applied are ignored.
+// Change events are recorded for the modifications in the database, so that the database's own change feed can be followed.
+func (dbm *DBManager) ApplyChanges(dbRef lex.DBRef, source string, cs ChangeSet) (ApplyResult, error) {
+	dbm.Lock()
+	defer dbm.Unlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return ApplyResult{}, fmt.Errorf("DBManager.ApplyChanges: no such db '%s'", dbRef)
+	}
+	version, err := dbm.dbifOf(db).getSchemaVersion(db)
+	if err != nil {
+		return ApplyResult{}, fmt.Errorf("DBManager.ApplyChanges: %v", err)
+	}
+	if version != cs.SchemaVersion {
+		return ApplyResult{}, fmt.Errorf("DBManager.ApplyChanges: mismatching schema versions. Change set: %s, db: %s", cs.SchemaVersion, version)
+	}
+
+	a := applier{res: ApplyResult{}, lexiconIDs: make(map[string]int64), columns: make(map[string]map[string]bool)}
+	a.tx, err = db.Begin()
+	if err != nil {
+		return ApplyResult{}, fmt.Errorf("DBManager.ApplyChanges: failed to start db transaction : %v", err)
+	}
+	rollback := func(msg string) error {
+		err := a.tx.Rollback()
+		if err != nil {
+			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err)
+		}
+		return errors.New("DBManager.ApplyChanges: " + msg)
+	}
+
+	var pos int64
+	err = a.tx.QueryRow("SELECT seq FROM ReplicationState WHERE source = ?", source).Scan(&pos)
+	if err != nil && err != sql.ErrNoRows {
+		return ApplyResult{}, rollback(fmt.Sprintf("failed to get replication state : %v", err))
+	}
+	a.res.Position = pos
+	if cs.Since > pos {
+		return a.res, rollback(fmt.Sprintf("change set starts after %d, but the db is at %d in the change feed of '%s'", cs.Since, pos, source))
+	}
+	if cs.Until <= pos {
+		_ = a.tx.Rollback()
+		return a.res, nil
+	}
+
+	err = a.apply(cs)
+	if err != nil {
+		return ApplyResult{}, rollback(err.Error())
+	}
+	err = setReplicationPosition(a.tx, source, cs.Until)
+	if err != nil {
+		return ApplyResult{}, rollback(err.Error())
+	}
+	// the change events are saved in the same transaction, so that the modifications are never missing from the database's own change feed
+	events := []ChangeEvent{}
+	for _, e := range a.events {
+		es, err := insertChangesTx(a.tx, changeEvents(lex.LexRef{DBRef: dbRef, LexName: e.LexRef.LexName}, e.Type, e.entryIDs...))
+		if err != nil {
+			return ApplyResult{}, rollback(err.Error())
+		}
+		events = append(events, es...)
+	}
+	err = a.tx.Commit()
+	if err != nil {
+		return ApplyResult{}, fmt.Errorf("DBManager.ApplyChanges: failed to commit : %v", err)
+	}
+	a.res.Position = cs.Until
+
+	dbm.invalidateDB(dbRef)
+	dbm.publishChanges(events)
+	return a.res, nil
+}
+
+// applyEvent is a change event to record for a change set
+type applyEvent struct {
+	ChangeEvent
+	entryIDs []int64
+}
+
+type applier struct {
+	tx         *sql.Tx
+	res        ApplyResult
+	lexiconIDs map[string]int64
+	// the columns of each table in the database, used to validate the column names in the change set
+	columns map[string]map[string]bool
+	events  []applyEvent
+}
+
+func (a *applier) event(lexName string, t ChangeType, entryIDs ...int64) {
+	a.events = append(a.events, applyEvent{ChangeEvent: ChangeEvent{LexRef: lex.LexRef{LexName: lex.LexName(lexName)}, Type: t}, entryIDs: entryIDs})
+}
+
+func (a *applier) apply(cs ChangeSet) error {
+	complete := make(map[string]bool)
+	for _, l := range cs.Lexicons {
+		if l.Deleted {
+			continue
+		}
+		err := a.defineLexicon(l)
+		if err != nil {
+			return err
+		}
+		if l.Complete {
+			complete[l.Name] = true
+		}
+	}
+
+	// entry ids in the change set for each lexicon
+	lexEntryIDs := make(map[string]map[int64]bool)
+	relations := []map[string]any{}
+	inserted := make(map[string][]int64)
+	updated := make(map[string][]int64)
+	for _, e := range cs.Entries {
+		isNew, err := a.saveEntry(e)
+		if err != nil {
+			return fmt.Errorf("failed to save entry %d : %v", e.ID, err)
+		}
+		if _, ok := lexEntryIDs[e.Lexicon]; !ok {
+			lexEntryIDs[e.Lexicon] = make(map[int64]bool)
+		}
+		lexEntryIDs[e.Lexicon][e.ID] = true
+		relations = append(relations, e.Rows["EntryRelation"]...)
+		if isNew {
+			a.res.InsertedEntries++
+			inserted[e.Lexicon] = append(inserted[e.Lexicon], e.ID)
+		} else {
+			a.res.UpdatedEntries++
+			updated[e.Lexicon] = append(updated[e.Lexicon], e.ID)
+		}
+	}
+	for _, r := range relations {
+		err := a.saveRelation(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	deleted := make(map[string][]int64)
+	for _, id := range cs.DeletedEntries {
+		lexName, ok, err := a.deleteEntry(id)
+		if err != nil {
+			return err
+		}
+		if ok {
+			deleted[lexName] = append(deleted[lexName], id)
+		}
+	}
+
+	for _, l := range cs.Lexicons {
+		if l.Deleted {
+			err := a.deleteLexicon(l.Name)
+			if err != nil {
+				return err
+			}
+			a.event(l.Name, ChangeLexiconDeleted)
+			continue
+		}
+		if !l.Complete {
+			continue
+		}
+		ids, err := queryIDs(a.tx, "SELECT id FROM Entry WHERE lexiconId = ?", a.lexiconIDs[l.Name])
+		if err != nil {
+			return fmt.Errorf("failed to list entries of lexicon '%s' : %v", l.Name, err)
+		}
+		for _, id := range ids {
+			if !lexEntryIDs[l.Name][id] {
+				_, _, err = a.deleteEntry(id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		a.event(l.Name, ChangeLexicon)
+	}
+
+	// entry events are not recorded for lexicons with a ChangeLexicon event
+	for _, m := range []struct {
+		t   ChangeType
+		ids map[string][]int64
+	}{{ChangeInsert, inserted}, {ChangeUpdate, updated}, {ChangeDelete, deleted}} {
+		for lexName, ids := range m.ids {
+			if !complete[lexName] && len(ids) > 0 {
+				a.event(lexName, m.t, ids...)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *applier) defineLexicon(l ReplicatedLexicon) error {
+	var id int64
+	err := a.tx.QueryRow("SELECT id FROM Lexicon WHERE name = ?", l.Name).Scan(&id)
+	if err == sql.ErrNoRows {
+		res, err := a.tx.Exec("INSERT INTO Lexicon (name, symbolSetName, locale) VALUES (?, ?, ?)", l.Name, l.SymbolSetName, l.Locale)
+		if err != nil {
+			return fmt.Errorf("failed to insert lexicon '%s' : %v", l.Name, err)
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get id of lexicon '%s' : %v", l.Name, err)
+		}
+		_, err = a.tx.Exec("INSERT INTO LexiconMeta (lexiconId) VALUES (?)", id)
+		if err != nil {
+			return fmt.Errorf("failed to insert meta data of lexicon '%s' : %v", l.Name, err)
+		}
+		a.lexiconIDs[l.Name] = id
+		a.event(l.Name, ChangeLexiconCreated)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get lexicon '%s' : %v", l.Name, err)
+	}
+	// the symbol set is changed by an in-place symbol set conversion
+	_, err = a.tx.Exec("UPDATE Lexicon SET symbolSetName = ?, locale = ? WHERE id = ?", l.SymbolSetName, l.Locale, id)
+	if err != nil {
+		return fmt.Errorf("failed to update lexicon '%s' : %v", l.Name, err)
+	}
+	a.lexiconIDs[l.Name] = id
+	return nil
+}
+
+func (a *applier) lexiconID(name string) (int64, error) {
+	if id, ok := a.lexiconIDs[name]; ok {
+		return id, nil
+	}
+	var id int64
+	err := a.tx.QueryRow("SELECT id FROM Lexicon WHERE name = ?", name).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get lexicon '%s' : %v", name, err)
+	}
+	a.lexiconIDs[name] = id
+	return id, nil
+}
+
+// saveEntry saves the rows of an entry. If the entry exists, its Entry row is updated (so that rows referring to the entry, but not included in the change set, such as usage statistics, are kept), and all other rows of the entry are replaced. If the entry gets a new status, its open assignments are closed. Returns true if the entry is new.
+func (a *applier) saveEntry(e ReplicatedEntry) (bool, error) {
+	lexiconID, err := a.lexiconID(e.Lexicon)
+	if err != nil {
+		return false, err
+	}
+	if len(e.Rows["Entry"]) != 1 {
+		return false, fmt.Errorf("expected one Entry row, found %d", len(e.Rows["Entry"]))
+	}
+	entry := make(map[string]any)
+	for k, v := range e.Rows["Entry"][0] {
+		entry[k] = v
+	}
+	entry["id"] = e.ID
+	entry["lexiconId"] = lexiconID
+
+	var n int
+	err = a.tx.QueryRow("SELECT COUNT(*) FROM Entry WHERE id = ?", e.ID).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	isNew := n == 0
+	if isNew {
+		err = a.insertRow("Entry", entry)
+	} else {
+		err = a.updateRow("Entry", entry, e.ID)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// the status before the update is needed to tell whether a new status was set
+	var statusBefore int64
+	if !isNew {
+		err = a.tx.QueryRow("SELECT id FROM EntryStatus WHERE entryId = ? AND current = 1", e.ID).Scan(&statusBefore)
+		if err != nil && err != sql.ErrNoRows {
+			return false, fmt.Errorf("failed to get entry status : %v", err)
+		}
+	}
+
+	err = a.deleteEntryRows(e.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, lemma := range e.Rows["Lemma"] {
+		err = a.saveLemma(lemma)
+		if err != nil {
+			return false, err
+		}
+	}
+	for _, table := range replicatedEntryTables {
+		for _, row := range e.Rows[table] {
+			err = a.insertRow(table, row)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	if !isNew {
+		var id int64
+		var status lex.EntryStatus
+		err = a.tx.QueryRow("SELECT id, name, source FROM EntryStatus WHERE entryId = ? AND current = 1", e.ID).Scan(&id, &status.Name, &status.Source)
+		if err != nil && err != sql.ErrNoRows {
+			return false, fmt.Errorf("failed to get entry status : %v", err)
+		}
+		if err == nil && id != statusBefore {
+			err = closeAssignmentsTx(a.tx, status, "= ?", e.ID)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	return isNew, nil
+}
+
+// saveLemma inserts or updates a lemma, identified by its id
+func (a *applier) saveLemma(row map[string]any) error {
+	id, ok := intValue(row["id"])
+	if !ok {
+		return fmt.Errorf("invalid lemma id : %v", row["id"])
+	}
+	var n int
+	err := a.tx.QueryRow("SELECT COUNT(*) FROM Lemma WHERE id = ?", id).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return a.insertRow("Lemma", row)
+	}
+	return a.updateRow("Lemma", row, id)
+}
+
+// saveRelation inserts a relation, unless it already exists, or refers to an entry that is not (yet) in the database (it will then be inserted along with the other entry)
+func (a *applier) saveRelation(row map[string]any) error {
+	id, ok1 := intValue(row["id"])
+	from, ok2 := intValue(row["fromEntryId"])
+	to, ok3 := intValue(row["toEntryId"])
+	if !ok1 || !ok2 || !ok3 {
+		return fmt.Errorf("invalid entry relation : %v", row)
+	}
+	var n int
+	err := a.tx.QueryRow("SELECT (SELECT COUNT(*) FROM EntryRelation WHERE id = ?) + 2 - (SELECT COUNT(*) FROM Entry WHERE id IN (?, ?))", id, from, to).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("failed to check entry relation %d : %v", id, err)
+	}
+	if n > 0 {
+		return nil
+	}
+	return a.insertRow("EntryRelation", row)
+}
+
+// deleteEntryRows deletes the rows of the entry in the replicated tables, except the Entry row itself
+func (a *applier) deleteEntryRows(id int64) error {
+	for _, table := range replicatedEntryTables {
+		_, err := a.tx.Exec("DELETE FROM "+table+" WHERE entryId = ?", id)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s rows of entry %d : %v", table, id, err)
+		}
+	}
+	_, err := a.tx.Exec("DELETE FROM EntryRelation WHERE fromEntryId = ? OR toEntryId = ?", id, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete relations of entry %d : %v", id, err)
+	}
+	return nil
+}
+
+// deleteEntry deletes an entry, and returns its lexicon name, and false if there was no such entry
+func (a *applier) deleteEntry(id int64) (string, bool, error) {
+	var lexName string
+	err := a.tx.QueryRow("SELECT Lexicon.name FROM Entry, Lexicon WHERE Entry.lexiconId = Lexicon.id AND Entry.id = ?", id).Scan(&lexName)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get entry %d : %v", id, err)
+	}
+	err = a.deleteEntryRows(id)
+	if err != nil {
+		return "", false, err
+	}
+	for _, table := range []string{"EntryUsage", "EntryProposal", "Assignment"} {
+		_, err = a.tx.Exec("DELETE FROM "+table+" WHERE entryId = ?", id)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to delete %s rows of entry %d : %v", table, id, err)
+		}
+	}
+	_, err = a.tx.Exec("DELETE FROM Entry WHERE id = ?", id)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to delete entry %d : %v", id, err)
+	}
+	a.res.DeletedEntries++
+	return lexName, true, nil
+}
+
+func (a *applier) deleteLexicon(name string) error {
+	var id int64
+	err := a.tx.QueryRow("SELECT id FROM Lexicon WHERE name = ?", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get lexicon '%s' : %v", name, err)
+	}
+	ids, err := queryIDs(a.tx, "SELECT id FROM Entry WHERE lexiconId = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to list entries of lexicon '%s' : %v", name, err)
+	}
+	for _, eid := range ids {
+		_, _, err = a.deleteEntry(eid)
+		if err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"LexiconMeta", "LexiconProperty", "MissedWord"} {
+		_, err = a.tx.Exec("DELETE FROM "+table+" WHERE lexiconId = ?", id)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s rows of lexicon '%s' : %v", table, name, err)
+		}
+	}
+	_, err = a.tx.Exec("DELETE FROM Lexicon WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete lexicon '%s' : %v", name, err)
+	}
+	delete(a.lexiconIDs, name)
+	return nil
+}
+
+// tableColumns returns the columns of a table in the database
+func (a *applier) tableColumns(table string) (map[string]bool, error) {
+	if cols, ok := a.columns[table]; ok {
+		return cols, nil
+	}
+	rows, err := a.tx.Query("SELECT * FROM " + table + " LIMIT 0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns of table %s : %v", table, err)
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns of table %s : %v", table, err)
+	}
+	cols := make(map[string]bool)
+	for _, c := range names {
+		cols[c] = true
+	}
+	a.columns[table] = cols
+	return cols, nil
+}
+
+// rowColumns returns the sorted column names and values of a row, after checking that the columns exist in the table (the column names are used in SQL statements)
+func (a *applier) rowColumns(table string, row map[string]any) ([]string, []any, error) {
+	cols, err := a.tableColumns(table)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := []string{}
+	for c := range row {
+		if !cols[c] {
+			return nil, nil, fmt.Errorf("unknown column %s.%s", table, c)
+		}
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	vals := []any{}
+	for _, c := range names {
+		vals = append(vals, rowValue(row[c]))
+	}
+	return names, vals, nil
+}
+
+func (a *applier) insertRow(table string, row map[string]any) error {
+	names, vals, err := a.rowColumns(table, row)
+	if err != nil {
+		return err
+	}
+	_, err = a.tx.Exec("INSERT INTO "+table+" ("+strings.Join(names, ", ")+") VALUES "+nQs(len(names)), vals...)
+	if err != nil {
+		return fmt.Errorf("failed to insert %s row : %v", table, err)
+	}
+	return nil
+}
+
+func (a *applier) updateRow(table string, row map[string]any, id int64) error {
+	names, vals, err := a.rowColumns(table, row)
+	if err != nil {
+		return err
+	}
+	sets := []string{}
+	for _, c := range names {
+		sets = append(sets, c+" = ?")
+	}
+	_, err = a.tx.Exec("UPDATE "+table+" SET "+strings.Join(sets, ", ")+" WHERE id = ?", append(vals, id)...)
+	if err != nil {
+		return fmt.Errorf("failed to update %s row : %v", table, err)
+	}
+	return nil
+}
+
+// rowValue converts a value decoded from JSON to a value for the database
+func rowValue(v any) any {
+	switch x := v.(type) {
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return n
+		}
+		if f, err := x.Float64(); err == nil {
+			return f
+		}
+		return x.String()
+	case float64:
+		if x == math.Trunc(x) {
+			return int64(x)
+		}
+	}
+	return v
+}
+
+func intValue(v any) (int64, bool) {
+	n, ok := rowValue(v).(int64)
+	return n, ok
+}
@@ -0,0 +1,229 @@
+package dbapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestReplicationSqlite(t *testing.T) {
+	primary := lex.DBRef("replication_primary_test")
+	replica := lex.DBRef("replication_replica_test")
+	dbm := createTestSqliteDBManager(t, primary)
+	defer dbm.CloseDB(primary)
+	err := dbm.DropDB(".", replica)
+	if err != nil {
+		t.Fatalf("failed to drop db : %v", err)
+	}
+	err = dbm.DefineDB(".", replica)
+	if err != nil {
+		t.Fatalf("failed to define db : %v", err)
+	}
+	defer dbm.CloseDB(replica)
+
+	lexRef1 := lex.NewLexRef(string(primary), "lex1")
+	lexRef2 := lex.NewLexRef(string(primary), "lex2")
+	defineTestLexicons(t, dbm, lexRef1, lexRef2)
+	newEntry := func(strn, trans string) lex.Entry {
+		e := newTestEntry(strn, trans)
+		e.Lemma = lex.Lemma{Strn: strn, Reading: "1"}
+		e.Tag = "tag_" + strn
+		e.Comments = []lex.EntryComment{{Label: "label", Source: "test", Comment: "comment on " + strn}}
+		return e
+	}
+	ids1, err := dbm.InsertEntries(lexRef1, []lex.Entry{newEntry("kexpaket", `"" k e k + p a . k % e: t`), newEntry("kex", `" k e k s`), newEntry("hund", `" h u0 n d`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	ids2, err := dbm.InsertEntries(lexRef2, []lex.Entry{newEntry("paket", `p a . k "e: t`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	compound, kex, hund, paket := ids1[0], ids1[1], ids1[2], ids2[0]
+	_, err = dbm.InsertEntryRelation(primary, lex.EntryRelation{Type: lex.CompoundPart, FromEntryID: compound, ToEntryID: kex, Position: 1})
+	if err != nil {
+		t.Fatalf("failed to insert relation : %v", err)
+	}
+	r2, err := dbm.InsertEntryRelation(primary, lex.EntryRelation{Type: lex.CompoundPart, FromEntryID: compound, ToEntryID: paket, Position: 2})
+	if err != nil {
+		t.Fatalf("failed to insert relation : %v", err)
+	}
+	updateStatus := func(id int64, lexRef lex.LexRef, status string) {
+		t.Helper()
+		es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{EntryIDs: []int64{id}}})
+		if err != nil || len(es) != 1 {
+			t.Fatalf("lookup failed : %v", err)
+		}
+		e := es[0]
+		e.EntryStatus = lex.EntryStatus{Name: status, Source: "tester"}
+		_, _, err = dbm.UpdateEntry(e)
+		if err != nil {
+			t.Fatalf("failed to update entry : %v", err)
+		}
+	}
+	updateStatus(hund, lexRef1, "ok")
+
+	lookUp := func(dbRef lex.DBRef) []lex.Entry {
+		t.Helper()
+		lexRefs := []lex.LexRef{}
+		lexNames, err := dbm.ListLexicons()
+		if err != nil {
+			t.Fatalf("failed to list lexicons : %v", err)
+		}
+		for _, l := range lexNames {
+			if l.LexRef.DBRef == dbRef {
+				lexRefs = append(lexRefs, l.LexRef)
+			}
+		}
+		if len(lexRefs) == 0 {
+			return []lex.Entry{}
+		}
+		es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: lexRefs, Query: Query{WordLike: "%", IncludeRelated: true}})
+		if err != nil {
+			t.Fatalf("lookup failed : %v", err)
+		}
+		for i := range es {
+			es[i].LexRef.DBRef = ""
+		}
+		return es
+	}
+	statusCount := func(dbRef lex.DBRef) int {
+		t.Helper()
+		var n int
+		err := dbm.dbs[dbRef].QueryRow("SELECT COUNT(*) FROM EntryStatus").Scan(&n)
+		if err != nil {
+			t.Fatalf("failed to count statuses : %v", err)
+		}
+		return n
+	}
+	// the change set is transferred as JSON, as between servers
+	transfer := func(cs ChangeSet) ChangeSet {
+		t.Helper()
+		jsn, err := json.Marshal(cs)
+		if err != nil {
+			t.Fatalf("failed to marshal change set : %v", err)
+		}
+		res, err := ReadChangeSet(bytes.NewReader(jsn))
+		if err != nil {
+			t.Fatalf("failed to read change set : %v", err)
+		}
+		return res
+	}
+	// sync exports and applies change sets of at most limit events, until the replica is up to date
+	sync := func(source string, limit int) {
+		t.Helper()
+		for {
+			pos, err := dbm.ReplicationPosition(replica, source)
+			if err != nil {
+				t.Fatalf("failed to get position : %v", err)
+			}
+			cs, err := dbm.ExportChanges(primary, pos, limit)
+			if err != nil {
+				t.Fatalf("failed to export changes : %v", err)
+			}
+			res, err := dbm.ApplyChanges(replica, source, transfer(cs))
+			if err != nil {
+				t.Fatalf("failed to apply changes : %v", err)
+			}
+			if res.Position != cs.Until {
+				t.Fatalf("expected position %d, got %d", cs.Until, res.Position)
+			}
+			if !cs.More {
+				return
+			}
+		}
+	}
+	check := func(msg string) {
+		t.Helper()
+		p, r := lookUp(primary), lookUp(replica)
+		if !reflect.DeepEqual(p, r) {
+			t.Errorf("%s: expected replica entries\n%#v\ngot\n%#v", msg, p, r)
+		}
+		if p, r := statusCount(primary), statusCount(replica); p != r {
+			t.Errorf("%s: expected %d status rows in replica, got %d", msg, p, r)
+		}
+	}
+
+	sync("primary", 0)
+	check("initial sync")
+	if len(lookUp(replica)) != 4 {
+		t.Errorf("expected 4 entries in replica, got %d", len(lookUp(replica)))
+	}
+
+	// applying the same changes again (from another source) has no effect
+	sync("other", 3)
+	check("repeated sync")
+
+	// a change set after the current position is refused
+	cs, err := dbm.ExportChanges(primary, 1000, 0)
+	if err != nil {
+		t.Fatalf("failed to export changes : %v", err)
+	}
+	cs.Until = 1001
+	_, err = dbm.ApplyChanges(replica, "primary", cs)
+	if err == nil {
+		t.Errorf("expected error for change set with gap")
+	}
+
+	// assignments are local to the replica, and closed when a replicated entry gets a new status
+	replicaLexRef := lex.NewLexRef(string(replica), "lex1")
+	_, err = dbm.AssignEntries(replicaLexRef, []int64{kex, compound}, AssignOptions{Assignee: "replica_user"})
+	if err != nil {
+		t.Fatalf("failed to assign entries : %v", err)
+	}
+
+	// incremental changes
+	updateStatus(kex, lexRef1, "ok")
+	err = dbm.DeleteEntryRelation(primary, r2.ID)
+	if err != nil {
+		t.Fatalf("failed to delete relation : %v", err)
+	}
+	_, err = dbm.DeleteEntry(hund, lexRef1)
+	if err != nil {
+		t.Fatalf("failed to delete entry : %v", err)
+	}
+	_, err = dbm.InsertEntries(lexRef1, []lex.Entry{newEntry("katt", `" k a t`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	_, err = dbm.DeleteEntry(paket, lexRef2)
+	if err != nil {
+		t.Fatalf("failed to delete entry : %v", err)
+	}
+	err = dbm.DeleteLexicon(lexRef2)
+	if err != nil {
+		t.Fatalf("failed to delete lexicon : %v", err)
+	}
+	sync("primary", 2)
+	check("incremental sync")
+	if n := len(lookUp(replica)); n != 3 {
+		t.Errorf("expected 3 entries in replica, got %d", n)
+	}
+	wl, err := dbm.Worklist("replica_user", "")
+	if err != nil {
+		t.Fatalf("failed to get worklist : %v", err)
+	}
+	for _, a := range wl.Assignments {
+		if a.EntryID == kex && (a.Status != AssignmentDone || a.ClosedStatus != "ok" || a.ClosedBy != "tester") {
+			t.Errorf("expected the assignment of the updated entry to be closed, got %#v", a)
+		}
+		if a.EntryID == compound && a.Status != AssignmentOpen {
+			t.Errorf("expected the assignment of the entry without a new status to be open, got %#v", a)
+		}
+	}
+	if len(wl.Assignments) != 2 {
+		t.Errorf("expected 2 assignments, got %#v", wl.Assignments)
+	}
+
+	// the replica's own change feed
+	es, err := dbm.ListChanges(replica, 0, []lex.LexName{"lex2"}, 0)
+	if err != nil {
+		t.Fatalf("failed to list changes : %v", err)
+	}
+	if len(es) == 0 || es[len(es)-1].Type != ChangeLexiconDeleted {
+		t.Errorf("expected lexicon_deleted event in replica change feed, got %#v", es)
+	}
+}
@@ -0,0 +1,57 @@
+package dbapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of the context carrying a request ID, e.g. the ID of an HTTP request to the lexserver (see DBManager.WithContext)
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID of the context, or the empty string if there is none (see ContextWithRequestID)
+func RequestIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// WithContext returns a DBManager for making calls on behalf of a request. It shares the databases, caches, locks, etc, of dbm, but failed calls are logged (using log/slog) with the request ID of the context (see ContextWithRequestID), so that errors can be traced to the request behind them.
+// The returned DBManager should only be used for the duration of the request. Settings made through it (e.g., SetLookupCacheSize or SetLexiconStackFile) do not apply to dbm.
+func (dbm *DBManager) WithContext(ctx context.Context) *DBManager {
+	res := *dbm
+	res.ctx = ctx
+	return &res
+}
+
+// context returns the context of the DBManager (see WithContext)
+func (dbm *DBManager) context() context.Context {
+	if dbm.ctx == nil {
+		return context.Background()
+	}
+	return dbm.ctx
+}
+
+// logError logs the error of a failed call, if any, with the request ID of the DBManager context (see WithContext). Calls rejected because of an entry lock or a frozen lexicon are logged at level WARN, other errors at level ERROR.
+func (dbm *DBManager) logError(call string, err error) {
+	if err == nil {
+		return
+	}
+	ctx := dbm.context()
+	attrs := []any{"call", call, "error", err}
+	if id := RequestIDFromContext(ctx); id != "" {
+		attrs = append(attrs, "request_id", id)
+	}
+	level := slog.LevelError
+	var le *EntryLockedError
+	var fe *FrozenLexiconError
+	if errors.As(err, &le) || errors.As(err, &fe) {
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "DBManager call failed", attrs...)
+}
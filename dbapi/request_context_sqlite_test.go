@@ -0,0 +1,60 @@
+package dbapi
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestRequestContextSqlite(t *testing.T) {
+	dbRef := lex.DBRef("request_context_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+	if id := RequestIDFromContext(ctx); id != "req-1" {
+		t.Errorf("expected request id req-1, got '%s'", id)
+	}
+	if id := RequestIDFromContext(context.Background()); id != "" {
+		t.Errorf("expected no request id, got '%s'", id)
+	}
+	rdbm := dbm.WithContext(ctx)
+
+	// the request DBManager shares the databases of dbm
+	ids, err := rdbm.InsertEntries(lexRef, []lex.Entry{{Strn: "hund",
+		Language:       "sv-se",
+		Transcriptions: []lex.Transcription{{Strn: `" h u0 n d`}},
+		EntryStatus:    lex.EntryStatus{Name: "imported", Source: "test"},
+	}})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	n, err := dbm.EntryCount(lexRef)
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 entry, got %d : %v", n, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing logged for successful call, got %s", buf.String())
+	}
+
+	_, err = rdbm.DeleteEntry(ids[0], lex.NewLexRef("no_such_db", "lex1"))
+	if err == nil {
+		t.Fatalf("expected error for deleting entry in non-existing db")
+	}
+	log := buf.String()
+	if !strings.Contains(log, `"request_id":"req-1"`) || !strings.Contains(log, `"call":"DBManager.DeleteEntry"`) {
+		t.Errorf("expected logged error with request id, got %s", log)
+	}
+}
@@ -5,12 +5,140 @@ package dbapi
 
 // TODO: SchemaVersion defined in schema.go
 
-const mariaDBDropTableStmt = `DROP TABLE IF EXISTS SchemaVersion, EntryComment, Lemma2Entry, Lemma, Transcription, EntryTag, EntryValidation, EntryStatus, Entry, Lexicon;`
+// Tables added in later schema versions, that Migrate creates in older databases (see addTables)
+
+const entryRelationTableMariaDB = `-- Typed, directed relations between entries (e.g., 'abbreviation_of', 'compound_part').
+	-- The related entries may belong to different lexicons in the same database.
+	CREATE TABLE IF NOT EXISTS EntryRelation (
+	    id integer not null primary key auto_increment,
+	    type varchar(128) not null,
+	    fromEntryId integer not null,
+	    toEntryId integer not null,
+	    position integer not null default 0,
+	    Timestamp DATETIME DEFAULT CURRENT_TIMESTAMP not null,
+	    unique(fromEntryId,toEntryId,type),
+	    FOREIGN KEY fk_9 (fromEntryId) REFERENCES Entry(id) ON DELETE CASCADE,
+	    FOREIGN KEY fk_10 (toEntryId) REFERENCES Entry(id) ON DELETE CASCADE);`
+
+const lexiconMetaTableMariaDB = `-- Descriptive meta data for each lexicon (description, license, provenance, owner).
+	-- One row per lexicon, created along with the lexicon.
+	CREATE TABLE IF NOT EXISTS LexiconMeta (
+	    lexiconId integer not null,
+	    description text not null default '',
+	    license text not null default '',
+	    sourceUrl text not null default '',
+	    sourceVersion varchar(128) not null default '',
+	    owner varchar(128) not null default '',
+	    created DATETIME DEFAULT CURRENT_TIMESTAMP not null,
+	    modified DATETIME DEFAULT CURRENT_TIMESTAMP not null,
+	    unique(lexiconId),
+	    FOREIGN KEY fk_11 (lexiconId) REFERENCES Lexicon(id) ON DELETE CASCADE);`
+
+const lexiconPropertyTableMariaDB = `-- Free key-value pairs of lexicon meta data
+	CREATE TABLE IF NOT EXISTS LexiconProperty (
+	    lexiconId integer not null,
+	    name varchar(128) not null,
+	    value text not null,
+	    unique(lexiconId,name),
+	    FOREIGN KEY fk_12 (lexiconId) REFERENCES Lexicon(id) ON DELETE CASCADE);`
+
+const entryUsageTableMariaDB = `-- Aggregated lookup hits per entry (see dbapi.UsageRecorder)
+	CREATE TABLE IF NOT EXISTS EntryUsage (
+	    entryId integer not null,
+	    hits bigint not null default 0,
+	    lastHit DATETIME DEFAULT CURRENT_TIMESTAMP not null,
+	    unique(entryId),
+	    FOREIGN KEY fk_13 (entryId) REFERENCES Entry(id) ON DELETE CASCADE);`
+
+const missedWordTableMariaDB = `-- Aggregated lookups of words not found in a lexicon (see dbapi.UsageRecorder)
+	CREATE TABLE IF NOT EXISTS MissedWord (
+	    lexiconId integer not null,
+	    strn varchar(255) not null,
+	    hits bigint not null default 0,
+	    lastHit DATETIME DEFAULT CURRENT_TIMESTAMP not null,
+	    unique(lexiconId,strn),
+	    FOREIGN KEY fk_14 (lexiconId) REFERENCES Lexicon(id) ON DELETE CASCADE);`
+
+const changeEventTableMariaDB = `-- Log of lexicon modifications, for the change feed (see dbapi.ChangeEvent). There are no foreign keys, since events for deleted entries and lexicons are kept.
+	CREATE TABLE IF NOT EXISTS ChangeEvent (
+	    seq bigint not null primary key auto_increment,
+	    lexiconName varchar(128) not null,
+	    type varchar(32) not null,
+	    entryId bigint,
+	    timestamp varchar(32) not null);`
+
+const replicationStateTableMariaDB = `-- Position in the change feed of the primary database, for databases replicated from another database (see dbapi.ApplyChanges)
+	CREATE TABLE IF NOT EXISTS ReplicationState (
+	    source varchar(255) not null primary key,
+	    seq bigint not null,
+	    modified varchar(32) not null);`
+
+const entryProposalTableMariaDB = `-- Proposed entry updates, pending review (see dbapi.Proposal). Base and proposed are JSON encoded lex.Entry objects.
+	CREATE TABLE IF NOT EXISTS EntryProposal (
+	    id bigint not null primary key auto_increment,
+	    entryId bigint not null,
+	    status varchar(32) not null,
+	    proposer varchar(128) not null,
+	    comment text not null default '',
+	    base mediumtext not null,
+	    proposed mediumtext not null,
+	    reviewer varchar(128) not null default '',
+	    reviewComment text not null default '',
+	    created varchar(32) not null,
+	    modified varchar(32) not null,
+	    FOREIGN KEY (entryId) REFERENCES Entry(id) ON DELETE CASCADE);`
+
+const assignmentTableMariaDB = `-- Entries assigned to users for review (see dbapi.Assignment)
+	CREATE TABLE IF NOT EXISTS Assignment (
+	    id bigint not null primary key auto_increment,
+	    entryId bigint not null,
+	    assignee varchar(128) not null,
+	    assignedBy varchar(128) not null default '',
+	    priority int not null default 0,
+	    due varchar(32) not null default '',
+	    comment text not null default '',
+	    status varchar(32) not null,
+	    created varchar(32) not null,
+	    closed varchar(32) not null default '',
+	    closedBy varchar(128) not null default '',
+	    closedStatus varchar(128) not null default '',
+	    FOREIGN KEY (entryId) REFERENCES Entry(id) ON DELETE CASCADE);`
+
+const importBatchTableMariaDB = `-- Import runs (see dbapi.ImportBatch)
+	CREATE TABLE IF NOT EXISTS ImportBatch (
+	    id bigint not null primary key auto_increment,
+	    lexiconId bigint not null,
+	    fileName text not null default '',
+	    checksum varchar(64) not null default '',
+	    importedBy varchar(128) not null default '',
+	    created varchar(32) not null,
+	    entryCount int not null default 0,
+	    undone varchar(32) not null default '',
+	    FOREIGN KEY (lexiconId) REFERENCES Lexicon(id) ON DELETE CASCADE);`
+
+const importBatchEntryTableMariaDB = `-- The entries created by each import run. There is no foreign key on entryId, so that entries deleted after the import can be reported.
+	CREATE TABLE IF NOT EXISTS ImportBatchEntry (
+	    batchId bigint not null,
+	    entryId bigint not null,
+	    lineNumber int not null,
+	    fingerprint varchar(64) not null,
+	    FOREIGN KEY (batchId) REFERENCES ImportBatch(id) ON DELETE CASCADE);`
+
+const frozenLexiconTableMariaDB = `-- Frozen (read-only) lexicons (see dbapi.FreezeLexicon)
+	CREATE TABLE IF NOT EXISTS FrozenLexicon (
+	    lexiconId bigint not null,
+	    frozenBy varchar(128) not null default '',
+	    comment text not null default '',
+	    created varchar(32) not null,
+	    UNIQUE(lexiconId),
+	    FOREIGN KEY (lexiconId) REFERENCES Lexicon(id) ON DELETE CASCADE);`
+
+const mariaDBDropTableStmt = `DROP TABLE IF EXISTS SchemaVersion, FrozenLexicon, ImportBatchEntry, ImportBatch, Assignment, EntryProposal, ReplicationState, ChangeEvent, MissedWord, EntryUsage, LexiconProperty, LexiconMeta, EntryRelation, EntryComment, Lemma2Entry, Lemma, Transcription, EntryTag, EntryValidation, EntryStatus, Entry, Lexicon;`
 
 var MariaDBSchema = []string{
 	`CREATE TABLE SchemaVersion (name text not null);`,
 
-	`INSERT INTO SchemaVersion VALUES (` + SchemaVersion + `);`,
+	`INSERT INTO SchemaVersion VALUES ('` + SchemaVersion + `');`,
 
 	`CREATE TABLE Lexicon (
 	    name varchar(128) not null,
@@ -51,6 +179,9 @@ var MariaDBSchema = []string{
 	    partOfSpeech varchar(128),
 	    morphology varchar(128),
 	    preferred integer not null default 0, -- TODO Why doesn't it work when changing integer -> boolean?
+	    -- Lookup forms of strn for case-insensitive and diacritic-insensitive lookup (see normalisation.go)
+	    strnFold text not null default '',
+	    strnBase text not null default '',
 	    foreign key fk_3  (lexiconId) references Lexicon(id));`,
 
 	`CREATE INDEX language on Entry (language);`,
@@ -60,6 +191,8 @@ var MariaDBSchema = []string{
 	`CREATE INDEX strnlangue on Entry (strn(255),language);`,
 	`CREATE INDEX estrnpref on Entry (strn(255),preferred);`,
 	`CREATE INDEX idid on Entry (id, lexiconId);`,
+	`CREATE INDEX entrystrnfold on Entry (strnFold(255));`,
+	`CREATE INDEX entrystrnbase on Entry (strnBase(255));`,
 
 	`-- Entry tag is a string used to distinguish between homographs.
 	-- Unique for an entry of a specific word form, but not for different
@@ -161,6 +294,41 @@ var MariaDBSchema = []string{
 	`CREATE UNIQUE INDEX l2euind on Lemma2Entry (lemmaId,entryId);`,
 	`CREATE UNIQUE INDEX idx46cf073d on Lemma2Entry (entryId);`,
 
+	entryRelationTableMariaDB,
+
+	`CREATE INDEX IF NOT EXISTS erfrom ON EntryRelation (fromEntryId);`,
+	`CREATE INDEX IF NOT EXISTS erto ON EntryRelation (toEntryId);`,
+	`CREATE INDEX IF NOT EXISTS ertype ON EntryRelation (type);`,
+
+	lexiconMetaTableMariaDB,
+
+	lexiconPropertyTableMariaDB,
+
+	entryUsageTableMariaDB,
+	`CREATE INDEX IF NOT EXISTS euhits ON EntryUsage (hits);`,
+
+	missedWordTableMariaDB,
+	`CREATE INDEX IF NOT EXISTS mwhits ON MissedWord (hits);`,
+
+	changeEventTableMariaDB,
+	`CREATE INDEX IF NOT EXISTS celexiconname ON ChangeEvent (lexiconName, seq);`,
+
+	replicationStateTableMariaDB,
+
+	entryProposalTableMariaDB,
+	`CREATE INDEX IF NOT EXISTS epentrystatus ON EntryProposal (entryId, status);`,
+
+	assignmentTableMariaDB,
+	`CREATE INDEX IF NOT EXISTS asgassigneestatus ON Assignment (assignee, status);`,
+	`CREATE INDEX IF NOT EXISTS asgentrystatus ON Assignment (entryId, status);`,
+
+	importBatchTableMariaDB,
+	importBatchEntryTableMariaDB,
+	`CREATE INDEX IF NOT EXISTS ibebatch ON ImportBatchEntry (batchId);`,
+	`CREATE INDEX IF NOT EXISTS ibeentry ON ImportBatchEntry (entryId);`,
+
+	frozenLexiconTableMariaDB,
+
 	/* TODO: Triggers removed for now. Triggers compile, but give runtime error
 
 	   	`-- Triggers to ensure only one preferred = 1 per orthographic word
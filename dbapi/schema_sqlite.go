@@ -1,5 +1,140 @@
 package dbapi
 
+// The tables added after schema version 3.1 are defined separately, so that they can also be created when migrating an older database (see addTables)
+
+const entryRelationTableSqlite = `-- Typed, directed relations between entries (e.g., 'abbreviation_of', 'compound_part').
+-- The related entries may belong to different lexicons in the same database.
+CREATE TABLE IF NOT EXISTS EntryRelation (
+    id integer not null primary key autoincrement,
+    type varchar(128) not null,
+    fromEntryId integer not null,
+    toEntryId integer not null,
+    position integer not null default 0,
+    Timestamp DATETIME DEFAULT CURRENT_TIMESTAMP not null,
+    unique(fromEntryId,toEntryId,type),
+foreign key (fromEntryId) references Entry(id) on delete cascade,
+foreign key (toEntryId) references Entry(id) on delete cascade);
+CREATE INDEX IF NOT EXISTS erfrom ON EntryRelation (fromEntryId);
+CREATE INDEX IF NOT EXISTS erto ON EntryRelation (toEntryId);
+CREATE INDEX IF NOT EXISTS ertype ON EntryRelation (type);`
+
+const lexiconMetaTablesSqlite = `-- Descriptive meta data for each lexicon (description, license, provenance, owner).
+-- One row per lexicon, created along with the lexicon.
+CREATE TABLE IF NOT EXISTS LexiconMeta (
+    lexiconId integer not null,
+    description text not null default '',
+    license text not null default '',
+    sourceUrl text not null default '',
+    sourceVersion varchar(128) not null default '',
+    owner varchar(128) not null default '',
+    created DATETIME DEFAULT CURRENT_TIMESTAMP not null,
+    modified DATETIME DEFAULT CURRENT_TIMESTAMP not null,
+    unique(lexiconId),
+foreign key (lexiconId) references Lexicon(id) on delete cascade);
+-- Free key-value pairs of lexicon meta data
+CREATE TABLE IF NOT EXISTS LexiconProperty (
+    lexiconId integer not null,
+    name varchar(128) not null,
+    value text not null,
+    unique(lexiconId,name),
+foreign key (lexiconId) references Lexicon(id) on delete cascade);`
+
+const usageTablesSqlite = `-- Aggregated lookup hits per entry (see dbapi.UsageRecorder)
+CREATE TABLE IF NOT EXISTS EntryUsage (
+    entryId integer not null,
+    hits integer not null default 0,
+    lastHit DATETIME DEFAULT CURRENT_TIMESTAMP not null,
+    unique(entryId),
+foreign key (entryId) references Entry(id) on delete cascade);
+CREATE INDEX IF NOT EXISTS euhits ON EntryUsage (hits);
+-- Aggregated lookups of words not found in a lexicon (see dbapi.UsageRecorder)
+CREATE TABLE IF NOT EXISTS MissedWord (
+    lexiconId integer not null,
+    strn varchar(255) not null,
+    hits integer not null default 0,
+    lastHit DATETIME DEFAULT CURRENT_TIMESTAMP not null,
+    unique(lexiconId,strn),
+foreign key (lexiconId) references Lexicon(id) on delete cascade);
+CREATE INDEX IF NOT EXISTS mwhits ON MissedWord (hits);`
+
+const changeEventTableSqlite = `-- Log of lexicon modifications, for the change feed (see dbapi.ChangeEvent). There are no foreign keys, since events for deleted entries and lexicons are kept.
+CREATE TABLE IF NOT EXISTS ChangeEvent (
+    seq integer not null primary key autoincrement,
+    lexiconName varchar(128) not null,
+    type varchar(32) not null,
+    entryId integer,
+    timestamp varchar(32) not null);
+CREATE INDEX IF NOT EXISTS celexiconname ON ChangeEvent (lexiconName, seq);`
+
+const replicationStateTableSqlite = `-- Position in the change feed of the primary database, for databases replicated from another database (see dbapi.ApplyChanges)
+CREATE TABLE IF NOT EXISTS ReplicationState (
+    source varchar(255) not null primary key,
+    seq integer not null,
+    modified varchar(32) not null);`
+
+const entryProposalTableSqlite = `-- Proposed entry updates, pending review (see dbapi.Proposal). Base and proposed are JSON encoded lex.Entry objects.
+CREATE TABLE IF NOT EXISTS EntryProposal (
+    id integer not null primary key autoincrement,
+    entryId integer not null,
+    status varchar(32) not null,
+    proposer varchar(128) not null,
+    comment text not null default '',
+    base text not null,
+    proposed text not null,
+    reviewer varchar(128) not null default '',
+    reviewComment text not null default '',
+    created varchar(32) not null,
+    modified varchar(32) not null,
+foreign key (entryId) references Entry(id) on delete cascade);
+CREATE INDEX IF NOT EXISTS epentrystatus ON EntryProposal (entryId, status);`
+
+const assignmentTableSqlite = `-- Entries assigned to users for review (see dbapi.Assignment)
+CREATE TABLE IF NOT EXISTS Assignment (
+    id integer not null primary key autoincrement,
+    entryId integer not null,
+    assignee varchar(128) not null,
+    assignedBy varchar(128) not null default '',
+    priority integer not null default 0,
+    due varchar(32) not null default '',
+    comment text not null default '',
+    status varchar(32) not null,
+    created varchar(32) not null,
+    closed varchar(32) not null default '',
+    closedBy varchar(128) not null default '',
+    closedStatus varchar(128) not null default '',
+foreign key (entryId) references Entry(id) on delete cascade);
+CREATE INDEX IF NOT EXISTS asgassigneestatus ON Assignment (assignee, status);
+CREATE INDEX IF NOT EXISTS asgentrystatus ON Assignment (entryId, status);`
+
+const importBatchTablesSqlite = `-- Import runs (see dbapi.ImportBatch), and the entries created by each run. There is no foreign key on entryId, so that entries deleted after the import can be reported.
+CREATE TABLE IF NOT EXISTS ImportBatch (
+    id integer not null primary key autoincrement,
+    lexiconId integer not null,
+    fileName text not null default '',
+    checksum varchar(64) not null default '',
+    importedBy varchar(128) not null default '',
+    created varchar(32) not null,
+    entryCount integer not null default 0,
+    undone varchar(32) not null default '',
+foreign key (lexiconId) references Lexicon(id) on delete cascade);
+CREATE TABLE IF NOT EXISTS ImportBatchEntry (
+    batchId integer not null,
+    entryId integer not null,
+    lineNumber integer not null,
+    fingerprint varchar(64) not null,
+foreign key (batchId) references ImportBatch(id) on delete cascade);
+CREATE INDEX IF NOT EXISTS ibebatch ON ImportBatchEntry (batchId);
+CREATE INDEX IF NOT EXISTS ibeentry ON ImportBatchEntry (entryId);`
+
+const frozenLexiconTableSqlite = `-- Frozen (read-only) lexicons (see dbapi.FreezeLexicon)
+CREATE TABLE IF NOT EXISTS FrozenLexicon (
+    lexiconId integer not null,
+    frozenBy varchar(128) not null default '',
+    comment text not null default '',
+    created varchar(32) not null,
+    unique(lexiconId),
+foreign key (lexiconId) references Lexicon(id) on delete cascade);`
+
 // SqliteSchema is a string containing the SQL definition of the lexicon database
 const SqliteSchema = `
 
@@ -9,7 +144,7 @@ const SqliteSchema = `
 -- To keep track of the version of this schema
 CREATE TABLE SchemaVersion (name varchar(255) not null);
 
-INSERT INTO SchemaVersion VALUES (` + SchemaVersion + `);
+INSERT INTO SchemaVersion VALUES ('` + SchemaVersion + `');
 
 -- Each lexical entry belongs to a lexicon.
 -- The Lexicon table defines a lexicon through a unique name, along with the name a of symbol set and a locale
@@ -70,6 +205,9 @@ CREATE TABLE Entry (
     partOfSpeech varchar(128),
     morphology varchar(128),
     preferred integer not null default 0, -- TODO Why doesn't it work when changing integer -> boolean? 
+    -- Lookup forms of strn for case-insensitive and diacritic-insensitive lookup (see normalisation.go)
+    strnFold text not null default '',
+    strnBase text not null default '',
 foreign key (lexiconId) references Lexicon(id));
 CREATE INDEX idx28d70584 on Entry (language);
 CREATE INDEX idx15890407 on Entry (strn);
@@ -78,6 +216,8 @@ CREATE INDEX entrypref ON Entry (preferred);
 CREATE INDEX idx4a250778 on Entry (strn,language);
 CREATE INDEX estrnpref on Entry (strn,preferred);
 CREATE INDEX idid on Entry (id, lexiconId);
+CREATE INDEX entrystrnfold on Entry (strnFold);
+CREATE INDEX entrystrnbase on Entry (strnBase);
 
 
 -- CREATE TABLE Tag (
@@ -197,6 +337,24 @@ CREATE INDEX l2eind2 on Lemma2Entry (lemmaId);
 CREATE UNIQUE INDEX l2euind on Lemma2Entry (lemmaId,entryId);
 CREATE UNIQUE INDEX idx46cf073d on Lemma2Entry (entryId);
 
+` + entryRelationTableSqlite + `
+
+` + lexiconMetaTablesSqlite + `
+
+` + usageTablesSqlite + `
+
+` + changeEventTableSqlite + `
+
+` + replicationStateTableSqlite + `
+
+` + entryProposalTableSqlite + `
+
+` + assignmentTableSqlite + `
+
+` + importBatchTablesSqlite + `
+
+` + frozenLexiconTableSqlite + `
+
 -- CREATE TABLE SurfaceForm2Entry (
 --    entryId bigint not null,
 --    surfaceFormId bigint not null,
@@ -4,6 +4,8 @@ import (
 	"strconv"
 	"strings"
 
+	"golang.org/x/text/unicode/norm"
+
 	"github.com/stts-se/pronlex/lex"
 )
 
@@ -103,8 +105,10 @@ func words(lexNames []lex.LexName, q Query) (string, []interface{}) {
 		return "", resv
 	} //else {
 	if len(q.Words) > 0 {
-		reses = append(reses, "Entry.strn in "+nQs(len(q.Words)))
-		resv = append(resv, convS(ToLower(q.Words))...)
+		reses = append(reses, q.wordColumn()+" in "+nQs(len(q.Words)))
+		for _, w := range q.Words {
+			resv = append(resv, q.WordKey(w))
+		}
 	}
 	if len(q.WordParts) > 0 {
 		reses = append(reses, "Entry.wordParts in "+nQs(len(q.WordParts)))
@@ -123,8 +127,12 @@ func words(lexNames []lex.LexName, q Query) (string, []interface{}) {
 		resv = append(resv, convI(q.EntryIDs)...)
 	}
 	if trm(q.WordLike) != "" {
-		reses = append(reses, "Entry.strn like ?")
-		resv = append(resv, q.WordLike)
+		reses = append(reses, q.wordColumn()+" like ?")
+		if q.IgnoreCase || q.IgnoreDiacritics {
+			resv = append(resv, q.WordKey(q.WordLike))
+		} else {
+			resv = append(resv, norm.NFC.String(q.WordLike))
+		}
 	}
 	if trm(q.WordRegexp) != "" {
 		reses = append(reses, "Entry.strn REGEXP ?")
@@ -355,7 +363,7 @@ WHERE Entry.id = Transcription.entryId AND Entry.lexiconId = Lexicon.id` // Entr
 // Queries db for all entries with transcriptions and optional lemma forms.
 var baseSQLSelect = "SELECT Lexicon.name, Entry.id, Entry.strn, Entry.language, Entry.partOfSpeech, Entry.morphology, Entry.wordParts, Entry.preferred, Transcription.id, Transcription.entryId, Transcription.strn, Transcription.language, Transcription.sources, Lemma.id, Lemma.strn, Lemma.reading, Lemma.paradigm, EntryTag.tag, EntryStatus.id, EntryStatus.name, EntryStatus.source, EntryStatus.timestamp, EntryStatus.current, EntryValidation.id, EntryValidation.level, EntryValidation.name, EntryValidation.message, EntryValidation.timestamp, EntryComment.id, EntryComment.label, EntryComment.source, EntryComment.comment " + baseSQLFrom
 
-//var baseSQLCount = `SELECT count(distinct Entry.id) ` + baseSQLFrom
+var baseSQLCount = `SELECT count(distinct Entry.id) ` + baseSQLFrom
 
 var baseSQLSelectIds = `SELECT distinct Entry.id ` + baseSQLFrom
 
@@ -424,7 +432,12 @@ func selectEntriesSQL(lexNames []lex.LexName, q Query) sqlStmt {
 	sqlQuery, args := appendQuery(baseSQLSelect, lexNames, q)
 
 	// sort by id to make sql rows -> Entry simpler
-	sqlQuery += " ORDER BY Entry.id, Transcription.id"
+	if q.OrderByUsage {
+		// the rows of each entry are still kept together, since entry id is the secondary sort key
+		sqlQuery += " ORDER BY COALESCE((SELECT EntryUsage.hits FROM EntryUsage WHERE EntryUsage.entryId = Entry.id), 0) DESC, Entry.id, Transcription.id"
+	} else {
+		sqlQuery += " ORDER BY Entry.id, Transcription.id"
+	}
 
 	// When both PageLength and Page values are zero, no page limit is used
 	// This is useful for example when exporting a complete lexicon
@@ -446,12 +459,10 @@ func selectEntryIdsSQL(lexNames []lex.LexName, q Query) sqlStmt {
 // CountEntriesSQL creates a SQL query string based on the values of
 // a Query struct instance, along with a slice of values,
 // corresponding to the params to be set (the '?':s of the query)
-/*
 func countEntriesSQL(lexNames []lex.LexName, q Query) sqlStmt {
 	sqlQuery, args := appendQuery(baseSQLCount, lexNames, q)
 	return sqlStmt{sql: sqlQuery, values: args}
 }
-*/
 
 // // entriesFromIdsSelect builds an sql select and returns it along with slice of matching id values
 // func entriesFromIdsSelect(ids []int64) (string, []interface{}) {
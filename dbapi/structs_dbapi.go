@@ -30,6 +30,10 @@ type Query struct {
 	WordLike   string `json:"wordLike"`
 	WordRegexp string `json:"wordRegexp"`
 
+	// Lookup modes for Words and WordLike (see FoldCase and RemoveDiacritics). IgnoreDiacritics also ignores case.
+	IgnoreCase       bool `json:"ignoreCase"`
+	IgnoreDiacritics bool `json:"ignoreDiacritics"`
+
 	WordParts       []string `json:"wordParts"`
 	WordPartsLike   string   `json:"wordPartsLike"`
 	WordPartsRegexp string   `json:"wordPartsRegexp"`
@@ -77,6 +81,12 @@ type Query struct {
 
 	MultipleTags bool `json:"multipleTags"`
 
+	// Not a search criterion: if true, the relations of each matching entry are included in the result, along with the related entries
+	IncludeRelated bool `json:"includeRelated"`
+
+	// Not a search criterion: if true, the result is sorted by the number of recorded lookup hits (see UsageRecorder), most used entries first
+	OrderByUsage bool `json:"orderByUsage"`
+
 	// // Search for Entries with EntryValidations with the listed
 	// // validation rule names (such as 'Decomp2Orth', etc)
 	// EntryValidations []string `json:"entryValidations"`
@@ -209,11 +219,39 @@ type LexStats struct {
 	LatestUpdatesPerSource LatestUpdatesPerSource
 }
 
-// QueryStats holds the result of a call to the dbapi.LexiconStats function.
-// TODO add fields for additional stats
+// LexiconMeta holds descriptive meta data for a lexicon: description, license, provenance, owner and free key-value pairs (Properties).
+// Created and Modified are set by the database, and cannot be changed using DBManager.SetLexiconMeta. Modified is the latest of the meta data update time and the latest entry update time. Both are formatted as RFC3339 timestamps (UTC).
+type LexiconMeta struct {
+	Description   string            `json:"description"`
+	License       string            `json:"license"`
+	SourceURL     string            `json:"sourceUrl"`
+	SourceVersion string            `json:"sourceVersion"`
+	Owner         string            `json:"owner"`
+	Created       string            `json:"created"`
+	Modified      string            `json:"modified"`
+	Properties    map[string]string `json:"properties"`
+}
+
+// FacetCount is the number of entries with a certain value (e.g., a certain part of speech) in a QueryStats facet
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// QueryStats holds the result of a call to the DBManager.QueryStats function: the number of entries matching a query, and facet counts for the matching entries.
+// Each facet is sorted by count (descending), then by value. An entry may be counted for more than one value of the validation and comment facets, and entries without a value for a facet (e.g., entries without comments) are not counted in that facet.
 type QueryStats struct {
 	Query   Query `json:"query"`
 	Entries int64 `json:"entries"`
+
+	// Facets
+	Statuses         []FacetCount `json:"statuses"`
+	StatusSources    []FacetCount `json:"statusSources"`
+	PartsOfSpeech    []FacetCount `json:"partsOfSpeech"`
+	Languages        []FacetCount `json:"languages"`
+	ValidationLevels []FacetCount `json:"validationLevels"`
+	ValidationRules  []FacetCount `json:"validationRules"`
+	CommentLabels    []FacetCount `json:"commentLabels"`
 }
 
 // LatestUpdatesPerSource holds the latest status timestamp per source
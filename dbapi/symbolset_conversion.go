@@ -0,0 +1,267 @@
+package dbapi
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/symbolset/mapper"
+)
+
+// SymbolSetConversionRuleName is the rule name of the validation messages added to entries with transcriptions that could not be mapped by DBManager.ConvertSymbolSet
+const SymbolSetConversionRuleName = "SymbolSetConversion"
+
+// SymbolSetConversionResult holds the result of a call to DBManager.ConvertSymbolSet
+type SymbolSetConversionResult struct {
+	Lexicon       string `json:"lexicon"`
+	FromSymbolSet string `json:"fromSymbolSet"`
+	ToSymbolSet   string `json:"toSymbolSet"`
+	// Total number of entries processed
+	Entries int `json:"entries"`
+	// Number of transcriptions that were changed by the mapping
+	ConvertedTranscriptions int `json:"convertedTranscriptions"`
+	// Number of entries with at least one transcription that could not be mapped
+	FailedEntries int `json:"failedEntries"`
+}
+
+func symbolSetConversionValidation(t lex.Transcription, err error) lex.EntryValidation {
+	return lex.EntryValidation{
+		Level:    "Fatal",
+		RuleName: SymbolSetConversionRuleName,
+		Message:  fmt.Sprintf("Couldn't map transcription /%s/ : %v", t.Strn, err),
+	}
+}
+
+// The SQL for symbol set conversion is the same for Sqlite and MariaDB, so the functions below are shared by both DBIF implementations. The entries are read using the DBIF lookup functions, in chunks, like in validate.
+
+var symbolSetConversionChunkSize = 500
+
+// convertSymbolSetInPlace maps all transcriptions of the lexicon, and updates the lexicon's symbol set name, in a single transaction. Entry ids, and all other entry data, including status history, are kept as is. Transcriptions that cannot be mapped are left unchanged, and a validation message is added to the entry.
+func convertSymbolSetInPlace(dbif DBIF, db *sql.DB, lexName lex.LexName, m mapper.Mapper) (SymbolSetConversionResult, error) {
+	start := time.Now()
+	res := SymbolSetConversionResult{Lexicon: string(lexName), FromSymbolSet: m.SymbolSet1.Name, ToSymbolSet: m.SymbolSet2.Name}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return res, fmt.Errorf("convertSymbolSetInPlace failed to start db transaction : %v", err)
+	}
+	defer tx.Commit()
+
+	rollback := func(msg string) error {
+		err2 := tx.Rollback()
+		if err2 != nil {
+			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
+		}
+		return errors.New(msg)
+	}
+
+	l, err := dbif.getLexiconTx(tx, string(lexName))
+	if err != nil {
+		return res, rollback(fmt.Sprintf("convertSymbolSetInPlace failed to get lexicon : %v", err))
+	}
+	err = checkNotFrozen(tx, l.id)
+	if err != nil {
+		err2 := tx.Rollback()
+		if err2 != nil {
+			return res, fmt.Errorf("%w : rollback failed : %v", err, err2)
+		}
+		return res, err
+	}
+	if l.symbolSetName != m.SymbolSet1.Name {
+		return res, rollback(fmt.Sprintf("convertSymbolSetInPlace: lexicon '%s' has symbol set '%s', but the mapper is defined for '%s'", l.name, l.symbolSetName, m.SymbolSet1.Name))
+	}
+
+	// Messages from a previous (failed) conversion are no longer relevant
+	_, err = tx.Exec("DELETE FROM EntryValidation WHERE name = ? AND entryId IN (SELECT id FROM Entry WHERE lexiconId = ?)", SymbolSetConversionRuleName, l.id)
+	if err != nil {
+		return res, rollback(fmt.Sprintf("convertSymbolSetInPlace failed to delete old validation messages : %v", err))
+	}
+
+	ids, err := dbif.lookUpIdsTx(tx, []lex.LexName{lexName}, Query{})
+	if err != nil {
+		return res, rollback(fmt.Sprintf("convertSymbolSetInPlace failed to lookup entry ids : %v", err))
+	}
+
+	for i := 0; i < len(ids); i += symbolSetConversionChunkSize {
+		end := i + symbolSetConversionChunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var w lex.EntrySliceWriter
+		err = dbif.lookUpTx(tx, []lex.LexName{}, Query{EntryIDs: ids[i:end]}, &w)
+		if err != nil {
+			return res, rollback(fmt.Sprintf("convertSymbolSetInPlace failed to lookup entries : %v", err))
+		}
+		for _, e := range w.Entries {
+			res.Entries++
+			var vals []lex.EntryValidation
+			for _, t := range e.Transcriptions {
+				newT, err := m.MapTranscription(t.Strn)
+				if err != nil {
+					vals = append(vals, symbolSetConversionValidation(t, err))
+					continue
+				}
+				if newT == t.Strn {
+					continue
+				}
+				_, err = tx.Exec("UPDATE Transcription SET strn = ? WHERE id = ?", newT, t.ID)
+				if err != nil {
+					return res, rollback(fmt.Sprintf("convertSymbolSetInPlace failed to update transcription : %v", err))
+				}
+				res.ConvertedTranscriptions++
+			}
+			if len(vals) > 0 {
+				res.FailedEntries++
+				err = dbif.insertEntryValidations(tx, e, vals)
+				if err != nil {
+					return res, err
+				}
+			}
+		}
+	}
+
+	_, err = tx.Exec("UPDATE Lexicon SET symbolSetName = ? WHERE id = ?", m.SymbolSet2.Name, l.id)
+	if err != nil {
+		return res, rollback(fmt.Sprintf("convertSymbolSetInPlace failed to update lexicon symbol set : %v", err))
+	}
+
+	log.Printf("dbapi/symbolset_conversion.go convertSymbolSetInPlace took %v\n", time.Since(start))
+	return res, nil
+}
+
+// convertSymbolSetToNewLexicon copies all entries of the lexicon into a new lexicon with the target symbol set, mapping the transcriptions on the way. The source lexicon is not changed. The copies get new entry ids, and only the current entry status is copied. Transcriptions that cannot be mapped are copied unchanged, and a validation message is added to the new entry.
+func convertSymbolSetToNewLexicon(dbif DBIF, db *sql.DB, lexName lex.LexName, m mapper.Mapper, newLexName lex.LexName) (SymbolSetConversionResult, error) {
+	start := time.Now()
+	res := SymbolSetConversionResult{Lexicon: string(newLexName), FromSymbolSet: m.SymbolSet1.Name, ToSymbolSet: m.SymbolSet2.Name}
+
+	l, err := dbif.getLexicon(db, string(lexName))
+	if err != nil {
+		return res, fmt.Errorf("convertSymbolSetToNewLexicon failed to get lexicon : %v", err)
+	}
+	if l.symbolSetName != m.SymbolSet1.Name {
+		return res, fmt.Errorf("convertSymbolSetToNewLexicon: lexicon '%s' has symbol set '%s', but the mapper is defined for '%s'", l.name, l.symbolSetName, m.SymbolSet1.Name)
+	}
+	locale, err := dbif.locale(db, string(lexName))
+	if err != nil {
+		return res, fmt.Errorf("convertSymbolSetToNewLexicon failed to get locale : %v", err)
+	}
+	ids, err := dbif.lookUpIds(db, []lex.LexName{lexName}, Query{})
+	if err != nil {
+		return res, fmt.Errorf("convertSymbolSetToNewLexicon failed to lookup entry ids : %v", err)
+	}
+
+	newL, err := dbif.defineLexicon(db, lexicon{name: string(newLexName), symbolSetName: m.SymbolSet2.Name, locale: locale})
+	if err != nil {
+		return res, fmt.Errorf("convertSymbolSetToNewLexicon failed to define lexicon '%s' : %v", newLexName, err)
+	}
+
+	// The entries are inserted in chunks, in separate transactions, so the half-filled new lexicon is removed if anything fails
+	cleanup := func(msg string) error {
+		err := removeLexicon(db, newL.id)
+		if err != nil {
+			msg = fmt.Sprintf("%s : failed to remove lexicon '%s' : %v", msg, newLexName, err)
+		}
+		return errors.New(msg)
+	}
+
+	// insertEntries resets the preferred flag of entries with the same orthography in all lexicons, including the source lexicon, so the copies are marked as preferred afterwards
+	var preferred []int64
+
+	for i := 0; i < len(ids); i += symbolSetConversionChunkSize {
+		end := i + symbolSetConversionChunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var w lex.EntrySliceWriter
+		err = dbif.lookUp(db, []lex.LexName{}, Query{EntryIDs: ids[i:end]}, &w)
+		if err != nil {
+			return res, cleanup(fmt.Sprintf("convertSymbolSetToNewLexicon failed to lookup entries : %v", err))
+		}
+		pref := make([]bool, len(w.Entries))
+		for j, e := range w.Entries {
+			res.Entries++
+			pref[j] = e.Preferred
+			var vals []lex.EntryValidation
+			var ts []lex.Transcription
+			for _, t := range e.Transcriptions {
+				newT, err := m.MapTranscription(t.Strn)
+				if err != nil {
+					vals = append(vals, symbolSetConversionValidation(t, err))
+					newT = t.Strn
+				} else if newT != t.Strn {
+					res.ConvertedTranscriptions++
+				}
+				ts = append(ts, lex.Transcription{Strn: newT, Language: t.Language, Sources: t.Sources})
+			}
+			if len(vals) > 0 {
+				res.FailedEntries++
+			}
+			e.ID = 0
+			e.Transcriptions = ts
+			e.EntryValidations = vals
+			e.Preferred = false
+			w.Entries[j] = e
+		}
+		newIDs, err := dbif.insertEntries(db, newL, w.Entries)
+		if err != nil {
+			return res, cleanup(fmt.Sprintf("convertSymbolSetToNewLexicon failed to insert entries : %v", err))
+		}
+		for j, id := range newIDs {
+			if pref[j] {
+				preferred = append(preferred, id)
+			}
+		}
+	}
+	err = setPreferred(db, preferred)
+	if err != nil {
+		return res, cleanup(fmt.Sprintf("convertSymbolSetToNewLexicon failed to set preferred entries : %v", err))
+	}
+
+	log.Printf("dbapi/symbolset_conversion.go convertSymbolSetToNewLexicon took %v\n", time.Since(start))
+	return res, nil
+}
+
+// setPreferred sets the preferred flag of the entries, without touching other entries
+func setPreferred(db *sql.DB, entryIDs []int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("setPreferred failed to start db transaction : %v", err)
+	}
+	defer tx.Commit()
+	for _, id := range entryIDs {
+		_, err = tx.Exec("UPDATE Entry SET preferred = 1 WHERE id = ?", id)
+		if err != nil {
+			msg := fmt.Sprintf("setPreferred failed to update entry : %v", err)
+			err2 := tx.Rollback()
+			if err2 != nil {
+				msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
+			}
+			return errors.New(msg)
+		}
+	}
+	return nil
+}
+
+// removeLexicon deletes the lexicon and all its entries. Only used to clean up after a failed conversion, since entries are normally never deleted together with their lexicon.
+func removeLexicon(db *sql.DB, lexiconID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("removeLexicon failed to start db transaction : %v", err)
+	}
+	defer tx.Commit()
+	for _, q := range []string{"DELETE FROM Entry WHERE lexiconId = ?", "DELETE FROM Lexicon WHERE id = ?"} {
+		_, err = tx.Exec(q, lexiconID)
+		if err != nil {
+			msg := fmt.Sprintf("removeLexicon failed to delete : %v", err)
+			err2 := tx.Rollback()
+			if err2 != nil {
+				msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
+			}
+			return errors.New(msg)
+		}
+	}
+	return nil
+}
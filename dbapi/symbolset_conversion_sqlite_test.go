@@ -0,0 +1,165 @@
+package dbapi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/symbolset/mapper"
+)
+
+func TestConvertSymbolSetSqlite(t *testing.T) {
+	dbRef := lex.DBRef("symbolsetconversion_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	m, err := mapper.LoadMapperFromFile("SYMBOL", "SYMBOL", "./test_data/sv-se_ws-sampa.sym", "./test_data/sv-se_sampa_mary.sym")
+	if err != nil {
+		t.Fatalf("failed to load mapper : %v", err)
+	}
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	katt := newTestEntry("katt", `" k a t`)
+	katt.Preferred = true
+	ids, err := dbm.InsertEntries(lexRef, []lex.Entry{
+		katt,
+		newTestEntry("sjal", `"" x A: . l a`),
+		newTestEntry("kqt", `" k Q t`),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	// the mapper must match the lexicon's current symbol set
+	wrongLexRef := lex.NewLexRef(string(dbRef), "lex2")
+	err = dbm.DefineLexicon(wrongLexRef, "sv-se_nst-xsampa", "sv_SE")
+	if err != nil {
+		t.Fatalf("failed to define lexicon : %v", err)
+	}
+	_, err = dbm.ConvertSymbolSet(wrongLexRef, m, "")
+	if err == nil {
+		t.Errorf("expected error for mismatching symbol set")
+	}
+
+	// convert into a new lexicon
+	res, err := dbm.ConvertSymbolSet(lexRef, m, "lex1_mary")
+	if err != nil {
+		t.Fatalf("failed to convert symbol set : %v", err)
+	}
+	if w, g := 3, res.Entries; w != g {
+		t.Errorf(fs, w, g)
+	}
+	if w, g := 1, res.FailedEntries; w != g {
+		t.Errorf(fs, w, g)
+	}
+	newLex, err := dbm.GetLexicon(lex.NewLexRef(string(dbRef), "lex1_mary"))
+	if err != nil {
+		t.Fatalf("failed to get new lexicon : %v", err)
+	}
+	if w, g := "sv-se_sampa_mary", newLex.SymbolSetName; w != g {
+		t.Errorf(fs, w, g)
+	}
+	oldLex, err := dbm.GetLexicon(lexRef)
+	if err != nil {
+		t.Fatalf("failed to get lexicon : %v", err)
+	}
+	if w, g := "sv-se_ws-sampa", oldLex.SymbolSetName; w != g {
+		t.Errorf(fs, w, g)
+	}
+	// the preferred flag is copied, and kept in the source lexicon
+	for _, ref := range []lex.LexRef{lexRef, lex.NewLexRef(string(dbRef), "lex1_mary")} {
+		es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{ref}, Query: Query{Words: []string{"katt"}}})
+		if err != nil {
+			t.Fatalf("failed to lookup entry : %v", err)
+		}
+		if w, g := 1, len(es); w != g {
+			t.Fatalf(fs, w, g)
+		}
+		if !es[0].Preferred {
+			t.Errorf("expected preferred entry in %s", ref.LexName)
+		}
+	}
+
+	// convert in place
+	res, err = dbm.ConvertSymbolSet(lexRef, m, "")
+	if err != nil {
+		t.Fatalf("failed to convert symbol set : %v", err)
+	}
+	if w, g := 3, res.Entries; w != g {
+		t.Errorf(fs, w, g)
+	}
+	if w, g := 2, res.ConvertedTranscriptions; w != g {
+		t.Errorf(fs, w, g)
+	}
+	if w, g := 1, res.FailedEntries; w != g {
+		t.Errorf(fs, w, g)
+	}
+	oldLex, err = dbm.GetLexicon(lexRef)
+	if err != nil {
+		t.Fatalf("failed to get lexicon : %v", err)
+	}
+	if w, g := "sv-se_sampa_mary", oldLex.SymbolSetName; w != g {
+		t.Errorf(fs, w, g)
+	}
+
+	for i, id := range ids {
+		es, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{EntryIDs: []int64{id}}})
+		if err != nil {
+			t.Fatalf("failed to lookup entry : %v", err)
+		}
+		if w, g := 1, len(es); w != g {
+			t.Fatalf(fs, w, g)
+		}
+		e := es[0]
+		switch i {
+		case 0:
+			if w, g := `' k a t`, e.Transcriptions[0].Strn; w != g {
+				t.Errorf(fs, w, g)
+			}
+		case 1:
+			if w, g := `" S A: - l a`, e.Transcriptions[0].Strn; w != g {
+				t.Errorf(fs, w, g)
+			}
+		case 2:
+			// unchanged, with a validation message
+			if w, g := `" k Q t`, e.Transcriptions[0].Strn; w != g {
+				t.Errorf(fs, w, g)
+			}
+			if w, g := 1, len(e.EntryValidations); w != g {
+				t.Fatalf(fs, w, g)
+			}
+			if w, g := SymbolSetConversionRuleName, e.EntryValidations[0].RuleName; w != g {
+				t.Errorf(fs, w, g)
+			}
+		}
+		// status history is kept
+		if w, g := "imported", e.EntryStatus.Name; w != g {
+			t.Errorf(fs, w, g)
+		}
+	}
+
+	// the mapper no longer matches the converted lexicon
+	_, err = dbm.ConvertSymbolSet(lexRef, m, "")
+	if err == nil || !strings.Contains(err.Error(), "sv-se_sampa_mary") {
+		t.Errorf("expected error for mismatching symbol set, got %v", err)
+	}
+
+	// a failed conversion into a new lexicon doesn't leave the new lexicon behind
+	m2, err := mapper.LoadMapperFromFile("SYMBOL", "SYMBOL", "./test_data/sv-se_sampa_mary.sym", "./test_data/sv-se_ws-sampa.sym")
+	if err != nil {
+		t.Fatalf("failed to load mapper : %v", err)
+	}
+	_, err = dbm.dbs[dbRef].Exec("DROP TABLE EntryValidation")
+	if err != nil {
+		t.Fatalf("failed to drop table : %v", err)
+	}
+	_, err = dbm.ConvertSymbolSet(lexRef, m2, "lex1_failed")
+	if err == nil {
+		t.Errorf("expected error for failed conversion")
+	}
+	_, err = dbm.GetLexicon(lex.NewLexRef(string(dbRef), "lex1_failed"))
+	if err == nil {
+		t.Errorf("expected failed conversion to remove the new lexicon")
+	}
+}
@@ -0,0 +1,304 @@
+package dbapi
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// UsageRecorder aggregates lookup hits (per entry) and misses (per lexicon and word) in memory, and flushes them to the database periodically, so that recording does not slow down lookup. The aggregated counts are saved in the EntryUsage and MissedWord tables, and can be listed using DBManager.UsageReport, or used for sorting lookup results (Query.OrderByUsage).
+type UsageRecorder struct {
+	dbm   *DBManager
+	mutex *sync.Mutex
+	// entry id counts, by db
+	hits map[lex.DBRef]map[int64]int64
+	// word counts, by lexicon
+	misses map[lex.LexRef]map[string]int64
+
+	stop chan bool
+	done chan bool
+}
+
+// NewUsageRecorder creates a UsageRecorder, and starts flushing recorded counts to the databases of the DBManager at the specified interval. Call Stop to end the recording.
+func NewUsageRecorder(dbm *DBManager, flushInterval time.Duration) *UsageRecorder {
+	r := &UsageRecorder{
+		dbm:    dbm,
+		mutex:  &sync.Mutex{},
+		hits:   make(map[lex.DBRef]map[int64]int64),
+		misses: make(map[lex.LexRef]map[string]int64),
+		stop:   make(chan bool),
+		done:   make(chan bool),
+	}
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := r.Flush()
+				if err != nil {
+					log.Printf("dbapi.UsageRecorder: %v", err)
+				}
+			case <-r.stop:
+				r.done <- true
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// RecordLookup records the result of a lookup of the words in a query (Query.Words) in a list of lexicons. Each entry in the result matching one of the words is counted as a hit. Each word without any matching entry in the result is counted as a miss in every lexicon searched.
+// Words and entries are matched using the lookup mode of the query (see Query.WordKey).
+func (r *UsageRecorder) RecordLookup(lexRefs []lex.LexRef, q Query, result []lex.Entry) {
+	if len(q.Words) == 0 {
+		return
+	}
+	// lookup key => normalised word
+	wanted := make(map[string]string)
+	for _, w := range q.Words {
+		wanted[q.WordKey(w)] = NormaliseWord(w)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	found := make(map[string]bool)
+	for _, e := range result {
+		k := q.WordKey(e.Strn)
+		if _, ok := wanted[k]; !ok {
+			continue
+		}
+		found[k] = true
+		dbHits, ok := r.hits[e.LexRef.DBRef]
+		if !ok {
+			dbHits = make(map[int64]int64)
+			r.hits[e.LexRef.DBRef] = dbHits
+		}
+		dbHits[e.ID]++
+	}
+	for k, w := range wanted {
+		if found[k] {
+			continue
+		}
+		for _, ref := range lexRefs {
+			lexMisses, ok := r.misses[ref]
+			if !ok {
+				lexMisses = make(map[string]int64)
+				r.misses[ref] = lexMisses
+			}
+			lexMisses[w]++
+		}
+	}
+}
+
+// Flush saves the counts recorded so far to the databases, and resets the in-memory counts
+func (r *UsageRecorder) Flush() error {
+	r.mutex.Lock()
+	hits := r.hits
+	misses := r.misses
+	r.hits = make(map[lex.DBRef]map[int64]int64)
+	r.misses = make(map[lex.LexRef]map[string]int64)
+	r.mutex.Unlock()
+
+	dbRefs := make(map[lex.DBRef]bool)
+	for dbRef := range hits {
+		dbRefs[dbRef] = true
+	}
+	missesByDB := make(map[lex.DBRef]map[lex.LexName]map[string]int64)
+	for ref, ws := range misses {
+		dbRefs[ref.DBRef] = true
+		if _, ok := missesByDB[ref.DBRef]; !ok {
+			missesByDB[ref.DBRef] = make(map[lex.LexName]map[string]int64)
+		}
+		missesByDB[ref.DBRef][ref.LexName] = ws
+	}
+
+	errs := []string{}
+	for dbRef := range dbRefs {
+		err := r.dbm.AddUsage(dbRef, hits[dbRef], missesByDB[dbRef])
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to flush usage counts : %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Stop ends the periodic flushing, and flushes the remaining counts
+func (r *UsageRecorder) Stop() error {
+	r.stop <- true
+	<-r.done
+	return r.Flush()
+}
+
+// AddUsage adds lookup hits (by entry id) and misses (by lexicon name and word) to the usage counts of the specified database. Hits for entries that no longer exist are ignored. Normally called by UsageRecorder.
+func (dbm *DBManager) AddUsage(dbRef lex.DBRef, hits map[int64]int64, misses map[lex.LexName]map[string]int64) error {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[dbRef]
+	if !ok {
+		return fmt.Errorf("DBManager.AddUsage: no such db '%s'", dbRef)
+	}
+	return addUsage(db, hits, misses)
+}
+
+// UsageReport lists the most frequent lookup hits and misses for a lexicon. At most n hits and n misses are listed. Misses for words that have been added to the lexicon since (in any case) are excluded.
+func (dbm *DBManager) UsageReport(lexRef lex.LexRef, n int) (UsageReport, error) {
+	dbm.RLock()
+	defer dbm.RUnlock()
+	db, ok := dbm.dbs[lexRef.DBRef]
+	if !ok {
+		return UsageReport{}, fmt.Errorf("DBManager.UsageReport: no such db '%s'", lexRef.DBRef)
+	}
+	return usageReport(db, lexRef, n)
+}
+
+// UsageHit is a lookup hit count for an entry
+type UsageHit struct {
+	EntryID int64  `json:"entryId"`
+	Strn    string `json:"strn"`
+	Hits    int64  `json:"hits"`
+	LastHit string `json:"lastHit"`
+}
+
+// UsageMiss is a lookup miss count for a word
+type UsageMiss struct {
+	Strn    string `json:"strn"`
+	Hits    int64  `json:"hits"`
+	LastHit string `json:"lastHit"`
+}
+
+// UsageReport holds the result of a call to DBManager.UsageReport
+type UsageReport struct {
+	Lexicon   string      `json:"lexicon"`
+	TopHits   []UsageHit  `json:"topHits"`
+	TopMisses []UsageMiss `json:"topMisses"`
+}
+
+// The SQL for usage counts is the same for Sqlite and MariaDB, so the functions below are called directly by DBManager for both db engines
+
+func addUsage(db *sql.DB, hits map[int64]int64, misses map[lex.LexName]map[string]int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("addUsage failed to start db transaction : %v", err)
+	}
+	defer tx.Commit()
+
+	rollback := func(msg string) error {
+		err2 := tx.Rollback()
+		if err2 != nil {
+			msg = fmt.Sprintf("%s : rollback failed : %v", msg, err2)
+		}
+		return errors.New(msg)
+	}
+
+	for id, n := range hits {
+		res, err := tx.Exec("UPDATE EntryUsage SET hits = hits + ?, lastHit = CURRENT_TIMESTAMP WHERE entryId = ?", n, id)
+		if err != nil {
+			return rollback(fmt.Sprintf("addUsage failed to update entry usage : %v", err))
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return rollback(fmt.Sprintf("addUsage failed to get rows affected : %v", err))
+		}
+		if updated == 0 {
+			// selecting from Entry, to skip deleted entries
+			_, err = tx.Exec("INSERT INTO EntryUsage (entryId, hits) SELECT id, ? FROM Entry WHERE id = ?", n, id)
+			if err != nil {
+				return rollback(fmt.Sprintf("addUsage failed to insert entry usage : %v", err))
+			}
+		}
+	}
+
+	for lexName, ws := range misses {
+		for w, n := range ws {
+			res, err := tx.Exec("UPDATE MissedWord SET hits = hits + ?, lastHit = CURRENT_TIMESTAMP WHERE strn = ? AND lexiconId = (SELECT id FROM Lexicon WHERE name = ?)", n, w, string(lexName))
+			if err != nil {
+				return rollback(fmt.Sprintf("addUsage failed to update missed word : %v", err))
+			}
+			updated, err := res.RowsAffected()
+			if err != nil {
+				return rollback(fmt.Sprintf("addUsage failed to get rows affected : %v", err))
+			}
+			if updated == 0 {
+				_, err = tx.Exec("INSERT INTO MissedWord (lexiconId, strn, hits) SELECT id, ?, ? FROM Lexicon WHERE name = ?", w, n, string(lexName))
+				if err != nil {
+					return rollback(fmt.Sprintf("addUsage failed to insert missed word : %v", err))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func usageReport(db *sql.DB, lexRef lex.LexRef, n int) (UsageReport, error) {
+	res := UsageReport{Lexicon: lexRef.String(), TopHits: []UsageHit{}, TopMisses: []UsageMiss{}}
+
+	rows, err := db.Query("SELECT Entry.id, Entry.strn, EntryUsage.hits, EntryUsage.lastHit FROM EntryUsage, Entry, Lexicon WHERE EntryUsage.entryId = Entry.id AND Entry.lexiconId = Lexicon.id AND Lexicon.name = ? ORDER BY EntryUsage.hits DESC, Entry.strn LIMIT ?", string(lexRef.LexName), n)
+	if err != nil {
+		return res, fmt.Errorf("usageReport failed to list hits : %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		h := UsageHit{}
+		err = rows.Scan(&h.EntryID, &h.Strn, &h.Hits, &h.LastHit)
+		if err != nil {
+			return res, fmt.Errorf("usageReport failed to scan row : %v", err)
+		}
+		res.TopHits = append(res.TopHits, h)
+	}
+	err = rows.Err()
+	if err != nil {
+		return res, fmt.Errorf("usageReport failed to list hits : %v", err)
+	}
+
+	// Misses are compared to the lexicon using Entry.strnFold, so that a miss is excluded when the word has been added with different case. The misses are read in pages, until n misses without entries are found.
+	pageSize := n
+	if pageSize < 100 {
+		pageSize = 100
+	}
+	for offset := 0; len(res.TopMisses) < n; offset += pageSize {
+		ms := []UsageMiss{}
+		rows2, err := db.Query("SELECT MissedWord.strn, MissedWord.hits, MissedWord.lastHit FROM MissedWord, Lexicon WHERE MissedWord.lexiconId = Lexicon.id AND Lexicon.name = ? ORDER BY MissedWord.hits DESC, MissedWord.strn LIMIT ? OFFSET ?", string(lexRef.LexName), pageSize, offset)
+		if err != nil {
+			return res, fmt.Errorf("usageReport failed to list misses : %v", err)
+		}
+		for rows2.Next() {
+			m := UsageMiss{}
+			err = rows2.Scan(&m.Strn, &m.Hits, &m.LastHit)
+			if err != nil {
+				rows2.Close()
+				return res, fmt.Errorf("usageReport failed to scan row : %v", err)
+			}
+			ms = append(ms, m)
+		}
+		rows2.Close()
+		err = rows2.Err()
+		if err != nil {
+			return res, fmt.Errorf("usageReport failed to list misses : %v", err)
+		}
+		for _, m := range ms {
+			var found int
+			err = db.QueryRow("SELECT COUNT(*) FROM Entry, Lexicon WHERE Entry.lexiconId = Lexicon.id AND Lexicon.name = ? AND Entry.strnFold = ?", string(lexRef.LexName), FoldCase(m.Strn)).Scan(&found)
+			if err != nil {
+				return res, fmt.Errorf("usageReport failed to look up missed word : %v", err)
+			}
+			if found == 0 && len(res.TopMisses) < n {
+				res.TopMisses = append(res.TopMisses, m)
+			}
+		}
+		if len(ms) < pageSize {
+			break
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,120 @@
+package dbapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+func TestUsageRecorderSqlite(t *testing.T) {
+	dbRef := lex.DBRef("usage_test")
+	dbm := createTestSqliteDBManager(t, dbRef)
+	defer dbm.CloseDB(dbRef)
+
+	lexRef := lex.NewLexRef(string(dbRef), "lex1")
+	defineTestLexicons(t, dbm, lexRef)
+	ids, err := dbm.InsertEntries(lexRef, []lex.Entry{
+		newTestEntry("hund", `" h u0 n d`),
+		newTestEntry("katt", `" k a t`),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	rec := NewUsageRecorder(dbm, time.Hour)
+	lookUp := func(words ...string) {
+		q := DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{Words: words}}
+		res, err := dbm.LookUpIntoSlice(q)
+		if err != nil {
+			t.Fatalf("lookup failed : %v", err)
+		}
+		rec.RecordLookup(q.LexRefs, q.Query, res)
+	}
+	lookUp("katt", "hund")
+	lookUp("katt", "hundd")
+	lookUp("katt")
+	err = rec.Flush()
+	if err != nil {
+		t.Fatalf("flush failed : %v", err)
+	}
+	lookUp("hundd")
+	err = rec.Stop()
+	if err != nil {
+		t.Fatalf("stop failed : %v", err)
+	}
+
+	rep, err := dbm.UsageReport(lexRef, 10)
+	if err != nil {
+		t.Fatalf("usage report failed : %v", err)
+	}
+	if w, g := 2, len(rep.TopHits); w != g {
+		t.Fatalf(fs, w, g)
+	}
+	if w, g := "katt", rep.TopHits[0].Strn; w != g {
+		t.Errorf(fs, w, g)
+	}
+	if w, g := int64(3), rep.TopHits[0].Hits; w != g {
+		t.Errorf(fs, w, g)
+	}
+	if w, g := 1, len(rep.TopMisses); w != g {
+		t.Fatalf(fs, w, g)
+	}
+	if w, g := "hundd", rep.TopMisses[0].Strn; w != g {
+		t.Errorf(fs, w, g)
+	}
+	if w, g := int64(2), rep.TopMisses[0].Hits; w != g {
+		t.Errorf(fs, w, g)
+	}
+
+	// sort by usage
+	res, err := dbm.LookUpIntoSlice(DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{EntryIDs: ids, OrderByUsage: true}})
+	if err != nil {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	if w, g := 2, len(res); w != g {
+		t.Fatalf(fs, w, g)
+	}
+	if w, g := "katt", res[0].Strn; w != g {
+		t.Errorf(fs, w, g)
+	}
+
+	// a missed word that is added to the lexicon is no longer reported
+	_, err = dbm.InsertEntries(lexRef, []lex.Entry{newTestEntry("hundd", `" h u0 n d`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	rep, err = dbm.UsageReport(lexRef, 10)
+	if err != nil {
+		t.Fatalf("usage report failed : %v", err)
+	}
+	if w, g := 0, len(rep.TopMisses); w != g {
+		t.Errorf(fs, w, g)
+	}
+
+	// also if it is added with different case
+	q := DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: Query{Words: []string{"Älg"}}}
+	rec.RecordLookup(q.LexRefs, q.Query, []lex.Entry{})
+	err = rec.Flush()
+	if err != nil {
+		t.Fatalf("flush failed : %v", err)
+	}
+	rep, err = dbm.UsageReport(lexRef, 10)
+	if err != nil {
+		t.Fatalf("usage report failed : %v", err)
+	}
+	if w, g := 1, len(rep.TopMisses); w != g {
+		t.Fatalf(fs, w, g)
+	}
+	_, err = dbm.InsertEntries(lexRef, []lex.Entry{newTestEntry("älg", `" E l j`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+	rep, err = dbm.UsageReport(lexRef, 10)
+	if err != nil {
+		t.Fatalf("usage report failed : %v", err)
+	}
+	if w, g := 0, len(rep.TopMisses); w != g {
+		t.Errorf(fs, w, g)
+	}
+}
@@ -74,13 +74,11 @@ func processChunk(dbif DBIF, db *sql.DB, chunk []int64, vd validation.Validator,
 
 	err = dbif.updateValidationTx(tx, updated)
 	if err != nil {
-		msg := fmt.Sprintf("couldn't update validation : %v", err)
 		err2 := tx.Rollback()
 		if err2 != nil {
-			msg = fmt.Sprintf("%s : failed rollback : %v", msg, err2)
+			return stats, fmt.Errorf("couldn't update validation : %w : failed rollback : %v", err, err2)
 		}
-
-		return stats, errors.New(msg)
+		return stats, fmt.Errorf("couldn't update validation : %w", err)
 	}
 	err = tx.Commit()
 	if err != nil {
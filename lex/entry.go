@@ -114,6 +114,63 @@ func (ev EntryValidation) String() string {
 	return fmt.Sprintf("%s|%s: %s", ev.Level, ev.RuleName, ev.Message)
 }
 
+// EntryRelation is a typed, directed link from one entry to another. The two entries must live in the same database, but may belong to different lexicons.
+// The Position field can be used to order several relations of the same type from the same entry (e.g., the parts of a compound).
+type EntryRelation struct {
+	ID          int64  `json:"id,omitempty"`
+	Type        string `json:"type"`
+	FromEntryID int64  `json:"fromEntryId"`
+	ToEntryID   int64  `json:"toEntryId"`
+	Position    int64  `json:"position,omitempty"`
+	Timestamp   string `json:"timestamp,omitempty"`
+}
+
+func (r EntryRelation) String() string {
+	return fmt.Sprintf("%d -%s-> %d", r.FromEntryID, r.Type, r.ToEntryID)
+}
+
+// EntryLock is an advisory lock, held by an owner (e.g., an annotator) while editing an entry, or all entries with the same orthography in a lexicon (an orthography group).
+// For entry locks, EntryID is the id of the entry, and Strn its orthography at the time of locking. For orthography group locks, EntryID is 0.
+type EntryLock struct {
+	ID      int64  `json:"id"`
+	LexRef  LexRef `json:"lexRef"`
+	EntryID int64  `json:"entryId,omitempty"`
+	Strn    string `json:"strn"`
+	Owner   string `json:"owner"`
+	// Created and Expires are timestamps in RFC3339 format
+	Created string `json:"created"`
+	Expires string `json:"expires"`
+}
+
+func (l EntryLock) String() string {
+	if l.EntryID != 0 {
+		return fmt.Sprintf("entry %d (%s) in %s, locked by %s until %s", l.EntryID, l.Strn, l.LexRef, l.Owner, l.Expires)
+	}
+	return fmt.Sprintf("orthography group '%s' in %s, locked by %s until %s", l.Strn, l.LexRef, l.Owner, l.Expires)
+}
+
+// Entry relation types
+const (
+	AbbreviationOf    = "abbreviation_of"
+	SpellingVariantOf = "spelling_variant_of"
+	CompoundPart      = "compound_part"
+	InflectedFormOf   = "inflected_form_of"
+	SeeAlso           = "see_also"
+)
+
+// EntryRelationTypes lists the valid values for EntryRelation.Type
+var EntryRelationTypes = []string{AbbreviationOf, SpellingVariantOf, CompoundPart, InflectedFormOf, SeeAlso}
+
+// ValidEntryRelationType returns true if the input string is one of the EntryRelationTypes
+func ValidEntryRelationType(relType string) bool {
+	for _, t := range EntryRelationTypes {
+		if t == relType {
+			return true
+		}
+	}
+	return false
+}
+
 // SourceDelimiter is used to split a string of sevaral sources into a slice
 var SourceDelimiter = " : "
 
@@ -194,6 +251,12 @@ type Entry struct {
 	Preferred bool           `json:"preferred,omitempty"`
 	Tag       string         `json:"tag,omitempty"`
 	Comments  []EntryComment `json:"comments,omitempty"`
+
+	// Relations to/from other entries. Only populated on request (see dbapi.Query.IncludeRelated)
+	Relations []EntryRelation `json:"relations,omitempty"`
+
+	// Lock is the lock held on the entry, if any (set by dbapi.DBManager.LookUp)
+	Lock *EntryLock `json:"lock,omitempty"`
 }
 
 // EntryWriter is an interface defining things to which one can write an Entry.
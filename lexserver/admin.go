@@ -10,10 +10,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/stts-se/pronlex/auth"
 	"github.com/stts-se/pronlex/dbapi"
 	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/pronlex/replication"
 	"github.com/stts-se/pronlex/validation"
 )
 
@@ -34,6 +38,7 @@ var adminListIDs = urlHandler{
 	url:      "/list_ids/{lexicon_name}",
 	help:     "List all IDs for the entries in one lexicon.",
 	examples: []string{"/list_ids/wikispeech_lexserver_testdb:sv"},
+	role:     auth.Reader,
 	handler: func(w http.ResponseWriter, r *http.Request) {
 		lexRef, err := getLexRefParam(r)
 		if err != nil {
@@ -42,7 +47,7 @@ var adminListIDs = urlHandler{
 			return
 		}
 
-		ids, err := dbm.ListIDs(lexRef)
+		ids, err := requestDBM(r).ListIDs(lexRef)
 
 		if err != nil {
 			log.Printf("lexserver: Failed to get ids: %v", err)
@@ -172,7 +177,7 @@ var adminLexImport = urlHandler{
 			http.Error(w, msg, http.StatusInternalServerError)
 			return
 		}
-		exists, err := dbm.LexiconExists(lexRef)
+		exists, err := requestDBM(r).LexiconExists(lexRef)
 		if err != nil {
 			msg := fmt.Sprintf("Couldn't lookup lexicon reference: %s", lexRef.String())
 			log.Println(msg)
@@ -196,7 +201,7 @@ var adminLexImport = urlHandler{
 		// 	return
 		// }
 
-		err = dbm.DefineLexicon(lexRef, symbolSetName, locale)
+		err = requestDBM(r).DefineLexicon(lexRef, symbolSetName, locale)
 		if err != nil {
 			log.Println(err)
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
@@ -218,15 +223,17 @@ var adminLexImport = urlHandler{
 		// 	}
 		// }
 
-		err = dbm.ImportLexiconFile(lexRef, logger, serverPath, validator)
+		jobDone := startJob("import")
+		batch, err := requestDBM(r).ImportLexiconFileAs(lexRef, logger, serverPath, validator, userName(r, "user"))
+		jobDone(err)
 
 		if err == nil {
-			msg := fmt.Sprintf("lexicon file imported successfully : %v", handler.Filename)
+			msg := fmt.Sprintf("lexicon file imported successfully : %v (import batch %d)", handler.Filename, batch.ID)
 			log.Println(msg)
 		} else {
 			msg := fmt.Sprintf("couldn't import lexicon file : %v", err)
 			log.Println(msg)
-			http.Error(w, msg, http.StatusInternalServerError)
+			http.Error(w, msg, frozenErrorStatus(err, http.StatusInternalServerError))
 			deleteUploadedFile(serverPath)
 			return
 		}
@@ -234,7 +241,7 @@ var adminLexImport = urlHandler{
 		//f.Close()
 		deleteUploadedFile(serverPath)
 
-		entryCount, err := dbm.EntryCount(lexRef)
+		entryCount, err := requestDBM(r).EntryCount(lexRef)
 		if err != nil {
 			msg := fmt.Sprintf("lexicon imported, but couldn't retrieve lexicon info from server : %v", err)
 			log.Println(msg)
@@ -278,7 +285,7 @@ var adminDefineLex = urlHandler{
 			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref %v : %v", lexRef, err), http.StatusInternalServerError)
 			return
 		}
-		exists, err := dbm.LexiconExists(lexRef)
+		exists, err := requestDBM(r).LexiconExists(lexRef)
 		if err != nil {
 			msg := fmt.Sprintf("Couldn't lookup lexicon reference: %s", lexRef.String())
 			log.Println(msg)
@@ -292,17 +299,136 @@ var adminDefineLex = urlHandler{
 			return
 		}
 
-		err = dbm.DefineLexicon(lexRef, symbolsetName, locale)
+		err = requestDBM(r).DefineLexicon(lexRef, symbolsetName, locale)
 		if err != nil {
 			log.Println(err)
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 			return
 		}
 		log.Println("Created lexicon: ", lexRef.String())
+		if vServ != nil && vServ.HasValidator(symbolsetName) {
+			v, err := bindValidator(lexRef)
+			if err != nil {
+				log.Printf("Couldn't bind validator to lexicon %s : %v", lexRef, err)
+			} else {
+				log.Printf("Bound validator %s to lexicon %s", v.Name, lexRef)
+			}
+		}
 		fmt.Fprint(w, "Created lexicon "+lexRef.String())
 	},
 }
 
+var adminLexiconMeta = urlHandler{
+	name:     "lexicon_meta",
+	url:      "/lexicon_meta/{lexicon_name}",
+	help:     "Get the meta data (description, license, source, owner, etc) of a lexicon. If the <meta> parameter is set (JSON), the meta data of the lexicon will be replaced. The created and modified timestamps are set automatically.",
+	examples: []string{"/lexicon_meta/wikispeech_lexserver_testdb:sv", `/lexicon_meta/wikispeech_lexserver_testdb:sv?meta={"description":"Swedish test lexicon","license":"CC0","owner":"admin","properties":{"derived_from":"NST"}}`},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, err := getLexRefParam(r)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref %v : %v", lexRef, err), http.StatusInternalServerError)
+			return
+		}
+
+		metaJSON := getParam("meta", r)
+		if strings.TrimSpace(metaJSON) != "" {
+			var meta dbapi.LexiconMeta
+			err = json.Unmarshal([]byte(metaJSON), &meta)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to process incoming meta json : %v", err), http.StatusBadRequest)
+				return
+			}
+			err = requestDBM(r).SetLexiconMeta(lexRef, meta)
+			if err != nil {
+				msg := fmt.Sprintf("lexserver failed to set lexicon meta data : %v", err)
+				log.Println(msg)
+				http.Error(w, msg, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		meta, err := requestDBM(r).GetLexiconMeta(lexRef)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("lexserver failed to get lexicon meta data : %v", err), http.StatusInternalServerError)
+			return
+		}
+		jsn, err := marshal(meta, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+var adminListLexiconStacks = urlHandler{
+	name:     "list_lexicon_stacks",
+	url:      "/list_lexicon_stacks",
+	help:     "List lexicon stacks. A lexicon stack is a named, ordered list of lexicons, used for layered lookup: for each word, only the entries of the first lexicon in the stack containing the word are returned. A stack name can be used in the 'lexicons' parameter of /lexicon/lookup and /lexicon/entries_exist.",
+	examples: []string{"/list_lexicon_stacks"},
+	role:     auth.Reader,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		jsn, err := marshal(requestDBM(r).ListLexiconStacks(), r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+var adminDefineLexiconStack = urlHandler{
+	name:     "define_lexicon_stack",
+	url:      "/define_lexicon_stack/{stack_name}",
+	help:     "Define a lexicon stack, or replace an existing stack with the same name. The 'lexicons' parameter lists the lexicons of the stack, in order of priority (highest first). Stack names cannot contain colons.",
+	examples: []string{"/define_lexicon_stack/wikispeech_sv?lexicons=wikispeech_lexserver_testdb:sv"},
+	global:   true,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		stack := dbapi.LexiconStack{Name: delQuote(getParam("stack_name", r))}
+		lexs := dbapi.RemoveEmptyStrings(splitRE.Split(getParam("lexicons", r), -1))
+		for _, l := range lexs {
+			ref, err := lex.ParseLexRef(l)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("couldn't parse lexicon reference from string %s", l), http.StatusBadRequest)
+				return
+			}
+			stack.Lexicons = append(stack.Lexicons, ref)
+		}
+		err := requestDBM(r).DefineLexiconStack(stack)
+		if err != nil {
+			msg := fmt.Sprintf("lexserver failed to define lexicon stack : %v", err)
+			log.Println(msg)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		log.Printf("Defined lexicon stack %s", stack)
+		fmt.Fprintf(w, "Defined lexicon stack %s", stack)
+	},
+}
+
+var adminDeleteLexiconStack = urlHandler{
+	name:     "delete_lexicon_stack",
+	url:      "/delete_lexicon_stack/{stack_name}",
+	help:     "Delete a lexicon stack. The lexicons in the stack are not affected.",
+	examples: []string{},
+	global:   true,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		name := delQuote(getParam("stack_name", r))
+		err := requestDBM(r).DeleteLexiconStack(name)
+		if err != nil {
+			msg := fmt.Sprintf("lexserver failed to delete lexicon stack : %v", err)
+			log.Println(msg)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		log.Printf("Deleted lexicon stack %s", name)
+		fmt.Fprintf(w, "Deleted lexicon stack %s", name)
+	},
+}
+
 var adminDeleteLex = urlHandler{
 	name:     "deletelexicon",
 	url:      "/deletelexicon/{lexicon_name}",
@@ -315,10 +441,10 @@ var adminDeleteLex = urlHandler{
 			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref %v : %v", lexRef, err), http.StatusInternalServerError)
 			return
 		}
-		err = dbm.DeleteLexicon(lexRef)
+		err = requestDBM(r).DeleteLexicon(lexRef)
 		if err != nil {
 			log.Printf("adminDeleteLex got error : %v\n", err)
-			http.Error(w, fmt.Sprintf("failed deleting lexicon : %v", err), http.StatusExpectationFailed)
+			http.Error(w, fmt.Sprintf("failed deleting lexicon : %v", err), frozenErrorStatus(err, http.StatusExpectationFailed))
 			return
 		}
 	},
@@ -356,8 +482,9 @@ var adminListDBs = urlHandler{
 	url:      "/list_dbs",
 	help:     "Lists available lexicon databases.",
 	examples: []string{"/list_dbs"},
+	role:     auth.Reader,
 	handler: func(w http.ResponseWriter, r *http.Request) {
-		dbs, err := dbm.ListDBNames()
+		dbs, err := requestDBM(r).ListDBNames()
 		if err != nil {
 			http.Error(w, fmt.Sprintf("list dbs failed : %v", err), http.StatusInternalServerError)
 			return
@@ -372,11 +499,130 @@ var adminListDBs = urlHandler{
 	},
 }
 
+// backupWriter keeps track of whether a backup has started to be written to the response
+type backupWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (bw *backupWriter) Write(p []byte) (int, error) {
+	bw.started = true
+	return bw.w.Write(p)
+}
+
+var adminLookupCache = urlHandler{
+	name:     "lookup_cache",
+	url:      "/lookup_cache",
+	help:     "Statistics for the lookup cache (hits, misses, evictions, etc). Only lookups of words, with no other search criteria, are cached. Cached lookups for a lexicon are invalidated when the lexicon is changed by the server. The cache size is set using the lexserver flag -lookup_cache_size.",
+	examples: []string{"/lookup_cache"},
+	global:   true,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		jsn, err := marshal(requestDBM(r).LookupCacheStats(), r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+var adminBackup = urlHandler{
+	name:     "backup",
+	url:      "/backup/{db_name}",
+	help:     "Download a gzip compressed backup of a lexicon database (a database file for Sqlite; an SQL dump for MariaDB). The backup is consistent, and the database can be written to while the backup is running. Restore using the backupDB command.",
+	examples: []string{"/backup/wikispeech_lexserver_testdb"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		dbName := delQuote(getParam("db_name", r))
+		if dbName == "" {
+			http.Error(w, "no value for parameter 'db_name'", http.StatusBadRequest)
+			return
+		}
+		dbRef := lex.DBRef(dbName)
+		dbs, err := requestDBM(r).ListDBNames()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("list dbs failed : %v", err), http.StatusInternalServerError)
+			return
+		}
+		found := false
+		for _, db := range dbs {
+			if db == dbRef {
+				found = true
+				break
+			}
+		}
+		if !found {
+			http.Error(w, fmt.Sprintf("no such db '%s'", dbName), http.StatusNotFound)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		// the server's write timeout would otherwise cut off large backups
+		err = rc.SetWriteDeadline(time.Time{})
+		if err != nil {
+			log.Printf("lexserver: couldn't disable write timeout for backup : %v", err)
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", requestDBM(r).BackupFileName(dbRef)))
+		bw := &backupWriter{w: w}
+		err = requestDBM(r).Backup(dbRef, bw)
+		if err != nil {
+			log.Printf("lexserver: backup of db %s failed : %v", dbName, err)
+			// if the response has already been started, the error cannot be reported to the client with a proper status code
+			if !bw.started {
+				w.Header().Del("Content-Disposition")
+				http.Error(w, fmt.Sprintf("backup failed : %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+		log.Printf("lexserver: sent backup of db %s", dbName)
+	},
+}
+
+var adminReplicationChanges = urlHandler{
+	name:     "replication_changes",
+	url:      "/replication_changes/{db_name}",
+	help:     "Exports the modifications of a lexicon database for replication: the current contents of all entries changed after a sequence number in the change feed (see /lexicon/changes), and the ids of deleted entries. Used by replica servers (see the lexsync command, and the lexserver flag -follow_primary). Optional params: since (sequence number, default 0), limit (max number of change events, default 1000).",
+	examples: []string{"/replication_changes/wikispeech_lexserver_testdb?since=0&limit=10"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		dbName := delQuote(getParam("db_name", r))
+		if dbName == "" {
+			http.Error(w, "no value for parameter 'db_name'", http.StatusBadRequest)
+			return
+		}
+		var since int64
+		var limit int
+		var err error
+		if sinceS := getParam("since", r); sinceS != "" {
+			since, err = strconv.ParseInt(sinceS, 10, 64)
+			if err != nil || since < 0 {
+				http.Error(w, fmt.Sprintf("invalid value for param since : %s", sinceS), http.StatusBadRequest)
+				return
+			}
+		}
+		if limitS := getParam("limit", r); limitS != "" {
+			limit, err = strconv.Atoi(limitS)
+			if err != nil || limit < 0 {
+				http.Error(w, fmt.Sprintf("invalid value for param limit : %s", limitS), http.StatusBadRequest)
+				return
+			}
+		}
+		rc := http.NewResponseController(w)
+		// the server's write timeout would otherwise cut off large exports
+		err = rc.SetWriteDeadline(time.Time{})
+		if err != nil {
+			log.Printf("lexserver: couldn't disable write timeout for replication changes : %v", err)
+		}
+		replication.ServeChanges(w, requestDBM(r), lex.DBRef(dbName), since, limit)
+	},
+}
+
 var adminCreateDB = urlHandler{
 	name:     "create_db",
 	url:      "/create_db/{db_name}",
 	help:     "Create a new (empty) lexicon database.",
 	examples: []string{},
+	global:   true,
 	handler: func(w http.ResponseWriter, r *http.Request) {
 		dbName := delQuote(getParam("db_name", r))
 		if dbName == "" {
@@ -386,7 +632,7 @@ var adminCreateDB = urlHandler{
 
 		//func (dbm *DBManager) DefineDB(dbRef lex.DBRef, dbPath string) error {
 		//dbPath := filepath.Join(*dbClusterLocation, dbName+".db")
-		err := dbm.DefineDB(*dbLocation, lex.DBRef(dbName))
+		err := requestDBM(r).DefineDB(*dbLocation, lex.DBRef(dbName))
 		if err != nil {
 			http.Error(w, fmt.Sprintf("couldn't define db : %v", err), http.StatusInternalServerError)
 			return
@@ -469,9 +715,9 @@ var adminMoveNewEntries = urlHandler{
 			return
 		}
 
-		moveRes, err := dbm.MoveNewEntries(lex.DBRef(dbName), lex.LexName(fromLexName), lex.LexName(toLexName), sourceName, statusName)
+		moveRes, err := requestDBM(r).MoveNewEntries(lex.DBRef(dbName), lex.LexName(fromLexName), lex.LexName(toLexName), sourceName, statusName)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("failure when trying to move entries from '%s' to '%s' : %v", fromLexName, toLexName, err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("failure when trying to move entries from '%s' to '%s' : %v", fromLexName, toLexName, err), frozenErrorStatus(err, http.StatusInternalServerError))
 			return
 		}
 
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/stts-se/pronlex/auth"
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+)
+
+// readableWorklist removes the assignments in lexicons that the authenticated user of the request is not allowed to read, and recomputes the progress counts
+func readableWorklist(r *http.Request, wl dbapi.Worklist) dbapi.Worklist {
+	u, ok := requestUser(r)
+	if !ok {
+		return wl
+	}
+	res := dbapi.Worklist{Assignee: wl.Assignee, Lexicons: []dbapi.LexiconProgress{}, Assignments: []dbapi.Assignment{}}
+	for _, l := range wl.Lexicons {
+		if u.Can(auth.Reader, l.LexRef.DBRef, l.LexRef.LexName) {
+			res.Lexicons = append(res.Lexicons, l)
+			res.Progress.Total += l.Total
+			res.Progress.Open += l.Open
+			res.Progress.Done += l.Done
+			res.Progress.Overdue += l.Overdue
+		}
+	}
+	for _, a := range wl.Assignments {
+		if u.Can(auth.Reader, a.LexRef.DBRef, a.LexRef.LexName) {
+			res.Assignments = append(res.Assignments, a)
+		}
+	}
+	return res
+}
+
+var lexiconAssign = urlHandler{
+	name:     "assign",
+	url:      "/assign",
+	help:     "Assigns entries to a user for review. The entries to assign are selected using the lexicons param and the query params of lookup (e.g. entryids, wordlike or commentlike); the query must not be empty. If lexicons is a lexicon stack, matching entries in all lexicons of the stack are assigned. Entries already assigned to the user are skipped. An assignment is closed when a new entry status is set for the entry (see updateentry). Required params: lexicons, assignee. Optional params: due (due date, YYYY-MM-DD), priority (integer; higher values come first in the worklist), comment. Returns the new assignments.",
+	examples: []string{"/assign?lexicons=wikispeech_lexserver_testdb:sv&entryids=3,4&assignee=tester&due=2030-01-31&priority=1&comment=check+stress", "/assign?lexicons=wikispeech_lexserver_testdb:sv&wordlike=kex%25&assignee=reader"},
+	role:     auth.Editor,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		// the default lexicons (see -default_lexicons) are not used for assignments
+		if strings.TrimSpace(getParam("lexicons", r)) == "" {
+			http.Error(w, "no value for parameter 'lexicons'", http.StatusBadRequest)
+			return
+		}
+		q, err := queryFromParams(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to process query params : %v", err), http.StatusBadRequest)
+			return
+		}
+		opts := dbapi.AssignOptions{
+			Assignee:   getParam("assignee", r),
+			AssignedBy: userName(r, "assigned_by"),
+			Due:        getParam("due", r),
+			Comment:    getParam("comment", r),
+		}
+		if p := strings.TrimSpace(getParam("priority", r)); p != "" {
+			opts.Priority, err = strconv.Atoi(p)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid value for param priority : %s", p), http.StatusBadRequest)
+				return
+			}
+		}
+		as, err := requestDBM(r).AssignQuery(q, opts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't assign entries : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, as)
+	},
+}
+
+var lexiconWorklist = urlHandler{
+	name:     "worklist",
+	url:      "/worklist",
+	help:     "Lists the entries assigned to a user (see assign), in all databases, with progress counts (total, open, done and overdue) for each lexicon. Open assignments are listed first, ordered by due date and priority. Optional params: user (default: the authenticated user), status (open, done, cancelled or all; default open).",
+	examples: []string{"/worklist", "/worklist?user=reader&status=all"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		user := getParam("user", r)
+		if user == "" {
+			user = userName(r, "user")
+		}
+		if strings.TrimSpace(user) == "" {
+			http.Error(w, "no value for parameter 'user'", http.StatusBadRequest)
+			return
+		}
+		status := dbapi.AssignmentOpen
+		switch s := getParam("status", r); s {
+		case "":
+		case "all":
+			status = ""
+		case string(dbapi.AssignmentOpen), string(dbapi.AssignmentDone), string(dbapi.AssignmentCancelled):
+			status = dbapi.AssignmentStatus(s)
+		default:
+			http.Error(w, fmt.Sprintf("invalid value for param status : %s", s), http.StatusBadRequest)
+			return
+		}
+		wl, err := requestDBM(r).Worklist(user, status)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't get worklist : %v", err), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, readableWorklist(r, wl))
+	},
+}
+
+var lexiconCancelAssignment = urlHandler{
+	name:     "assignments/cancel",
+	url:      "/assignments/cancel",
+	help:     "Cancels an open assignment (see assign). Required params: lexicon, id (assignment id, as listed by worklist). Returns the cancelled assignment.",
+	examples: []string{"/assignments/cancel?lexicon=wikispeech_lexserver_testdb:sv&id=2"},
+	role:     auth.Editor,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, err := lex.ParseLexRef(getParam("lexicon", r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref : %v", err), http.StatusBadRequest)
+			return
+		}
+		idS := getParam("id", r)
+		id, err := strconv.ParseInt(idS, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid value for param id : %s", idS), http.StatusBadRequest)
+			return
+		}
+		a, err := requestDBM(r).CancelAssignment(lexRef, id, userName(r, "user"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't cancel assignment : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, a)
+	},
+}
+
+var adminConvertAssignComments = urlHandler{
+	name:     "convert_assign_comments",
+	url:      "/convert_assign_comments/{db_name}",
+	help:     "Converts entry comments with the label assign_to (e.g. [assign_to: nisse] (bengt)) into assignments (see /lexicon/assign), and removes the comments. Databases are converted when migrated from an older schema version, so this is only needed after importing lexicon files with such comments.",
+	examples: []string{"/convert_assign_comments/wikispeech_lexserver_testdb"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		dbName := delQuote(getParam("db_name", r))
+		if dbName == "" {
+			http.Error(w, "no value for parameter 'db_name'", http.StatusBadRequest)
+			return
+		}
+		n, err := requestDBM(r).ConvertAssignComments(lex.DBRef(dbName))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't convert assign comments : %v", err), frozenErrorStatus(err, http.StatusBadRequest))
+			return
+		}
+		fmt.Fprintf(w, "converted %d assign_to comments into assignments", n)
+	},
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/stts-se/pronlex/auth"
+	"github.com/stts-se/pronlex/lex"
+)
+
+// authStore holds the users and API tokens. If nil, authentication is disabled, and all requests are allowed (see -auth_file).
+var authStore *auth.Store
+
+// anonymousRead allows requests without a token to call handlers requiring the reader role (see -auth_anonymous_read)
+var anonymousRead bool
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// requestUser returns the authenticated user of a request, and false if authentication is disabled, or the request is anonymous
+func requestUser(r *http.Request) (auth.User, bool) {
+	u, ok := r.Context().Value(userContextKey).(auth.User)
+	return u, ok
+}
+
+// userName returns the name of the authenticated user of a request. If there is no authenticated user, the value of the param is returned instead, so that authenticated users cannot act on behalf of others.
+func userName(r *http.Request, param string) string {
+	if u, ok := requestUser(r); ok {
+		return u.Name
+	}
+	return getParam(param, r)
+}
+
+// requestToken returns the API token of a request, sent as a bearer token (Authorization: Bearer <token>), or as the password of HTTP basic authentication (the user name is ignored). Basic authentication is used by browsers.
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// requestScopes returns the databases and lexicons referenced by the params of a request. A database without a lexicon is returned as a lexicon reference with an empty lexicon name.
+func requestScopes(r *http.Request) []lex.LexRef {
+	res := []lex.LexRef{}
+	for _, p := range []string{"lexicon", "lexicon_name"} {
+		if lexRef, err := lex.ParseLexRef(getParam(p, r)); err == nil {
+			res = append(res, lexRef)
+		}
+	}
+	for _, l := range lexiconsParam(r) {
+		if lexRef, err := lex.ParseLexRef(l); err == nil {
+			res = append(res, lexRef)
+		} else if stack, ok := requestDBM(r).GetLexiconStack(l); ok {
+			res = append(res, stack.Lexicons...)
+		}
+	}
+	if dbName := strings.TrimSpace(getParam("db_name", r)); dbName != "" {
+		res = append(res, lex.LexRef{DBRef: lex.DBRef(dbName)})
+	}
+	if entryJSON := getParam("entry", r); entryJSON != "" {
+		var e lex.Entry
+		if err := json.Unmarshal([]byte(entryJSON), &e); err == nil && e.LexRef.DBRef != "" {
+			res = append(res, e.LexRef)
+		}
+	}
+	return res
+}
+
+// authorised returns true if the user has the role for all databases and lexicons referenced by the request (the scopes). If the request doesn't reference any database or lexicon, the role is required in any scope, and the handler is responsible for limiting its results to the user's scopes (see requestCan). For global handlers, the role is required for all databases.
+func authorised(u auth.User, role auth.Role, global bool, scopes []lex.LexRef) bool {
+	if global {
+		return u.Can(role, "", "")
+	}
+	if len(scopes) == 0 {
+		return u.CanAnywhere(role)
+	}
+	for _, s := range scopes {
+		if !u.Can(role, s.DBRef, s.LexName) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestCan returns true if the user of the request has the role for the database and lexicon (an empty lexicon name is used for the database as a whole). If authentication is disabled, or the request is anonymous (see -auth_anonymous_read), true is returned, since the request has already been authorised.
+func requestCan(r *http.Request, role auth.Role, dbRef lex.DBRef, lexName lex.LexName) bool {
+	u, ok := requestUser(r)
+	if !ok {
+		return true
+	}
+	return u.Can(role, dbRef, lexName)
+}
+
+// authorise wraps a handler with authentication and authorisation, if enabled. The authenticated user is added to the request context (see requestUser).
+func (rout *subRouter) authorise(h urlHandler) http.HandlerFunc {
+	role := h.role
+	if role == "" {
+		role = rout.role
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authStore == nil {
+			h.handler(w, r)
+			return
+		}
+		token := requestToken(r)
+		if token == "" {
+			if anonymousRead && role == auth.Reader && !h.global {
+				h.handler(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="lexserver"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		user, ok := authStore.Authenticate(token)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="lexserver"`)
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		scopes := requestScopes
+		if h.scopes != nil {
+			scopes = h.scopes
+		}
+		if !authorised(user, role, h.global, scopes(r)) {
+			http.Error(w, fmt.Sprintf("user '%s' is not authorised to call %s%s (requires role %s)", user.Name, rout.root, h.url, role), http.StatusForbidden)
+			return
+		}
+		h.handler(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+	}
+}
+
+// setupAuth enables authentication, using the users of the JSON file. If there are no users, an admin user is created, and its token is logged, since this is the only way to obtain it.
+func setupAuth(fileName string) error {
+	var err error
+	authStore, err = auth.NewStore(fileName)
+	if err != nil {
+		return err
+	}
+	if len(authStore.Users()) > 0 {
+		return nil
+	}
+	_, err = authStore.AddUser("admin", auth.Grant{Role: auth.Admin, Scope: auth.AllScope})
+	if err != nil {
+		return err
+	}
+	token, _, err := authStore.CreateToken("admin", "created on first start")
+	if err != nil {
+		return err
+	}
+	log.Printf("lexserver: created user admin with API token %s (the token is not shown again; use /admin/create_token to create more tokens)", token)
+	return nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stts-se/pronlex/auth"
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+)
+
+// changeFeedBufferSize is the number of change events that may be waiting for a slow client, before it is disconnected
+const changeFeedBufferSize = 1000
+
+// changeFeedKeepAlive is the interval for sending keep-alive comments to change stream clients
+const changeFeedKeepAlive = 30 * time.Second
+
+// changeFilter holds the lexicons to include for each db (all lexicons in the db, if the list is empty), and the sequence number to start after
+type changeFilter struct {
+	lexNames map[lex.DBRef][]lex.LexName
+	since    map[lex.DBRef]int64
+}
+
+func (f changeFilter) dbRefs() []lex.DBRef {
+	res := []lex.DBRef{}
+	for dbRef := range f.lexNames {
+		res = append(res, dbRef)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+func (f changeFilter) includes(e dbapi.ChangeEvent) bool {
+	lexNames, ok := f.lexNames[e.LexRef.DBRef]
+	if !ok {
+		return false
+	}
+	if e.Seq <= f.since[e.LexRef.DBRef] {
+		return false
+	}
+	if len(lexNames) == 0 {
+		return true
+	}
+	for _, l := range lexNames {
+		if strings.EqualFold(string(l), string(e.LexRef.LexName)) {
+			return true
+		}
+	}
+	return false
+}
+
+// cursor returns the current positions in the change feed, formatted as "db1:seq1,db2:seq2"
+func (f changeFilter) cursor() string {
+	res := []string{}
+	for _, dbRef := range f.dbRefs() {
+		res = append(res, fmt.Sprintf("%s:%d", dbRef, f.since[dbRef]))
+	}
+	return strings.Join(res, ",")
+}
+
+// changeLexiconsParam returns the values of the lexicons param. Unlike lookups, the default lexicons are not used (see lexiconsParam).
+func changeLexiconsParam(r *http.Request) []string {
+	return dbapi.RemoveEmptyStrings(splitRE.Split(getParam("lexicons", r), -1))
+}
+
+// changeScopes returns the lexicons of the lexicons param, for authorisation (see urlHandler.scopes). Without lexicons, the changes are limited to the lexicons that the user can read (see changeFilterFromParams).
+func changeScopes(r *http.Request) []lex.LexRef {
+	res := []lex.LexRef{}
+	for _, l := range changeLexiconsParam(r) {
+		if lexRef, err := lex.ParseLexRef(l); err == nil {
+			res = append(res, lexRef)
+		}
+	}
+	return res
+}
+
+// changeFilterFromParams reads the lexicons and since params. The since param is either a single sequence number (used for all dbs) or a cursor as returned by changeFilter.cursor. For streams, the Last-Event-ID header (sent by reconnecting SSE clients) overrides the since param, and without since, the stream starts after the most recent event.
+func changeFilterFromParams(r *http.Request, stream bool) (changeFilter, error) {
+	res := changeFilter{lexNames: make(map[lex.DBRef][]lex.LexName), since: make(map[lex.DBRef]int64)}
+	lexs := changeLexiconsParam(r)
+	if len(lexs) == 0 {
+		// all lexicons that the user can read
+		dbRefs, err := requestDBM(r).ListDBNames()
+		if err != nil {
+			return res, err
+		}
+		for _, dbRef := range dbRefs {
+			if requestCan(r, auth.Reader, dbRef, "") {
+				res.lexNames[dbRef] = []lex.LexName{}
+			}
+		}
+		if _, ok := requestUser(r); ok {
+			lexicons, err := requestDBM(r).ListLexicons()
+			if err != nil {
+				return res, err
+			}
+			for _, l := range lexicons {
+				if _, ok := res.lexNames[l.LexRef.DBRef]; ok && len(res.lexNames[l.LexRef.DBRef]) == 0 {
+					continue
+				}
+				if requestCan(r, auth.Reader, l.LexRef.DBRef, l.LexRef.LexName) {
+					res.lexNames[l.LexRef.DBRef] = append(res.lexNames[l.LexRef.DBRef], l.LexRef.LexName)
+				}
+			}
+		}
+	}
+	for _, l := range lexs {
+		lexRef, err := lex.ParseLexRef(l)
+		if err != nil {
+			return res, err
+		}
+		if !requestDBM(r).ContainsDB(lexRef.DBRef) {
+			return res, fmt.Errorf("no such db '%s'", lexRef.DBRef)
+		}
+		res.lexNames[lexRef.DBRef] = append(res.lexNames[lexRef.DBRef], lexRef.LexName)
+	}
+
+	since := strings.TrimSpace(getParam("since", r))
+	if id := strings.TrimSpace(r.Header.Get("Last-Event-ID")); id != "" {
+		since = id
+	}
+	if since == "" {
+		if stream {
+			for dbRef := range res.lexNames {
+				seq, err := requestDBM(r).LastChange(dbRef)
+				if err != nil {
+					return res, err
+				}
+				res.since[dbRef] = seq
+			}
+		}
+		return res, nil
+	}
+	if n, err := strconv.ParseInt(since, 10, 64); err == nil {
+		for dbRef := range res.lexNames {
+			res.since[dbRef] = n
+		}
+		return res, nil
+	}
+	for _, s := range strings.Split(since, ",") {
+		fs := strings.SplitN(strings.TrimSpace(s), ":", 2)
+		if len(fs) != 2 {
+			return res, fmt.Errorf("invalid value for param since : %s", since)
+		}
+		n, err := strconv.ParseInt(fs[1], 10, 64)
+		if err != nil {
+			return res, fmt.Errorf("invalid value for param since : %s", since)
+		}
+		res.since[lex.DBRef(fs[0])] = n
+	}
+	return res, nil
+}
+
+// listChanges returns the change events matching the filter, in sequence order for each db. If limit is greater than 0, at most limit events are listed for each db.
+func listChanges(dbm *dbapi.DBManager, f changeFilter, limit int) ([]dbapi.ChangeEvent, error) {
+	res := []dbapi.ChangeEvent{}
+	for _, dbRef := range f.dbRefs() {
+		es, err := dbm.ListChanges(dbRef, f.since[dbRef], f.lexNames[dbRef], limit)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, es...)
+	}
+	return res, nil
+}
+
+var lexiconChanges = urlHandler{
+	name:     "changes",
+	url:      "/changes",
+	help:     "Lists modifications of lexicons (change events: insert, update, status, delete, lexicon_created, lexicon_deleted, lexicon_changed), in sequence order. Each db has its own sequence numbers. Optional params: lexicons (default: all lexicons that the user can read), since (a sequence number, or db:seq pairs separated by comma; only events after this are listed), limit (max number of events per db, default 1000). To follow the changes as they happen, use changes_stream.",
+	examples: []string{"/changes?lexicons=wikispeech_lexserver_testdb:sv", "/changes?lexicons=wikispeech_lexserver_testdb:sv&since=10&limit=5"},
+	scopes:   changeScopes,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		f, err := changeFilterFromParams(r, false)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse params : %v", err), http.StatusBadRequest)
+			return
+		}
+		limit := 1000
+		if limitS := getParam("limit", r); limitS != "" {
+			limit, err = strconv.Atoi(limitS)
+			if err != nil || limit < 0 {
+				http.Error(w, fmt.Sprintf("invalid value for param limit : %s", limitS), http.StatusBadRequest)
+				return
+			}
+		}
+		res, err := listChanges(requestDBM(r), f, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't list changes : %v", err), http.StatusInternalServerError)
+			return
+		}
+		jsn, err := marshal(res, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+var lexiconChangesStream = urlHandler{
+	name:     "changes_stream",
+	url:      "/changes_stream",
+	help:     "Streams modifications of lexicons using Server-Sent Events (see changes for a description of the events). Each event has the JSON encoded change event as data, and the current position in the feed as id. Clients that reconnect with a Last-Event-ID header (as browsers do automatically) will resume where they left off. Slow clients are disconnected, and should reconnect. Optional params: lexicons (default: all lexicons that the user can read), since (see changes; default: only events after the connection), follow (default true; if false, the stream is closed after the existing events have been sent).",
+	examples: []string{"/changes_stream?lexicons=wikispeech_lexserver_testdb:sv&since=10&follow=false", "/changes_stream?lexicons=wikispeech_lexserver_testdb:sv&since=0&follow=false"},
+	scopes:   changeScopes,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		f, err := changeFilterFromParams(r, true)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse params : %v", err), http.StatusBadRequest)
+			return
+		}
+		follow := getParam("follow", r) != "false"
+
+		// subscribe before listing the existing events, so that no events are lost in between
+		sub := requestDBM(r).SubscribeChanges(changeFeedBufferSize)
+		defer sub.Cancel()
+		existing, err := listChanges(requestDBM(r), f, 0)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't list changes : %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		// the server's write timeout would otherwise close the stream
+		err = rc.SetWriteDeadline(time.Time{})
+		if err != nil {
+			log.Printf("lexserver: couldn't disable write timeout for change stream : %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(e dbapi.ChangeEvent) error {
+			if !f.includes(e) {
+				return nil
+			}
+			f.since[e.LexRef.DBRef] = e.Seq
+			jsn, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "id: %s\ndata: %s\n\n", f.cursor(), jsn)
+			if err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+		for _, e := range existing {
+			err = send(e)
+			if err != nil {
+				return
+			}
+		}
+		err = rc.Flush()
+		if err != nil || !follow {
+			return
+		}
+
+		keepAlive := time.NewTicker(changeFeedKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				_, err = fmt.Fprint(w, ": keepalive\n\n")
+				if err == nil {
+					err = rc.Flush()
+				}
+				if err != nil {
+					return
+				}
+			case e, ok := <-sub.Events:
+				if !ok {
+					// disconnected by the feed; the client should reconnect
+					return
+				}
+				err = send(e)
+				if err != nil {
+					return
+				}
+			}
+		}
+	},
+}
+
+// pruneChanges deletes change events older than the retention period from all databases, at the specified interval, until the context is cancelled (see DBManager.PruneChanges)
+func pruneChanges(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		dbRefs, err := dbm.ListDBNames()
+		if err != nil {
+			log.Printf("lexserver: couldn't prune change events : %v", err)
+		}
+		for _, dbRef := range dbRefs {
+			n, err := dbm.PruneChanges(dbRef, time.Now().Add(-retention))
+			if err != nil {
+				log.Printf("lexserver: couldn't prune change events : %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("lexserver: pruned %d change event(s) older than %v from db %s", n, retention, dbRef)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stts-se/pronlex/dbapi"
+)
+
+// serverConfig is the contents of a lexserver configuration file (see -config). Except for the additional databases, each value corresponds to a command line flag, and flags given on the command line override the values of the file.
+type serverConfig struct {
+	Port   string `json:"port,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	Static string `json:"static,omitempty"`
+	// Databases are the database locations. The first location corresponds to the -db_engine and -db_location flags, and is used for new databases. Databases are loaded from all locations.
+	Databases    []dbLocationConfig `json:"databases,omitempty"`
+	MaxOpenConns *int               `json:"maxOpenConns,omitempty"`
+	// DefaultLexicons are the lexicons (or lexicon stack) used by lookups and other queries without the lexicons param
+	DefaultLexicons []string `json:"defaultLexicons,omitempty"`
+	// ValidatorDirs are folders with symbol set files, used to load validators
+	ValidatorDirs     []string      `json:"validatorDirs,omitempty"`
+	ParadigmDir       string        `json:"paradigmDir,omitempty"`
+	LexiconStacks     string        `json:"lexiconStacks,omitempty"`
+	Logger            string        `json:"logger,omitempty"`
+	LogFormat         string        `json:"logFormat,omitempty"`
+	LogLevel          string        `json:"logLevel,omitempty"`
+	Timeouts          timeoutConfig `json:"timeouts"`
+	Features          featureConfig `json:"features"`
+	Auth              authConfig    `json:"auth"`
+	Follow            followConfig  `json:"follow"`
+	HealthOptionalDBs []string      `json:"healthOptionalDBs,omitempty"`
+}
+
+type dbLocationConfig struct {
+	// Engine is sqlite or mariadb
+	Engine string `json:"engine"`
+	// Location is a folder with database files for sqlite, and a server DSN for mariadb
+	Location string `json:"location"`
+}
+
+// timeoutConfig holds durations, such as "10s" or "1m"
+type timeoutConfig struct {
+	Read  string `json:"read,omitempty"`
+	Write string `json:"write,omitempty"`
+}
+
+type featureConfig struct {
+	RecordUsage        *bool  `json:"recordUsage,omitempty"`
+	UsageFlushInterval string `json:"usageFlushInterval,omitempty"`
+	LookupCacheSize    *int   `json:"lookupCacheSize,omitempty"`
+	ChangeRetention    string `json:"changeRetention,omitempty"`
+}
+
+type authConfig struct {
+	File          string `json:"file,omitempty"`
+	AnonymousRead *bool  `json:"anonymousRead,omitempty"`
+}
+
+type followConfig struct {
+	Primary  string `json:"primary,omitempty"`
+	Token    string `json:"token,omitempty"`
+	Interval string `json:"interval,omitempty"`
+}
+
+// extraDBLocations are the database locations of the config file, in addition to -db_location (see serverConfig.Databases)
+var extraDBLocations []dbLocationConfig
+
+// defaultLexicons are used by lookups and other queries without the lexicons param (see -default_lexicons)
+var defaultLexicons []string
+
+// httpReadTimeout and httpWriteTimeout are the timeouts of the http server (see -read_timeout and -write_timeout)
+var httpReadTimeout, httpWriteTimeout = 10 * time.Second, 10 * time.Second
+
+func parseDBEngine(engine string) (dbapi.DBEngine, error) {
+	switch engine {
+	case "sqlite":
+		return dbapi.Sqlite, nil
+	case "mariadb":
+		return dbapi.MariaDB, nil
+	default:
+		return dbapi.Sqlite, fmt.Errorf("invalid db engine: %s", engine)
+	}
+}
+
+func defaultDBLocation(engine string) string {
+	if engine == "mariadb" {
+		return "speechoid:@tcp(127.0.0.1:3306)"
+	}
+	return filepath.Join(".", "db_files")
+}
+
+// readConfigFile reads a JSON config file. Unknown fields are reported as errors, to catch misspelt settings.
+func readConfigFile(fileName string) (serverConfig, error) {
+	var res serverConfig
+	bts, err := os.ReadFile(filepath.Clean(fileName))
+	if err != nil {
+		return res, fmt.Errorf("couldn't read file : %v", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(bts))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&res)
+	if err != nil {
+		return res, fmt.Errorf("couldn't parse file '%s' : %v", fileName, err)
+	}
+	for _, db := range res.Databases {
+		if _, err := parseDBEngine(db.Engine); err != nil {
+			return res, fmt.Errorf("invalid database location '%s' in file '%s' : %v", db.Location, fileName, err)
+		}
+		if strings.TrimSpace(db.Location) == "" {
+			return res, fmt.Errorf("empty database location in file '%s'", fileName)
+		}
+	}
+	return res, nil
+}
+
+// flagValues returns the values of the config, for the corresponding command line flags. Values that are not set in the config are not included.
+func (c serverConfig) flagValues() map[string]string {
+	res := make(map[string]string)
+	str := func(name string, v string) {
+		if v != "" {
+			res[name] = v
+		}
+	}
+	list := func(name string, v []string) {
+		if len(v) > 0 {
+			res[name] = strings.Join(v, ",")
+		}
+	}
+	str("prefix", c.Prefix)
+	str("static", c.Static)
+	if len(c.Databases) > 0 {
+		str("db_engine", c.Databases[0].Engine)
+		str("db_location", c.Databases[0].Location)
+	}
+	if c.MaxOpenConns != nil {
+		res["max_open_conns"] = strconv.Itoa(*c.MaxOpenConns)
+	}
+	list("default_lexicons", c.DefaultLexicons)
+	list("symbolset_dir", c.ValidatorDirs)
+	str("paradigms", c.ParadigmDir)
+	str("lexicon_stacks", c.LexiconStacks)
+	str("logger", c.Logger)
+	str("log_format", c.LogFormat)
+	str("log_level", c.LogLevel)
+	str("read_timeout", c.Timeouts.Read)
+	str("write_timeout", c.Timeouts.Write)
+	if c.Features.RecordUsage != nil {
+		res["record_usage"] = strconv.FormatBool(*c.Features.RecordUsage)
+	}
+	str("usage_flush_interval", c.Features.UsageFlushInterval)
+	if c.Features.LookupCacheSize != nil {
+		res["lookup_cache_size"] = strconv.Itoa(*c.Features.LookupCacheSize)
+	}
+	str("change_retention", c.Features.ChangeRetention)
+	str("auth_file", c.Auth.File)
+	if c.Auth.AnonymousRead != nil {
+		res["auth_anonymous_read"] = strconv.FormatBool(*c.Auth.AnonymousRead)
+	}
+	str("follow_primary", c.Follow.Primary)
+	str("follow_token", c.Follow.Token)
+	str("follow_interval", c.Follow.Interval)
+	list("health_optional_dbs", c.HealthOptionalDBs)
+	return res
+}
+
+// applyConfig sets the flags to the values of the config, except for flags given on the command line
+func applyConfig(c serverConfig) error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for name, value := range c.flagValues() {
+		if explicit[name] {
+			continue
+		}
+		// a location from the file doesn't apply to another engine given on the command line
+		if name == "db_location" && explicit["db_engine"] {
+			continue
+		}
+		err := flag.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s : %v", name, err)
+		}
+	}
+	if len(c.Databases) > 1 {
+		extraDBLocations = c.Databases[1:]
+	}
+	return nil
+}
+
+// splitFlagList splits a comma separated flag value
+func splitFlagList(value string) []string {
+	res := []string{}
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// maskDSNPassword masks the password of a MariaDB DSN, such as "user:password@tcp(127.0.0.1:3306)/dbname"
+func maskDSNPassword(dsn string) string {
+	server := dsn
+	if i := strings.LastIndex(server, "/"); i >= 0 {
+		server = server[:i]
+	}
+	at := strings.LastIndex(server, "@")
+	if at < 0 {
+		return dsn
+	}
+	colon := strings.Index(server[:at], ":")
+	if colon < 0 || colon == at-1 {
+		return dsn
+	}
+	return dsn[:colon+1] + "********" + dsn[at:]
+}
+
+// effectiveConfig returns the configuration in use, from the flags (after applyConfig), for -print_config. Secrets are masked.
+func effectiveConfig(port string) serverConfig {
+	value := func(name string) string {
+		return flag.Lookup(name).Value.String()
+	}
+	intValue := func(name string) *int {
+		i, _ := strconv.Atoi(value(name))
+		return &i
+	}
+	boolValue := func(name string) *bool {
+		b, _ := strconv.ParseBool(value(name))
+		return &b
+	}
+	location := value("db_location")
+	if location == "" {
+		location = defaultDBLocation(value("db_engine"))
+	}
+	token := value("follow_token")
+	if token != "" {
+		token = "********"
+	}
+	dbs := []dbLocationConfig{}
+	for _, db := range append([]dbLocationConfig{{Engine: value("db_engine"), Location: location}}, extraDBLocations...) {
+		if db.Engine == "mariadb" {
+			db.Location = maskDSNPassword(db.Location)
+		}
+		dbs = append(dbs, db)
+	}
+	return serverConfig{
+		Port:              strings.TrimPrefix(port, ":"),
+		Prefix:            value("prefix"),
+		Static:            value("static"),
+		Databases:         dbs,
+		MaxOpenConns:      intValue("max_open_conns"),
+		DefaultLexicons:   splitFlagList(value("default_lexicons")),
+		ValidatorDirs:     splitFlagList(value("symbolset_dir")),
+		ParadigmDir:       value("paradigms"),
+		LexiconStacks:     value("lexicon_stacks"),
+		Logger:            value("logger"),
+		LogFormat:         value("log_format"),
+		LogLevel:          value("log_level"),
+		Timeouts:          timeoutConfig{Read: value("read_timeout"), Write: value("write_timeout")},
+		Features:          featureConfig{RecordUsage: boolValue("record_usage"), UsageFlushInterval: value("usage_flush_interval"), LookupCacheSize: intValue("lookup_cache_size"), ChangeRetention: value("change_retention")},
+		Auth:              authConfig{File: value("auth_file"), AnonymousRead: boolValue("auth_anonymous_read")},
+		Follow:            followConfig{Primary: value("follow_primary"), Token: token, Interval: value("follow_interval")},
+		HealthOptionalDBs: splitFlagList(value("health_optional_dbs")),
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"log"
 	"path/filepath"
+	"strings"
 
 	"github.com/stts-se/pronlex/dbapi"
 	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/pronlex/paradigm"
 )
 
 func demoEntries() []lex.Entry {
@@ -162,6 +164,32 @@ func demoEntries() []lex.Entry {
 	return entries
 }
 
+var demoParadigms = `
+PARADIGM	s7n-övriga ex träd	NN
+NEU IND SIN NOM	0	0	0	0
+NEU IND SIN GEN	0	s	0	s
+NEU DEF SIN NOM	0	et	0	. @ t
+NEU DEF SIN GEN	0	ets	0	. @ t s
+NEU IND PLU NOM	0	0	0	0
+NEU IND PLU GEN	0	s	0	s
+NEU DEF PLU NOM	0	en	0	. @ n
+NEU DEF PLU GEN	0	ens	0	. @ n s
+`
+
+// setupDemoParadigms adds the demo paradigms, unless paradigms with the same names are already loaded
+func setupDemoParadigms() error {
+	ps, err := paradigm.Parse(strings.NewReader(demoParadigms))
+	if err != nil {
+		return fmt.Errorf("failed to parse demo paradigms : %v", err)
+	}
+	for _, p := range ps {
+		if _, ok := paradigms[p.Name]; !ok {
+			paradigms[p.Name] = p
+		}
+	}
+	return nil
+}
+
 func setupDemoDB(engine dbapi.DBEngine) error {
 	var err error
 
@@ -204,6 +232,11 @@ func setupDemoDB(engine dbapi.DBEngine) error {
 		return fmt.Errorf("failed to insert entries to db %v: %v", lexRef, err)
 	}
 
+	err = setupDemoParadigms()
+	if err != nil {
+		return err
+	}
+
 	log.Println("demo_setup: test database completed")
 	return nil
 }
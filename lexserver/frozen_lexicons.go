@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/stts-se/pronlex/dbapi"
+)
+
+// frozenErrorStatus returns 403 Forbidden if the error is caused by a frozen lexicon (see freeze_lexicon), and the default status otherwise
+func frozenErrorStatus(err error, status int) int {
+	var fe *dbapi.FrozenLexiconError
+	if errors.As(err, &fe) {
+		return http.StatusForbidden
+	}
+	return status
+}
+
+var adminFreezeLexicon = urlHandler{
+	name:     "freeze_lexicon",
+	url:      "/freeze_lexicon/{lexicon_name}",
+	help:     "Freezes a lexicon, making it read-only: entries cannot be added, updated, deleted, moved, imported or revalidated, and the lexicon cannot be deleted, until it is unfrozen (see unfreeze_lexicon). Such requests fail with status 403 Forbidden. Optional param: comment (e.g., the reason for freezing the lexicon). Returns the frozen state of the lexicon.",
+	examples: []string{"/freeze_lexicon/wikispeech_lexserver_testdb:sv?comment=released"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, err := getLexRefParam(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref %v : %v", lexRef, err), http.StatusBadRequest)
+			return
+		}
+		f, err := requestDBM(r).FreezeLexicon(lexRef, userName(r, "user"), getParam("comment", r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't freeze lexicon : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, f)
+	},
+}
+
+var adminUnfreezeLexicon = urlHandler{
+	name:     "unfreeze_lexicon",
+	url:      "/unfreeze_lexicon/{lexicon_name}",
+	help:     "Unfreezes a frozen lexicon (see freeze_lexicon). Returns the frozen state of the lexicon before it was unfrozen.",
+	examples: []string{"/unfreeze_lexicon/wikispeech_lexserver_testdb:sv"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, err := getLexRefParam(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref %v : %v", lexRef, err), http.StatusBadRequest)
+			return
+		}
+		f, err := requestDBM(r).UnfreezeLexicon(lexRef)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't unfreeze lexicon : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, f)
+	},
+}
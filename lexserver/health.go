@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+)
+
+// Health checks for orchestrators and load balancers. Unlike the sub router handlers, they require no authentication.
+
+// healthCheckTimeout is the max time to wait for each database in a readiness check
+const healthCheckTimeout = 5 * time.Second
+
+// optionalDBs are databases that are checked by /health/ready, but don't make the server unready if they are unavailable (see -health_optional_dbs)
+var optionalDBs = make(map[lex.DBRef]bool)
+
+type readiness struct {
+	Ready     bool          `json:"ready"`
+	Databases []dbReadiness `json:"databases"`
+}
+
+type dbReadiness struct {
+	dbapi.DBHealth
+	Required bool `json:"required"`
+}
+
+// liveHandler reports that the server is running, without checking the databases
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, map[string]string{"status": "live"})
+}
+
+// readyHandler checks each database (see dbapi.DBManager.CheckHealth), and responds with status 503 Service Unavailable if any required database is not ok
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	res := readiness{Ready: true, Databases: []dbReadiness{}}
+	for _, h := range requestDBM(r).CheckHealth(healthCheckTimeout) {
+		required := !optionalDBs[h.DBRef]
+		if required && !h.OK() {
+			res.Ready = false
+		}
+		res.Databases = append(res.Databases, dbReadiness{DBHealth: h, Required: required})
+	}
+	status := http.StatusOK
+	if !res.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	jsn, err := marshal(res, r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprint(w, string(jsn))
+}
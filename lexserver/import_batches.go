@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/stts-se/pronlex/auth"
+	"github.com/stts-se/pronlex/lex"
+)
+
+// getImportBatchParams returns the db_name and batch_id params of a request
+func getImportBatchParams(r *http.Request) (lex.DBRef, int64, error) {
+	dbName := delQuote(getParam("db_name", r))
+	if dbName == "" {
+		return "", 0, fmt.Errorf("no value for parameter 'db_name'")
+	}
+	idS := getParam("batch_id", r)
+	id, err := strconv.ParseInt(idS, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid value for param batch_id : %s", idS)
+	}
+	return lex.DBRef(dbName), id, nil
+}
+
+var adminImportBatches = urlHandler{
+	name:     "import_batches",
+	url:      "/import_batches/{db_name}",
+	help:     "Lists the import batches of a database. Each lexicon file import, and each call to addentry, is recorded as an import batch, with the file name, a SHA-256 checksum of the input, the user and a timestamp. Optional params: lex_name (lexicon name; default: all lexicons).",
+	examples: []string{"/import_batches/wikispeech_lexserver_testdb", "/import_batches/wikispeech_lexserver_testdb?lex_name=sv"},
+	role:     auth.Reader,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		dbName := delQuote(getParam("db_name", r))
+		if dbName == "" {
+			http.Error(w, "no value for parameter 'db_name'", http.StatusBadRequest)
+			return
+		}
+		bs, err := requestDBM(r).ListImportBatches(lex.DBRef(dbName), lex.LexName(getParam("lex_name", r)))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't list import batches : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, bs)
+	},
+}
+
+var adminImportBatchEntries = urlHandler{
+	name:     "import_batch_entries",
+	url:      "/import_batch_entries/{db_name}/{batch_id}",
+	help:     "Lists the entries created by an import batch (see import_batches), with their line numbers in the imported file.",
+	examples: []string{"/import_batch_entries/wikispeech_lexserver_testdb/1"},
+	role:     auth.Reader,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		dbRef, id, err := getImportBatchParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		es, err := requestDBM(r).ImportBatchEntries(dbRef, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't list import batch entries : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, es)
+	},
+}
+
+var adminUndoImportBatch = urlHandler{
+	name:     "undo_import_batch",
+	url:      "/undo_import_batch/{db_name}/{batch_id}",
+	help:     "Undoes an import batch (see import_batches): deletes the entries created by the batch, except entries that have been edited since the import, and entries that are locked. Returns a report listing the deleted entries, and the entries that were kept (edited, locked, or already deleted), with their line numbers in the imported file. A batch can only be undone once.",
+	examples: []string{"/undo_import_batch/wikispeech_lexserver_testdb/2"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		dbRef, id, err := getImportBatchParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		report, err := requestDBM(r).UndoImportBatch(dbRef, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't undo import batch : %v", err), frozenErrorStatus(err, http.StatusBadRequest))
+			return
+		}
+		writeJSON(w, r, report)
+	},
+}
@@ -6,10 +6,12 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"time"
 
+	"github.com/stts-se/pronlex/auth"
 	"github.com/stts-se/pronlex/lex"
 )
 
@@ -33,26 +35,25 @@ func runInitTests(s *http.Server, port string) error {
 
 	nErrs1, nTests1, err1 := testExampleURLs(port)
 	nErrs2, nTests2, err2 := testURLsWithContent(port)
+	nErrs3, nTests3, err3 := testAuth(port)
 
-	var err error
-	if err1 != nil && err2 != nil {
-		err = fmt.Errorf("%v, %v", err1, err2)
-	} else if err1 != nil {
-		err = err1
-	} else if err2 != nil {
-		err = err2
+	errs := []string{}
+	for _, err := range []error{err1, err2, err3} {
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
 	}
-	if err != nil {
-		return err
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
 	}
 
-	nTests := nTests1 + nTests2
+	nTests := nTests1 + nTests2 + nTests3
 	testString := "tests"
 	if nTests == 1 {
 		testString = "test"
 	}
-	if nErrs1 > 0 || nErrs2 > 0 {
-		nErrs := nErrs1 + nErrs2
+	if nErrs1 > 0 || nErrs2 > 0 || nErrs3 > 0 {
+		nErrs := nErrs1 + nErrs2 + nErrs3
 		errString := "errors"
 		if nErrs == 1 {
 			errString = "error"
@@ -65,6 +66,152 @@ func runInitTests(s *http.Server, port string) error {
 	return nil
 }
 
+// API tokens used by the tests: testToken has the admin role for all databases, readerToken only the reader role for the demo lexicon, and otherDBToken the editor role for another database (see setupTestAuth)
+var testToken, readerToken, otherDBToken string
+
+// setupTestAuth enables authentication for the test server, with users in a temporary file
+func setupTestAuth() error {
+	dir, err := ioutil.TempDir("", "lexserver-auth-")
+	if err != nil {
+		return err
+	}
+	authStore, err = auth.NewStore(filepath.Join(dir, "users.json"))
+	if err != nil {
+		return err
+	}
+	for _, u := range []struct {
+		name  string
+		grant auth.Grant
+		token *string
+	}{
+		{name: "tester", grant: auth.Grant{Role: auth.Admin, Scope: auth.AllScope}, token: &testToken},
+		{name: "reader", grant: auth.Grant{Role: auth.Reader, Scope: "wikispeech_lexserver_testdb:sv"}, token: &readerToken},
+		{name: "otherdb", grant: auth.Grant{Role: auth.Editor, Scope: "auth_scope_testdb"}, token: &otherDBToken},
+	} {
+		_, err = authStore.AddUser(u.name, u.grant)
+		if err != nil {
+			return err
+		}
+		*u.token, _, err = authStore.CreateToken(u.name, "init tests")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// testGet sends a GET request authenticated with testToken
+func testGet(url string) (*http.Response, error) {
+	return getWithToken(url, testToken)
+}
+
+func getWithToken(url, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// testAuth checks that requests are rejected without a token, if the user doesn't have the required role, or if the lexicon is frozen, and that results are limited to the user's scopes
+func testAuth(port string) (int, int, error) {
+
+	log.Println("init_tests: testing authentication")
+
+	nFailed := 0
+	nTests := 0
+
+	// a lock in the demo lexicon, for testing lock operations by id
+	lockURL := "http://localhost" + port + "/lexicon/lock?lexicon=wikispeech_lexserver_testdb:sv&entry_id=5&owner=tester"
+	resp, err := testGet(lockURL)
+	if err != nil {
+		return nFailed, nTests, fmt.Errorf("couldn't retrieve URL %s : %v", lockURL, err)
+	}
+	var lock lex.EntryLock
+	err = json.NewDecoder(resp.Body).Decode(&lock)
+	resp.Body.Close()
+	if err != nil {
+		return nFailed, nTests, fmt.Errorf("couldn't decode lock from %s : %v", lockURL, err)
+	}
+	defer func() {
+		resp, err := testGet(fmt.Sprintf("http://localhost%s/lexicon/unlock?id=%d&owner=tester", port, lock.ID))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	for _, test := range []struct {
+		url    string
+		token  string
+		expect int
+	}{
+		{url: "/admin/list_dbs", token: "", expect: http.StatusUnauthorized},
+		{url: "/admin/list_dbs", token: "invalid.token", expect: http.StatusUnauthorized},
+		{url: "/lexicon/lookup?lexicons=wikispeech_lexserver_testdb:sv&words=hund", token: readerToken, expect: http.StatusOK},
+		{url: "/lexicon/lookup?lexicons=lexserver_testdb:sv&words=hund", token: readerToken, expect: http.StatusForbidden},
+		{url: "/lexicon/lock?lexicon=wikispeech_lexserver_testdb:sv&entry_id=4", token: readerToken, expect: http.StatusForbidden},
+		{url: "/admin/create_db/auth_test_db", token: readerToken, expect: http.StatusForbidden},
+		{url: "/admin/users", token: readerToken, expect: http.StatusForbidden},
+		{url: "/lexicon/changes?lexicons=wikispeech_lexserver_testdb:sv", token: otherDBToken, expect: http.StatusForbidden},
+		{url: "/lexicon/changes_stream?lexicons=wikispeech_lexserver_testdb:sv&follow=false", token: otherDBToken, expect: http.StatusForbidden},
+		{url: fmt.Sprintf("/lexicon/lock?id=%d&owner=tester", lock.ID), token: otherDBToken, expect: http.StatusForbidden},
+		{url: fmt.Sprintf("/lexicon/unlock?id=%d&owner=tester", lock.ID), token: otherDBToken, expect: http.StatusForbidden},
+		{url: "/admin/freeze_lexicon/wikispeech_lexserver_testdb:sv", token: testToken, expect: http.StatusOK},
+		{url: "/lexicon/delete_entry/wikispeech_lexserver_testdb:sv/4", token: testToken, expect: http.StatusForbidden},
+		{url: "/admin/unfreeze_lexicon/wikispeech_lexserver_testdb:sv", token: testToken, expect: http.StatusOK},
+	} {
+		nTests = nTests + 1
+		url := "http://localhost" + port + test.url
+		resp, err := getWithToken(url, test.token)
+		if err != nil {
+			return nFailed, nTests, fmt.Errorf("couldn't retrieve URL %s : %v", url, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.expect {
+			fmt.Printf("** FAILED TEST ** for %s : expected response code %d, found %d\n", url, test.expect, resp.StatusCode)
+			nFailed = nFailed + 1
+		}
+	}
+
+	// without the lexicons param, only changes and locks that the user can read are listed
+	for _, test := range []struct {
+		url     string
+		token   string
+		visible bool
+	}{
+		{url: "/lexicon/changes", token: readerToken, visible: true},
+		{url: "/lexicon/changes", token: otherDBToken, visible: false},
+		{url: "/lexicon/changes_stream?since=0&follow=false", token: otherDBToken, visible: false},
+		{url: "/lexicon/locks", token: readerToken, visible: true},
+		{url: "/lexicon/locks", token: otherDBToken, visible: false},
+	} {
+		nTests = nTests + 1
+		url := "http://localhost" + port + test.url
+		resp, err := getWithToken(url, test.token)
+		if err != nil {
+			return nFailed, nTests, fmt.Errorf("couldn't retrieve URL %s : %v", url, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nFailed, nTests, fmt.Errorf("couldn't read response from %s : %v", url, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("** FAILED TEST ** for %s : expected response code %d, found %d\n", url, http.StatusOK, resp.StatusCode)
+			nFailed = nFailed + 1
+			continue
+		}
+		if visible := strings.Contains(string(body), "wikispeech_lexserver_testdb"); visible != test.visible {
+			fmt.Printf("** FAILED TEST ** for %s : expected demo lexicon in response: %v, found: %v\n", url, test.visible, visible)
+			nFailed = nFailed + 1
+		}
+	}
+	return nFailed, nTests, nil
+}
+
 func shortenURL(url string) string {
 	limit := 50
 	r := []rune(url)
@@ -88,7 +235,7 @@ func testURLsWithContent(port string) (int, int, error) {
 
 		"/lexicon/lookup?lemmas=kex&lexicons=wikispeech_lexserver_testdb:sv": `[{"id":1,"lexRef":{"dbRef":"wikispeech_lexserver_testdb","lexName":"sv"},"strn":"kex","language":"sv","partOfSpeech":"NN","morphology":"NEU IND SIN","wordParts":"kex","lemma":{"id":1,"strn":"kex"},"transcriptions":[{"id":1,"entryId":1,"strn":"\" k e k s","language":"sv"},{"id":2,"entryId":1,"strn":"\" C e k s","language":"sv"}],"status":{"id":1,"name":"demo","source":"auto","timestamp":"2020-05-25T12:46:40Z","current":true},"preferred":false,"tag":""},{"id":2,"lexRef":{"dbRef":"wikispeech_lexserver_testdb","lexName":"sv"},"strn":"kexet","language":"sv","partOfSpeech":"NN","morphology":"NEU DEF SIN","wordParts":"kexet","lemma":{"id":1,"strn":"kex"},"transcriptions":[{"id":3,"entryId":2,"strn":"\" k e k . s @ t","language":"sv"},{"id":4,"entryId":2,"strn":"\" C e k . s @ t","language":"sv"}],"status":{"id":2,"name":"demo","source":"auto","timestamp":"2020-05-25T12:46:40Z","current":true},"preferred":false,"tag":""}]`,
 
-		"/lexicon/lookup?lexicons=wikispeech_lexserver_testdb:sv&words=dom&transcriptionlike=%25o:%25&pp=yes": `[   {     "id": 9,     "lexRef": {       "dbRef": "wikispeech_lexserver_testdb",       "lexName": "sv"     },     "strn": "dom",     "language": "sv",     "partOfSpeech": "NN",     "morphology": "UTR IND SIN",     "wordParts": "dom",     "lemma": {       "id": 5,       "strn": "dom"     },     "transcriptions": [       {         "id": 12,         "entryId": 9,         "strn": "\" d o: m",         "language": "sv"       }     ],     "status": {       "id": 11,       "name": "demo",       "source": "auto",       "timestamp": "2020-05-25T12:47:04Z",       "current": true     },         "preferred": false,     "tag": "building" } ]`}
+		"/lexicon/lookup?lexicons=wikispeech_lexserver_testdb:sv&words=dom&transcriptionlike=%25o:%25&pp=yes": `[   {     "id": 9,     "lexRef": {       "dbRef": "wikispeech_lexserver_testdb",       "lexName": "sv"     },     "strn": "dom",     "language": "sv",     "partOfSpeech": "NN",     "morphology": "UTR IND SIN",     "wordParts": "dom",     "lemma": {       "id": 5,       "strn": "dom"     },     "transcriptions": [       {         "id": 12,         "entryId": 9,         "strn": "\" d o: m",         "language": "sv"       }     ],     "status": {       "id": 11,       "name": "demo",       "source": "tester",       "timestamp": "2020-05-25T12:47:04Z",       "current": true     },         "preferred": false,     "tag": "building" } ]`}
 
 	jsonMapTests := map[string]string{
 		// "/mapper/map/sv-se_ws-sampa-DEMO/sv-se_sampa_mary-DEMO/%22%22%20p%20O%20j%20.%20k%20@": `{"From":"sv-se_ws-sampa-DEMO","To":"sv-se_sampa_mary-DEMO","Input":"\"\" p O j . k @","Result":"\" p O j - k @"}`,
@@ -110,6 +257,9 @@ func testURLsWithContent(port string) (int, int, error) {
 
 	mustExistTests := []string{
 		"/ipa_table.txt",
+		"/metrics",
+		"/health/live",
+		"/health/ready",
 	}
 
 	log.Printf("init_tests: testing 200 status: %d", len(mustExistTests))
@@ -178,7 +328,7 @@ func testURLsWithContent(port string) (int, int, error) {
 func jsonMapTest(port string, url string, expect string) (bool, error) {
 	url = "http://localhost" + port + url
 	/* #nosec G107 */
-	resp, err := http.Get(url)
+	resp, err := testGet(url)
 	if err != nil {
 		fmt.Printf("** FAILED TEST ** for %s : couldn't retrieve URL : %v\n", url, err)
 		return false, nil
@@ -218,7 +368,7 @@ func jsonMapTest(port string, url string, expect string) (bool, error) {
 func jsonTestBool(port string, url string, expect bool) (bool, error) {
 	url = "http://localhost" + port + url
 	/* #nosec G107 */
-	resp, err := http.Get(url)
+	resp, err := testGet(url)
 	if err != nil {
 		fmt.Printf("** FAILED TEST ** for %s : couldn't retrieve URL : %v\n", url, err)
 		return false, nil
@@ -260,7 +410,7 @@ func contains(slice []string, item string) bool {
 func jsonListTestMustContain(port string, url string, expect []string) (bool, error) {
 	url = "http://localhost" + port + url
 	/* #nosec G107 */
-	resp, err := http.Get(url)
+	resp, err := testGet(url)
 	if err != nil {
 		fmt.Printf("** FAILED TEST ** for %s : couldn't retrieve URL : %v\n", url, err)
 		return false, nil
@@ -301,7 +451,7 @@ func jsonListTestMustContain(port string, url string, expect []string) (bool, er
 func lookupTest(port string, url string, expect string) (bool, error) {
 	url = "http://localhost" + port + url
 	/* #nosec G107 */
-	resp, err := http.Get(url)
+	resp, err := testGet(url)
 	if err != nil {
 		fmt.Printf("** FAILED TEST ** for %s : couldn't retrieve URL : %v\n", url, err)
 		return false, nil
@@ -351,7 +501,7 @@ func lookupTest(port string, url string, expect string) (bool, error) {
 func mustExistTest(port string, url string) (bool, error) {
 	url = "http://localhost" + port + url
 	/* #nosec G107 */
-	resp, err := http.Get(url)
+	resp, err := testGet(url)
 	if err != nil {
 		fmt.Printf("** FAILED TEST ** for %s : couldn't retrieve URL : %v\n", url, err)
 		return false, nil
@@ -375,7 +525,7 @@ func testExampleURLs(port string) (int, int, error) {
 	nTests := 0
 
 	/* #nosec G107 */
-	resp, err := http.Get("http://localhost" + port + "/meta/examples")
+	resp, err := testGet("http://localhost" + port + "/meta/examples")
 	if err != nil {
 		return nFailed, nTests, fmt.Errorf("couldn't retrieve server's url examples : %v", err)
 	}
@@ -395,7 +545,7 @@ func testExampleURLs(port string) (int, int, error) {
 		nTests = nTests + 1
 		url := "http://localhost" + port + urlEnc(example.URL)
 		/* #nosec G107 */
-		resp, err = http.Get(url)
+		resp, err = testGet(url)
 		if err != nil {
 			fmt.Printf("** FAILED TEST ** for %s : couldn't retrieve URL : %v\n", url, err)
 			nFailed = nFailed + 1
@@ -3,15 +3,24 @@ package main
 // The handlers of calls prefixed with '/lexicon/':
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/stts-se/pronlex/auth"
+	"github.com/stts-se/pronlex/dbapi"
 	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/pronlex/paradigm"
 )
 
 // var lexiconValidationPage = urlHandler{
@@ -67,8 +76,9 @@ var lexiconUpdateEntryURL = `/updateentry?entry={
 var lexiconUpdateEntry = urlHandler{
 	name:     "updateentry",
 	url:      "/updateentry",
-	help:     "Updates an entry in the database. Input is an entry variable in JSON format. For examples, see <a href=\"https://godoc.org/github.com/stts-se/pronlex/lex\">package documentation</a>.",
+	help:     "Updates an entry in the database. Input is an entry variable in JSON format. For examples, see <a href=\"https://godoc.org/github.com/stts-se/pronlex/lex\">package documentation</a>. Optional params: owner (lock owner; required if the entry is locked, see lock). Returns status 409 (Conflict) if the entry is locked by someone else. If authentication is enabled (see /admin/users), the authenticated user is used as the lock owner, and as the source of the entry status.",
 	examples: []string{lexiconUpdateEntryURL},
+	role:     auth.Editor,
 	handler: func(w http.ResponseWriter, r *http.Request) {
 		entryJSON := getParam("entry", r)
 		//body, err := ioutil.ReadAll(r.Body)
@@ -81,10 +91,18 @@ var lexiconUpdateEntry = urlHandler{
 		}
 
 		// Underscore below matches bool indicating if any update has taken place. Return this info?
-		res, _, err2 := dbm.UpdateEntry(e)
+		// the authenticated user is the source of a new entry status
+		if u, ok := requestUser(r); ok && e.EntryStatus.Name != "" {
+			e.EntryStatus.Source = u.Name
+		}
+		res, _, err2 := requestDBM(r).UpdateEntryAs(e, userName(r, "owner"))
 		if err2 != nil {
 			log.Printf("lexserver: Failed to update entry : %v", err2)
-			http.Error(w, fmt.Sprintf("failed to update Entry : %v", err2), http.StatusInternalServerError)
+			status := http.StatusInternalServerError
+			if _, ok := err2.(*dbapi.EntryLockedError); ok {
+				status = http.StatusConflict
+			}
+			http.Error(w, fmt.Sprintf("failed to update Entry : %v", err2), frozenErrorStatus(err2, status))
 			return
 		}
 
@@ -104,6 +122,7 @@ var lexiconUpdateValidation = urlHandler{
 	url:      "/updatevalidation",
 	help:     "Updates the validation for an entry in the database. Input is an entry variable in JSON format. For examples, see <a href=\"https://godoc.org/github.com/stts-se/pronlex/lex\">package documentation</a>.",
 	examples: []string{},
+	role:     auth.Editor,
 	handler: func(w http.ResponseWriter, r *http.Request) {
 		entryJSON := getParam("entry", r)
 		if entryJSON == "" {
@@ -120,10 +139,10 @@ var lexiconUpdateValidation = urlHandler{
 			return
 		}
 
-		err2 := dbm.UpdateValidation(e)
+		err2 := requestDBM(r).UpdateValidation(e)
 		if err2 != nil {
 			log.Printf("lexserver: Failed to update entry : %v", err2)
-			http.Error(w, fmt.Sprintf("failed to update Entry : %v", err2), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("failed to update Entry : %v", err2), frozenErrorStatus(err2, http.StatusInternalServerError))
 			return
 		}
 
@@ -138,33 +157,40 @@ type LexWithEntryCount struct {
 	SymbolSetName string `json:"symbolSetName"`
 	Locale        string `json:"locale"`
 	EntryCount    int64  `json:"entryCount"`
+	// Frozen is true if the lexicon is read-only (see /admin/freeze_lexicon)
+	Frozen bool `json:"frozen"`
 }
 
 var lexiconList = urlHandler{
 	name:     "list",
 	url:      "/list",
-	help:     "Lists available lexicons along with some basic info.",
+	help:     "Lists available lexicons along with some basic info, including whether the lexicon is frozen (read-only).",
 	examples: []string{"/list"},
 	handler: func(w http.ResponseWriter, r *http.Request) {
-		lexs0, err := dbm.ListLexicons() // TODO error handling
+		lexs0, err := requestDBM(r).ListLexicons() // TODO error handling
 		if err != nil {
 			http.Error(w, fmt.Sprintf("list lexicons failed : %v", err), http.StatusInternalServerError)
 			return
 		}
 		var lexs []LexWithEntryCount = []LexWithEntryCount{}
 		for _, lex := range lexs0 {
-			entryCount, err := dbm.EntryCount(lex.LexRef)
+			entryCount, err := requestDBM(r).EntryCount(lex.LexRef)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("lexicon stats failed : %v", err), http.StatusInternalServerError)
 				return
 			}
 
-			locale, err := dbm.Locale(lex.LexRef)
+			locale, err := requestDBM(r).Locale(lex.LexRef)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("lexicon stats failed : %v", err), http.StatusInternalServerError)
+				return
+			}
+			freeze, err := requestDBM(r).GetLexiconFreeze(lex.LexRef)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("lexicon stats failed : %v", err), http.StatusInternalServerError)
 				return
 			}
-			lexs = append(lexs, LexWithEntryCount{Name: lex.LexRef.String(), SymbolSetName: lex.SymbolSetName, Locale: locale, EntryCount: entryCount})
+			lexs = append(lexs, LexWithEntryCount{Name: lex.LexRef.String(), SymbolSetName: lex.SymbolSetName, Locale: locale, EntryCount: entryCount, Frozen: freeze.Frozen})
 		}
 		jsn, err := marshal(lexs, r)
 		if err != nil {
@@ -190,7 +216,7 @@ var lexiconListCurrentEntryStatuses = urlHandler{
 		}
 		freq := getParam("freq", r)
 		if freq == "true" {
-			statuses, err := dbm.ListCurrentEntryStatusesWithFreq(lexRef)
+			statuses, err := requestDBM(r).ListCurrentEntryStatusesWithFreq(lexRef)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("listCurrentEntryStatuses : %v", err), http.StatusInternalServerError)
 				return
@@ -203,7 +229,7 @@ var lexiconListCurrentEntryStatuses = urlHandler{
 
 			fmt.Fprint(w, string(j))
 		} else {
-			statuses, err := dbm.ListCurrentEntryStatuses(lexRef)
+			statuses, err := requestDBM(r).ListCurrentEntryStatuses(lexRef)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("listCurrentEntryStatuses : %v", err), http.StatusInternalServerError)
 				return
@@ -232,7 +258,7 @@ var lexiconListCommentLabels = urlHandler{
 			return
 		}
 
-		statuses, err := dbm.ListCommentLabels(lexRef)
+		statuses, err := requestDBM(r).ListCommentLabels(lexRef)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("listCommentLabels: %v", err), http.StatusInternalServerError)
 			return
@@ -261,7 +287,7 @@ var lexiconListCurrentEntryUsers = urlHandler{
 		}
 		freq := getParam("freq", r)
 		if freq == "true" {
-			users, err := dbm.ListCurrentEntryUsersWithFreq(lexRef)
+			users, err := requestDBM(r).ListCurrentEntryUsersWithFreq(lexRef)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("listCurrentEntryUsers : %v", err), http.StatusInternalServerError)
 				return
@@ -274,7 +300,7 @@ var lexiconListCurrentEntryUsers = urlHandler{
 			fmt.Fprint(w, string(j))
 
 		} else {
-			users, err := dbm.ListCurrentEntryUsers(lexRef)
+			users, err := requestDBM(r).ListCurrentEntryUsers(lexRef)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("listCurrentEntryUsers : %v", err), http.StatusInternalServerError)
 				return
@@ -304,7 +330,7 @@ var lexiconListAllEntryStatuses = urlHandler{
 			return
 		}
 
-		statuses, err := dbm.ListAllEntryStatuses(lexRef)
+		statuses, err := requestDBM(r).ListAllEntryStatuses(lexRef)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("listAllEntryStatuses : %v", err), http.StatusInternalServerError)
 			return
@@ -321,14 +347,19 @@ var lexiconListAllEntryStatuses = urlHandler{
 
 // LexInfo is a struct for collecting lexicon info for json result
 type LexInfo struct {
-	Name          string `json:"name"`
-	SymbolSetName string `json:"symbolSetName"`
+	Name          string            `json:"name"`
+	SymbolSetName string            `json:"symbolSetName"`
+	Meta          dbapi.LexiconMeta `json:"meta"`
+	// Frozen is true if the lexicon is read-only (see /admin/freeze_lexicon)
+	Frozen bool `json:"frozen"`
+	// Freeze tells who froze the lexicon, when and why, if it is frozen
+	Freeze *dbapi.LexiconFreeze `json:"freeze,omitempty"`
 }
 
 var lexiconInfo = urlHandler{
 	name:     "info",
 	url:      "/info/{lexicon_name}",
-	help:     "Get some basic lexicon info.",
+	help:     "Get some basic lexicon info, including lexicon meta data, and the frozen (read-only) state of the lexicon.",
 	examples: []string{"/info/wikispeech_lexserver_testdb:sv"},
 	handler: func(w http.ResponseWriter, r *http.Request) {
 		lexRef, err := getLexRefParam(r)
@@ -338,12 +369,25 @@ var lexiconInfo = urlHandler{
 			return
 		}
 
-		lex, err := dbm.GetLexicon(lexRef)
+		lex, err := requestDBM(r).GetLexicon(lexRef)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("get lexicon failed : %v", err), http.StatusInternalServerError)
 			return
 		}
-		li := LexInfo{Name: lexRef.String(), SymbolSetName: lex.SymbolSetName}
+		meta, err := requestDBM(r).GetLexiconMeta(lexRef)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("get lexicon meta data failed : %v", err), http.StatusInternalServerError)
+			return
+		}
+		freeze, err := requestDBM(r).GetLexiconFreeze(lexRef)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("get lexicon frozen state failed : %v", err), http.StatusInternalServerError)
+			return
+		}
+		li := LexInfo{Name: lexRef.String(), SymbolSetName: lex.SymbolSetName, Meta: meta, Frozen: freeze.Frozen}
+		if freeze.Frozen {
+			li.Freeze = &freeze
+		}
 		jsn, err := marshal(li, r)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
@@ -354,6 +398,176 @@ var lexiconInfo = urlHandler{
 	},
 }
 
+var lexiconUsage = urlHandler{
+	name:     "usage",
+	url:      "/usage/{lexicon_name}",
+	help:     "Lists the most frequent lookup hits (entries) and misses (words not in the lexicon). Only lookups using the 'words' parameter are recorded, and only if the server is started with the -record_usage flag. Optional param: n (max number of hits/misses to list, default 100).",
+	examples: []string{"/usage/wikispeech_lexserver_testdb:sv", "/usage/wikispeech_lexserver_testdb:sv?n=10"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, err := getLexRefParam(r)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref %v : %v", lexRef, err), http.StatusInternalServerError)
+			return
+		}
+		n := 100
+		if nS := getParam("n", r); nS != "" {
+			n, err = strconv.Atoi(nS)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("invalid value for param n : %s", nS), http.StatusBadRequest)
+				return
+			}
+		}
+		if usageRecorder != nil {
+			err = usageRecorder.Flush()
+			if err != nil {
+				log.Printf("lexserver: couldn't save lookup usage : %v", err)
+			}
+		}
+		rep, err := requestDBM(r).UsageReport(lexRef, n)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("usage report failed : %v", err), http.StatusInternalServerError)
+			return
+		}
+		jsn, err := marshal(rep, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+var lexiconActivity = urlHandler{
+	name:     "activity",
+	url:      "/activity/{lexicon_name}",
+	help:     fmt.Sprintf("Lists the number of status changes per time period, by status name and by status source, based on the entry status history. Optional params: from and to (YYYY-MM-DD; from is inclusive, to is exclusive; default: from the first status change up to and including today), bucket (day, week or month; default: day), format (json or csv; default: json). At most %d buckets are returned; longer intervals fail with status 400 Bad Request.", dbapi.MaxActivityBuckets),
+	examples: []string{"/activity/wikispeech_lexserver_testdb:sv", "/activity/wikispeech_lexserver_testdb:sv?bucket=week&format=csv"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, err := getLexRefParam(r)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref %v : %v", lexRef, err), http.StatusInternalServerError)
+			return
+		}
+		var from, to time.Time
+		if fromS := getParam("from", r); fromS != "" {
+			from, err = time.Parse("2006-01-02", fromS)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid value for param from (expected YYYY-MM-DD) : %s", fromS), http.StatusBadRequest)
+				return
+			}
+		}
+		if toS := getParam("to", r); toS != "" {
+			to, err = time.Parse("2006-01-02", toS)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid value for param to (expected YYYY-MM-DD) : %s", toS), http.StatusBadRequest)
+				return
+			}
+		}
+		bucket := dbapi.ActivityDay
+		if bucketS := getParam("bucket", r); bucketS != "" {
+			bucket, err = dbapi.ParseActivityBucketSize(bucketS)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		format := strings.ToLower(getParam("format", r))
+		if format != "" && format != "json" && format != "csv" {
+			http.Error(w, fmt.Sprintf("invalid value for param format (expected json or csv) : %s", format), http.StatusBadRequest)
+			return
+		}
+
+		stats, err := requestDBM(r).Activity(lexRef, from, to, bucket)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var re *dbapi.ActivityRangeError
+			if errors.As(err, &re) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, fmt.Sprintf("activity failed : %v", err), status)
+			return
+		}
+
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			err = writeActivityCSV(w, stats)
+			if err != nil {
+				log.Printf("lexserver: failed to write activity csv : %v", err)
+			}
+			return
+		}
+		jsn, err := marshal(stats, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+// writeActivityCSV writes one line per time bucket, with one column per status name and one per status source
+func writeActivityCSV(w io.Writer, stats dbapi.ActivityStats) error {
+	cw := csv.NewWriter(w)
+	header := []string{"start", "total"}
+	for _, s := range stats.Statuses {
+		header = append(header, "status:"+s)
+	}
+	for _, s := range stats.Sources {
+		header = append(header, "source:"+s)
+	}
+	err := cw.Write(header)
+	if err != nil {
+		return err
+	}
+	for _, b := range stats.Buckets {
+		line := []string{b.Start, strconv.FormatInt(b.Total, 10)}
+		for _, s := range stats.Statuses {
+			line = append(line, strconv.FormatInt(b.Statuses[s], 10))
+		}
+		for _, s := range stats.Sources {
+			line = append(line, strconv.FormatInt(b.Sources[s], 10))
+		}
+		err = cw.Write(line)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var lexiconQueryStats = urlHandler{
+	name:     "query_stats",
+	url:      "/query_stats",
+	help:     "Counts the entries matching a query, with facet counts by current status, status source, part of speech, language, validation level, validation rule and comment label. Takes the same query params as lookup (paging params are ignored). If 'lexicons' is a lexicon stack, all lexicons in the stack are counted.",
+	examples: []string{"/query_stats?lexicons=wikispeech_lexserver_testdb:sv&wordlike=%25", "/query_stats?lexicons=wikispeech_lexserver_testdb:sv&lemmas=kex&pp=true"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		q, err := queryFromParams(r)
+		if err != nil {
+			log.Printf("lexserver: Failed to get query params: %v", err)
+			http.Error(w, fmt.Sprintf("couldn't process query params : %v", err), http.StatusInternalServerError)
+			return
+		}
+		stats, err := requestDBM(r).QueryStats(q)
+		if err != nil {
+			log.Printf("lexserver: Failed to get query stats: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+		jsn, err := marshal(stats, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
 var lexiconStats = urlHandler{
 	name:     "stats",
 	url:      "/stats/{lexicon_name}",
@@ -367,7 +581,7 @@ var lexiconStats = urlHandler{
 			return
 		}
 
-		stats, err := dbm.LexiconStats(lexRef)
+		stats, err := requestDBM(r).LexiconStats(lexRef)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("lexiconStatsHandler: call to  dbapi.LexiconStats failed : %v", err), http.StatusInternalServerError)
 			return
@@ -386,8 +600,8 @@ var lexiconStats = urlHandler{
 var lexiconLookup = urlHandler{
 	name:     "lookup",
 	url:      "/lookup",
-	help:     "Lookup in lexicon.",
-	examples: []string{"/lookup"},
+	help:     "Lookup in lexicon. The 'lexicons' parameter may also be the name of a lexicon stack (see /admin/list_lexicon_stacks). If 'lexicons' is not set, the default lexicons of the server are used (see -default_lexicons). Use 'orderbyusage=true' to sort the result by the number of recorded lookups (see /lexicon/usage). Use 'ignorecase=true' or 'ignorediacritics=true' for case-insensitive or diacritic-insensitive matching of 'words' and 'wordlike' (ignoring diacritics also ignores case).",
+	examples: []string{"/lookup", "/lookup?lexicons=wikispeech_lexserver_testdb:sv&words=HAST&ignorediacritics=true"},
 	handler: func(w http.ResponseWriter, r *http.Request) {
 
 		var err error
@@ -420,13 +634,18 @@ var lexiconLookup = urlHandler{
 			return
 		}
 
-		res, err := dbm.LookUpIntoSlice(q)
+		writer := lex.EntrySliceWriter{}
+		err = lookUp(r, q, &writer)
+		res := append([]lex.Entry{}, writer.Entries...)
 
 		if err != nil {
 			log.Printf("lexserver: Failed to get entries: %v", err)
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 			return
 		}
+		if usageRecorder != nil {
+			usageRecorder.RecordLookup(q.LexRefs, q.Query, res)
+		}
 
 		jsn, err := marshal(res, r)
 		if err != nil {
@@ -485,7 +704,7 @@ var lexiconEntriesExist = urlHandler{
 		}
 		var res = make(map[string][]MiniEntry)
 		writer := lex.EntrySliceWriter{}
-		err = dbm.LookUp(q, &writer)
+		err = lookUp(r, q, &writer)
 		if err != nil {
 			log.Printf("lexserver: Failed to get entries: %v", err)
 			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
@@ -542,6 +761,7 @@ var lexiconAddEntry = urlHandler{
 	url:      "/addentry",
 	help:     "Add an entry to the database. Input entry in JSON format. For examples, see <a href=\"https://godoc.org/github.com/stts-se/pronlex/lex\">package documentation</a>.",
 	examples: []string{lexiconAddEntryURL},
+	role:     auth.Editor,
 	handler: func(w http.ResponseWriter, r *http.Request) {
 		lexRef, err := getLexRefParam(r)
 		if err != nil {
@@ -559,11 +779,14 @@ var lexiconAddEntry = urlHandler{
 			return
 		}
 
-		ids, err := dbm.InsertEntries(lexRef, []lex.Entry{e})
+		if u, ok := requestUser(r); ok && e.EntryStatus.Name != "" {
+			e.EntryStatus.Source = u.Name
+		}
+		ids, _, err := requestDBM(r).InsertEntriesAs(lexRef, []lex.Entry{e}, userName(r, "user"))
 		if err != nil {
 			msg := fmt.Sprintf("lexserver failed to update entry : %v", err)
 			log.Println(msg)
-			http.Error(w, msg, http.StatusInternalServerError)
+			http.Error(w, msg, frozenErrorStatus(err, http.StatusInternalServerError))
 			return
 		}
 		jsids := IDs{ids}
@@ -596,10 +819,14 @@ var deleteEntry = func(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	idRes, err := dbm.DeleteEntry(id, lexRef)
+	idRes, err := requestDBM(r).DeleteEntryAs(id, lexRef, userName(r, "owner"))
 	if err != nil {
 		log.Println(err)
-		http.Error(w, fmt.Sprintf("failed to detele entry id '%s' in lexicon '%s' : %v", entryID, lexRef.LexName, err), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if _, ok := err.(*dbapi.EntryLockedError); ok {
+			status = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("failed to detele entry id '%s' in lexicon '%s' : %v", entryID, lexRef.LexName, err), frozenErrorStatus(err, status))
 		return
 	}
 
@@ -609,8 +836,9 @@ var deleteEntry = func(w http.ResponseWriter, r *http.Request) {
 var lexiconDeleteEntry = urlHandler{
 	name:     "delete_entry",
 	url:      "/delete_entry/{lexicon_name}/{entry_id}",
-	help:     "Delete an entry from the database.",
+	help:     "Delete an entry from the database. Optional params: owner (lock owner; required if the entry is locked, see lock). Returns status 409 (Conflict) if the entry is locked by someone else.",
 	examples: []string{},
+	role:     auth.Editor,
 	handler:  deleteEntry,
 }
 
@@ -698,3 +926,234 @@ var lexiconDeleteEntry = urlHandler{
 // 	}
 // 	return d
 // }
+
+func getEntryRelationParam(r *http.Request) (lex.EntryRelation, error) {
+	var rel lex.EntryRelation
+	relJSON := getParam("relation", r)
+	if strings.TrimSpace(relJSON) == "" {
+		return rel, fmt.Errorf("input param <relation> must not be empty")
+	}
+	err := json.Unmarshal([]byte(relJSON), &rel)
+	if err != nil {
+		return rel, fmt.Errorf("failed to process incoming relation json : %v", err)
+	}
+	return rel, nil
+}
+
+var lexiconListRelations = urlHandler{
+	name:     "list_relations",
+	url:      "/list_relations/{db_name}/{entry_id}",
+	help:     "List relations to and from an entry.",
+	examples: []string{"/list_relations/wikispeech_lexserver_testdb/3"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		dbRef := lex.DBRef(delQuote(getParam("db_name", r)))
+		entryID := getParam("entry_id", r)
+		id, err := strconv.ParseInt(entryID, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to parse entry id %s : %v", entryID, err), http.StatusBadRequest)
+			return
+		}
+		rels, err := requestDBM(r).ListEntryRelations(dbRef, id)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, fmt.Sprintf("failed to list relations : %v", err), http.StatusInternalServerError)
+			return
+		}
+		jsn, err := marshal(rels, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+var lexiconAddRelation = urlHandler{
+	name:     "add_relation",
+	url:      "/add_relation",
+	help:     "Add a typed relation between two entries in the same database. Input relation in JSON format. Valid relation types: " + strings.Join(lex.EntryRelationTypes, ", ") + ".",
+	examples: []string{`/add_relation?db_name=wikispeech_lexserver_testdb&relation={"type":"compound_part","fromEntryId":3,"toEntryId":1,"position":1}`},
+	role:     auth.Editor,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		dbRef := lex.DBRef(delQuote(getParam("db_name", r)))
+		rel, err := getEntryRelationParam(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		rel, err = requestDBM(r).InsertEntryRelation(dbRef, rel)
+		if err != nil {
+			msg := fmt.Sprintf("lexserver failed to add relation : %v", err)
+			log.Println(msg)
+			http.Error(w, msg, frozenErrorStatus(err, http.StatusInternalServerError))
+			return
+		}
+		jsn, err := marshal(rel, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+var lexiconUpdateRelation = urlHandler{
+	name:     "update_relation",
+	url:      "/update_relation",
+	help:     "Update the type and/or position of an existing relation (identified by its id). Input relation in JSON format.",
+	examples: []string{},
+	role:     auth.Editor,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		dbRef := lex.DBRef(delQuote(getParam("db_name", r)))
+		rel, err := getEntryRelationParam(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		rel, err = requestDBM(r).UpdateEntryRelation(dbRef, rel)
+		if err != nil {
+			msg := fmt.Sprintf("lexserver failed to update relation : %v", err)
+			log.Println(msg)
+			http.Error(w, msg, frozenErrorStatus(err, http.StatusInternalServerError))
+			return
+		}
+		jsn, err := marshal(rel, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+var lexiconDeleteRelation = urlHandler{
+	name:     "delete_relation",
+	url:      "/delete_relation/{db_name}/{relation_id}",
+	help:     "Delete a relation between two entries.",
+	examples: []string{},
+	role:     auth.Editor,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		dbRef := lex.DBRef(delQuote(getParam("db_name", r)))
+		relID := getParam("relation_id", r)
+		id, err := strconv.ParseInt(relID, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to parse relation id %s : %v", relID, err), http.StatusBadRequest)
+			return
+		}
+		err = requestDBM(r).DeleteEntryRelation(dbRef, id)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, fmt.Sprintf("failed to delete relation id '%d' : %v", id, err), frozenErrorStatus(err, http.StatusInternalServerError))
+			return
+		}
+		fmt.Fprintf(w, "deleted relation id '%d' from db '%s'\n", id, dbRef)
+	},
+}
+
+var lexiconListParadigms = urlHandler{
+	name:     "list_paradigms",
+	url:      "/list_paradigms",
+	help:     "List paradigm definitions available for inflection generation.",
+	examples: []string{"/list_paradigms"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		res := []paradigm.Paradigm{}
+		for _, p := range paradigms {
+			res = append(res, p)
+		}
+		sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+		jsn, err := marshal(res, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+// GeneratedParadigm is the result of a call to generate_paradigm
+type GeneratedParadigm struct {
+	Paradigm   string               `json:"paradigm"`
+	Candidates []paradigm.Candidate `json:"candidates"`
+}
+
+var lexiconGenerateParadigm = urlHandler{
+	name:     "generate_paradigm",
+	url:      "/generate_paradigm",
+	help:     "Generate inflected entries from a lemma entry, using the paradigm of the entry's lemma (or the paradigm specified by the <i>paradigm</i> parameter). The lemma entry is specified by <i>entry_id</i> (for an entry in the lexicon), or <i>entry</i> (JSON). Each generated entry is marked with whether it already exists in the lexicon. Nothing is saved to the database.",
+	examples: []string{"/generate_paradigm?lexicon_name=wikispeech_lexserver_testdb:sv&entry_id=1&paradigm=s7n-övriga ex träd"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, err := getLexRefParam(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref %v : %v", lexRef, err), http.StatusBadRequest)
+			return
+		}
+
+		var base lex.Entry
+		if entryJSON := getParam("entry", r); entryJSON != "" {
+			err = json.Unmarshal([]byte(entryJSON), &base)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to process incoming Entry json : %v", err), http.StatusBadRequest)
+				return
+			}
+		} else {
+			entryID := getParam("entry_id", r)
+			id, err := strconv.ParseInt(entryID, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to parse entry id '%s' : %v", entryID, err), http.StatusBadRequest)
+				return
+			}
+			es, err := requestDBM(r).LookUpIntoSlice(dbapi.DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: dbapi.Query{EntryIDs: []int64{id}}})
+			if err != nil {
+				http.Error(w, fmt.Sprintf("lookup failed : %v", err), http.StatusInternalServerError)
+				return
+			}
+			if len(es) != 1 {
+				http.Error(w, fmt.Sprintf("no entry with id %d in lexicon %s", id, lexRef), http.StatusBadRequest)
+				return
+			}
+			base = es[0]
+		}
+
+		pName := strings.TrimSpace(getParam("paradigm", r))
+		if pName == "" {
+			pName = base.Lemma.Paradigm
+		}
+		if pName == "" {
+			http.Error(w, "no paradigm specified, and the input entry has no lemma paradigm", http.StatusBadRequest)
+			return
+		}
+		p, ok := paradigms[pName]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown paradigm '%s'", pName), http.StatusBadRequest)
+			return
+		}
+
+		generated, err := p.Generate(base)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		words := []string{}
+		for _, e := range generated {
+			words = append(words, e.Strn)
+		}
+		existing, err := requestDBM(r).LookUpIntoSlice(dbapi.DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: dbapi.Query{Words: words}})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("lookup failed : %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		res := GeneratedParadigm{Paradigm: p.Name, Candidates: paradigm.MarkExisting(generated, existing)}
+		jsn, err := marshal(res, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
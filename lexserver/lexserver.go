@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"io"
+	"log/slog"
 	"log/syslog"
 	//"database/sql"
 	"encoding/json"
@@ -24,8 +26,11 @@ import (
 	"github.com/gorilla/mux"
 	"golang.org/x/net/websocket"
 
+	"github.com/stts-se/pronlex/auth"
 	"github.com/stts-se/pronlex/dbapi"
 	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/pronlex/paradigm"
+	"github.com/stts-se/pronlex/replication"
 )
 
 func getParam(paramName string, r *http.Request) string {
@@ -51,7 +56,7 @@ func getLexRefParam(r *http.Request) (lex.LexRef, error) {
 }
 
 func (rout *subRouter) addHandler(handler urlHandler) {
-	rout.router.HandleFunc(handler.url, handler.handler)
+	rout.router.HandleFunc(handler.url, instrument(rout.root, handler.name, rout.authorise(handler)))
 	rout.handlers = append(rout.handlers, handler)
 }
 
@@ -60,6 +65,8 @@ type subRouter struct {
 	router   *mux.Router
 	handlers []urlHandler
 	desc     string
+	// role is the default role required to call the handlers, if authentication is enabled (see urlHandler.role)
+	role auth.Role
 }
 
 var subRouters []*subRouter
@@ -70,6 +77,12 @@ type urlHandler struct {
 	url      string
 	help     string
 	examples []string
+	// role is the role required to call the handler, if authentication is enabled (see -auth_file). If empty, the role of the sub router is used. The role is required for the databases and lexicons referenced by the request params (see requestScopes).
+	role auth.Role
+	// global is true if the role is required for all databases, e.g. for server-wide settings
+	global bool
+	// scopes returns the databases and lexicons referenced by the request params, for handlers reading other params than requestScopes
+	scopes func(r *http.Request) []lex.LexRef
 }
 
 // TODO: Neat URL encoding...
@@ -124,11 +137,12 @@ func removeInitialSlash(url string) string {
 		return res
 	}
 */
-func newSubRouter(rout *mux.Router, root string, description string) *subRouter {
+func newSubRouter(rout *mux.Router, root string, description string, role auth.Role) *subRouter {
 	var res = subRouter{
 		router: rout.PathPrefix(root).Subrouter(),
 		root:   root,
 		desc:   description,
+		role:   role,
 	}
 
 	helpHandler := func(w http.ResponseWriter, r *http.Request) {
@@ -366,6 +380,10 @@ var knownParams = map[string]int{
 	"commentsourcelike":   1,
 	"commentlike":         1,
 	"multipletags":        1,
+	"includerelated":      1,
+	"orderbyusage":        1,
+	"ignorecase":          1,
+	"ignorediacritics":    1,
 	"page":                1,
 	"pagelength":          1,
 	"pp":                  1,
@@ -374,10 +392,18 @@ var knownParams = map[string]int{
 // list of values to the same param splits on comma and/or space
 var splitRE = regexp.MustCompile("[, ]+")
 
+// lexiconsParam returns the lexicons (or lexicon stack) of the 'lexicons' param, or the default lexicons (see -default_lexicons) if the param is empty
+func lexiconsParam(r *http.Request) []string {
+	lexs := dbapi.RemoveEmptyStrings(splitRE.Split(getParam("lexicons", r), -1))
+	if len(lexs) == 0 {
+		return defaultLexicons
+	}
+	return lexs
+}
+
 func queryFromParams(r *http.Request) (dbapi.DBMQuery, error) {
 
-	lexs := dbapi.RemoveEmptyStrings(
-		splitRE.Split(getParam("lexicons", r), -1))
+	lexs := lexiconsParam(r)
 	words := dbapi.RemoveEmptyStrings(
 		splitRE.Split(getParam("words", r), -1))
 	wordParts := dbapi.RemoveEmptyStrings(
@@ -428,6 +454,25 @@ func queryFromParams(r *http.Request) (dbapi.DBMQuery, error) {
 	if strings.ToLower(getParam("multipletags", r)) == "true" {
 		multipleTags = true
 	}
+	// If true, related entries are included in the result
+	includeRelated := false
+	if strings.ToLower(getParam("includerelated", r)) == "true" {
+		includeRelated = true
+	}
+	// If true, the result is sorted by recorded lookup usage
+	orderByUsage := false
+	if strings.ToLower(getParam("orderbyusage", r)) == "true" {
+		orderByUsage = true
+	}
+	// Lookup modes for words and wordlike
+	ignoreCase := false
+	if strings.ToLower(getParam("ignorecase", r)) == "true" {
+		ignoreCase = true
+	}
+	ignoreDiacritics := false
+	if strings.ToLower(getParam("ignorediacritics", r)) == "true" {
+		ignoreDiacritics = true
+	}
 	validationRuleLike := strings.TrimSpace(getParam("validationrulelike", r))
 	validationLevelLike := strings.TrimSpace(getParam("validationlevellike", r))
 
@@ -453,13 +498,23 @@ func queryFromParams(r *http.Request) (dbapi.DBMQuery, error) {
 		pageLength = 0
 	}
 
+	stack, err := stackFromParams(r)
+	if err != nil {
+		return dbapi.DBMQuery{}, err
+	}
 	lexRefs := []lex.LexRef{}
-	for _, l := range lexs {
-		ref, err := lex.ParseLexRef(l)
-		if err != nil {
-			return dbapi.DBMQuery{}, fmt.Errorf("couldn't parse lexicon reference from string %s", l)
+	if stack != "" {
+		// the lexicons of the stack are used as is by queries other than lookup (see lookUp)
+		s, _ := requestDBM(r).GetLexiconStack(stack)
+		lexRefs = append(lexRefs, s.Lexicons...)
+	} else {
+		for _, l := range lexs {
+			ref, err := lex.ParseLexRef(l)
+			if err != nil {
+				return dbapi.DBMQuery{}, fmt.Errorf("couldn't parse lexicon reference from string %s", l)
+			}
+			lexRefs = append(lexRefs, ref)
 		}
-		lexRefs = append(lexRefs, ref)
 	}
 
 	q := dbapi.Query{
@@ -495,6 +550,10 @@ func queryFromParams(r *http.Request) (dbapi.DBMQuery, error) {
 		ValidationRuleLike:  validationRuleLike,
 		ValidationLevelLike: validationLevelLike,
 		Users:               users,
+		IncludeRelated:      includeRelated,
+		OrderByUsage:        orderByUsage,
+		IgnoreCase:          ignoreCase,
+		IgnoreDiacritics:    ignoreDiacritics,
 	}
 
 	dq := dbapi.DBMQuery{
@@ -504,6 +563,32 @@ func queryFromParams(r *http.Request) (dbapi.DBMQuery, error) {
 	return dq, nil
 }
 
+// stackFromParams returns the name of the lexicon stack given in the 'lexicons' param, or the empty string if the param contains lexicon references only. A stack cannot be combined with other lexicons.
+func stackFromParams(r *http.Request) (string, error) {
+	lexs := lexiconsParam(r)
+	for _, l := range lexs {
+		if _, ok := requestDBM(r).GetLexiconStack(l); ok {
+			if len(lexs) > 1 {
+				return "", fmt.Errorf("lexicon stack '%s' cannot be combined with other lexicons", l)
+			}
+			return l, nil
+		}
+	}
+	return "", nil
+}
+
+// lookUp performs the query on the lexicons in q, or, if the 'lexicons' param names a lexicon stack, on that stack
+func lookUp(r *http.Request, q dbapi.DBMQuery, out lex.EntryWriter) error {
+	stack, err := stackFromParams(r)
+	if err != nil {
+		return err
+	}
+	if stack != "" {
+		return requestDBM(r).LookUpStack(stack, q.Query, out)
+	}
+	return requestDBM(r).LookUp(q, out)
+}
+
 // Remove initial and trailing " or ' from string
 func delQuote(s string) string {
 	res := s
@@ -607,6 +692,12 @@ func keepClientsAlive() {
 var dbm *dbapi.DBManager
 var dbEngine *string
 
+// usageRecorder records lookup hits and misses, if enabled (see the -record_usage flag)
+var usageRecorder *dbapi.UsageRecorder
+
+// paradigms holds the paradigm definitions used for inflection generation, with paradigm name as key
+var paradigms = make(map[string]paradigm.Paradigm)
+
 /*
 func keepAlive(wsC chan string) {
 	c := time.Tick(57 * time.Second)
@@ -626,7 +717,7 @@ func apiChangedHandler(msg string) func(http.ResponseWriter, *http.Request) {
 	}
 */
 func isStaticPage(url string) bool {
-	return url == "/" || strings.Contains(url, "externals") || strings.Contains(url, "built") || url == "/websockreg" || url == "/favicon.ico" || url == "/static/" || url == "/ipa_table.txt" || url == "/ping" || url == "/version"
+	return url == "/" || strings.Contains(url, "externals") || strings.Contains(url, "built") || url == "/websockreg" || url == "/favicon.ico" || url == "/static/" || url == "/ipa_table.txt" || url == "/ping" || url == "/version" || url == "/metrics" || url == "/health/live" || url == "/health/ready"
 }
 
 var prefix string
@@ -638,16 +729,36 @@ func main() {
 	tag := "standard"
 	vInfo = getVersionInfo()
 
-	defaultSqliteLocation := filepath.Join(".", "db_files")
-	defaultMariaDBLocation := "speechoid:@tcp(127.0.0.1:3306)"
+	defaultSqliteLocation := defaultDBLocation("sqlite")
+	defaultMariaDBLocation := defaultDBLocation("mariadb")
 
 	var test = flag.Bool("test", false, "run server tests")
 	dbEngine = flag.String("db_engine", "sqlite", "db engine (sqlite or mariadb)")
 	var maxOpenConns = flag.Int("max_open_conns", 0, "max open connections to one db")
 	dbLocation = flag.String("db_location", "", fmt.Sprintf("db location (default \"%s\" for sqlite; \"%s\" for mariadb)", defaultSqliteLocation, defaultMariaDBLocation))
 	var logger = flag.String("logger", "stderr", "System `logger` (stderr, syslog or filename)")
+	var logFormat = flag.String("log_format", "text", "log `format` (text or json)")
+	var logLevel = flag.String("log_level", "info", "log `level` (debug, info, warn or error). Successful requests are logged at level debug, and failed requests at level warn or error")
 	var prefixFlag = flag.String("prefix", "", "Explicit server prefix (e.g. /lexserver)")
 	var static = flag.String("static", filepath.Join(".", "static"), "location for static html files")
+	var paradigmDir = flag.String("paradigms", "", "location for paradigm definition files (*"+paradigm.FileExtension+")")
+	var symbolSetDir = flag.String("symbolset_dir", "", "comma separated list of folders with symbol set files, used to load validators. If set, validators are bound to all lexicons with a matching symbol set, so that inserted and updated entries are validated automatically")
+	var recordUsage = flag.Bool("record_usage", false, "record lookup hits and misses (see /lexicon/usage)")
+	var usageFlushInterval = flag.Duration("usage_flush_interval", time.Minute, "interval for saving recorded lookup hits and misses to the database")
+	var lookupCacheSize = flag.Int("lookup_cache_size", 10000, "max number of cached word lookups (see /admin/lookup_cache); 0 disables the cache")
+	var followPrimary = flag.String("follow_primary", "", "base `URL` of a primary lexserver to replicate the databases from (follower mode). Missing databases are created from backups of the primary. The replicated databases should not be edited on this server")
+	var followToken = flag.String("follow_token", "", "API token for the primary lexserver, if it requires authentication (see -follow_primary)")
+	var followInterval = flag.Duration("follow_interval", time.Minute, "interval for pulling changes from the primary lexserver (see -follow_primary)")
+	var changeRetention = flag.Duration("change_retention", 30*24*time.Hour, "retention period for change events (see /changes). Older events are deleted, except for the most recent one in each db; 0 keeps all events. Followers must pull changes more often than this")
+	var authFile = flag.String("auth_file", "", "JSON `file` with users and API tokens. If set, requests must be authenticated (see /admin/users). If the file has no users, an admin user is created, and its token is logged")
+	var authAnonymousRead = flag.Bool("auth_anonymous_read", false, "allow unauthenticated requests to handlers requiring the reader role (see -auth_file)")
+	var stackFile = flag.String("lexicon_stacks", "", "JSON file for persisting lexicon stacks (default \"<db_location>/lexicon_stacks.json\" for sqlite, and \"lexicon_stacks.json\" in the working directory for mariadb)")
+	var healthOptionalDBs = flag.String("health_optional_dbs", "", "comma separated list of `databases` that don't make the server unready if they are unavailable (see /health/ready)")
+	var defaultLexiconsFlag = flag.String("default_lexicons", "", "comma separated list of `lexicons` (or a lexicon stack) used by lookups and other queries without the lexicons param")
+	var readTimeout = flag.Duration("read_timeout", 10*time.Second, "max duration for reading a request")
+	var writeTimeout = flag.Duration("write_timeout", 10*time.Second, "max duration for writing a response")
+	var configFile = flag.String("config", "", "JSON config `file`, with the same settings as the flags, and additional database locations. Flags given on the command line override the values of the file")
+	var printConfig = flag.Bool("print_config", false, "print the configuration in use (from the config file and flags) as JSON, and exit")
 	var version = flag.Bool("version", false, "print version and exit")
 	var help = flag.Bool("help", false, "print usage/help and exit")
 
@@ -688,6 +799,20 @@ Default ports:
 		os.Exit(1)
 	}
 
+	if *configFile != "" {
+		cfg, err := readConfigFile(*configFile)
+		if err != nil {
+			log.Fatalf("Couldn't read config file: %v", err)
+		}
+		err = applyConfig(cfg)
+		if err != nil {
+			log.Fatalf("Couldn't apply config file %s: %v", *configFile, err)
+		}
+		if cfg.Port != "" {
+			port = cfg.Port
+		}
+	}
+
 	if *test {
 		port = testPort
 		tag = "test"
@@ -703,6 +828,20 @@ Default ports:
 		port = ":" + port
 	}
 
+	if *printConfig {
+		jsn, err := json.MarshalIndent(effectiveConfig(port), "", "  ")
+		if err != nil {
+			log.Fatalf("Couldn't marshal config: %v", err)
+		}
+		fmt.Println(string(jsn))
+		os.Exit(0)
+	}
+	defaultLexicons = splitFlagList(*defaultLexiconsFlag)
+	httpReadTimeout = *readTimeout
+	httpWriteTimeout = *writeTimeout
+
+	var logWriter io.Writer = os.Stderr
+	logTime := true
 	if *logger == "stderr" {
 		// default logger
 	} else if *logger == "syslog" {
@@ -710,8 +849,8 @@ Default ports:
 		if err != nil {
 			log.Fatalf("Couldn't create logger: %v", err)
 		}
-		log.SetOutput(writer)
-		log.SetFlags(0) // no timestamps etc, since syslog already prints that
+		logWriter = writer
+		logTime = false // no timestamps, since syslog already prints that
 	} else {
 		f, err := os.OpenFile(*logger, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 		if err != nil {
@@ -723,8 +862,13 @@ Default ports:
 				log.Fatalf("Couldn't close logger: %v", err)
 			}
 		}()
-		log.SetOutput(f)
+		logWriter = f
 	}
+	logHandler, err := newLogHandler(logWriter, *logFormat, *logLevel, logTime)
+	if err != nil {
+		log.Fatalf("Couldn't create logger: %v", err)
+	}
+	slog.SetDefault(slog.New(logHandler))
 	log.Println("lexserver: created logger for " + *logger)
 
 	prefix = *prefixFlag
@@ -741,23 +885,23 @@ Default ports:
 	//dbLocation = *dbFiles
 	staticFolder = *static
 
-	var engine dbapi.DBEngine
-	if *dbEngine == "sqlite" {
-		engine = dbapi.Sqlite
-		if *dbLocation == "" {
-			dbLocation = &defaultSqliteLocation
-		}
-	} else if *dbEngine == "mariadb" {
-		engine = dbapi.MariaDB
-		if *dbLocation == "" {
+	engine, err := parseDBEngine(*dbEngine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *dbLocation == "" {
+		if engine == dbapi.MariaDB {
 			dbLocation = &defaultMariaDBLocation
+		} else {
+			dbLocation = &defaultSqliteLocation
 		}
-	} else {
-		log.Fatalf("Invalid db engine: %s", *dbEngine)
 	}
 	log.Printf("lexserver: db_location = %s", *dbLocation)
+	for _, loc := range extraDBLocations {
+		log.Printf("lexserver: additional db_location = %s (%s)", loc.Location, loc.Engine)
+	}
 
-	err := initFolders()
+	err = initFolders()
 	if err != nil {
 		log.Fatal(fmt.Errorf("lexserver: couldn't initialize folders : %v", err))
 		os.Exit(1)
@@ -769,9 +913,82 @@ Default ports:
 		os.Exit(1)
 	}
 	dbm.MaxOpenConns = *maxOpenConns
-	if engine == dbapi.Sqlite {
+	// the regexp driver is needed for all sqlite locations, including the additional ones
+	useSqlite := engine == dbapi.Sqlite
+	for _, loc := range extraDBLocations {
+		if loc.Engine == "sqlite" {
+			useSqlite = true
+		}
+	}
+	if useSqlite {
 		dbapi.Sqlite3WithRegex()
 	}
+	for _, loc := range extraDBLocations {
+		e, _ := parseDBEngine(loc.Engine) // validated by readConfigFile
+		err = dbm.AddDBLocation(e, loc.Location)
+		if err != nil {
+			log.Fatalf("lexserver: couldn't add db location : %v", err)
+		}
+	}
+	dbm.SetLookupCacheSize(*lookupCacheSize)
+	for _, db := range dbapi.RemoveEmptyStrings(strings.Split(*healthOptionalDBs, ",")) {
+		optionalDBs[lex.DBRef(strings.TrimSpace(db))] = true
+	}
+
+	if *paradigmDir != "" {
+		paradigms, err = paradigm.LoadDir(*paradigmDir)
+		if err != nil {
+			log.Fatal(fmt.Errorf("lexserver: couldn't load paradigms : %v", err))
+			os.Exit(1)
+		}
+		log.Printf("lexserver: loaded %d paradigm(s) from %s", len(paradigms), *paradigmDir)
+	}
+
+	if *stackFile == "" {
+		// the mariadb location is a server DSN, so the stacks are saved in the working directory
+		*stackFile = "lexicon_stacks.json"
+		if engine == dbapi.Sqlite {
+			*stackFile = filepath.Join(*dbLocation, "lexicon_stacks.json")
+		}
+	}
+	err = dbm.SetLexiconStackFile(*stackFile)
+	if err != nil {
+		log.Fatal(fmt.Errorf("lexserver: couldn't load lexicon stacks : %v", err))
+		os.Exit(1)
+	}
+	log.Printf("lexserver: loaded %d lexicon stack(s) from %s", len(dbm.ListLexiconStacks()), *stackFile)
+
+	if *test {
+		err = setupTestAuth()
+		if err != nil {
+			log.Fatal(fmt.Errorf("lexserver: couldn't set up authentication for tests : %v", err))
+			os.Exit(1)
+		}
+	} else if *authFile != "" {
+		err = setupAuth(*authFile)
+		if err != nil {
+			log.Fatal(fmt.Errorf("lexserver: couldn't load users : %v", err))
+			os.Exit(1)
+		}
+		anonymousRead = *authAnonymousRead
+		log.Printf("lexserver: authentication enabled for %d user(s) from %s", len(authStore.Users()), *authFile)
+	} else {
+		log.Printf("lexserver: no auth file, authentication is disabled")
+	}
+
+	if *symbolSetDir != "" {
+		err = loadValidators(splitFlagList(*symbolSetDir)...)
+		if err != nil {
+			log.Fatal(fmt.Errorf("lexserver: couldn't load validators : %v", err))
+			os.Exit(1)
+		}
+		log.Printf("lexserver: loaded validators : %v", validatorNames())
+	}
+
+	if *recordUsage {
+		usageRecorder = dbapi.NewUsageRecorder(dbm, *usageFlushInterval)
+		log.Printf("lexserver: recording lookup usage, saved every %v", *usageFlushInterval)
+	}
 
 	log.Println("lexserver: started")
 
@@ -806,7 +1023,20 @@ Default ports:
 		}()
 		log.Printf("lexserver: server up and running using port %s", port)
 
+		ctx, stopBackground := context.WithCancel(context.Background())
+		defer stopBackground()
+		if *followPrimary != "" {
+			client := replication.Client{PrimaryURL: *followPrimary, Token: *followToken}
+			go replication.Follow(ctx, dbm, *dbLocation, client, *followInterval)
+			log.Printf("lexserver: following primary server %s, pulling changes every %v", client.Source(), *followInterval)
+		}
+		if *changeRetention > 0 {
+			go pruneChanges(ctx, *changeRetention, time.Hour)
+			log.Printf("lexserver: pruning change events older than %v", *changeRetention)
+		}
+
 		<-stop
+		stopBackground()
 
 		// This happens after Ctrl-C
 		fmt.Fprintf(os.Stderr, "\n")
@@ -822,6 +1052,13 @@ func shutdown(s *http.Server) {
 	defer cancel()
 	defer s.Shutdown(ctx)
 
+	if usageRecorder != nil {
+		err := usageRecorder.Stop()
+		if err != nil {
+			log.Printf("couldn't save lookup usage : %v", err)
+		}
+	}
+
 	// shut down databases nicely
 	dbNames, err := dbm.ListDBNames()
 	if err != nil {
@@ -837,6 +1074,28 @@ func shutdown(s *http.Server) {
 	log.Println("lexserver: shutdown completed")
 }
 
+// migrateDBs upgrades the loaded databases that were created with an older schema version (see dbapi.DBManager.Migrate)
+func migrateDBs() error {
+	dbs, err := dbm.ListDBNames()
+	if err != nil {
+		return fmt.Errorf("failed to list dbs : %v", err)
+	}
+	for _, dbRef := range dbs {
+		report, err := dbm.Migrate(dbRef)
+		if err != nil {
+			return fmt.Errorf("failed to migrate db %s : %v", dbRef, err)
+		}
+		if !report.Migrated {
+			continue
+		}
+		log.Printf("lexserver: migrated db %s from schema version %s to %s (%d normalised entries, %d assign comments converted into assignments)", dbRef, report.FromVersion, report.ToVersion, report.NormalisedEntries, report.ConvertedAssignComments)
+		for _, c := range report.Collisions {
+			log.Printf("lexserver: normalisation collision in lexicon %s:%s : %q (entries %v)", dbRef, c.Lexicon, c.Variants, c.EntryIDs)
+		}
+	}
+	return nil
+}
+
 func createServer(port string) (*http.Server, error) {
 
 	var s *http.Server
@@ -853,18 +1112,33 @@ func createServer(port string) (*http.Server, error) {
 	if err != nil {
 		return s, err
 	}
-	// err = loadValidators(symbolSetFileArea)
-	// if err != nil {
-	// 	return s, fmt.Errorf("failed to load validators : %v", err)
-	// }
-	// log.Printf("lexserver: loaded validators : %v", validatorNames())
+	for _, loc := range extraDBLocations {
+		log.Print("lexserver: loading dbs from location ", loc.Location)
+		err = dbm.FirstTimePopulateDBCache(loc.Location)
+		if err != nil {
+			return s, err
+		}
+	}
+	err = migrateDBs()
+	if err != nil {
+		return s, err
+	}
+	err = bindAllValidators()
+	if err != nil {
+		return s, fmt.Errorf("failed to bind validators : %v", err)
+	}
 
-	lexicon := newSubRouter(rout, "/lexicon", "Lexicon management/admin, including full validation")
+	lexicon := newSubRouter(rout, "/lexicon", "Lexicon management/admin, including full validation", auth.Reader)
 	lexicon.addHandler(lexiconList)
 	lexicon.addHandler(lexiconLookup) // has its own index page in static/
 	lexicon.addHandler(lexiconEntriesExist)
 	lexicon.addHandler(lexiconInfo)
 	lexicon.addHandler(lexiconStats)
+	lexicon.addHandler(lexiconUsage)
+	lexicon.addHandler(lexiconActivity)
+	lexicon.addHandler(lexiconQueryStats)
+	lexicon.addHandler(lexiconChanges)
+	lexicon.addHandler(lexiconChangesStream)
 	lexicon.addHandler(lexiconListCommentLabels)
 	lexicon.addHandler(lexiconListCurrentEntryUsers)
 	lexicon.addHandler(lexiconListCurrentEntryStatuses)
@@ -875,17 +1149,58 @@ func createServer(port string) (*http.Server, error) {
 	lexicon.addHandler(lexiconUpdateValidation)
 	lexicon.addHandler(lexiconAddEntry)
 	lexicon.addHandler(lexiconDeleteEntry)
-
-	admin := newSubRouter(rout, "/admin", "Misc admin tools")
+	lexicon.addHandler(lexiconLock)
+	lexicon.addHandler(lexiconUnlock)
+	lexicon.addHandler(lexiconLocks)
+	lexicon.addHandler(lexiconSubmitProposal)
+	lexicon.addHandler(lexiconProposals)
+	lexicon.addHandler(lexiconAmendProposal)
+	lexicon.addHandler(lexiconApproveProposal)
+	lexicon.addHandler(lexiconRejectProposal)
+	lexicon.addHandler(lexiconAssign)
+	lexicon.addHandler(lexiconWorklist)
+	lexicon.addHandler(lexiconCancelAssignment)
+	lexicon.addHandler(lexiconListRelations)
+	lexicon.addHandler(lexiconAddRelation)
+	lexicon.addHandler(lexiconUpdateRelation)
+	lexicon.addHandler(lexiconDeleteRelation)
+	lexicon.addHandler(lexiconListParadigms)
+	lexicon.addHandler(lexiconGenerateParadigm)
+
+	admin := newSubRouter(rout, "/admin", "Misc admin tools", auth.Admin)
 	admin.addHandler(adminLexImportPage)
 	admin.addHandler(adminLexImport)
 	admin.addHandler(adminListDBs)
 	admin.addHandler(adminCreateDB)
+	admin.addHandler(adminBackup)
+	admin.addHandler(adminReplicationChanges)
+	admin.addHandler(adminLookupCache)
 	admin.addHandler(adminDefineLex)
+	admin.addHandler(adminLexiconMeta)
+	admin.addHandler(adminListLexiconStacks)
+	admin.addHandler(adminDefineLexiconStack)
+	admin.addHandler(adminDeleteLexiconStack)
+	admin.addHandler(adminListBoundValidators)
+	admin.addHandler(adminBindValidator)
+	admin.addHandler(adminUnbindValidator)
 	admin.addHandler(adminMoveNewEntries)
+	admin.addHandler(adminForceUnlock)
+	admin.addHandler(adminConvertAssignComments)
 	admin.addHandler(adminDeleteLex)
 	// // admin.addHandler(adminSuperDeleteLex)
 	admin.addHandler(adminListIDs)
+	admin.addHandler(adminImportBatches)
+	admin.addHandler(adminImportBatchEntries)
+	admin.addHandler(adminUndoImportBatch)
+	admin.addHandler(adminFreezeLexicon)
+	admin.addHandler(adminUnfreezeLexicon)
+	admin.addHandler(adminUsers)
+	admin.addHandler(adminAddUser)
+	admin.addHandler(adminGrant)
+	admin.addHandler(adminRevoke)
+	admin.addHandler(adminCreateToken)
+	admin.addHandler(adminRevokeToken)
+	admin.addHandler(adminDeleteUser)
 
 	// Sqlite3 ANALYZE command in some instances make search quicker,
 	// but it takes a while to perform. TODO: Re-add this call?
@@ -894,6 +1209,9 @@ func createServer(port string) (*http.Server, error) {
 	rout.HandleFunc("/", indexHandler)
 	rout.HandleFunc("/ping", pingHandler)
 	rout.HandleFunc("/version", versionHandler)
+	rout.HandleFunc("/metrics", metricsHandler)
+	rout.HandleFunc("/health/live", liveHandler)
+	rout.HandleFunc("/health/ready", readyHandler)
 	rout.Handle("/websockreg", websocket.Handler(webSockRegHandler))
 
 	// static
@@ -924,7 +1242,7 @@ func createServer(port string) (*http.Server, error) {
 		log.Printf("server failed to walk through route handlers : %v", err)
 	}
 
-	meta := newSubRouter(rout, "/meta", "Meta API calls (list served URLs, etc)")
+	meta := newSubRouter(rout, "/meta", "Meta API calls (list served URLs, etc)", auth.Reader)
 	meta.addHandler(metaURLsHandler(urls))
 	meta.addHandler(metaExamplesHandler)
 
@@ -935,10 +1253,11 @@ func createServer(port string) (*http.Server, error) {
 
 	s = &http.Server{
 		Addr:           port,
-		Handler:        rout,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
+		Handler:        withRequestID(rout),
+		ReadTimeout:    httpReadTimeout,
+		WriteTimeout:   httpWriteTimeout,
 		MaxHeaderBytes: 1 << 20,
+		ConnState:      trackConnState,
 	}
 
 	return s, nil
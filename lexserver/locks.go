@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stts-se/pronlex/auth"
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+)
+
+// lockErrorStatus returns the http status for a failed lock operation: 409 Conflict if the entry is locked by someone else
+func lockErrorStatus(err error) int {
+	if _, ok := err.(*dbapi.EntryLockedError); ok {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+func writeLock(w http.ResponseWriter, r *http.Request, l lex.EntryLock) {
+	jsn, err := marshal(l, r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, string(jsn))
+}
+
+func getLockIDParam(r *http.Request) (int64, error) {
+	idS := getParam("id", r)
+	id, err := strconv.ParseInt(idS, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for param id : %s", idS)
+	}
+	return id, nil
+}
+
+// lockAuthorised returns false if the lock exists, and the user of the request doesn't have the role for its lexicon. Locks are referenced by id only, so the lexicon is not checked when the request is authorised (see requestScopes).
+func lockAuthorised(r *http.Request, id int64, role auth.Role) bool {
+	for _, l := range requestDBM(r).ListEntryLocks() {
+		if l.ID == id {
+			return requestCan(r, role, l.LexRef.DBRef, l.LexRef.LexName)
+		}
+	}
+	return true
+}
+
+var lexiconLock = urlHandler{
+	name: "lock",
+	url:  "/lock",
+	help: "Locks an entry, or all entries with the same orthography in a lexicon (an orthography group), for editing. Locks are advisory: while an entry is locked, it can only be updated or deleted by the lock owner (see the owner param of updateentry and delete_entry). Locks are shown in lookup results. A lock expires unless it is renewed, by calling lock again. Locks are not persisted, and are released when the server is restarted. " +
+		"Required params: owner, and either lexicon and entry_id (entry lock), lexicon and strn (orthography group lock), or id (renew an existing lock). Optional params: ttl (time to live, e.g. 30m; default " + dbapi.DefaultEntryLockTTL.String() + "). Returns the lock, or status 409 (Conflict) if the entry is locked by someone else.",
+	examples: []string{"/lock?lexicon=wikispeech_lexserver_testdb:sv&entry_id=3&owner=tester", "/lock?lexicon=wikispeech_lexserver_testdb:sv&entry_id=4&owner=tester&ttl=5m", "/lock?id=2&owner=tester&ttl=10m"},
+	role:     auth.Editor,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		owner := strings.TrimSpace(userName(r, "owner"))
+		if owner == "" {
+			http.Error(w, "no value for parameter 'owner'", http.StatusBadRequest)
+			return
+		}
+		var ttl time.Duration
+		var err error
+		if ttlS := getParam("ttl", r); ttlS != "" {
+			ttl, err = time.ParseDuration(ttlS)
+			if err != nil || ttl <= 0 {
+				http.Error(w, fmt.Sprintf("invalid value for param ttl : %s", ttlS), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var res lex.EntryLock
+		if getParam("id", r) != "" {
+			id, err := getLockIDParam(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !lockAuthorised(r, id, auth.Editor) {
+				http.Error(w, fmt.Sprintf("not authorised to renew lock %d", id), http.StatusForbidden)
+				return
+			}
+			res, err = requestDBM(r).RenewEntryLock(id, owner, ttl)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("couldn't renew lock : %v", err), lockErrorStatus(err))
+				return
+			}
+			writeLock(w, r, res)
+			return
+		}
+
+		lexRef, err := lex.ParseLexRef(getParam("lexicon", r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref : %v", err), http.StatusBadRequest)
+			return
+		}
+		if idS := getParam("entry_id", r); idS != "" {
+			id, err := strconv.ParseInt(idS, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid value for param entry_id : %s", idS), http.StatusBadRequest)
+				return
+			}
+			res, err = requestDBM(r).LockEntry(lexRef, id, owner, ttl)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("couldn't lock entry : %v", err), lockErrorStatus(err))
+				return
+			}
+		} else if strn := getParam("strn", r); strn != "" {
+			res, err = requestDBM(r).LockOrthography(lexRef, strn, owner, ttl)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("couldn't lock orthography group : %v", err), lockErrorStatus(err))
+				return
+			}
+		} else {
+			http.Error(w, "one of the params id, entry_id and strn is required", http.StatusBadRequest)
+			return
+		}
+		writeLock(w, r, res)
+	},
+}
+
+var lexiconUnlock = urlHandler{
+	name:     "unlock",
+	url:      "/unlock",
+	help:     "Releases a lock (see lock). Required params: id (lock id), owner (must be the lock owner; see /admin/force_unlock). Returns the released lock.",
+	examples: []string{"/unlock?id=1&owner=tester"},
+	role:     auth.Editor,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		id, err := getLockIDParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		owner := strings.TrimSpace(userName(r, "owner"))
+		if owner == "" {
+			http.Error(w, "no value for parameter 'owner'", http.StatusBadRequest)
+			return
+		}
+		if !lockAuthorised(r, id, auth.Editor) {
+			http.Error(w, fmt.Sprintf("not authorised to release lock %d", id), http.StatusForbidden)
+			return
+		}
+		res, err := requestDBM(r).UnlockEntry(id, owner)
+		if err != nil {
+			status := lockErrorStatus(err)
+			if status != http.StatusConflict {
+				status = http.StatusNotFound
+			}
+			http.Error(w, fmt.Sprintf("couldn't unlock : %v", err), status)
+			return
+		}
+		writeLock(w, r, res)
+	},
+}
+
+var lexiconLocks = urlHandler{
+	name:     "locks",
+	url:      "/locks",
+	help:     "Lists active entry locks (see lock). Optional params: lexicons (default: all lexicons that the user can read).",
+	examples: []string{"/locks", "/locks?lexicons=wikispeech_lexserver_testdb:sv"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRefs := []lex.LexRef{}
+		for _, l := range dbapi.RemoveEmptyStrings(splitRE.Split(getParam("lexicons", r), -1)) {
+			lexRef, err := lex.ParseLexRef(l)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("couldn't parse lexicon ref : %v", err), http.StatusBadRequest)
+				return
+			}
+			lexRefs = append(lexRefs, lexRef)
+		}
+		locks := []lex.EntryLock{}
+		for _, l := range requestDBM(r).ListEntryLocks(lexRefs...) {
+			if requestCan(r, auth.Reader, l.LexRef.DBRef, l.LexRef.LexName) {
+				locks = append(locks, l)
+			}
+		}
+		jsn, err := marshal(locks, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+var adminForceUnlock = urlHandler{
+	name:     "force_unlock",
+	url:      "/force_unlock",
+	help:     "Releases an entry lock regardless of its owner (see /lexicon/lock). Required params: id (lock id). Returns the released lock.",
+	examples: []string{"/force_unlock?id=2"},
+	global:   true,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		id, err := getLockIDParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := requestDBM(r).ForceUnlockEntry(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't unlock : %v", err), http.StatusNotFound)
+			return
+		}
+		writeLock(w, r, res)
+	},
+}
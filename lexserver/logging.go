@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/stts-se/pronlex/dbapi"
+)
+
+// Logging uses log/slog, configured by the -log_format and -log_level flags. Output from the standard log package (log.Printf, etc) is logged at level INFO.
+// Each request is assigned a request ID (see withRequestID), which is included in the request log, in the DBManager calls made on behalf of the request (see requestDBM), and in error responses.
+
+// requestIDHeader is the HTTP header for request IDs, in requests and responses
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID matches request IDs accepted from clients
+var validRequestID = regexp.MustCompile("^[A-Za-z0-9._:-]{1,128}$")
+
+// newLogHandler creates a slog handler writing to w. Valid formats are text and json; valid levels are debug, info, warn and error. If logTime is false, timestamps are not logged (e.g., since syslog adds them).
+func newLogHandler(w io.Writer, format string, level string, logTime bool) (slog.Handler, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level '%s' (valid levels: debug, info, warn, error)", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	if !logTime {
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		}
+	}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format '%s' (valid formats: text, json)", format)
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		// crypto/rand doesn't fail on supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// withRequestID is a middleware assigning a request ID to each request. The ID is taken from the X-Request-ID request header, if it is valid, or generated. It is added to the request context (see requestID), and returned in the X-Request-ID response header.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(dbapi.ContextWithRequestID(r.Context(), id)))
+	})
+}
+
+// requestID returns the ID of a request (see withRequestID)
+func requestID(r *http.Request) string {
+	return dbapi.RequestIDFromContext(r.Context())
+}
+
+// requestDBM returns the DBManager to use on behalf of a request, so that failed calls are logged with the request ID (see dbapi.DBManager.WithContext)
+func requestDBM(r *http.Request) *dbapi.DBManager {
+	return dbm.WithContext(r.Context())
+}
+
+// maxLoggedError is the max number of bytes of an error response to include in the request log
+const maxLoggedError = 512
+
+// logRequest logs a handled request. Failed requests are logged at level WARN (client errors) or ERROR (server errors), with the error response message. Other requests are logged at level DEBUG.
+func logRequest(r *http.Request, router string, handler string, rec *statusRecorder, duration time.Duration) {
+	level := slog.LevelDebug
+	if rec.status >= 500 {
+		level = slog.LevelError
+	} else if rec.status >= 400 {
+		level = slog.LevelWarn
+	}
+	attrs := []any{"request_id", requestID(r), "method", r.Method, "path", r.URL.Path, "router", strings.TrimPrefix(router, "/"), "handler", handler, "status", rec.status, "duration", duration}
+	if msg := strings.TrimSpace(rec.errorBody.String()); msg != "" {
+		attrs = append(attrs, "error", msg)
+	}
+	slog.Log(r.Context(), level, "request", attrs...)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+)
+
+// Server metrics are exposed at /metrics, in the Prometheus text exposition format (see https://prometheus.io/docs/instrumenting/exposition_formats/). Request metrics are collected by the instrument middleware, which wraps all sub router handlers (see subRouter.addHandler).
+
+// handlerKey identifies a urlHandler by sub router and handler name
+type handlerKey struct {
+	router  string
+	handler string
+}
+
+type handlerMetrics struct {
+	// requests counts the requests by response status code
+	requests map[int]uint64
+	latency  *dbapi.LatencyHistogram
+}
+
+var requestMetrics = struct {
+	sync.Mutex
+	handlers map[handlerKey]*handlerMetrics
+}{handlers: make(map[handlerKey]*handlerMetrics)}
+
+// openConnections is the number of open http connections (see trackConnState)
+var openConnections int64
+
+// trackConnState counts open http connections. It is used as the http.Server ConnState hook.
+func trackConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		atomic.AddInt64(&openConnections, 1)
+	case http.StateHijacked, http.StateClosed:
+		atomic.AddInt64(&openConnections, -1)
+	}
+}
+
+// jobKey identifies a job type (e.g. import or validation) and result (ok or error)
+type jobKey struct {
+	job    string
+	result string
+}
+
+var jobMetrics = struct {
+	sync.Mutex
+	running  map[string]int64
+	finished map[jobKey]uint64
+}{running: map[string]int64{"import": 0, "validation": 0}, finished: make(map[jobKey]uint64)}
+
+// startJob counts a running job of the specified type, e.g. a lexicon import. The returned function should be called with the result of the job when it has finished.
+func startJob(job string) func(error) {
+	jobMetrics.Lock()
+	defer jobMetrics.Unlock()
+	jobMetrics.running[job]++
+	return func(err error) {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		jobMetrics.Lock()
+		defer jobMetrics.Unlock()
+		jobMetrics.running[job]--
+		jobMetrics.finished[jobKey{job: job, result: result}]++
+	}
+}
+
+// statusRecorder saves the status code of a response, and the beginning of the message of an error response (see logRequest)
+type statusRecorder struct {
+	http.ResponseWriter
+	status    int
+	errorBody bytes.Buffer
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if n := maxLoggedError - r.errorBody.Len(); r.status >= 400 && n > 0 {
+		if n > len(b) {
+			n = len(b)
+		}
+		r.errorBody.Write(b[:n])
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the original response writer, for use by http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument is a middleware counting the requests to a handler, and their latency. It also logs the requests (see logRequest), and adds the request ID to plain text error responses.
+func instrument(router, handler string, h http.HandlerFunc) http.HandlerFunc {
+	key := handlerKey{router: strings.TrimPrefix(router, "/"), handler: handler}
+	requestMetrics.Lock()
+	m, ok := requestMetrics.handlers[key]
+	if !ok {
+		m = &handlerMetrics{requests: make(map[int]uint64), latency: dbapi.NewLatencyHistogram()}
+		requestMetrics.handlers[key] = m
+	}
+	requestMetrics.Unlock()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+		duration := time.Since(start)
+		if rec.status >= 400 && strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+			fmt.Fprintf(rec.ResponseWriter, "request id: %s\n", requestID(r))
+		}
+		logRequest(r, router, handler, rec, duration)
+		m.latency.Observe(duration)
+		requestMetrics.Lock()
+		m.requests[rec.status]++
+		requestMetrics.Unlock()
+	}
+}
+
+// labelValue escapes a Prometheus label value
+func labelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// metric is a sample with labels, written as name{label1="value1",...} value
+type metric struct {
+	labels []string
+	value  string
+}
+
+// metricsWriter writes metrics in the Prometheus text format
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (mw metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// labels formats label pairs (name, value, name, value, ...)
+func labels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	res := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		res = append(res, fmt.Sprintf(`%s="%s"`, pairs[i], labelValue(pairs[i+1])))
+	}
+	return "{" + strings.Join(res, ",") + "}"
+}
+
+func (mw metricsWriter) samples(name, typ, help string, ms ...metric) {
+	mw.header(name, typ, help)
+	for _, m := range ms {
+		fmt.Fprintf(mw.w, "%s%s %s\n", name, labels(m.labels...), m.value)
+	}
+}
+
+func (mw metricsWriter) histogram(name string, s dbapi.LatencySnapshot, labelPairs ...string) {
+	for i, b := range s.Buckets {
+		fmt.Fprintf(mw.w, "%s_bucket%s %d\n", name, labels(append(labelPairs, "le", strconv.FormatFloat(b, 'g', -1, 64))...), s.Counts[i])
+	}
+	fmt.Fprintf(mw.w, "%s_bucket%s %d\n", name, labels(append(labelPairs, "le", "+Inf")...), s.Count)
+	fmt.Fprintf(mw.w, "%s_sum%s %s\n", name, labels(labelPairs...), strconv.FormatFloat(s.Sum, 'g', -1, 64))
+	fmt.Fprintf(mw.w, "%s_count%s %d\n", name, labels(labelPairs...), s.Count)
+}
+
+func uintValue(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+func intValue(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// writeMetrics writes all server metrics
+func writeMetrics(w io.Writer) {
+	mw := metricsWriter{w: w}
+
+	// requests per handler
+	requestMetrics.Lock()
+	keys := []handlerKey{}
+	for k := range requestMetrics.handlers {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].router != keys[j].router {
+			return keys[i].router < keys[j].router
+		}
+		return keys[i].handler < keys[j].handler
+	})
+	requests := []metric{}
+	latencies := make(map[handlerKey]dbapi.LatencySnapshot)
+	for _, k := range keys {
+		m := requestMetrics.handlers[k]
+		codes := []int{}
+		for code := range m.requests {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			requests = append(requests, metric{labels: []string{"router", k.router, "handler", k.handler, "code", strconv.Itoa(code)}, value: uintValue(m.requests[code])})
+		}
+		latencies[k] = m.latency.Snapshot()
+	}
+	requestMetrics.Unlock()
+	mw.samples("lexserver_http_requests_total", "counter", "Number of http requests by handler and response status code.", requests...)
+	mw.header("lexserver_http_request_duration_seconds", "histogram", "Latency of http requests by handler.")
+	for _, k := range keys {
+		mw.histogram("lexserver_http_request_duration_seconds", latencies[k], "router", k.router, "handler", k.handler)
+	}
+	mw.samples("lexserver_http_open_connections", "gauge", "Number of open http connections.", metric{value: intValue(atomic.LoadInt64(&openConnections))})
+
+	// websocket clients
+	webSocks.RLock()
+	nClients := len(webSocks.clients)
+	webSocks.RUnlock()
+	mw.samples("lexserver_websocket_clients", "gauge", "Number of registered websocket clients.", metric{value: strconv.Itoa(nClients)})
+
+	// databases
+	lookups := dbm.LookupLatencies()
+	stats := dbm.DBStats()
+	dbs := []lex.DBRef{}
+	for dbRef := range stats {
+		dbs = append(dbs, dbRef)
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i] < dbs[j] })
+	mw.header("lexserver_db_lookup_duration_seconds", "histogram", "Latency of database lookups (not including lookups answered by the lookup cache).")
+	for _, db := range dbs {
+		if s, ok := lookups[db]; ok {
+			mw.histogram("lexserver_db_lookup_duration_seconds", s, "db", string(db))
+		}
+	}
+	open := []metric{}
+	inUse := []metric{}
+	for _, db := range dbs {
+		s := stats[db]
+		open = append(open, metric{labels: []string{"db", string(db)}, value: strconv.Itoa(s.OpenConnections)})
+		inUse = append(inUse, metric{labels: []string{"db", string(db)}, value: strconv.Itoa(s.InUse)})
+	}
+	mw.samples("lexserver_db_open_connections", "gauge", "Number of open database connections.", open...)
+	mw.samples("lexserver_db_in_use_connections", "gauge", "Number of database connections in use.", inUse...)
+
+	// lookup cache
+	cs := dbm.LookupCacheStats()
+	enabled := "0"
+	if cs.Enabled {
+		enabled = "1"
+	}
+	mw.samples("lexserver_lookup_cache_enabled", "gauge", "1 if the lookup cache is enabled (see -lookup_cache_size), otherwise 0.", metric{value: enabled})
+	mw.samples("lexserver_lookup_cache_size", "gauge", "Number of cached lookups.", metric{value: strconv.Itoa(cs.Size)})
+	mw.samples("lexserver_lookup_cache_max_size", "gauge", "Max number of cached lookups.", metric{value: strconv.Itoa(cs.MaxSize)})
+	mw.samples("lexserver_lookup_cache_hits_total", "counter", "Number of lookups answered by the lookup cache.", metric{value: intValue(cs.Hits)})
+	mw.samples("lexserver_lookup_cache_misses_total", "counter", "Number of cacheable lookups not found in the lookup cache.", metric{value: intValue(cs.Misses)})
+	mw.samples("lexserver_lookup_cache_evictions_total", "counter", "Number of lookups evicted from the lookup cache.", metric{value: intValue(cs.Evictions)})
+	mw.samples("lexserver_lookup_cache_invalidations_total", "counter", "Number of cached lookups invalidated by lexicon changes.", metric{value: intValue(cs.Invalidations)})
+
+	// import and validation jobs
+	jobMetrics.Lock()
+	jobs := []string{}
+	for job := range jobMetrics.running {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+	running := []metric{}
+	finished := []metric{}
+	for _, job := range jobs {
+		running = append(running, metric{labels: []string{"job", job}, value: intValue(jobMetrics.running[job])})
+		for _, result := range []string{"ok", "error"} {
+			finished = append(finished, metric{labels: []string{"job", job, "result", result}, value: uintValue(jobMetrics.finished[jobKey{job: job, result: result}])})
+		}
+	}
+	jobMetrics.Unlock()
+	mw.samples("lexserver_jobs_running", "gauge", "Number of running import and validation jobs.", running...)
+	mw.samples("lexserver_jobs_total", "counter", "Number of finished import and validation jobs, by result.", finished...)
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w)
+}
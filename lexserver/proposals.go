@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/stts-se/pronlex/auth"
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+)
+
+// proposalErrorStatus returns the http status for a failed proposal operation: 409 Conflict if the entry has been changed since the proposal was made, or if it is locked by someone else, and 403 Forbidden if the lexicon is frozen
+func proposalErrorStatus(err error) int {
+	switch err.(type) {
+	case *dbapi.ProposalConflictError, *dbapi.EntryLockedError:
+		return http.StatusConflict
+	}
+	return frozenErrorStatus(err, http.StatusBadRequest)
+}
+
+func writeProposal(w http.ResponseWriter, r *http.Request, p dbapi.Proposal) {
+	jsn, err := marshal(p, r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, string(jsn))
+}
+
+// getProposalParams returns the lexicon and id params of a request for an existing proposal
+func getProposalParams(r *http.Request) (lex.LexRef, int64, error) {
+	lexRef, err := lex.ParseLexRef(getParam("lexicon", r))
+	if err != nil {
+		return lexRef, 0, fmt.Errorf("couldn't parse lexicon ref : %v", err)
+	}
+	idS := getParam("id", r)
+	id, err := strconv.ParseInt(idS, 10, 64)
+	if err != nil {
+		return lexRef, 0, fmt.Errorf("invalid value for param id : %s", idS)
+	}
+	return lexRef, id, nil
+}
+
+func getEntryParam(r *http.Request) (lex.Entry, error) {
+	var e lex.Entry
+	entryJSON := getParam("entry", r)
+	if entryJSON == "" {
+		return e, fmt.Errorf("no value for parameter 'entry'")
+	}
+	err := json.Unmarshal([]byte(entryJSON), &e)
+	if err != nil {
+		return e, fmt.Errorf("failed to process incoming Entry json : %v", err)
+	}
+	return e, nil
+}
+
+var lexiconProposalsURL = `/proposals/submit?proposer=tester&comment=remove+alternative+pronunciation&entry={"id":3,"lexRef":{"dbRef":"wikispeech_lexserver_testdb","lexName":"sv"},"strn":"kexpaket","language":"sv","partOfSpeech":"NN","morphology":"NEU IND SIN","wordParts":"kex%2Bpaket","lemma":{"strn":"kexpaket"},"transcriptions":[{"strn":"\"\" k e k %2B p a . k %25 e: t","language":"sv"}]}`
+
+var lexiconProposalsURL2 = `/proposals/submit?proposer=tester&entry={"id":3,"lexRef":{"dbRef":"wikispeech_lexserver_testdb","lexName":"sv"},"strn":"kexpaket","language":"sv","partOfSpeech":"NN","morphology":"NEU IND SIN","wordParts":"kex%2Bpaket","lemma":{"strn":"kexpaket"},"transcriptions":[{"strn":"\"\" k e k %2B p a . k %25 e: t","language":"sv"},{"strn":"\"\" C e k %2B p a . k %25 e: t","language":"sv"}],"comments":[{"label":"RE: pronunciation","comment":"is the second pronunciation in use?","source":"tester"}]}`
+
+var lexiconProposals = urlHandler{
+	name:     "proposals",
+	url:      "/proposals",
+	help:     "Lists the review queue of a lexicon: proposed entry updates (see proposals/submit). Each proposal holds the entry when the proposal was made (base), the proposed entry, and a diff between the two. A pending proposal is stale if the entry has been changed since the proposal was made. Required params: lexicon. Optional params: status (pending, approved, rejected or all; default pending).",
+	examples: []string{"/proposals?lexicon=wikispeech_lexserver_testdb:sv", "/proposals?lexicon=wikispeech_lexserver_testdb:sv&status=all"},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, err := lex.ParseLexRef(getParam("lexicon", r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref : %v", err), http.StatusBadRequest)
+			return
+		}
+		status := dbapi.ProposalPending
+		switch s := getParam("status", r); s {
+		case "":
+		case "all":
+			status = ""
+		case string(dbapi.ProposalPending), string(dbapi.ProposalApproved), string(dbapi.ProposalRejected):
+			status = dbapi.ProposalStatus(s)
+		default:
+			http.Error(w, fmt.Sprintf("invalid value for param status : %s", s), http.StatusBadRequest)
+			return
+		}
+		ps, err := requestDBM(r).ListProposals(lexRef, status)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't list proposals : %v", err), http.StatusInternalServerError)
+			return
+		}
+		jsn, err := marshal(ps, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+var lexiconSubmitProposal = urlHandler{
+	name:     "proposals/submit",
+	url:      "/proposals/submit",
+	help:     "Submits a proposed update of an entry for review, instead of updating the entry directly (see updateentry). The proposal is stored as a diff against the current entry, and the entry is not changed until the proposal is approved. Required params: entry (the updated entry, in JSON format; the entry status is ignored), proposer. Optional params: comment. Returns the proposal.",
+	examples: []string{lexiconProposalsURL, lexiconProposalsURL2},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		e, err := getEntryParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := requestDBM(r).ProposeUpdate(e, userName(r, "proposer"), getParam("comment", r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't submit proposal : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeProposal(w, r, p)
+	},
+}
+
+var lexiconAmendProposal = urlHandler{
+	name:     "proposals/amend",
+	url:      "/proposals/amend",
+	help:     "Replaces the proposed entry of a pending proposal, and rebases the proposal on the current entry. Use this to bring a stale proposal up to date. Required params: lexicon, id (proposal id), entry (the updated entry, in JSON format). Optional params: comment (replaces the proposal comment). Returns the amended proposal.",
+	examples: []string{`/proposals/amend?lexicon=wikispeech_lexserver_testdb:sv&id=1&entry={"id":3,"strn":"kexpaket","language":"sv","partOfSpeech":"NN","morphology":"NEU IND SIN","wordParts":"kex%2Bpaket","lemma":{"strn":"kexpaket"},"transcriptions":[{"strn":"\"\" C e k %2B p a . k %25 e: t","language":"sv"}]}&comment=keep+the+other+pronunciation`},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, id, err := getProposalParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e, err := getEntryParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := requestDBM(r).AmendProposal(lexRef, id, e, getParam("comment", r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't amend proposal : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeProposal(w, r, p)
+	},
+}
+
+var lexiconApproveProposal = urlHandler{
+	name:     "proposals/approve",
+	url:      "/proposals/approve",
+	help:     "Approves a pending proposal, and applies it to the lexicon as an entry update with a new entry status. Required params: lexicon, id (proposal id), reviewer, status (entry status name). Optional params: source (entry status source; default: reviewer), comment. Returns the approved proposal, or status 409 (Conflict) if the entry has been changed since the proposal was made (the proposal must then be amended), or if the entry is locked by someone other than the reviewer (see lock).",
+	examples: []string{"/proposals/approve?lexicon=wikispeech_lexserver_testdb:sv&id=1&reviewer=tester2&status=ok"},
+	role:     auth.Editor,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, id, err := getProposalParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status := lex.EntryStatus{Name: getParam("status", r), Source: userName(r, "source")}
+		p, err := requestDBM(r).ApproveProposal(lexRef, id, userName(r, "reviewer"), status, getParam("comment", r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't approve proposal : %v", err), proposalErrorStatus(err))
+			return
+		}
+		writeProposal(w, r, p)
+	},
+}
+
+var lexiconRejectProposal = urlHandler{
+	name:     "proposals/reject",
+	url:      "/proposals/reject",
+	help:     "Rejects a pending proposal. The entry is not changed. Required params: lexicon, id (proposal id), reviewer, comment (the reason for rejecting the proposal). Returns the rejected proposal.",
+	examples: []string{"/proposals/reject?lexicon=wikispeech_lexserver_testdb:sv&id=2&reviewer=tester2&comment=use+a+comment+label"},
+	role:     auth.Editor,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, id, err := getProposalParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := requestDBM(r).RejectProposal(lexRef, id, userName(r, "reviewer"), getParam("comment", r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't reject proposal : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeProposal(w, r, p)
+	},
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/stts-se/pronlex/auth"
+	"github.com/stts-se/pronlex/dbapi"
+)
+
+// checkAuthEnabled writes an error and returns false if authentication is disabled
+func checkAuthEnabled(w http.ResponseWriter) bool {
+	if authStore == nil {
+		http.Error(w, "authentication is not enabled (see lexserver flag -auth_file)", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	jsn, err := marshal(v, r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, string(jsn))
+}
+
+func getGrantsParam(r *http.Request, param string) ([]auth.Grant, error) {
+	res := []auth.Grant{}
+	for _, s := range dbapi.RemoveEmptyStrings(splitRE.Split(getParam(param, r), -1)) {
+		g, err := auth.ParseGrant(s)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, g)
+	}
+	return res, nil
+}
+
+var adminUsers = urlHandler{
+	name:     "users",
+	url:      "/users",
+	help:     "Lists the users of the server, with their grants and API tokens (the tokens themselves are not stored, and cannot be listed). Authentication is enabled using the lexserver flag -auth_file. Each user has a list of grants, each giving a role (reader, editor or admin) in a scope (all databases, a database, or a lexicon), on the form role@scope, e.g. editor@wikispeech_lexserver_testdb:sv. Requests are authenticated using an API token, sent as a bearer token (Authorization: Bearer &lt;token&gt;), or as the password of HTTP basic authentication.",
+	examples: []string{"/users"},
+	global:   true,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuthEnabled(w) {
+			return
+		}
+		writeJSON(w, r, authStore.Users())
+	},
+}
+
+var adminAddUser = urlHandler{
+	name:     "add_user",
+	url:      "/add_user",
+	help:     "Adds a user (see users). Required params: name. Optional params: grants (comma separated list of grants, e.g. reader,editor@wikispeech_lexserver_testdb; a grant without a scope is for all databases). Use create_token to create an API token for the user.",
+	examples: []string{"/add_user?name=anna&grants=editor@wikispeech_lexserver_testdb:sv"},
+	global:   true,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuthEnabled(w) {
+			return
+		}
+		grants, err := getGrantsParam(r, "grants")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse grants : %v", err), http.StatusBadRequest)
+			return
+		}
+		u, err := authStore.AddUser(getParam("name", r), grants...)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't add user : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, u)
+	},
+}
+
+var adminGrant = urlHandler{
+	name:     "grant",
+	url:      "/grant",
+	help:     "Adds a grant to a user (see users). Required params: name, grant (e.g. reader@wikispeech_lexserver_testdb).",
+	examples: []string{"/grant?name=anna&grant=reader@wikispeech_lexserver_testdb"},
+	global:   true,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuthEnabled(w) {
+			return
+		}
+		g, err := auth.ParseGrant(getParam("grant", r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse grant : %v", err), http.StatusBadRequest)
+			return
+		}
+		u, err := authStore.Grant(getParam("name", r), g)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't add grant : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, u)
+	},
+}
+
+var adminRevoke = urlHandler{
+	name:     "revoke",
+	url:      "/revoke",
+	help:     "Removes a grant from a user (see users). Required params: name, grant.",
+	examples: []string{"/revoke?name=anna&grant=reader@wikispeech_lexserver_testdb"},
+	global:   true,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuthEnabled(w) {
+			return
+		}
+		g, err := auth.ParseGrant(getParam("grant", r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse grant : %v", err), http.StatusBadRequest)
+			return
+		}
+		u, err := authStore.Revoke(getParam("name", r), g)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't remove grant : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, u)
+	},
+}
+
+type createdToken struct {
+	Token string     `json:"token"`
+	Info  auth.Token `json:"info"`
+}
+
+var adminCreateToken = urlHandler{
+	name:     "create_token",
+	url:      "/create_token",
+	help:     "Creates an API token for a user (see users). Required params: name. Optional params: description. Returns the token, which is only shown once, since it is not stored by the server.",
+	examples: []string{"/create_token?name=anna&description=test"},
+	global:   true,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuthEnabled(w) {
+			return
+		}
+		token, info, err := authStore.CreateToken(getParam("name", r), getParam("description", r))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't create token : %v", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, createdToken{Token: token, Info: info})
+	},
+}
+
+var adminRevokeToken = urlHandler{
+	name:     "revoke_token",
+	url:      "/revoke_token",
+	help:     "Deletes an API token of a user (see users). Required params: name, id (token id, as listed by users).",
+	examples: []string{},
+	global:   true,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuthEnabled(w) {
+			return
+		}
+		err := authStore.RevokeToken(getParam("name", r), strings.TrimSpace(getParam("id", r)))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't revoke token : %v", err), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "revoked token %s", getParam("id", r))
+	},
+}
+
+var adminDeleteUser = urlHandler{
+	name:     "delete_user",
+	url:      "/delete_user",
+	help:     "Deletes a user and its API tokens (see users). Required params: name.",
+	examples: []string{"/delete_user?name=anna"},
+	global:   true,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuthEnabled(w) {
+			return
+		}
+		name := getParam("name", r)
+		err := authStore.DeleteUser(name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't delete user : %v", err), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "deleted user %s", name)
+	},
+}
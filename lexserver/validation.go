@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/stts-se/pronlex/auth"
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+	"github.com/stts-se/pronlex/validation"
+	"github.com/stts-se/pronlex/validation/validators"
+	"github.com/stts-se/symbolset"
+)
+
+// vServ holds the validators loaded at startup, by symbol set name (see the -symbolset_dir flag). If nil, no validators are loaded.
+var vServ *validators.ValidatorService
+
+func loadValidators(symsetDirNames ...string) error {
+	vs := validators.ValidatorService{Validators: make(map[string]*validation.Validator)}
+	for _, dir := range symsetDirNames {
+		symbolSets, err := symbolset.LoadSymbolSetsFromDir(dir)
+		if err != nil {
+			return err
+		}
+		err = vs.Load(symbolSets, dir)
+		if err != nil {
+			return err
+		}
+	}
+	vServ = &vs
+	return nil
+}
+
+func validatorNames() []string {
+	res := []string{}
+	if vServ == nil {
+		return res
+	}
+	for _, v := range vServ.Validators {
+		res = append(res, v.Name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// bindValidator binds the validator for the lexicon's symbol set to the lexicon, so that inserted and updated entries are validated automatically (see dbapi.DBManager.BindValidator)
+func bindValidator(lexRef lex.LexRef) (validation.Validator, error) {
+	info, err := dbm.GetLexicon(lexRef)
+	if err != nil {
+		return validation.Validator{}, err
+	}
+	if vServ == nil {
+		return validation.Validator{}, fmt.Errorf("no validators loaded")
+	}
+	v, err := vServ.ValidatorForName(info.SymbolSetName)
+	if err != nil {
+		return validation.Validator{}, err
+	}
+	return *v, dbm.BindValidator(lexRef, *v)
+}
+
+// bindAllValidators binds validators to all lexicons with a symbol set for which there is a validator
+func bindAllValidators() error {
+	if vServ == nil {
+		return nil
+	}
+	lexs, err := dbm.ListLexicons()
+	if err != nil {
+		return err
+	}
+	for _, l := range lexs {
+		if !vServ.HasValidator(l.SymbolSetName) {
+			continue
+		}
+		v, err := bindValidator(l.LexRef)
+		if err != nil {
+			return fmt.Errorf("couldn't bind validator to lexicon %s : %v", l.LexRef, err)
+		}
+		log.Printf("lexserver: bound validator %s to lexicon %s", v.Name, l.LexRef)
+	}
+	return nil
+}
+
+var adminListBoundValidators = urlHandler{
+	name:     "list_bound_validators",
+	url:      "/list_bound_validators",
+	help:     "List the validators bound to lexicons (lexicon => validator name). Entries inserted or updated in a lexicon with a bound validator are validated automatically.",
+	examples: []string{"/list_bound_validators"},
+	role:     auth.Reader,
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		res := make(map[string]string)
+		for ref, name := range requestDBM(r).BoundValidators() {
+			res[ref.String()] = name
+		}
+		jsn, err := marshal(res, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, string(jsn))
+	},
+}
+
+var adminBindValidator = urlHandler{
+	name:     "bind_validator",
+	url:      "/bind_validator/{lexicon_name}",
+	help:     "Bind the validator for the lexicon's symbol set to the lexicon, so that inserted and updated entries are validated automatically. Validators are only available if the server is started with the -symbolset_dir flag. Optional param: revalidate=true, to also validate all existing entries in the lexicon.",
+	examples: []string{},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, err := getLexRefParam(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref %v : %v", lexRef, err), http.StatusBadRequest)
+			return
+		}
+		v, err := bindValidator(lexRef)
+		if err != nil {
+			msg := fmt.Sprintf("lexserver failed to bind validator : %v", err)
+			log.Println(msg)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		log.Printf("Bound validator %s to lexicon %s", v.Name, lexRef)
+		msg := fmt.Sprintf("Bound validator %s to lexicon %s", v.Name, lexRef)
+		if strings.ToLower(getParam("revalidate", r)) == "true" {
+			jobDone := startJob("validation")
+			stats, err := requestDBM(r).Validate(lexRef, dbapi.StderrLogger{}, v, dbapi.Query{})
+			jobDone(err)
+			if err != nil {
+				msg := fmt.Sprintf("lexserver failed to validate lexicon : %v", err)
+				log.Println(msg)
+				http.Error(w, msg, frozenErrorStatus(err, http.StatusInternalServerError))
+				return
+			}
+			msg = fmt.Sprintf("%s, and validated %d entries (%d invalid)", msg, stats.ValidatedEntries, stats.InvalidEntries)
+		}
+		fmt.Fprint(w, msg)
+	},
+}
+
+var adminUnbindValidator = urlHandler{
+	name:     "unbind_validator",
+	url:      "/unbind_validator/{lexicon_name}",
+	help:     "Remove the validator bound to a lexicon. Existing validation messages are kept.",
+	examples: []string{},
+	handler: func(w http.ResponseWriter, r *http.Request) {
+		lexRef, err := getLexRefParam(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("couldn't parse lexicon ref %v : %v", lexRef, err), http.StatusBadRequest)
+			return
+		}
+		if !requestDBM(r).UnbindValidator(lexRef) {
+			http.Error(w, fmt.Sprintf("no validator bound to lexicon %s", lexRef), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Unbound validator from lexicon %s", lexRef)
+		fmt.Fprintf(w, "Unbound validator from lexicon %s", lexRef)
+	},
+}
@@ -0,0 +1,18 @@
+/*
+Package paradigm is used to generate inflected entries from a lemma entry, using simple suffix rules for orthography and transcription.
+
+A paradigm definition file is a tab separated text file. Empty lines and lines starting with # are ignored. Each paradigm starts with a header line, followed by one line per morphological slot:
+
+	PARADIGM	<paradigm name>	<part of speech>
+	<morphology>	<orth strip>	<orth add>	<trans strip>	<trans add>
+
+The value 0 is used for an empty strip or add field. A slot is generated from the lemma entry by removing the strip suffix from the orthography (and transcription), and then adding the add suffix. Transcription suffixes are space separated symbol strings, so the add field is appended using a space as delimiter.
+
+Example:
+
+	PARADIGM	s7n-övriga ex träd	NN
+	NEU IND SIN NOM	0	0	0	0
+	NEU DEF SIN NOM	0	et	0	. @ t
+	NEU DEF PLU NOM	0	en	0	. @ n
+*/
+package paradigm
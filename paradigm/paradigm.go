@@ -0,0 +1,237 @@
+package paradigm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+// FileExtension is the file extension used for paradigm definition files
+const FileExtension = ".para"
+
+// empty is used in paradigm definition files for empty strip/add fields
+const empty = "0"
+
+// Slot is a rule for generating one inflected form of a paradigm
+type Slot struct {
+	Morphology string `json:"morphology"`
+	OrthStrip  string `json:"orthStrip,omitempty"`
+	OrthAdd    string `json:"orthAdd,omitempty"`
+	TransStrip string `json:"transStrip,omitempty"`
+	TransAdd   string `json:"transAdd,omitempty"`
+}
+
+// Paradigm is a named set of slots
+type Paradigm struct {
+	Name         string `json:"name"`
+	PartOfSpeech string `json:"partOfSpeech,omitempty"`
+	Slots        []Slot `json:"slots"`
+}
+
+// Candidate is a generated entry, along with info on whether it is already in the lexicon
+type Candidate struct {
+	Entry       lex.Entry `json:"entry"`
+	Exists      bool      `json:"exists"`
+	ExistingIDs []int64   `json:"existingIds,omitempty"`
+}
+
+func emptyIfZero(s string) string {
+	s = strings.TrimSpace(s)
+	if s == empty {
+		return ""
+	}
+	return s
+}
+
+// Parse reads paradigm definitions from the reader
+func Parse(r io.Reader) ([]Paradigm, error) {
+	var res []Paradigm
+	var curr *Paradigm
+	seen := make(map[string]bool)
+
+	s := bufio.NewScanner(r)
+	n := 0
+	for s.Scan() {
+		n++
+		l := s.Text()
+		if strings.TrimSpace(l) == "" || strings.HasPrefix(strings.TrimSpace(l), "#") {
+			continue
+		}
+		fs := strings.Split(l, "\t")
+		if fs[0] == "PARADIGM" {
+			if len(fs) < 2 || strings.TrimSpace(fs[1]) == "" {
+				return res, fmt.Errorf("line %d: missing paradigm name : %s", n, l)
+			}
+			if len(fs) > 3 {
+				return res, fmt.Errorf("line %d: expected max 3 fields for paradigm header, found %d : %s", n, len(fs), l)
+			}
+			if curr != nil {
+				res = append(res, *curr)
+			}
+			name := strings.TrimSpace(fs[1])
+			if seen[name] {
+				return res, fmt.Errorf("line %d: duplicate paradigm name '%s'", n, name)
+			}
+			seen[name] = true
+			curr = &Paradigm{Name: name}
+			if len(fs) == 3 {
+				curr.PartOfSpeech = strings.TrimSpace(fs[2])
+			}
+			continue
+		}
+		if curr == nil {
+			return res, fmt.Errorf("line %d: slot definition before first paradigm header : %s", n, l)
+		}
+		if len(fs) != 5 {
+			return res, fmt.Errorf("line %d: expected 5 fields for slot definition, found %d : %s", n, len(fs), l)
+		}
+		slot := Slot{
+			Morphology: strings.TrimSpace(fs[0]),
+			OrthStrip:  emptyIfZero(fs[1]),
+			OrthAdd:    emptyIfZero(fs[2]),
+			TransStrip: emptyIfZero(fs[3]),
+			TransAdd:   emptyIfZero(fs[4]),
+		}
+		curr.Slots = append(curr.Slots, slot)
+	}
+	if err := s.Err(); err != nil {
+		return res, err
+	}
+	if curr != nil {
+		res = append(res, *curr)
+	}
+	for _, p := range res {
+		if len(p.Slots) == 0 {
+			return res, fmt.Errorf("paradigm '%s' has no slots", p.Name)
+		}
+	}
+	return res, nil
+}
+
+// LoadFile reads paradigm definitions from file
+func LoadFile(fName string) ([]Paradigm, error) {
+	fh, err := os.Open(filepath.Clean(fName))
+	if err != nil {
+		return []Paradigm{}, fmt.Errorf("failed to open paradigm file : %v", err)
+	}
+	/* #nosec G307 */
+	defer fh.Close()
+	res, err := Parse(fh)
+	if err != nil {
+		return res, fmt.Errorf("failed to read paradigm file %s : %v", fName, err)
+	}
+	return res, nil
+}
+
+// LoadDir reads paradigm definitions from all files in the folder with the FileExtension suffix. The paradigms are returned in a map, with paradigm name as key.
+func LoadDir(dirName string) (map[string]Paradigm, error) {
+	res := make(map[string]Paradigm)
+	files, err := filepath.Glob(filepath.Join(dirName, "*"+FileExtension))
+	if err != nil {
+		return res, fmt.Errorf("failed to list paradigm files : %v", err)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		ps, err := LoadFile(f)
+		if err != nil {
+			return res, err
+		}
+		for _, p := range ps {
+			if _, ok := res[p.Name]; ok {
+				return res, fmt.Errorf("duplicate paradigm name '%s' in %s", p.Name, f)
+			}
+			res[p.Name] = p
+		}
+	}
+	return res, nil
+}
+
+func applyOrth(s string, strip string, add string) (string, error) {
+	if !strings.HasSuffix(s, strip) {
+		return s, fmt.Errorf("'%s' doesn't end with '%s'", s, strip)
+	}
+	return strings.TrimSuffix(s, strip) + add, nil
+}
+
+func applyTrans(t string, strip string, add string) (string, error) {
+	syms := strings.Fields(t)
+	stripSyms := strings.Fields(strip)
+	if len(stripSyms) > len(syms) {
+		return t, fmt.Errorf("transcription '%s' doesn't end with '%s'", t, strip)
+	}
+	n := len(syms) - len(stripSyms)
+	for i, s := range stripSyms {
+		if syms[n+i] != s {
+			return t, fmt.Errorf("transcription '%s' doesn't end with '%s'", t, strip)
+		}
+	}
+	return strings.Join(append(syms[0:n], strings.Fields(add)...), " "), nil
+}
+
+// Generate creates one entry per slot in the paradigm, from the input lemma entry. The generated entries have no id and no status.
+func (p Paradigm) Generate(base lex.Entry) ([]lex.Entry, error) {
+	var res []lex.Entry
+	if strings.TrimSpace(base.Strn) == "" {
+		return res, fmt.Errorf("input entry has empty orthography")
+	}
+	lemma := base.Lemma
+	if lemma.Strn == "" {
+		lemma.Strn = base.Strn
+	}
+	lemma.Paradigm = p.Name
+	pos := p.PartOfSpeech
+	if pos == "" {
+		pos = base.PartOfSpeech
+	}
+
+	for _, slot := range p.Slots {
+		strn, err := applyOrth(base.Strn, slot.OrthStrip, slot.OrthAdd)
+		if err != nil {
+			return res, fmt.Errorf("paradigm '%s' cannot generate %s : %v", p.Name, slot.Morphology, err)
+		}
+		e := lex.Entry{
+			Strn:         strn,
+			Language:     base.Language,
+			PartOfSpeech: pos,
+			Morphology:   slot.Morphology,
+			Lemma:        lemma,
+		}
+		if base.WordParts != "" {
+			wp, err := applyOrth(base.WordParts, slot.OrthStrip, slot.OrthAdd)
+			if err == nil {
+				e.WordParts = wp
+			}
+		}
+		for _, t := range base.Transcriptions {
+			ts, err := applyTrans(t.Strn, slot.TransStrip, slot.TransAdd)
+			if err != nil {
+				return res, fmt.Errorf("paradigm '%s' cannot generate %s : %v", p.Name, slot.Morphology, err)
+			}
+			e.Transcriptions = append(e.Transcriptions, lex.Transcription{Strn: ts, Language: t.Language})
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+// MarkExisting compares the generated entries to a list of existing entries. A generated entry is considered to exist if there is an existing entry with the same orthography and morphology.
+func MarkExisting(generated []lex.Entry, existing []lex.Entry) []Candidate {
+	var res []Candidate
+	for _, g := range generated {
+		c := Candidate{Entry: g}
+		for _, e := range existing {
+			if e.Strn == g.Strn && e.Morphology == g.Morphology {
+				c.Exists = true
+				c.ExistingIDs = append(c.ExistingIDs, e.ID)
+			}
+		}
+		res = append(res, c)
+	}
+	return res
+}
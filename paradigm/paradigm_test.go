@@ -0,0 +1,110 @@
+package paradigm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stts-se/pronlex/lex"
+)
+
+var testParadigms = `# test paradigms
+PARADIGM	s7n-övriga ex träd	NN
+NEU IND SIN NOM	0	0	0	0
+NEU DEF SIN NOM	0	et	0	. @ t
+NEU DEF PLU NOM	0	en	0	. @ n
+
+PARADIGM	s1a-flicka	NN
+UTR IND SIN NOM	0	0	0	0
+UTR IND PLU NOM	a	or	a	u0 r
+`
+
+func TestParse(t *testing.T) {
+	ps, err := Parse(strings.NewReader(testParadigms))
+	if err != nil {
+		t.Fatalf("didn't expect error here : %v", err)
+	}
+	if w, g := 2, len(ps); w != g {
+		t.Fatalf("wanted %v got %v", w, g)
+	}
+	if w, g := "s7n-övriga ex träd", ps[0].Name; w != g {
+		t.Errorf("wanted %v got %v", w, g)
+	}
+	if w, g := 3, len(ps[0].Slots); w != g {
+		t.Errorf("wanted %v got %v", w, g)
+	}
+	if w, g := (Slot{Morphology: "UTR IND PLU NOM", OrthStrip: "a", OrthAdd: "or", TransStrip: "a", TransAdd: "u0 r"}), ps[1].Slots[1]; w != g {
+		t.Errorf("wanted %#v got %#v", w, g)
+	}
+
+	for _, invalid := range []string{
+		"NEU IND SIN NOM	0	0	0	0",
+		"PARADIGM	p1	NN\nNEU IND SIN NOM	0	0",
+		"PARADIGM	p1	NN\nPARADIGM	p2	NN\nNEU IND SIN NOM	0	0	0	0",
+		"PARADIGM	p1	NN\nNEU IND SIN NOM	0	0	0	0\nPARADIGM	p1	NN\nNEU IND SIN NOM	0	0	0	0",
+	} {
+		_, err = Parse(strings.NewReader(invalid))
+		if err == nil {
+			t.Errorf("expected error for input %s", invalid)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	ps, err := Parse(strings.NewReader(testParadigms))
+	if err != nil {
+		t.Fatalf("didn't expect error here : %v", err)
+	}
+
+	base := lex.Entry{Strn: "flicka", PartOfSpeech: "NN", Language: "sv-se",
+		Transcriptions: []lex.Transcription{{Strn: `" f l I . k a`}},
+	}
+	res, err := ps[1].Generate(base)
+	if err != nil {
+		t.Fatalf("didn't expect error here : %v", err)
+	}
+	if w, g := 2, len(res); w != g {
+		t.Fatalf("wanted %v got %v", w, g)
+	}
+	if w, g := "flickor", res[1].Strn; w != g {
+		t.Errorf("wanted %v got %v", w, g)
+	}
+	if w, g := `" f l I . k u0 r`, res[1].Transcriptions[0].Strn; w != g {
+		t.Errorf("wanted %v got %v", w, g)
+	}
+	if w, g := "UTR IND PLU NOM", res[1].Morphology; w != g {
+		t.Errorf("wanted %v got %v", w, g)
+	}
+	if w, g := (lex.Lemma{Strn: "flicka", Paradigm: "s1a-flicka"}), res[1].Lemma; w != g {
+		t.Errorf("wanted %v got %v", w, g)
+	}
+
+	// strip suffix doesn't match
+	base = lex.Entry{Strn: "pojke", Transcriptions: []lex.Transcription{{Strn: `" p O j . k e`}}}
+	_, err = ps[1].Generate(base)
+	if err == nil {
+		t.Errorf("expected error for non-matching suffix")
+	}
+
+	base = lex.Entry{Strn: "kex", Transcriptions: []lex.Transcription{{Strn: `" k e k s`}}}
+	res, err = ps[0].Generate(base)
+	if err != nil {
+		t.Fatalf("didn't expect error here : %v", err)
+	}
+	existing := []lex.Entry{
+		{ID: 1, Strn: "kex", Morphology: "NEU IND SIN NOM"},
+		{ID: 2, Strn: "kexet", Morphology: "NEU DEF SIN"},
+	}
+	cands := MarkExisting(res, existing)
+	if w, g := 3, len(cands); w != g {
+		t.Fatalf("wanted %v got %v", w, g)
+	}
+	if !cands[0].Exists || cands[0].ExistingIDs[0] != 1 {
+		t.Errorf("expected %s to exist", cands[0].Entry.Strn)
+	}
+	if cands[1].Exists {
+		t.Errorf("expected %s not to exist (different morphology)", cands[1].Entry.Strn)
+	}
+	if w, g := `" k e k s . @ t`, cands[1].Entry.Transcriptions[0].Strn; w != g {
+		t.Errorf("wanted %v got %v", w, g)
+	}
+}
@@ -0,0 +1,208 @@
+// Package replication copies lexicon databases from a primary lexserver to replica databases, using the change feed of the primary (see dbapi.DBManager.ExportChanges and dbapi.DBManager.ApplyChanges).
+//
+// A replica database is created from a backup of the primary database (Bootstrap), and is then kept up to date by pulling the changes made after the backup (Pull). The primary and the replica must use the same db engine and schema version. Replica databases should not be edited other than through replication.
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+)
+
+// DefaultLimit is the default max number of change events in a change set
+const DefaultLimit = 1000
+
+// Client fetches backups and change sets from a primary lexserver
+type Client struct {
+	// PrimaryURL is the base URL of the primary lexserver (e.g. http://localhost:8787, or http://localhost/lexserver if the server uses a prefix)
+	PrimaryURL string
+	// HTTPClient is used for requests to the primary (http.DefaultClient if nil)
+	HTTPClient *http.Client
+	// Limit is the max number of change events to fetch in each request (DefaultLimit if 0)
+	Limit int
+	// Token is the API token sent to the primary, if it requires authentication. The token should have the admin role for the replicated databases.
+	Token string
+}
+
+// Source returns the name of the primary in the replication state of replica databases
+func (c Client) Source() string {
+	return strings.TrimSuffix(c.PrimaryURL, "/")
+}
+
+func (c Client) get(path string) (*http.Response, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	u := c.Source() + path
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned %s : %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// ListDBs lists the databases of the primary
+func (c Client) ListDBs() ([]lex.DBRef, error) {
+	res := []lex.DBRef{}
+	resp, err := c.get("/admin/list_dbs")
+	if err != nil {
+		return res, fmt.Errorf("couldn't list dbs : %v", err)
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return res, fmt.Errorf("couldn't list dbs : %v", err)
+	}
+	return res, nil
+}
+
+// FetchChanges fetches the change set for the primary database after the sequence number since
+func (c Client) FetchChanges(dbRef lex.DBRef, since int64) (dbapi.ChangeSet, error) {
+	limit := c.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	resp, err := c.get(fmt.Sprintf("/admin/replication_changes/%s?since=%d&limit=%d", url.PathEscape(string(dbRef)), since, limit))
+	if err != nil {
+		return dbapi.ChangeSet{}, fmt.Errorf("couldn't fetch changes : %v", err)
+	}
+	defer resp.Body.Close()
+	return dbapi.ReadChangeSet(resp.Body)
+}
+
+// ServeChanges writes the JSON encoded change set for the database after the sequence number since, with at most limit change events (DefaultLimit if 0). It is used by the primary server for the requests made by FetchChanges.
+func ServeChanges(w http.ResponseWriter, dbm *dbapi.DBManager, dbRef lex.DBRef, since int64, limit int) {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if !dbm.ContainsDB(dbRef) {
+		http.Error(w, fmt.Sprintf("no such db '%s'", dbRef), http.StatusNotFound)
+		return
+	}
+	cs, err := dbm.ExportChanges(dbRef, since, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("couldn't export changes : %v", err), http.StatusInternalServerError)
+		return
+	}
+	jsn, err := json.Marshal(cs)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed marshalling : %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsn)
+}
+
+// Pull applies the changes of the primary database to the replica database with the same name, until the replica is up to date. Returns the final position, and the total number of inserted, updated and deleted entries.
+func Pull(dbm *dbapi.DBManager, dbRef lex.DBRef, c Client) (dbapi.ApplyResult, error) {
+	res := dbapi.ApplyResult{}
+	for {
+		pos, err := dbm.ReplicationPosition(dbRef, c.Source())
+		if err != nil {
+			return res, err
+		}
+		cs, err := c.FetchChanges(dbRef, pos)
+		if err != nil {
+			return res, err
+		}
+		r, err := dbm.ApplyChanges(dbRef, c.Source(), cs)
+		if err != nil {
+			return res, err
+		}
+		res.Position = r.Position
+		res.InsertedEntries += r.InsertedEntries
+		res.UpdatedEntries += r.UpdatedEntries
+		res.DeletedEntries += r.DeletedEntries
+		if !cs.More || r.Position <= pos {
+			return res, nil
+		}
+	}
+}
+
+// Bootstrap creates a replica database from a backup of the primary database, and sets its position in the primary's change feed. The replica database must not exist. Returns the position.
+func Bootstrap(dbm *dbapi.DBManager, dbLocation string, dbRef lex.DBRef, c Client) (int64, error) {
+	resp, err := c.get("/admin/backup/" + url.PathEscape(string(dbRef)))
+	if err != nil {
+		return 0, fmt.Errorf("couldn't fetch backup : %v", err)
+	}
+	defer resp.Body.Close()
+	err = dbm.Restore(dbLocation, dbRef, resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't restore backup : %v", err)
+	}
+	return dbm.InitReplication(dbRef, c.Source())
+}
+
+// Sync pulls the changes for each of the databases (all databases of the primary, if dbRefs is empty). Databases that don't exist locally are bootstrapped from the primary.
+func Sync(dbm *dbapi.DBManager, dbLocation string, c Client, dbRefs ...lex.DBRef) error {
+	if len(dbRefs) == 0 {
+		var err error
+		dbRefs, err = c.ListDBs()
+		if err != nil {
+			return err
+		}
+	}
+	errs := []string{}
+	for _, dbRef := range dbRefs {
+		if !dbm.ContainsDB(dbRef) {
+			pos, err := Bootstrap(dbm, dbLocation, dbRef, c)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s : %v", dbRef, err))
+				continue
+			}
+			log.Printf("replication: created db %s from %s at position %d", dbRef, c.Source(), pos)
+			continue
+		}
+		res, err := Pull(dbm, dbRef, c)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s : %v", dbRef, err))
+			continue
+		}
+		if res.InsertedEntries+res.UpdatedEntries+res.DeletedEntries > 0 {
+			log.Printf("replication: pulled db %s from %s to position %d (inserted: %d, updated: %d, deleted: %d)", dbRef, c.Source(), res.Position, res.InsertedEntries, res.UpdatedEntries, res.DeletedEntries)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("replication failed : %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Follow calls Sync at each interval, until the context is cancelled. Errors are logged, and replication is retried at the next interval.
+func Follow(ctx context.Context, dbm *dbapi.DBManager, dbLocation string, c Client, interval time.Duration, dbRefs ...lex.DBRef) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := Sync(dbm, dbLocation, c, dbRefs...)
+		if err != nil {
+			log.Printf("replication: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package replication
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stts-se/pronlex/dbapi"
+	"github.com/stts-se/pronlex/lex"
+)
+
+// primaryServer serves the admin requests used by Client, as the lexserver does
+func primaryServer(dbm *dbapi.DBManager) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/list_dbs", func(w http.ResponseWriter, r *http.Request) {
+		dbs, err := dbm.ListDBNames()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(dbs)
+	})
+	mux.HandleFunc("/admin/backup/", func(w http.ResponseWriter, r *http.Request) {
+		err := dbm.Backup(lex.DBRef(strings.TrimPrefix(r.URL.Path, "/admin/backup/")), w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/admin/replication_changes/", func(w http.ResponseWriter, r *http.Request) {
+		since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		ServeChanges(w, dbm, lex.DBRef(strings.TrimPrefix(r.URL.Path, "/admin/replication_changes/")), since, limit)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestReplicationSqlite(t *testing.T) {
+	dbapi.Sqlite3WithRegex()
+	dbRef := lex.DBRef("replication_test")
+	lexRef := lex.NewLexRef(string(dbRef), "sv")
+	primaryDir, replicaDir := t.TempDir(), t.TempDir()
+
+	primary := dbapi.NewSqliteDBManager()
+	err := primary.DefineDB(primaryDir, dbRef)
+	if err != nil {
+		t.Fatalf("failed to define db : %v", err)
+	}
+	defer primary.CloseDB(dbRef)
+	err = primary.DefineLexicon(lexRef, "sv-se_ws-sampa", "sv_SE")
+	if err != nil {
+		t.Fatalf("failed to define lexicon : %v", err)
+	}
+	newEntry := func(strn, trans string) lex.Entry {
+		return lex.Entry{Strn: strn,
+			Language:       "sv-se",
+			Transcriptions: []lex.Transcription{{Strn: trans}},
+			EntryStatus:    lex.EntryStatus{Name: "imported", Source: "test"},
+		}
+	}
+	ids, err := primary.InsertEntries(lexRef, []lex.Entry{newEntry("hund", `" h u0 n d`), newEntry("katt", `" k a t`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	server := primaryServer(primary)
+	defer server.Close()
+	client := Client{PrimaryURL: server.URL + "/", Limit: 2}
+
+	replica := dbapi.NewSqliteDBManager()
+	defer replica.CloseDB(dbRef)
+
+	lookUp := func(dbm *dbapi.DBManager) []lex.Entry {
+		t.Helper()
+		es, err := dbm.LookUpIntoSlice(dbapi.DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: dbapi.Query{WordLike: "%"}})
+		if err != nil {
+			t.Fatalf("lookup failed : %v", err)
+		}
+		return es
+	}
+	check := func(msg string, expectN int) {
+		t.Helper()
+		p, r := lookUp(primary), lookUp(replica)
+		if len(r) != expectN {
+			t.Errorf("%s: expected %d entries in replica, got %d", msg, expectN, len(r))
+		}
+		if !reflect.DeepEqual(p, r) {
+			t.Errorf("%s: expected replica entries\n%#v\ngot\n%#v", msg, p, r)
+		}
+	}
+
+	// the replica db is created from a backup
+	err = Sync(replica, replicaDir, client)
+	if err != nil {
+		t.Fatalf("sync failed : %v", err)
+	}
+	check("bootstrap", 2)
+
+	// changes after the backup
+	es, err := primary.LookUpIntoSlice(dbapi.DBMQuery{LexRefs: []lex.LexRef{lexRef}, Query: dbapi.Query{EntryIDs: []int64{ids[0]}}})
+	if err != nil || len(es) != 1 {
+		t.Fatalf("lookup failed : %v", err)
+	}
+	e := es[0]
+	e.Transcriptions[0].Strn = `" h u0 n t`
+	e.EntryStatus = lex.EntryStatus{Name: "ok", Source: "tester"}
+	_, _, err = primary.UpdateEntry(e)
+	if err != nil {
+		t.Fatalf("failed to update entry : %v", err)
+	}
+	_, err = primary.DeleteEntry(ids[1], lexRef)
+	if err != nil {
+		t.Fatalf("failed to delete entry : %v", err)
+	}
+	_, err = primary.InsertEntries(lexRef, []lex.Entry{newEntry("räv", `" r E: v`), newEntry("mus", `" m }: s`)})
+	if err != nil {
+		t.Fatalf("failed to insert entries : %v", err)
+	}
+
+	res, err := Pull(replica, dbRef, client)
+	if err != nil {
+		t.Fatalf("pull failed : %v", err)
+	}
+	if res.InsertedEntries != 2 || res.UpdatedEntries != 1 || res.DeletedEntries != 1 {
+		t.Errorf("unexpected pull result : %#v", res)
+	}
+	check("pull", 3)
+	pos, err := replica.ReplicationPosition(dbRef, server.URL)
+	if err != nil {
+		t.Fatalf("failed to get position : %v", err)
+	}
+	if pos != res.Position {
+		t.Errorf("expected position %d, got %d", res.Position, pos)
+	}
+
+	// nothing more to pull
+	res, err = Pull(replica, dbRef, client)
+	if err != nil {
+		t.Fatalf("pull failed : %v", err)
+	}
+	if res.InsertedEntries+res.UpdatedEntries+res.DeletedEntries != 0 || res.Position != pos {
+		t.Errorf("expected no changes, got %#v", res)
+	}
+	check("repeated pull", 3)
+}